@@ -42,6 +42,18 @@ addHotkey('i', true, false, false, true, true, 'stand(1);stand(2);stand(3);stand
 addHotkey('PAUSE', false, false, false, true, false, 'togglePause();closeMenu()')
 addHotkey('PAUSE', true, false, false, true, false, 'step()')
 addHotkey('SCROLLLOCK', false, false, false, true, false, 'step()')
+addHotkey('i', true, false, true, true, true, 'toggleInspector()')
+addHotkey('PAGEUP', true, false, false, true, true, 'inspectorSelect(-1)')
+addHotkey('PAGEDOWN', true, false, false, true, true, 'inspectorSelect(1)')
+addHotkey('PAGEUP', true, false, true, true, true, 'inspectorScroll(-5)')
+addHotkey('PAGEDOWN', true, false, true, true, true, 'inspectorScroll(5)')
+addHotkey('HOME', true, false, false, true, true, 'inspectorPage(-1)')
+addHotkey('END', true, false, false, true, true, 'inspectorPage(1)')
+addHotkey('F7', false, false, false, true, false, 'saveTrainingState()')
+addHotkey('F8', false, false, false, true, false, 'loadTrainingState()')
+addHotkey('t', true, false, true, true, false, 'traceDump()')
+addHotkey('p', true, false, true, true, true, 'toggleProfiler()')
+addHotkey('r', true, false, true, true, true, 'profilerReport()')
 
 local speedMul = 1
 local speedAdd = 0
@@ -56,6 +68,19 @@ function changeSpeed(add)
 	setAccel(math.max(0.01, speedMul + speedAdd))
 end
 
+--training mode savestates, kept in the 'quick' slot unless named
+function saveTrainingState(name)
+	if gamemode('training') then
+		trainingSaveState(name or 'quick')
+	end
+end
+
+function loadTrainingState(name)
+	if gamemode('training') then
+		trainingLoadState(name or 'quick')
+	end
+end
+
 function toggleAI(p)
 	local oldid = id()
 	if player(p) then
@@ -171,7 +196,21 @@ function stateInfo()
 	)
 end
 
-loadDebugInfo({'engineInfo', 'playerInfo', 'actionInfo', 'stateInfo'})
+function cacheInfo()
+	local t = sffCacheInfo()
+	local limit = 'unlimited'
+	if t.limit > 0 then
+		limit = string.format('%.1fMB', t.limit / 1048576)
+	end
+	local ret = string.format('SFF cache: %.1fMB/%s, %d files', t.used / 1048576, limit, #t.files)
+	for i = 1, math.min(#t.files, 3) do
+		local f = t.files[i]
+		ret = ret .. string.format('; %s %.1fMB (%d)', f.file:gsub('^.*[/\\]', ''), f.size / 1048576, f.refs)
+	end
+	return ret
+end
+
+loadDebugInfo({'engineInfo', 'playerInfo', 'actionInfo', 'stateInfo', 'cacheInfo'})
 
 --;===========================================================
 --; MATCH LOOP
@@ -19,7 +19,15 @@ json = (loadfile 'external/script/json.lua')()
 
 --return file content
 function main.f_fileRead(path, mode)
-	local file = io.open(path, mode or 'r')
+	--read through the engine, so files inside archives and mounts are found
+	if mode == nil or mode == 'r' or mode == 'rb' then
+		local str = readFile(path)
+		if str == nil then
+			panicError("\nFile doesn't exist: " .. path)
+		end
+		return str
+	end
+	local file = io.open(path, mode)
 	if file == nil then
 		panicError("\nFile doesn't exist: " .. path)
 		return
@@ -220,12 +228,7 @@ function main.f_fileExists(file)
 	if file == '' then
 		return false
 	end
-	local f = io.open(file,'r')
-	if f ~= nil then
-		io.close(f)
-		return true
-	end
-	return false
+	return fileExists(file)
 end
 
 --prints "t" table content into "toFile" file
@@ -316,7 +319,7 @@ end
 if main.flags['-speed'] ~= nil and tonumber(main.flags['-speed']) > 0 then
 	setGameSpeed(tonumber(main.flags['-speed']) * config.Framerate / 100)
 end
-if main.flags['-speedtest'] ~= nil then
+if main.flags['-speedtest'] ~= nil or main.flags['-scenario'] ~= nil then
 	setGameSpeed(100 * config.Framerate)
 end
 if main.flags['-nosound'] ~= nil then
@@ -1298,13 +1301,26 @@ end
 
 --play music
 main.lastBgm = ''
-function main.f_playBGM(interrupt, bgm, bgmLoop, bgmVolume, bgmLoopstart, bgmLoopend)
+function main.f_playBGM(interrupt, bgm, bgmLoop, bgmVolume, bgmLoopstart, bgmLoopend, crossfade, shuffle)
 	if main.flags['-nomusic'] ~= nil then
 		return
 	end
 	local bgm = bgm or ''
+	--several tracks are played as a playlist
+	local playlist = type(bgm) == 'table'
+	if playlist then
+		bgm = table.concat(bgm, ',')
+	end
 	if interrupt or bgm:gsub('^%./', '') ~= main.lastBgm then
-		playBGM(bgm, bgmLoop or 1, bgmVolume or 100, bgmLoopstart or 0, bgmLoopend or 0)
+		setBGMCrossfade(crossfade or 0)
+		if playlist then
+			if shuffle == nil then
+				shuffle = motif.music.playlist_shuffle == 1
+			end
+			playBGMPlaylist(bgm, shuffle, bgmVolume or 100)
+		else
+			playBGM(bgm, bgmLoop or 1, bgmVolume or 100, bgmLoopstart or 0, bgmLoopend or 0)
+		end
 		main.lastBgm = bgm:gsub('^%./', '')
 	end
 end
@@ -1501,6 +1517,9 @@ function main.f_commandLine()
 	if main.flags['-log'] ~= nil then
 		main.f_printTable(t_gameStats, main.flags['-log'])
 	end
+	if main.flags['-scenario'] ~= nil then
+		os.exit(scenarioResult())
+	end
 	os.exit()
 end
 
@@ -1947,6 +1966,14 @@ function main.f_addStage(file, hidden)
 					t_ref[1][prefix] = tonumber(v)
 				end
 			end
+		elseif k == 'bgmplaylist' then
+			main.t_selStages[stageNo].bgmplaylist = {}
+			for _, c in ipairs(main.f_strsplit(',', v)) do
+				c = c:gsub('^%s*(.-)%s*$', '%1')
+				if c ~= '' then
+					table.insert(main.t_selStages[stageNo].bgmplaylist, searchFile(c, {file, "", "data/", "sound/"}))
+				end
+			end
 		elseif v ~= '' then
 			main.t_selStages[stageNo][k:gsub('%.', '_')] = main.f_dataType(v)
 		end
@@ -4206,6 +4233,22 @@ if main.flags['-stresstest'] ~= nil then
 	os.exit()
 end
 
+if main.flags['-render'] ~= nil then
+	main.f_default()
+	local replay = main.flags['-render']
+	if main.menu.submenu.server ~= nil and recordStart(replay:gsub('%.replay$', ''), true) then
+		enterReplay(replay)
+		synchronize()
+		math.randomseed(sszRandom())
+		main.f_cmdBufReset()
+		main.menu.submenu.server.loop()
+		recordStop()
+		exitReplay()
+	end
+	--return instead of os.exit so that the engine shuts down normally
+	return
+end
+
 main.f_loadingRefresh(main.txt_loading)
 main.txt_loading = nil
 --sleep(1)
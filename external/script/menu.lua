@@ -58,6 +58,21 @@ menu.t_valuename = {
 		{itemname = 'd', displayname = motif.training_info.menu_valuename_buttonjam_d},
 		{itemname = 'w', displayname = motif.training_info.menu_valuename_buttonjam_w},
 	},
+	recordslot = {
+		{itemname = '1', displayname = motif.training_info.menu_valuename_recordslot_1},
+		{itemname = '2', displayname = motif.training_info.menu_valuename_recordslot_2},
+		{itemname = '3', displayname = motif.training_info.menu_valuename_recordslot_3},
+		{itemname = '4', displayname = motif.training_info.menu_valuename_recordslot_4},
+		{itemname = '5', displayname = motif.training_info.menu_valuename_recordslot_5},
+	},
+	playback = {
+		{itemname = 'off', displayname = motif.training_info.menu_valuename_playback_off},
+		{itemname = 'loop', displayname = motif.training_info.menu_valuename_playback_loop},
+		{itemname = 'random', displayname = motif.training_info.menu_valuename_playback_random},
+		{itemname = 'wakeup', displayname = motif.training_info.menu_valuename_playback_wakeup},
+		{itemname = 'block', displayname = motif.training_info.menu_valuename_playback_block},
+		{itemname = 'hitstun', displayname = motif.training_info.menu_valuename_playback_hitstun},
+	},
 }
 
 -- Shared logic for training menu option change, returns 2 values:
@@ -169,6 +184,31 @@ menu.t_itemname = {
 		end
 		return true
 	end,
+	--Recording Slot
+	['recordslot'] = function(t, item, cursorPosY, moveTxt, section)
+		if menu.f_valueChanged(t.items[item], motif[section]) then
+			menu.f_playbackSet()
+		end
+		return true
+	end,
+	--Record (closes the menu, recording stops when the menu is opened again)
+	['record'] = function(t, item, cursorPosY, moveTxt, section)
+		if main.f_input(main.t_players, {'pal', 's'}) then
+			sndPlay(motif.files.snd_data, motif[section].cursor_done_snd[1], motif[section].cursor_done_snd[2])
+			trainingRecord(menu.recordslot or 1, 1)
+			togglePause(false)
+			main.pauseMenu = false
+			return false
+		end
+		return true
+	end,
+	--Playback
+	['playback'] = function(t, item, cursorPosY, moveTxt, section)
+		if menu.f_valueChanged(t.items[item], motif[section]) then
+			menu.f_playbackSet()
+		end
+		return true
+	end,
 	--Key Config
 	['keyboard'] = function(t, item, cursorPosY, moveTxt, section)
 		if main.f_input(main.t_players, {'pal', 's'}) --[[or getKey('F1')]] then
@@ -331,8 +371,34 @@ menu.t_vardisplay = {
 	['buttonjam'] = function()
 		return menu.t_valuename.buttonjam[menu.buttonjam or 1].displayname
 	end,
+	['recordslot'] = function()
+		local frames = trainingInfo().frames[menu.recordslot or 1]
+		if frames == 0 then
+			return menu.t_valuename.recordslot[menu.recordslot or 1].displayname
+		end
+		return menu.t_valuename.recordslot[menu.recordslot or 1].displayname .. ' (' .. frames .. 'f)'
+	end,
+	['playback'] = function()
+		return menu.t_valuename.playback[menu.playback or 1].displayname
+	end,
 }
 
+-- Applies the Playback and Recording Slot settings. Random playback picks any
+-- recorded slot, the other modes play the selected one.
+function menu.f_playbackSet()
+	local mode = menu.t_valuename.playback[menu.playback or 1].itemname
+	local slot = menu.recordslot or 1
+	if mode == 'random' then
+		slot = 0
+	end
+	trainingPlayback(mode, slot)
+	for _, v in ipairs(menu.t_vardisplayPointers) do
+		if v.itemname == 'recordslot' then
+			v.vardisplay = menu.f_vardisplay(v.itemname)
+		end
+	end
+end
+
 -- Returns setting value rendered alongside menu item name (calls appropriate
 -- function from menu or options t_vardisplay table)
 function menu.f_vardisplay(itemname)
@@ -477,6 +543,8 @@ function menu.f_trainingReset()
 	charMapSet(2, '_iksys_trainingFallRecovery', 0)
 	charMapSet(2, '_iksys_trainingDistance', 0)
 	charMapSet(2, '_iksys_trainingButtonJam', 0)
+	trainingStop()
+	trainingPlayback('off')
 end
 
 menu.movelistChar = 1
@@ -486,6 +554,8 @@ function menu.f_init()
 	main.pauseMenu = true
 	main.f_bgReset(motif.optionbgdef.bg)
 	if gamemode('training') then
+		trainingStop()
+		menu.f_playbackSet()
 		sndPlay(motif.files.snd_data, motif.training_info.enter_snd[1], motif.training_info.enter_snd[2])
 		main.f_bgReset(motif.trainingbgdef.bg)
 		main.f_fadeReset('fadein', motif.training_info)
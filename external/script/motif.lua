@@ -92,6 +92,7 @@ local motif =
 		hiscore_bgm_loop = 1, --Ikemen feature
 		hiscore_bgm_loopstart = 0, --Ikemen feature
 		hiscore_bgm_loopend = 0, --Ikemen feature
+		playlist_shuffle = 0, --Ikemen feature
 	},
 	languages =
 	{
@@ -109,11 +110,11 @@ local motif =
 		title_offset = {159, 15}, --Ikemen feature
 		title_font = {-1, 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_scale = {1.0, 1.0}, --Ikemen feature
-		title_text = 'MAIN MENU', --Ikemen feature
+		title_text = '$(title_info.title.text)', --Ikemen feature
 		loading_offset = {main.SP_Localcoord[1] - 1 - main.f_round(10 * main.SP_Localcoord[1] / 320), main.SP_Localcoord[2] - 8}, --Ikemen feature
 		loading_font = {'default-3x5.def', 0, -1, 191, 191, 191, -1}, --Ikemen feature
 		loading_scale = {1.0, 1.0}, --Ikemen feature
-		loading_text = 'LOADING...', --Ikemen feature
+		loading_text = '$(title_info.loading.text)', --Ikemen feature
 		footer1_offset = {main.f_round(2 * main.SP_Localcoord[1] / 320), main.SP_Localcoord[2]}, --Ikemen feature
 		footer1_font = {'default-3x5.def', 0, 1, 191, 191, 191, -1}, --Ikemen feature
 		footer1_scale = {1.0, 1.0}, --Ikemen feature
@@ -121,7 +122,7 @@ local motif =
 		footer2_offset = {main.SP_Localcoord[1] / 2, main.SP_Localcoord[2]}, --Ikemen feature
 		footer2_font = {'default-3x5.def', 0, 0, 191, 191, 191, -1}, --Ikemen feature
 		footer2_scale = {1.0, 1.0}, --Ikemen feature
-		footer2_text = 'Press F1 for info', --Ikemen feature
+		footer2_text = '$(title_info.footer2.text)', --Ikemen feature
 		footer3_offset = {main.SP_Localcoord[1] - 1 - main.f_round(2 * main.SP_Localcoord[1] / 320), main.SP_Localcoord[2]}, --Ikemen feature
 		footer3_font = {'default-3x5.def', 0, -1, 191, 191, 191, -1}, --Ikemen feature
 		footer3_scale = {1.0, 1.0}, --Ikemen feature
@@ -337,23 +338,23 @@ local motif =
 		title_offset = {0, 0},
 		title_font = {-1, 0, 0, 255, 255, 255, -1},
 		title_scale = {1.0, 1.0}, --Ikemen feature
-		title_arcade_text = 'Arcade', --Ikemen feature
-		title_teamarcade_text = 'Team Arcade', --Ikemen feature
-		title_teamcoop_text = 'Team Cooperative', --Ikemen feature
-		title_versus_text = 'Versus Mode', --Ikemen feature
-		title_teamversus_text = 'Team Versus', --Ikemen feature
-		title_versuscoop_text = 'Versus Cooperative', --Ikemen feature
-		title_freebattle_text = 'Quick Match', --Ikemen feature
-		title_storymode_text = 'Story Mode', --Ikemen feature
-		title_netplayversus_text = 'Online Versus', --Ikemen feature
-		title_netplayteamcoop_text = 'Online Cooperative', --Ikemen feature
-		title_netplaysurvivalcoop_text = 'Online Survival', --Ikemen feature
-		title_training_text = 'Training Mode', --Ikemen feature
-		title_timeattack_text = 'Time Attack', --Ikemen feature
-		title_survival_text = 'Survival', --Ikemen feature
-		title_survivalcoop_text = 'Survival Cooperative', --Ikemen feature
-		title_bonus_text = 'Bonus', --Ikemen feature
-		title_watch_text = 'Watch Mode', --Ikemen feature
+		title_arcade_text = '$(select_info.title.arcade.text)', --Ikemen feature
+		title_teamarcade_text = '$(select_info.title.teamarcade.text)', --Ikemen feature
+		title_teamcoop_text = '$(select_info.title.teamcoop.text)', --Ikemen feature
+		title_versus_text = '$(select_info.title.versus.text)', --Ikemen feature
+		title_teamversus_text = '$(select_info.title.teamversus.text)', --Ikemen feature
+		title_versuscoop_text = '$(select_info.title.versuscoop.text)', --Ikemen feature
+		title_freebattle_text = '$(select_info.title.freebattle.text)', --Ikemen feature
+		title_storymode_text = '$(select_info.title.storymode.text)', --Ikemen feature
+		title_netplayversus_text = '$(select_info.title.netplayversus.text)', --Ikemen feature
+		title_netplayteamcoop_text = '$(select_info.title.netplayteamcoop.text)', --Ikemen feature
+		title_netplaysurvivalcoop_text = '$(select_info.title.netplaysurvivalcoop.text)', --Ikemen feature
+		title_training_text = '$(select_info.title.training.text)', --Ikemen feature
+		title_timeattack_text = '$(select_info.title.timeattack.text)', --Ikemen feature
+		title_survival_text = '$(select_info.title.survival.text)', --Ikemen feature
+		title_survivalcoop_text = '$(select_info.title.survivalcoop.text)', --Ikemen feature
+		title_bonus_text = '$(select_info.title.bonus.text)', --Ikemen feature
+		title_watch_text = '$(select_info.title.watch.text)', --Ikemen feature
 		--title_replay_text = 'Replay', --Ikemen feature
 		p1_face_pos = {0, 0},
 		p1_face_num = 1, --Ikemen feature
@@ -404,13 +405,13 @@ local motif =
 		p1_name_font = {-1, 4, 1, 255, 255, 255, -1},
 		p1_name_scale = {1.0, 1.0}, --Ikemen feature
 		p1_name_spacing = {0, 14},
-		p1_name_random_text = 'Random', --Ikemen feature
+		p1_name_random_text = '$(select_info.p1.name.random.text)', --Ikemen feature
 		p2_name_num = 4, --Ikemen feature
 		p2_name_offset = {0, 0},
 		p2_name_font = {-1, 1, -1, 255, 255, 255, -1},
 		p2_name_scale = {1.0, 1.0}, --Ikemen feature
 		p2_name_spacing = {0, 14},
-		p2_name_random_text = 'Random', --Ikemen feature
+		p2_name_random_text = '$(select_info.p2.name.random.text)', --Ikemen feature
 		stage_pos = {0, 0},
 		stage_active_offset = {0, 0}, --Ikemen feature
 		stage_active_font = {-1, 0, 0, 255, 255, 255, -1},
@@ -423,7 +424,7 @@ local motif =
 		stage_done_font = {-1, 0, 0, 255, 255, 255, -1},
 		stage_done_scale = {1.0, 1.0}, --Ikemen feature
 		stage_text = 'Stage %i: %s', --Ikemen feature
-		stage_random_text = 'Stage: Random', --Ikemen feature
+		stage_random_text = '$(select_info.stage.random.text)', --Ikemen feature
 		stage_portrait_anim = -1, --Ikemen feature
 		stage_portrait_spr = {}, --Ikemen feature
 		stage_portrait_offset = {0, 0}, --Ikemen feature
@@ -820,23 +821,23 @@ local motif =
 		fadeout_col = {0, 0, 0}, --Ikemen feature
 		fadeout_anim = -1, --Ikemen feature
 		pos = {160, 40},
-		continue_text = 'Continue?',
+		continue_text = '$(continue_screen.continue.text)',
 		continue_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1},
 		continue_scale = {1.0, 1.0},
 		continue_offset = {0, 0},
-		yes_text = 'Yes',
+		yes_text = '$(continue_screen.yes.text)',
 		yes_font = {'f-6x9.def', 0, 0, 191, 191, 191, -1},
 		yes_scale = {1.0, 1.0},
 		yes_offset = {-17, 20},
-		yes_active_text = 'Yes',
+		yes_active_text = '$(continue_screen.yes.active.text)',
 		yes_active_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1},
 		yes_active_scale = {1.0, 1.0},
 		yes_active_offset = {-17, 20},
-		no_text = 'No',
+		no_text = '$(continue_screen.no.text)',
 		no_font = {'f-6x9.def', 0, 0, 191, 191, 191, -1},
 		no_scale = {1.0, 1.0},
 		no_offset = {15, 20},
-		no_active_text = 'No',
+		no_active_text = '$(continue_screen.no.active.text)',
 		no_active_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1},
 		no_active_scale = {1.0, 1.0},
 		no_active_offset = {15, 20},
@@ -943,7 +944,7 @@ local motif =
 		p2_name_offset = {0, 0}, --Ikemen feature
 		p2_name_font = {-1, 0, 1, 255, 255, 255, -1}, --Ikemen feature
 		p2_name_scale = {1.0, 1.0}, --Ikemen feature
-		winquote_text = 'Winner!',
+		winquote_text = '$(victory_screen.winquote.text)',
 		winquote_offset = {20, 192},
 		winquote_spacing = {0, 0}, --Ikemen feature
 		winquote_font = {-1, 0, 1, 255, 255, 255, -1},
@@ -976,7 +977,7 @@ local motif =
 		fadeout_col = {0, 0, 0}, --Ikemen feature
 		fadeout_anim = -1, --Ikemen feature
 		pose_time = 300,
-		wintext_text = 'Congratulations!',
+		wintext_text = '$(win_screen.wintext.text)',
 		wintext_offset = {159, 70},
 		wintext_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1},
 		wintext_scale = {1.0, 1.0},
@@ -1086,7 +1087,7 @@ local motif =
 		title_offset = {159, 15},
 		title_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1},
 		title_scale = {1.0, 1.0},
-		title_text = 'OPTIONS', --Ikemen feature
+		title_text = '$(option_info.title.text)', --Ikemen feature
 		menu_uselocalcoord = 0, --Ikemen feature
 		menu_pos = {85, 33}, --Ikemen feature
 		--menu_bg_<itemname>_anim = -1, --Ikemen feature
@@ -1147,17 +1148,17 @@ local motif =
 		menu_arrow_down_facing = 1, --Ikemen feature
 		menu_arrow_down_scale = {1.0, 1.0}, --Ikemen feature
 		menu_title_uppercase = 1, --Ikemen feature
-		menu_valuename_none = 'None', --Ikemen feature
-		menu_valuename_random = 'Random', --Ikemen feature
-		menu_valuename_default = 'Default', --Ikemen feature
+		menu_valuename_none = '$(option_info.menu.valuename.none)', --Ikemen feature
+		menu_valuename_random = '$(option_info.menu.valuename.random)', --Ikemen feature
+		menu_valuename_default = '$(option_info.menu.valuename.default)', --Ikemen feature
 		menu_valuename_f = '(F%i)', --Ikemen feature
 		menu_valuename_esc = '(Esc)', --Ikemen feature
 		menu_valuename_page = '(Tab)', --Ikemen feature
 		menu_valuename_nokey = 'Not used', --Ikemen feature
-		menu_valuename_yes = 'Yes', --Ikemen feature
-		menu_valuename_no = 'No', --Ikemen feature
-		menu_valuename_enabled = 'Enabled', --Ikemen feature
-		menu_valuename_disabled = 'Disabled', --Ikemen feature
+		menu_valuename_yes = '$(option_info.menu.valuename.yes)', --Ikemen feature
+		menu_valuename_no = '$(option_info.menu.valuename.no)', --Ikemen feature
+		menu_valuename_enabled = '$(option_info.menu.valuename.enabled)', --Ikemen feature
+		menu_valuename_disabled = '$(option_info.menu.valuename.disabled)', --Ikemen feature
 		keymenu_p1_pos = {39, 33}, --Ikemen feature
 		keymenu_p2_pos = {178, 33}, --Ikemen feature
 		--keymenu_bg_<itemname>_anim = -1, --Ikemen feature
@@ -1262,6 +1263,7 @@ local motif =
 		--menu_itemname_msaa = 'MSAA', --Ikemen feature
 		--menu_itemname_shaders = 'Shaders', --Ikemen feature
 		--menu_itemname_noshader = 'Disable', --Ikemen feature
+		--menu_itemname_postprocessing = 'Post-processing', --Ikemen feature
 		--menu_itemname_mastervolume = 'Master Volume', --Ikemen feature
 		--menu_itemname_bgmvolume = 'BGM Volume', --Ikemen feature
 		--menu_itemname_sfxvolume = 'SFX Volume', --Ikemen feature
@@ -1301,7 +1303,7 @@ local motif =
 		title_offset = {159, 15}, --Ikemen feature
 		title_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_scale = {1.0, 1.0}, --Ikemen feature
-		title_text = 'REPLAY SELECT', --Ikemen feature
+		title_text = '$(replay_info.title.text)', --Ikemen feature
 		menu_uselocalcoord = 0, --Ikemen feature
 		menu_pos = {85, 33}, --Ikemen feature
 		--menu_bg_<itemname>_anim = -1, --Ikemen feature
@@ -1362,7 +1364,7 @@ local motif =
 		title_offset = {159, 15}, --Ikemen feature
 		title_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_scale = {1.0, 1.0}, --Ikemen feature
-		title_text = 'PAUSE', --Ikemen feature
+		title_text = '$(menu_info.title.text)', --Ikemen feature
 		menu_uselocalcoord = 0, --Ikemen feature
 		menu_pos = {85, 33}, --Ikemen feature
 		--menu_bg_<itemname>_anim = -1, --Ikemen feature
@@ -1431,7 +1433,7 @@ local motif =
 		movelist_text_font = {'Open_Sans.def', 0, 1, 255, 255, 255, -1}, --Ikemen feature
 		movelist_text_scale = {0.4, 0.4}, --Ikemen feature
 		movelist_text_spacing = {1, 1}, --Ikemen feature
-		movelist_text_text = 'Command List not found.', --Ikemen feature
+		movelist_text_text = '$(menu_info.movelist.text.text)', --Ikemen feature
 		movelist_glyphs_offset = {0, 2}, --Ikemen feature
 		movelist_glyphs_scale = {1.0, 1.0}, --Ikemen feature
 		movelist_glyphs_spacing = {2, 0}, --Ikemen feature
@@ -1507,6 +1509,17 @@ local motif =
 		menu_valuename_buttonjam_s = "Start", --Ikemen feature
 		menu_valuename_buttonjam_d = "D", --Ikemen feature
 		menu_valuename_buttonjam_w = "W", --Ikemen feature
+		menu_valuename_recordslot_1 = "1", --Ikemen feature
+		menu_valuename_recordslot_2 = "2", --Ikemen feature
+		menu_valuename_recordslot_3 = "3", --Ikemen feature
+		menu_valuename_recordslot_4 = "4", --Ikemen feature
+		menu_valuename_recordslot_5 = "5", --Ikemen feature
+		menu_valuename_playback_off = "Off", --Ikemen feature
+		menu_valuename_playback_loop = "Loop", --Ikemen feature
+		menu_valuename_playback_random = "Random", --Ikemen feature
+		menu_valuename_playback_wakeup = "On Wakeup", --Ikemen feature
+		menu_valuename_playback_block = "On Block", --Ikemen feature
+		menu_valuename_playback_hitstun = "After Hitstun", --Ikemen feature
 		--menu_itemname_dummycontrol = "Dummy Control", --Ikemen feature
 		--menu_itemname_ailevel = "AI Level", --Ikemen feature
 		--menu_itemname_dummymode = "Dummy Mode", --Ikemen feature
@@ -1514,6 +1527,9 @@ local motif =
 		--menu_itemname_fallrecovery = "Fall Recovery", --Ikemen feature
 		--menu_itemname_distance = "Distance", --Ikemen feature
 		--menu_itemname_buttonjam = "Button Jam", --Ikemen feature
+		--menu_itemname_recordslot = "Recording Slot", --Ikemen feature
+		--menu_itemname_record = "Record", --Ikemen feature
+		--menu_itemname_playback = "Playback", --Ikemen feature
 	},
 	trainingbgdef =
 	{
@@ -1536,12 +1552,12 @@ local motif =
 		intro_storyboard = '', --Ikemen feature
 		start_storyboard = '', --Ikemen feature
 		start_time = 600, --Ikemen feature
-		start_insert_text = 'Insert coin', --Ikemen feature
+		start_insert_text = '$(attract_mode.start.insert.text)', --Ikemen feature
 		start_insert_offset = {159, 185}, --Ikemen feature
 		start_insert_font = {'jg.fnt', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		start_insert_scale = {1.0, 1.0}, --Ikemen feature
 		start_insert_blinktime = 30, --Ikemen feature
-		start_press_text = 'Press Start', --Ikemen feature
+		start_press_text = '$(attract_mode.start.press.text)', --Ikemen feature
 		start_press_offset = {159, 185}, --Ikemen feature
 		start_press_font = {'jg.fnt', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		start_press_scale = {1.0, 1.0}, --Ikemen feature
@@ -1561,7 +1577,7 @@ local motif =
 		title_offset = {159, 15}, --Ikemen feature
 		title_font = {-1, 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_scale = {1.0, 1.0}, --Ikemen feature
-		title_text = 'MAIN MENU', --Ikemen feature
+		title_text = '$(attract_mode.title.text)', --Ikemen feature
 		menu_next_key = '$D&$F', --Ikemen feature
 		menu_previous_key = '$U&$B', --Ikemen feature
 		menu_accept_key = 'a&b&c&x&y&z&s', --Ikemen feature
@@ -1723,19 +1739,19 @@ local motif =
 		title_rank_offset = {0, 0}, --Ikemen feature
 		title_rank_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_rank_scale = {1.0, 1.0}, --Ikemen feature
-		title_rank_text = 'Rank', --Ikemen feature
+		title_rank_text = '$(hiscore_info.title.rank.text)', --Ikemen feature
 		title_data_offset = {0, 0}, --Ikemen feature
 		title_data_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_data_scale = {1.0, 1.0}, --Ikemen feature
-		title_data_text = 'Result', --Ikemen feature
+		title_data_text = '$(hiscore_info.title.data.text)', --Ikemen feature
 		title_name_offset = {0, 0}, --Ikemen feature
 		title_name_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_name_scale = {1.0, 1.0}, --Ikemen feature
-		title_name_text = 'Name', --Ikemen feature
+		title_name_text = '$(hiscore_info.title.name.text)', --Ikemen feature
 		title_face_offset = {0, 0}, --Ikemen feature
 		title_face_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_face_scale = {1.0, 1.0}, --Ikemen feature
-		title_face_text = 'Character', --Ikemen feature
+		title_face_text = '$(hiscore_info.title.face.text)', --Ikemen feature
 		item_offset = {0, 0}, --Ikemen feature
 		item_spacing = {0, 0}, --Ikemen feature
 		item_rank_offset = {0, 0}, --Ikemen feature
@@ -1819,7 +1835,7 @@ local motif =
 		title_offset = {159, 15}, --Ikemen feature
 		title_font = {'f-6x9.def', 0, 0, 255, 255, 255, -1}, --Ikemen feature
 		title_scale = {1.0, 1.0}, --Ikemen feature
-		title_text = 'WARNING', --Ikemen feature
+		title_text = '$(warning_info.title.text)', --Ikemen feature
 		text_offset = {25, 33}, --Ikemen feature
 		text_font = {'f-6x9.def', 0, 1, 255, 255, 255, -1}, --Ikemen feature
 		text_scale = {1.0, 1.0}, --Ikemen feature
@@ -1829,6 +1845,7 @@ local motif =
 		text_keys_text = 'Conflict between button keys detected.\nAll keys should have unique assignment.\n\nPress any key to continue.\nPress ESC to reset.', --Ikemen feature
 		text_pad_text = 'Controller not detected.\nCheck if your controller is plugged in.', --Ikemen feature
 		text_shaders_text = 'No external OpenGL shaders detected.\nIkemen GO supports files with .vert and .frag extensions.\nShaders are loaded from "./external/shaders" directory.', --Ikemen feature
+		text_postprocessing_text = 'The active post-processing shaders have no parameters.\nPresets are defined with PostProcessingPresets in config.json.', --Ikemen feature
 		overlay_window = {0, 0, main.SP_Localcoord[1], main.SP_Localcoord[2]}, --Ikemen feature (0, 0, 320, 240)
 		overlay_col = {0, 0, 0}, --Ikemen feature
 		overlay_alpha = {0, 128}, --Ikemen feature
@@ -1915,17 +1932,17 @@ local motif =
 }
 
 function motif.setBaseTitleInfo()
-	motif.title_info.menu_itemname_arcade = "ARCADE"
-	motif.title_info.menu_itemname_versus = "VS MODE"
-	motif.title_info.menu_itemname_teamarcade = "TEAM ARCADE"
-	motif.title_info.menu_itemname_teamversus = "TEAM VS"
-	motif.title_info.menu_itemname_teamcoop = "TEAM CO-OP"
-	motif.title_info.menu_itemname_survival = "SURVIVAL"
-	motif.title_info.menu_itemname_survivalcoop = "SURVIVAL CO-OP"
-	motif.title_info.menu_itemname_training = "TRAINING"
-	motif.title_info.menu_itemname_watch = "WATCH"
-	motif.title_info.menu_itemname_options = "OPTIONS"
-	motif.title_info.menu_itemname_exit = "EXIT"
+	motif.title_info.menu_itemname_arcade = "$(title_info.menu.itemname.arcade)"
+	motif.title_info.menu_itemname_versus = "$(title_info.menu.itemname.versus)"
+	motif.title_info.menu_itemname_teamarcade = "$(title_info.menu.itemname.teamarcade)"
+	motif.title_info.menu_itemname_teamversus = "$(title_info.menu.itemname.teamversus)"
+	motif.title_info.menu_itemname_teamcoop = "$(title_info.menu.itemname.teamcoop)"
+	motif.title_info.menu_itemname_survival = "$(title_info.menu.itemname.survival)"
+	motif.title_info.menu_itemname_survivalcoop = "$(title_info.menu.itemname.survivalcoop)"
+	motif.title_info.menu_itemname_training = "$(title_info.menu.itemname.training)"
+	motif.title_info.menu_itemname_watch = "$(title_info.menu.itemname.watch)"
+	motif.title_info.menu_itemname_options = "$(title_info.menu.itemname.options)"
+	motif.title_info.menu_itemname_exit = "$(title_info.menu.itemname.exit)"
 	if main.t_sort.title_info == nil then
 		main.t_sort.title_info = {}
 	end
@@ -1946,70 +1963,70 @@ function motif.setBaseTitleInfo()
 end
 
 function motif.setBaseOptionInfo()
-	motif.option_info.menu_itemname_menugame = "Game Settings"
-	motif.option_info.menu_itemname_menugame_language = "Language"
-	motif.option_info.menu_itemname_menugame_difficulty = "Difficulty Level"
-	motif.option_info.menu_itemname_menugame_roundtime = "Time Limit"
-	motif.option_info.menu_itemname_menugame_lifemul = "Life"
-	motif.option_info.menu_itemname_menugame_singlevsteamlife = "Single VS Team Life"
-	motif.option_info.menu_itemname_menugame_gamespeed = "Game FPS"
-	motif.option_info.menu_itemname_menugame_roundsnumsingle = "Rounds to Win (Single)"
-	motif.option_info.menu_itemname_menugame_maxdrawgames = "Max Draw Games"
-	motif.option_info.menu_itemname_menugame_credits = "Credits"
-	motif.option_info.menu_itemname_menugame_aipalette = "Arcade Palette"
-	motif.option_info.menu_itemname_menugame_aisurvivalpalette = "Survival Palette"
-	motif.option_info.menu_itemname_menugame_airamping = "AI Ramping"
-	motif.option_info.menu_itemname_menugame_quickcontinue = "Quick Continue"
-	motif.option_info.menu_itemname_menugame_autoguard = "Auto-Guard"
-	motif.option_info.menu_itemname_menugame_stunbar = "Dizzy"
-	motif.option_info.menu_itemname_menugame_guardbar = "Guard Break"
-	motif.option_info.menu_itemname_menugame_redlifebar = "Red Life"
-	motif.option_info.menu_itemname_menugame_teamduplicates = "Team Duplicates"
-	motif.option_info.menu_itemname_menugame_teamlifeshare = "Team Life Share"
-	motif.option_info.menu_itemname_menugame_teampowershare = "Team Power Share"
+	motif.option_info.menu_itemname_menugame = "$(option_info.menu.itemname.menugame)"
+	motif.option_info.menu_itemname_menugame_language = "$(option_info.menu.itemname.menugame.language)"
+	motif.option_info.menu_itemname_menugame_difficulty = "$(option_info.menu.itemname.menugame.difficulty)"
+	motif.option_info.menu_itemname_menugame_roundtime = "$(option_info.menu.itemname.menugame.roundtime)"
+	motif.option_info.menu_itemname_menugame_lifemul = "$(option_info.menu.itemname.menugame.lifemul)"
+	motif.option_info.menu_itemname_menugame_singlevsteamlife = "$(option_info.menu.itemname.menugame.singlevsteamlife)"
+	motif.option_info.menu_itemname_menugame_gamespeed = "$(option_info.menu.itemname.menugame.gamespeed)"
+	motif.option_info.menu_itemname_menugame_roundsnumsingle = "$(option_info.menu.itemname.menugame.roundsnumsingle)"
+	motif.option_info.menu_itemname_menugame_maxdrawgames = "$(option_info.menu.itemname.menugame.maxdrawgames)"
+	motif.option_info.menu_itemname_menugame_credits = "$(option_info.menu.itemname.menugame.credits)"
+	motif.option_info.menu_itemname_menugame_aipalette = "$(option_info.menu.itemname.menugame.aipalette)"
+	motif.option_info.menu_itemname_menugame_aisurvivalpalette = "$(option_info.menu.itemname.menugame.aisurvivalpalette)"
+	motif.option_info.menu_itemname_menugame_airamping = "$(option_info.menu.itemname.menugame.airamping)"
+	motif.option_info.menu_itemname_menugame_quickcontinue = "$(option_info.menu.itemname.menugame.quickcontinue)"
+	motif.option_info.menu_itemname_menugame_autoguard = "$(option_info.menu.itemname.menugame.autoguard)"
+	motif.option_info.menu_itemname_menugame_stunbar = "$(option_info.menu.itemname.menugame.stunbar)"
+	motif.option_info.menu_itemname_menugame_guardbar = "$(option_info.menu.itemname.menugame.guardbar)"
+	motif.option_info.menu_itemname_menugame_redlifebar = "$(option_info.menu.itemname.menugame.redlifebar)"
+	motif.option_info.menu_itemname_menugame_teamduplicates = "$(option_info.menu.itemname.menugame.teamduplicates)"
+	motif.option_info.menu_itemname_menugame_teamlifeshare = "$(option_info.menu.itemname.menugame.teamlifeshare)"
+	motif.option_info.menu_itemname_menugame_teampowershare = "$(option_info.menu.itemname.menugame.teampowershare)"
 	motif.option_info.menu_itemname_menugame_empty = ""
-	motif.option_info.menu_itemname_menugame_menutag = "Tag Settings"
-	motif.option_info.menu_itemname_menugame_menutag_roundsnumtag = "Rounds to Win (Tag)"
-	motif.option_info.menu_itemname_menugame_menutag_losekotag = "Partner KOed Lose"
+	motif.option_info.menu_itemname_menugame_menutag = "$(option_info.menu.itemname.menugame.menutag)"
+	motif.option_info.menu_itemname_menugame_menutag_roundsnumtag = "$(option_info.menu.itemname.menugame.menutag.roundsnumtag)"
+	motif.option_info.menu_itemname_menugame_menutag_losekotag = "$(option_info.menu.itemname.menugame.menutag.losekotag)"
 	motif.option_info.menu_itemname_menugame_menutag_empty = ""
-	motif.option_info.menu_itemname_menugame_menutag_mintag = "Min Tag Chars"
-	motif.option_info.menu_itemname_menugame_menutag_maxtag = "Max Tag Chars"
+	motif.option_info.menu_itemname_menugame_menutag_mintag = "$(option_info.menu.itemname.menugame.menutag.mintag)"
+	motif.option_info.menu_itemname_menugame_menutag_maxtag = "$(option_info.menu.itemname.menugame.menutag.maxtag)"
 	motif.option_info.menu_itemname_menugame_menutag_empty = ""
-	motif.option_info.menu_itemname_menugame_menutag_back = "Back"
-	motif.option_info.menu_itemname_menugame_menusimul = "Simul Settings"
-	motif.option_info.menu_itemname_menugame_menusimul_roundsnumsimul = "Rounds to Win (Simul)"
-	motif.option_info.menu_itemname_menugame_menusimul_losekosimul = "Player KOed Lose"
+	motif.option_info.menu_itemname_menugame_menutag_back = "$(option_info.menu.itemname.menugame.menutag.back)"
+	motif.option_info.menu_itemname_menugame_menusimul = "$(option_info.menu.itemname.menugame.menusimul)"
+	motif.option_info.menu_itemname_menugame_menusimul_roundsnumsimul = "$(option_info.menu.itemname.menugame.menusimul.roundsnumsimul)"
+	motif.option_info.menu_itemname_menugame_menusimul_losekosimul = "$(option_info.menu.itemname.menugame.menusimul.losekosimul)"
 	motif.option_info.menu_itemname_menugame_menusimul_empty = ""
-	motif.option_info.menu_itemname_menugame_menusimul_minsimul = "Min Simul Chars"
-	motif.option_info.menu_itemname_menugame_menusimul_maxsimul = "Max Simul Chars"
+	motif.option_info.menu_itemname_menugame_menusimul_minsimul = "$(option_info.menu.itemname.menugame.menusimul.minsimul)"
+	motif.option_info.menu_itemname_menugame_menusimul_maxsimul = "$(option_info.menu.itemname.menugame.menusimul.maxsimul)"
 	motif.option_info.menu_itemname_menugame_menusimul_empty = ""
-	motif.option_info.menu_itemname_menugame_menusimul_back = "Back"
-	motif.option_info.menu_itemname_menugame_menuturns = "Turns Settings"
-	motif.option_info.menu_itemname_menugame_menuturns_turnsrecoverybase = "Turns Recovery Base"
-	motif.option_info.menu_itemname_menugame_menuturns_turnsrecoverybonus = "Turns Recovery Bonus"
+	motif.option_info.menu_itemname_menugame_menusimul_back = "$(option_info.menu.itemname.menugame.menusimul.back)"
+	motif.option_info.menu_itemname_menugame_menuturns = "$(option_info.menu.itemname.menugame.menuturns)"
+	motif.option_info.menu_itemname_menugame_menuturns_turnsrecoverybase = "$(option_info.menu.itemname.menugame.menuturns.turnsrecoverybase)"
+	motif.option_info.menu_itemname_menugame_menuturns_turnsrecoverybonus = "$(option_info.menu.itemname.menugame.menuturns.turnsrecoverybonus)"
 	motif.option_info.menu_itemname_menugame_menuturns_empty = ""
-	motif.option_info.menu_itemname_menugame_menuturns_minturns = "Min Turns Chars"
-	motif.option_info.menu_itemname_menugame_menuturns_maxturns = "Max Turns Chars"
+	motif.option_info.menu_itemname_menugame_menuturns_minturns = "$(option_info.menu.itemname.menugame.menuturns.minturns)"
+	motif.option_info.menu_itemname_menugame_menuturns_maxturns = "$(option_info.menu.itemname.menugame.menuturns.maxturns)"
 	motif.option_info.menu_itemname_menugame_menuturns_empty = ""
-	motif.option_info.menu_itemname_menugame_menuturns_back = "Back"
-	motif.option_info.menu_itemname_menugame_menuratio = "Ratio Settings"
-	motif.option_info.menu_itemname_menugame_menuratio_ratiorecoverybase = "Ratio Recovery Base"
-	motif.option_info.menu_itemname_menugame_menuratio_ratiorecoverybonus = "Ratio Recovery Bonus"
+	motif.option_info.menu_itemname_menugame_menuturns_back = "$(option_info.menu.itemname.menugame.menuturns.back)"
+	motif.option_info.menu_itemname_menugame_menuratio = "$(option_info.menu.itemname.menugame.menuratio)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratiorecoverybase = "$(option_info.menu.itemname.menugame.menuratio.ratiorecoverybase)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratiorecoverybonus = "$(option_info.menu.itemname.menugame.menuratio.ratiorecoverybonus)"
 	motif.option_info.menu_itemname_menugame_menuratio_empty = ""
-	motif.option_info.menu_itemname_menugame_menuratio_ratio1life = "Ratio 1 Life"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio1attack = "Ratio 1 Damage"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio2life = "Ratio 2 Life"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio2attack = "Ratio 2 Damage"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio3life = "Ratio 3 Life"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio3attack = "Ratio 3 Damage"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio4life = "Ratio 4 Life"
-	motif.option_info.menu_itemname_menugame_menuratio_ratio4attack = "Ratio 4 Damage"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio1life = "$(option_info.menu.itemname.menugame.menuratio.ratio1life)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio1attack = "$(option_info.menu.itemname.menugame.menuratio.ratio1attack)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio2life = "$(option_info.menu.itemname.menugame.menuratio.ratio2life)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio2attack = "$(option_info.menu.itemname.menugame.menuratio.ratio2attack)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio3life = "$(option_info.menu.itemname.menugame.menuratio.ratio3life)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio3attack = "$(option_info.menu.itemname.menugame.menuratio.ratio3attack)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio4life = "$(option_info.menu.itemname.menugame.menuratio.ratio4life)"
+	motif.option_info.menu_itemname_menugame_menuratio_ratio4attack = "$(option_info.menu.itemname.menugame.menuratio.ratio4attack)"
 	motif.option_info.menu_itemname_menugame_menuratio_empty = ""
-	motif.option_info.menu_itemname_menugame_menuratio_back = "Back"
-	motif.option_info.menu_itemname_menugame_back = "Back"
+	motif.option_info.menu_itemname_menugame_menuratio_back = "$(option_info.menu.itemname.menugame.menuratio.back)"
+	motif.option_info.menu_itemname_menugame_back = "$(option_info.menu.itemname.menugame.back)"
 
-	motif.option_info.menu_itemname_menuvideo = "Video Settings"
-	motif.option_info.menu_itemname_menuvideo_resolution = "Resolution" --reserved submenu
+	motif.option_info.menu_itemname_menuvideo = "$(option_info.menu.itemname.menuvideo)"
+	motif.option_info.menu_itemname_menuvideo_resolution = "$(option_info.menu.itemname.menuvideo.resolution)" --reserved submenu
 	-- Resolution is assigned based on values used in itemname suffix (e.g. 320x240)
 	motif.option_info.menu_itemname_menuvideo_resolution_320x240 = "320x240    (4:3 QVGA)"
 	motif.option_info.menu_itemname_menuvideo_resolution_640x480 = "640x480    (4:3 VGA)"
@@ -2018,56 +2035,60 @@ function motif.setBaseOptionInfo()
 	motif.option_info.menu_itemname_menuvideo_resolution_1600x900 = "1600x900   (16:9 HD+)"
 	motif.option_info.menu_itemname_menuvideo_resolution_1920x1080 = "1920x1080  (16:9 FHD)"
 	motif.option_info.menu_itemname_menuvideo_resolution_empty = ""
-	motif.option_info.menu_itemname_menuvideo_resolution_customres = "Custom"
-	motif.option_info.menu_itemname_menuvideo_resolution_back = "Back"
-	motif.option_info.menu_itemname_menuvideo_fullscreen = "Fullscreen"
-	motif.option_info.menu_itemname_menuvideo_vretrace = "VSync"
-	motif.option_info.menu_itemname_menuvideo_keepaspect = "Keep Aspect Ratio"
-	motif.option_info.menu_itemname_menuvideo_windowscalemode = "Bilinear Filtering"
+	motif.option_info.menu_itemname_menuvideo_resolution_customres = "$(option_info.menu.itemname.menuvideo.resolution.customres)"
+	motif.option_info.menu_itemname_menuvideo_resolution_back = "$(option_info.menu.itemname.menuvideo.resolution.back)"
+	motif.option_info.menu_itemname_menuvideo_fullscreen = "$(option_info.menu.itemname.menuvideo.fullscreen)"
+	motif.option_info.menu_itemname_menuvideo_vretrace = "$(option_info.menu.itemname.menuvideo.vretrace)"
+	motif.option_info.menu_itemname_menuvideo_keepaspect = "$(option_info.menu.itemname.menuvideo.keepaspect)"
+	motif.option_info.menu_itemname_menuvideo_windowscalemode = "$(option_info.menu.itemname.menuvideo.windowscalemode)"
 	motif.option_info.menu_itemname_menuvideo_msaa = "MSAA"
-	motif.option_info.menu_itemname_menuvideo_shaders = "Shaders" --reserved submenu
+	motif.option_info.menu_itemname_menuvideo_shaders = "$(option_info.menu.itemname.menuvideo.shaders)" --reserved submenu
 	-- This list is populated with shaders existing in 'external/shaders' directory
 	motif.option_info.menu_itemname_menuvideo_shaders_empty = ""
-	motif.option_info.menu_itemname_menuvideo_shaders_noshader = "Disable"
-	motif.option_info.menu_itemname_menuvideo_shaders_back = "Back"
+	motif.option_info.menu_itemname_menuvideo_shaders_noshader = "$(option_info.menu.itemname.menuvideo.shaders.noshader)"
+	motif.option_info.menu_itemname_menuvideo_shaders_back = "$(option_info.menu.itemname.menuvideo.shaders.back)"
+	motif.option_info.menu_itemname_menuvideo_postprocessing = "$(option_info.menu.itemname.menuvideo.postprocessing)" --reserved submenu
+	-- This list is populated with the parameters of the active preset
+	motif.option_info.menu_itemname_menuvideo_postprocessing_empty = ""
+	motif.option_info.menu_itemname_menuvideo_postprocessing_back = "$(option_info.menu.itemname.menuvideo.postprocessing.back)"
 	motif.option_info.menu_itemname_menuvideo_empty = ""
-	motif.option_info.menu_itemname_menuvideo_back = "Back"
+	motif.option_info.menu_itemname_menuvideo_back = "$(option_info.menu.itemname.menuvideo.back)"
 
-	motif.option_info.menu_itemname_menuaudio = "Audio Settings"
-	motif.option_info.menu_itemname_menuaudio_mastervolume = "Master Volume"
-	motif.option_info.menu_itemname_menuaudio_bgmvolume = "BGM Volume"
-	motif.option_info.menu_itemname_menuaudio_sfxvolume = "SFX Volume"
-	motif.option_info.menu_itemname_menuaudio_audioducking = "Audio Ducking"
-	motif.option_info.menu_itemname_menuaudio_stereoeffects = "Stereo Effects"
-	motif.option_info.menu_itemname_menuaudio_panningrange = "Panning Range"
+	motif.option_info.menu_itemname_menuaudio = "$(option_info.menu.itemname.menuaudio)"
+	motif.option_info.menu_itemname_menuaudio_mastervolume = "$(option_info.menu.itemname.menuaudio.mastervolume)"
+	motif.option_info.menu_itemname_menuaudio_bgmvolume = "$(option_info.menu.itemname.menuaudio.bgmvolume)"
+	motif.option_info.menu_itemname_menuaudio_sfxvolume = "$(option_info.menu.itemname.menuaudio.sfxvolume)"
+	motif.option_info.menu_itemname_menuaudio_audioducking = "$(option_info.menu.itemname.menuaudio.audioducking)"
+	motif.option_info.menu_itemname_menuaudio_stereoeffects = "$(option_info.menu.itemname.menuaudio.stereoeffects)"
+	motif.option_info.menu_itemname_menuaudio_panningrange = "$(option_info.menu.itemname.menuaudio.panningrange)"
 	motif.option_info.menu_itemname_menuaudio_empty = ""
-	motif.option_info.menu_itemname_menuaudio_back = "Back"
+	motif.option_info.menu_itemname_menuaudio_back = "$(option_info.menu.itemname.menuaudio.back)"
 
-	motif.option_info.menu_itemname_menuinput = "Input Settings"
-	motif.option_info.menu_itemname_menuinput_keyboard = "Key Config"
-	motif.option_info.menu_itemname_menuinput_gamepad = "Joystick Config"
+	motif.option_info.menu_itemname_menuinput = "$(option_info.menu.itemname.menuinput)"
+	motif.option_info.menu_itemname_menuinput_keyboard = "$(option_info.menu.itemname.menuinput.keyboard)"
+	motif.option_info.menu_itemname_menuinput_gamepad = "$(option_info.menu.itemname.menuinput.gamepad)"
 	motif.option_info.menu_itemname_menuinput_empty = ""
-	motif.option_info.menu_itemname_menuinput_inputdefault = "Default"
-	motif.option_info.menu_itemname_menuinput_back = "Back"
+	motif.option_info.menu_itemname_menuinput_inputdefault = "$(option_info.menu.itemname.menuinput.inputdefault)"
+	motif.option_info.menu_itemname_menuinput_back = "$(option_info.menu.itemname.menuinput.back)"
 
-	motif.option_info.menu_itemname_menuengine = "Engine Settings"
-	motif.option_info.menu_itemname_menuengine_players = "Players"
-	motif.option_info.menu_itemname_menuengine_debugkeys = "Debug Keys"
-	motif.option_info.menu_itemname_menuengine_debugmode = "Debug Mode"
+	motif.option_info.menu_itemname_menuengine = "$(option_info.menu.itemname.menuengine)"
+	motif.option_info.menu_itemname_menuengine_players = "$(option_info.menu.itemname.menuengine.players)"
+	motif.option_info.menu_itemname_menuengine_debugkeys = "$(option_info.menu.itemname.menuengine.debugkeys)"
+	motif.option_info.menu_itemname_menuengine_debugmode = "$(option_info.menu.itemname.menuengine.debugmode)"
 	motif.option_info.menu_itemname_menuengine_empty = ""
 	motif.option_info.menu_itemname_menuengine_helpermax = "HelperMax"
 	motif.option_info.menu_itemname_menuengine_projectilemax = "PlayerProjectileMax"
 	motif.option_info.menu_itemname_menuengine_explodmax = "ExplodMax"
 	motif.option_info.menu_itemname_menuengine_afterimagemax = "AfterImageMax"
 	motif.option_info.menu_itemname_menuengine_empty = ""
-	motif.option_info.menu_itemname_menuengine_back = "Back"
+	motif.option_info.menu_itemname_menuengine_back = "$(option_info.menu.itemname.menuengine.back)"
 
 	motif.option_info.menu_itemname_empty = ""
-	motif.option_info.menu_itemname_portchange = "Port Change"
-	motif.option_info.menu_itemname_default = "Default Values"
+	motif.option_info.menu_itemname_portchange = "$(option_info.menu.itemname.portchange)"
+	motif.option_info.menu_itemname_default = "$(option_info.menu.itemname.default)"
 	motif.option_info.menu_itemname_empty = ""
-	motif.option_info.menu_itemname_savereturn = "Save and Return"
-	motif.option_info.menu_itemname_return = "Return Without Saving"
+	motif.option_info.menu_itemname_savereturn = "$(option_info.menu.itemname.savereturn)"
+	motif.option_info.menu_itemname_return = "$(option_info.menu.itemname.return)"
 	-- Default options screen order.
 	if main.t_sort.option_info == nil then
 		main.t_sort.option_info = {}
@@ -2154,6 +2175,9 @@ function motif.setBaseOptionInfo()
 		"menuvideo_shaders_empty",
 		"menuvideo_shaders_noshader",
 		"menuvideo_shaders_back",
+		"menuvideo_postprocessing",
+		"menuvideo_postprocessing_empty",
+		"menuvideo_postprocessing_back",
 		"menuvideo_empty",
 		"menuvideo_back",
 		"menuaudio",
@@ -2193,18 +2217,18 @@ function motif.setBaseOptionInfo()
 end
 
 function motif.setBaseMenuInfo()
-	motif.menu_info.menu_itemname_back = "Continue"
-	motif.menu_info.menu_itemname_menuinput = "Button Config"
-	motif.menu_info.menu_itemname_menuinput_keyboard = "Key Config"
-	motif.menu_info.menu_itemname_menuinput_gamepad = "Joystick Config"
+	motif.menu_info.menu_itemname_back = "$(menu_info.menu.itemname.back)"
+	motif.menu_info.menu_itemname_menuinput = "$(menu_info.menu.itemname.menuinput)"
+	motif.menu_info.menu_itemname_menuinput_keyboard = "$(menu_info.menu.itemname.menuinput.keyboard)"
+	motif.menu_info.menu_itemname_menuinput_gamepad = "$(menu_info.menu.itemname.menuinput.gamepad)"
 	motif.menu_info.menu_itemname_menuinput_empty = ""
-	motif.menu_info.menu_itemname_menuinput_inputdefault = "Default"
-	motif.menu_info.menu_itemname_menuinput_back = "Back"
+	motif.menu_info.menu_itemname_menuinput_inputdefault = "$(menu_info.menu.itemname.menuinput.inputdefault)"
+	motif.menu_info.menu_itemname_menuinput_back = "$(menu_info.menu.itemname.menuinput.back)"
 	--menu_itemname_reset = "Round Reset"
 	--menu_itemname_reload = "Rematch"
-	motif.menu_info.menu_itemname_commandlist = "Command List"
-	motif.menu_info.menu_itemname_characterchange = "Character Change"
-	motif.menu_info.menu_itemname_exit = "Exit"
+	motif.menu_info.menu_itemname_commandlist = "$(menu_info.menu.itemname.commandlist)"
+	motif.menu_info.menu_itemname_characterchange = "$(menu_info.menu.itemname.characterchange)"
+	motif.menu_info.menu_itemname_exit = "$(menu_info.menu.itemname.exit)"
 	if main.t_sort.menu_info == nil then
 		main.t_sort.menu_info = {}
 	end
@@ -2226,27 +2250,30 @@ function motif.setBaseMenuInfo()
 end
 
 function motif.setBaseTrainingInfo()
-	motif.training_info.menu_itemname_back = "Continue"
-	motif.training_info.menu_itemname_menutraining = "Training Menu"
-	motif.training_info.menu_itemname_menutraining_dummycontrol = "Dummy Control"
-	motif.training_info.menu_itemname_menutraining_ailevel = "AI Level"
-	motif.training_info.menu_itemname_menutraining_dummymode = "Dummy Mode"
-	motif.training_info.menu_itemname_menutraining_guardmode = "Guard Mode"
-	motif.training_info.menu_itemname_menutraining_fallrecovery = "Fall Recovery"
-	motif.training_info.menu_itemname_menutraining_distance = "Distance"
-	motif.training_info.menu_itemname_menutraining_buttonjam = "Button Jam"
-	motif.training_info.menu_itemname_menutraining_back = "Back"
-	motif.training_info.menu_itemname_menuinput = "Button Config"
-	motif.training_info.menu_itemname_menuinput_keyboard = "Key Config"
-	motif.training_info.menu_itemname_menuinput_gamepad = "Joystick Config"
+	motif.training_info.menu_itemname_back = "$(training_info.menu.itemname.back)"
+	motif.training_info.menu_itemname_menutraining = "$(training_info.menu.itemname.menutraining)"
+	motif.training_info.menu_itemname_menutraining_dummycontrol = "$(training_info.menu.itemname.menutraining.dummycontrol)"
+	motif.training_info.menu_itemname_menutraining_ailevel = "$(training_info.menu.itemname.menutraining.ailevel)"
+	motif.training_info.menu_itemname_menutraining_dummymode = "$(training_info.menu.itemname.menutraining.dummymode)"
+	motif.training_info.menu_itemname_menutraining_guardmode = "$(training_info.menu.itemname.menutraining.guardmode)"
+	motif.training_info.menu_itemname_menutraining_fallrecovery = "$(training_info.menu.itemname.menutraining.fallrecovery)"
+	motif.training_info.menu_itemname_menutraining_distance = "$(training_info.menu.itemname.menutraining.distance)"
+	motif.training_info.menu_itemname_menutraining_buttonjam = "$(training_info.menu.itemname.menutraining.buttonjam)"
+	motif.training_info.menu_itemname_menutraining_recordslot = "$(training_info.menu.itemname.menutraining.recordslot)"
+	motif.training_info.menu_itemname_menutraining_record = "$(training_info.menu.itemname.menutraining.record)"
+	motif.training_info.menu_itemname_menutraining_playback = "$(training_info.menu.itemname.menutraining.playback)"
+	motif.training_info.menu_itemname_menutraining_back = "$(training_info.menu.itemname.menutraining.back)"
+	motif.training_info.menu_itemname_menuinput = "$(training_info.menu.itemname.menuinput)"
+	motif.training_info.menu_itemname_menuinput_keyboard = "$(training_info.menu.itemname.menuinput.keyboard)"
+	motif.training_info.menu_itemname_menuinput_gamepad = "$(training_info.menu.itemname.menuinput.gamepad)"
 	motif.training_info.menu_itemname_menuinput_empty = ""
-	motif.training_info.menu_itemname_menuinput_inputdefault = "Default"
-	motif.training_info.menu_itemname_menuinput_back = "Back"
+	motif.training_info.menu_itemname_menuinput_inputdefault = "$(training_info.menu.itemname.menuinput.inputdefault)"
+	motif.training_info.menu_itemname_menuinput_back = "$(training_info.menu.itemname.menuinput.back)"
 	--motif.training_info.menu_itemname_reset = "Round Reset"
 	--motif.training_info.menu_itemname_reload = "Rematch"
-	motif.training_info.menu_itemname_commandlist = "Command List"
-	motif.training_info.menu_itemname_characterchange = "Character Change"
-	motif.training_info.menu_itemname_exit = "Exit"
+	motif.training_info.menu_itemname_commandlist = "$(training_info.menu.itemname.commandlist)"
+	motif.training_info.menu_itemname_characterchange = "$(training_info.menu.itemname.characterchange)"
+	motif.training_info.menu_itemname_exit = "$(training_info.menu.itemname.exit)"
 	if main.t_sort.training_info == nil then
 		main.t_sort.training_info = {}
 	end
@@ -2260,6 +2287,9 @@ function motif.setBaseTrainingInfo()
 		"menutraining_fallrecovery",
 		"menutraining_distance",
 		"menutraining_buttonjam",
+		"menutraining_recordslot",
+		"menutraining_record",
+		"menutraining_playback",
 		"menutraining_back",
 		"menuinput",
 		"menuinput_keyboard",
@@ -2560,7 +2590,13 @@ for _, v in ipairs({
 	{group = 'attract_mode', param = 'intro_storyboard', dirs = {motif.fileDir, '', 'data/'}},
 	{group = 'attract_mode', param = 'start_storyboard', dirs = {motif.fileDir, '', 'data/'}},
 }) do
-	motif[v.group][v.param] = searchFile(motif[v.group][v.param], v.dirs)
+	if type(motif[v.group][v.param]) == 'table' then --music playlist
+		for k, f in ipairs(motif[v.group][v.param]) do
+			motif[v.group][v.param][k] = searchFile(tostring(f), v.dirs)
+		end
+	else
+		motif[v.group][v.param] = searchFile(motif[v.group][v.param], v.dirs)
+	end
 end
 
 motif.files.spr_data = sffNew(motif.files.spr)
@@ -2854,6 +2890,23 @@ for i = 1, 2 do
 	end
 end
 
+--languages with string table files
+for _, v in ipairs(getLanguages()) do
+	local found = false
+	for _, c in ipairs(motif.languages.languages) do
+		if c == v.code then
+			found = true
+			break
+		end
+	end
+	if not found then
+		table.insert(motif.languages.languages, v.code)
+	end
+	if motif.languages[v.code] == nil then
+		motif.languages[v.code] = v.name
+	end
+end
+
 --disabled scaling if element uses default values (non-existing in mugen)
 motif.defaultMenu = motif.menu_info.menu_uselocalcoord == 0
 motif.defaultOptions = motif.option_info.menu_uselocalcoord == 0
@@ -60,6 +60,64 @@ local function f_externalShaderName()
 	return motif.option_info.menu_valuename_disabled
 end
 
+local function f_postProcessingName()
+	if getPostProcessingPreset() ~= '' then
+		return getPostProcessingPreset()
+	end
+	return motif.option_info.menu_valuename_disabled
+end
+
+--fill the post-processing submenu with the parameters of the active preset
+local function f_postProcessingItems(t, params)
+	for i = #t.items, 1, -1 do
+		if t.items[i].postprocessing then
+			table.remove(t.items, i)
+		end
+	end
+	if config.PostProcessingParams == nil then
+		config.PostProcessingParams = json.decode('{}') --saved as an object even if empty
+	end
+	local t_menuWindow = main.f_menuWindow(motif.option_info)
+	for k, p in ipairs(params) do
+		local key = p.shader .. '.' .. p.name
+		local value = p.value
+		options.t_itemname['postprocessing_' .. key] = function(t, item, cursorPosY, moveTxt)
+			local step = p.step
+			if step <= 0 then
+				step = 0.01
+			end
+			local v = value
+			if main.f_input(main.t_players, {'$F'}) then
+				v = value + step
+			elseif main.f_input(main.t_players, {'$B'}) then
+				v = value - step
+			end
+			if p.min < p.max then
+				v = math.max(p.min, math.min(p.max, v))
+			end
+			v = options.f_precision(v, '%.04f')
+			if v ~= value then
+				sndPlay(motif.files.snd_data, motif.option_info.cursor_move_snd[1], motif.option_info.cursor_move_snd[2])
+				value = v
+				config.PostProcessingParams[key] = v
+				setPostProcessingParam(p.shader, p.name, v)
+				t.items[item].vardisplay = v
+				options.modified = true
+			end
+			return true
+		end
+		table.insert(t.items, k, {
+			data = text:create({window = t_menuWindow}),
+			itemname = 'postprocessing_' .. key,
+			displayname = p.label ~= '' and p.label or p.name,
+			vardata = text:create({window = t_menuWindow}),
+			vardisplay = options.f_precision(value, '%.04f'),
+			selected = false,
+			postprocessing = true,
+		})
+	end
+end
+
 -- Associative elements table storing functions controlling behaviour of each
 -- option screen item. Can be appended via external module.
 options.t_itemname = {
@@ -171,6 +229,8 @@ options.t_itemname = {
 			config.PanningRange = 30
 			config.Players = 4
 			--config.PngSpriteFilter = true
+			config.PostProcessingParams = json.decode('{}')
+			config.PostProcessingPreset = ''
 			config.PostProcessingShader = 0
 			config.QuickContinue = false
 			config.RatioAttack = {0.82, 1.0, 1.17, 1.30}
@@ -227,6 +287,10 @@ options.t_itemname = {
 			setMaxHelper(config.MaxHelper)
 			setMaxPlayerProjectile(config.MaxPlayerProjectile)
 			setPanningRange(config.PanningRange)
+			setPostProcessingPreset(config.PostProcessingPreset)
+			for _, p in ipairs(getPostProcessingParams()) do
+				setPostProcessingParam(p.shader, p.name, p.default)
+			end
 			setPowerShare(1, config.TeamPowerShare)
 			setPowerShare(2, config.TeamPowerShare)
 			setStereoEffects(config.StereoEffects)
@@ -294,7 +358,7 @@ options.t_itemname = {
 				config.Language = motif.languages.languages[currentLanguage + 1]
 			end
 			options.modified = true
-			options.needReload = true
+			setLanguage(config.Language)
 			loadstring("sfs = " .. "motif.languages." .. config.Language)()
 			t.items[item].vardisplay = sfs or config.Language
 		elseif main.f_input(main.t_players, {'$B'}) then
@@ -312,7 +376,7 @@ options.t_itemname = {
 				config.Language = motif.languages.languages[currentLanguage - 1]
 			end
 			options.modified = true
-			options.needReload = true
+			setLanguage(config.Language)
 			loadstring("sfs = " .. "motif.languages." .. config.Language)()
 			t.items[item].vardisplay = sfs or config.Language
 		end
@@ -961,12 +1025,46 @@ options.t_itemname = {
 		end
 		return true
 	end,
+	--Post-processing preset (left/right) and its parameters (submenu)
+	['postprocessing'] = function(t, item, cursorPosY, moveTxt)
+		local presets = getPostProcessingPresets()
+		if main.f_input(main.t_players, {'$F', '$B'}) and #presets > 0 then
+			sndPlay(motif.files.snd_data, motif.option_info.cursor_move_snd[1], motif.option_info.cursor_move_snd[2])
+			--index 0 disables the preset
+			local idx = 0
+			for k, v in ipairs(presets) do
+				if v == getPostProcessingPreset() then
+					idx = k
+				end
+			end
+			if main.f_input(main.t_players, {'$F'}) then
+				idx = (idx + 1) % (#presets + 1)
+			else
+				idx = (idx - 1) % (#presets + 1)
+			end
+			config.PostProcessingPreset = presets[idx] or ''
+			setPostProcessingPreset(config.PostProcessingPreset)
+			t.items[item].vardisplay = f_postProcessingName()
+			options.modified = true
+		elseif main.f_input(main.t_players, {'pal', 's'}) then
+			local params = getPostProcessingParams()
+			if #params == 0 then
+				main.f_warning(main.f_extractText(motif.warning_info.text_postprocessing_text), motif.optionbgdef)
+				return true
+			end
+			sndPlay(motif.files.snd_data, motif.option_info.cursor_done_snd[1], motif.option_info.cursor_done_snd[2])
+			f_postProcessingItems(t.submenu[t.items[item].itemname], params)
+			t.submenu[t.items[item].itemname].loop()
+		end
+		return true
+	end,
 	--Disable (shader)
 	['noshader'] = function(t, item, cursorPosY, moveTxt)
 		if main.f_input(main.t_players, {'pal', 's'}) then
 			sndPlay(motif.files.snd_data, motif.option_info.cancel_snd[1], motif.option_info.cancel_snd[2])
 			config.ExternalShaders = {}
 			config.PostProcessingShader = 0
+			config.PostProcessingPreset = ''
 			options.modified = true
 			options.needReload = true
 			return false
@@ -1508,6 +1606,9 @@ options.t_vardisplay = {
 	['sfxvolume'] = function()
 		return config.VolumeSfx .. '%'
 	end,
+	['postprocessing'] = function()
+		return f_postProcessingName()
+	end,
 	['shaders'] = function()
 		return f_externalShaderName()
 	end,
@@ -1574,6 +1675,7 @@ function options.f_start()
 						sndPlay(motif.files.snd_data, motif.option_info.cursor_done_snd[1], motif.option_info.cursor_done_snd[2])
 						config.ExternalShaders = {path .. filename}
 						config.PostProcessingShader = 1
+						config.PostProcessingPreset = ''
 						return false
 					end
 					return true
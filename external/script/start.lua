@@ -595,14 +595,19 @@ function start.f_setMusic(num, data)
 			side = 1
 		end
 	end
-	-- bgmratio.life, bgmtrigger.life
-	for k, v in pairs({bgmratio_life = 30, bgmtrigger_life = 1}) do
+	-- bgmratio.life, bgmtrigger.life, bgmcrossfade
+	for k, v in pairs({bgmratio_life = 30, bgmtrigger_life = 1, bgmcrossfade = 0}) do
 		if main.t_selStages[num] ~= nil and main.t_selStages[num][k] ~= nil then
 			start.t_music[k] = main.t_selStages[num][k]
 		else
 			start.t_music[k] = v
 		end
 	end
+	-- bgmplaylist, bgmplaylist.shuffle
+	if main.t_selStages[num] ~= nil and main.t_selStages[num].bgmplaylist ~= nil and #main.t_selStages[num].bgmplaylist > 0 then
+		start.t_music.bgmplaylist = main.t_selStages[num].bgmplaylist
+		start.t_music.bgmplaylist_shuffle = main.t_selStages[num].bgmplaylist_shuffle == 1
+	end
 end
 
 --remaps palette based on button press and character's keymap settings
@@ -4035,11 +4040,14 @@ function start.f_stageMusic()
 			end
 			-- final round music assigned
 			if roundNo > 1 and roundtype() == 3 and start.t_music.musicfinal.bgmusic ~= nil then
-				main.f_playBGM(false, start.t_music.musicfinal.bgmusic, 1, start.t_music.musicfinal.bgmvolume, start.t_music.musicfinal.bgmloopstart, start.t_music.musicfinal.bgmloopend)
+				main.f_playBGM(false, start.t_music.musicfinal.bgmusic, 1, start.t_music.musicfinal.bgmvolume, start.t_music.musicfinal.bgmloopstart, start.t_music.musicfinal.bgmloopend, start.t_music.bgmcrossfade)
+			-- stage playlist assigned, continues between rounds
+			elseif start.t_music.bgmplaylist ~= nil then
+				main.f_playBGM(matchno() == 1 and start.bgmround == 1, start.t_music.bgmplaylist, 1, 100, 0, 0, start.t_music.bgmcrossfade, start.t_music.bgmplaylist_shuffle)
 			-- music exists for this round
 			elseif start.t_music.music[roundNo] ~= nil then
 				-- interrupt same track playing only on round 1 of first match (skips continuous survival etc.)
-				main.f_playBGM(matchno() == 1 and roundNo == 1, start.t_music.music[roundNo].bgmusic, 1, start.t_music.music[roundNo].bgmvolume, start.t_music.music[roundNo].bgmloopstart, start.t_music.music[roundNo].bgmloopend)
+				main.f_playBGM(matchno() == 1 and roundNo == 1, start.t_music.music[roundNo].bgmusic, 1, start.t_music.music[roundNo].bgmvolume, start.t_music.music[roundNo].bgmloopstart, start.t_music.music[roundNo].bgmloopend, start.t_music.bgmcrossfade)
 			-- stop versus screen track or life bgm even if stage music is not assigned
 			elseif start.bgmround == 1 or start.bgmstate == 1 then
 				main.f_playBGM(true)
@@ -4063,7 +4071,7 @@ function start.f_stageMusic()
 				end
 				if ok then
 					if start.t_music.bgmtrigger_life == 1 or roundtype() >= 2 then
-						main.f_playBGM(true, start.t_music.musiclife.bgmusic, 1, start.t_music.musiclife.bgmvolume, start.t_music.musiclife.bgmloopstart, start.t_music.musiclife.bgmloopend)
+						main.f_playBGM(true, start.t_music.musiclife.bgmusic, 1, start.t_music.musiclife.bgmvolume, start.t_music.musiclife.bgmloopstart, start.t_music.musiclife.bgmloopend, start.t_music.bgmcrossfade)
 						start.bgmstate = 1
 						break
 					end
@@ -4074,7 +4082,7 @@ function start.f_stageMusic()
 	elseif #start.t_music.musicvictory > 0 and start.bgmstate ~= -1 and roundstate() == 3 then
 		for i = 1, 2 do
 			if start.t_music.musicvictory[i] ~= nil and player(i) and win() and (roundtype() == 1 or roundtype() == 3) then --assign sys.debugWC to player i
-				main.f_playBGM(true, start.t_music.musicvictory[i].bgmusic, 1, start.t_music.musicvictory[i].bgmvolume, start.t_music.musicvictory[i].bgmloopstart, start.t_music.musicvictory[i].bgmloopend)
+				main.f_playBGM(true, start.t_music.musicvictory[i].bgmusic, 1, start.t_music.musicvictory[i].bgmvolume, start.t_music.musicvictory[i].bgmloopstart, start.t_music.musicvictory[i].bgmloopend, start.t_music.bgmcrossfade)
 				start.bgmstate = -1
 				break
 			end
@@ -7,25 +7,28 @@ require (
 	github.com/go-gl/gl v0.0.0-20231021071112-07e5d0ea2e71
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20231124074035-2de0cf0c80af
 	github.com/go-gl/mathgl v1.0.0
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	github.com/gopxl/beep/v2 v2.0.2
 	github.com/leonkasovan/gl v0.0.0-20240302015147-1ee9f5b02a16
 	github.com/leonkasovan/glfont v0.0.0-20240116222114-fd1d8a52b71d
 	github.com/lukegb/dds v0.0.0-20190402175749-8b7170e64003
+	github.com/mewkiz/flac v1.0.12
 	github.com/qmuntal/gltf v0.24.2
 	github.com/veandco/go-sdl2 v0.4.38
 	github.com/yuin/gopher-lua v1.1.0
+	golang.org/x/image v0.19.0
 	golang.org/x/mobile v0.0.0-20221110043201-43a038452099
 )
 
 require (
 	github.com/ebitengine/oto/v3 v3.2.0 // indirect
 	github.com/ebitengine/purego v0.7.1 // indirect
-	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/hajimehoshi/go-mp3 v0.3.4 // indirect
+	github.com/icza/bitio v1.1.0 // indirect
 	github.com/jfreymuth/oggvorbis v1.0.5 // indirect
 	github.com/jfreymuth/vorbis v1.0.2 // indirect
+	github.com/mewkiz/pkg v0.0.0-20230226050401-4010bf0fec14 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/samhocevar/go-meltysynth v0.0.0-20230403180939-aca4a036cb16 // indirect
-	golang.org/x/image v0.19.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 )
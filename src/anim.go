@@ -724,7 +724,7 @@ func (a *Animation) Draw(window *[4]int32, x, y, xcs, ycs, xs, xbs, ys,
 	}
 	mask := int32(a.mask)
 	rp := RenderParams{
-		a.spr.Tex, paltex, a.spr.Size,
+		a.spr.Tex, paltex, a.spr.uv, a.spr.Size,
 		x * sys.widthScale,
 		y * sys.heightScale, a.tile, xs * sys.widthScale, xcs * xbs * h * sys.widthScale,
 		ys * sys.heightScale, 1, xcs * rxadd * sys.widthScale / sys.heightScale, h, v, rot,
@@ -746,7 +746,7 @@ func (a *Animation) ShadowDraw(window *[4]int32, x, y, xscl, yscl, vscl, rxadd f
 
 	mask := int32(a.mask)
 	rp := RenderParams{
-		a.spr.Tex, nil, a.spr.Size,
+		a.spr.Tex, nil, a.spr.uv, a.spr.Size,
 		AbsF(xscl*h) * float32(a.spr.Offset[0]) * sys.widthScale,
 		AbsF(yscl*v) * float32(a.spr.Offset[1]) * sys.heightScale, a.tile,
 		xscl * h * sys.widthScale, xscl * h * sys.widthScale,
@@ -870,11 +870,13 @@ type SprData struct {
 	projection  int32
 	fLength     float32
 	window      [4]float32
+	model       *CharModel // Drawn instead of the sprite if clip is set
+	clip        *GLTFAnimation
 }
 type DrawList []*SprData
 
 func (dl *DrawList) add(sd *SprData, sc, salp int32, so, fo float32) {
-	if sys.frameSkip || sd.anim == nil || sd.anim.spr == nil {
+	if sys.frameSkip || sd.anim == nil || (sd.anim.spr == nil && sd.clip == nil) {
 		return
 	}
 	if sd.rot.angle != 0 {
@@ -923,7 +925,9 @@ func (dl DrawList) draw(x, y, scl float32) {
 				(sys.cam.GroundLevel()+sys.cam.Offset[1]-sys.envShake.getOffset())/cs -
 					(y/cs - s.pos[1])}
 		}
-		if s.window[0] != 0 || s.window[1] != 0 || s.window[2] != 0 || s.window[3] != 0 {
+		if s.clip != nil {
+			s.model.draw(s, p[0], p[1], cs)
+		} else if s.window[0] != 0 || s.window[1] != 0 || s.window[2] != 0 || s.window[3] != 0 {
 			w := s.window
 			if w[0] > w[2] {
 				w[0], w[2] = w[2], w[0]
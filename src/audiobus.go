@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/speaker"
+)
+
+// Audio buses, in the order they are mixed
+const (
+	BusVoice = iota
+	BusSfx
+	BusSystem
+	BusAmbience
+	BusBgm
+	NumAudioBuses
+)
+
+var audioBusNames = [NumAudioBuses]string{"voice", "sfx", "system", "ambience", "bgm"}
+
+// Bus index from its name, or -1 if there is no such bus
+func audioBusIndex(name string) int {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for i, n := range audioBusNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// ------------------------------------------------------------------
+// AudioBusSettings
+
+// Settings of a bus, as read from the AudioBuses section of config.json.
+// Missing keys keep their default values.
+type AudioBusSettings struct {
+	Volume          float32 // Percent
+	Mute            bool
+	LowPass         float32 // Cutoff frequency in Hz, 0 disables the filter
+	Reverb          float32 // Wet level, 0 to 1
+	ReverbRoom      float32 // Room size, 0 to 1
+	Compressor      float32 // Threshold in dB, 0 disables the compressor
+	CompressorRatio float32
+	DuckBy          string  // Bus whose signal lowers the volume of this one
+	DuckAmount      float32 // Gain reduction in dB while ducked
+	DuckAttack      float32 // Milliseconds
+	DuckRelease     float32 // Milliseconds
+}
+
+var defaultAudioBusSettings = AudioBusSettings{
+	Volume:          100,
+	ReverbRoom:      0.5,
+	CompressorRatio: 4,
+	DuckAttack:      10,
+	DuckRelease:     300,
+}
+
+func (s *AudioBusSettings) UnmarshalJSON(data []byte) error {
+	type plain AudioBusSettings
+	p := plain(defaultAudioBusSettings)
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*s = AudioBusSettings(p)
+	return nil
+}
+
+// Numeric parameter by its lowercase name, for automation
+func (s *AudioBusSettings) param(name string) *float32 {
+	switch name {
+	case "volume":
+		return &s.Volume
+	case "lowpass":
+		return &s.LowPass
+	case "reverb":
+		return &s.Reverb
+	case "reverbroom":
+		return &s.ReverbRoom
+	case "compressor":
+		return &s.Compressor
+	case "compressorratio":
+		return &s.CompressorRatio
+	case "duckamount":
+		return &s.DuckAmount
+	case "duckattack":
+		return &s.DuckAttack
+	case "duckrelease":
+		return &s.DuckRelease
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------
+// AudioBus
+
+// AudioBus mixes the sounds routed to it and runs the mix through its
+// inserts: low-pass filter, reverb, compressor, sidechain ducking and
+// volume, in that order. Buses never drain, so they can stay in the output
+// graph for the whole session.
+type AudioBus struct {
+	name      string
+	mixer     beep.Mixer
+	settings  AudioBusSettings
+	sidechain *AudioBus
+	lowpass   [2]biquad
+	reverb    *reverb
+	// Smoothed gains, linear
+	gain, duck, comp float64
+	// Peak envelope of the output, used when this bus is a sidechain
+	level float64
+	ramps map[string]*audioBusRamp
+}
+
+type audioBusRamp struct {
+	from, to      float32
+	frame, frames int32
+}
+
+// Create every bus from the configured settings
+func newAudioBuses(settings map[string]AudioBusSettings) [NumAudioBuses]*AudioBus {
+	var buses [NumAudioBuses]*AudioBus
+	for i, name := range audioBusNames {
+		st, ok := settings[name]
+		if !ok {
+			st = defaultAudioBusSettings
+		}
+		buses[i] = &AudioBus{name: name, settings: st, duck: 1, comp: 1,
+			ramps: make(map[string]*audioBusRamp)}
+	}
+	for _, b := range buses {
+		b.setDuckBy(b.settings.DuckBy, buses)
+		b.gain = b.targetGain()
+		b.apply()
+	}
+	return buses
+}
+
+// Route a streamer to this bus
+func (b *AudioBus) Add(s beep.Streamer) {
+	speaker.Lock()
+	b.mixer.Add(s)
+	speaker.Unlock()
+}
+
+func (b *AudioBus) setDuckBy(name string, buses [NumAudioBuses]*AudioBus) bool {
+	b.settings.DuckBy, b.sidechain = name, nil
+	if i := audioBusIndex(name); i >= 0 && buses[i] != b {
+		b.sidechain = buses[i]
+	} else if name != "" {
+		sys.errLog.Printf("Invalid DuckBy bus for %v: %v", b.name, name)
+		return false
+	}
+	return true
+}
+
+// Duck this bus by the signal of another one, or stop ducking if the name
+// is empty
+func (b *AudioBus) SetDuckBy(name string) bool {
+	speaker.Lock()
+	defer speaker.Unlock()
+	return b.setDuckBy(name, sys.audioBuses)
+}
+
+func (b *AudioBus) targetGain() float64 {
+	if b.settings.Mute {
+		return 0
+	}
+	return math.Max(0, float64(b.settings.Volume)/100)
+}
+
+// Recompute the filter coefficients after a settings change
+func (b *AudioBus) apply() {
+	rate := float64(sys.audioSampleRate)
+	for i := range b.lowpass {
+		b.lowpass[i].setLowPass(float64(b.settings.LowPass), rate)
+	}
+	if b.settings.Reverb > 0 && b.reverb == nil {
+		b.reverb = newReverb(rate)
+	}
+	if b.reverb != nil {
+		b.reverb.setRoom(float64(b.settings.ReverbRoom))
+	}
+}
+
+// Current value of a parameter. Mute is returned as 0 or 1.
+func (b *AudioBus) Param(name string) (float32, bool) {
+	name = strings.ToLower(name)
+	if name == "mute" {
+		return float32(Btoi(b.settings.Mute)), true
+	}
+	if p := b.settings.param(name); p != nil {
+		return *p, true
+	}
+	return 0, false
+}
+
+// Set a parameter, moving to the new value over the given number of frames.
+// Mute is switched immediately.
+func (b *AudioBus) SetParam(name string, value float32, frames int32) bool {
+	name = strings.ToLower(name)
+	if name == "mute" {
+		speaker.Lock()
+		b.settings.Mute = value != 0
+		speaker.Unlock()
+		return true
+	}
+	p := b.settings.param(name)
+	if p == nil {
+		return false
+	}
+	if frames <= 0 {
+		delete(b.ramps, name)
+		speaker.Lock()
+		*p = value
+		b.apply()
+		speaker.Unlock()
+		return true
+	}
+	from := *p
+	if name == "lowpass" && from <= 0 {
+		// Sweep down from the top of the audible range
+		from = float32(sys.audioSampleRate) / 2
+	}
+	b.ramps[name] = &audioBusRamp{from: from, to: value, frames: frames}
+	return true
+}
+
+// Advance parameter ramps by one frame
+func (b *AudioBus) Tick() {
+	if len(b.ramps) == 0 {
+		return
+	}
+	speaker.Lock()
+	for name, r := range b.ramps {
+		r.frame++
+		*b.settings.param(name) = r.from + (r.to-r.from)*float32(r.frame)/float32(r.frames)
+		if r.frame >= r.frames {
+			delete(b.ramps, name)
+		}
+	}
+	b.apply()
+	speaker.Unlock()
+}
+
+func (b *AudioBus) Stream(samples [][2]float64) (n int, ok bool) {
+	n, _ = b.mixer.Stream(samples)
+	rate := float64(sys.audioSampleRate)
+	gainCoef := smoothingCoef(5, rate)
+	levelCoef := smoothingCoef(50, rate)
+	ducking := b.sidechain != nil && b.settings.DuckAmount != 0
+	duckGain := math.Pow(10, -math.Abs(float64(b.settings.DuckAmount))/20)
+	duckAttack := smoothingCoef(float64(b.settings.DuckAttack), rate)
+	duckRelease := smoothingCoef(float64(b.settings.DuckRelease), rate)
+	compOn := b.settings.Compressor < 0
+	compThreshold := float64(b.settings.Compressor)
+	compSlope := 1 - 1/math.Max(1, float64(b.settings.CompressorRatio))
+	compAttack, compRelease := smoothingCoef(5, rate), smoothingCoef(100, rate)
+	target := b.targetGain()
+	for i := range samples[:n] {
+		s := &samples[i]
+		if b.settings.LowPass > 0 {
+			s[0] = b.lowpass[0].process(s[0])
+			s[1] = b.lowpass[1].process(s[1])
+		}
+		if b.reverb != nil && b.settings.Reverb > 0 {
+			b.reverb.process(s, float64(b.settings.Reverb))
+		}
+		if compOn {
+			peak := math.Max(math.Abs(s[0]), math.Abs(s[1]))
+			cg := 1.0
+			if peak > 0 {
+				if over := 20*math.Log10(peak) - compThreshold; over > 0 {
+					cg = math.Pow(10, -over*compSlope/20)
+				}
+			}
+			// Fast attack, slow release
+			if cg < b.comp {
+				b.comp += (cg - b.comp) * (1 - compAttack)
+			} else {
+				b.comp += (cg - b.comp) * (1 - compRelease)
+			}
+			s[0] *= b.comp
+			s[1] *= b.comp
+		}
+		if ducking {
+			// The sidechain bus is mixed first, so its level is current
+			if b.sidechain.level > 0.01 {
+				b.duck += (duckGain - b.duck) * (1 - duckAttack)
+			} else {
+				b.duck += (1 - b.duck) * (1 - duckRelease)
+			}
+		} else {
+			b.duck = 1
+		}
+		b.gain += (target - b.gain) * (1 - gainCoef)
+		g := b.gain * b.duck
+		s[0] *= g
+		s[1] *= g
+		peak := math.Max(math.Abs(s[0]), math.Abs(s[1]))
+		if peak > b.level {
+			b.level = peak
+		} else {
+			b.level *= levelCoef
+		}
+	}
+	return n, true
+}
+
+func (b *AudioBus) Err() error {
+	return nil
+}
+
+// Per sample smoothing factor of a one pole filter with the given time
+// constant in milliseconds
+func smoothingCoef(ms, rate float64) float64 {
+	if ms <= 0 {
+		return 0
+	}
+	return math.Exp(-1000 / (ms * rate))
+}
+
+// ------------------------------------------------------------------
+// biquad
+
+// Second order low-pass filter (RBJ cookbook, Butterworth Q)
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+	cutoff             float64
+}
+
+func (f *biquad) setLowPass(cutoff, rate float64) {
+	if cutoff == f.cutoff {
+		return
+	}
+	f.cutoff = cutoff
+	if cutoff <= 0 || cutoff >= rate/2 {
+		f.b0, f.b1, f.b2, f.a1, f.a2 = 1, 0, 0, 0, 0
+		return
+	}
+	w := 2 * math.Pi * cutoff / rate
+	alpha := math.Sin(w) / math.Sqrt2 // Q = 1/sqrt(2)
+	cos := math.Cos(w)
+	a0 := 1 + alpha
+	f.b0 = (1 - cos) / 2 / a0
+	f.b1 = (1 - cos) / a0
+	f.b2 = f.b0
+	f.a1 = -2 * cos / a0
+	f.a2 = (1 - alpha) / a0
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// ------------------------------------------------------------------
+// reverb
+
+// Small Schroeder/Freeverb style reverb: parallel damped comb filters
+// followed by series all-pass filters, per channel
+type reverb struct {
+	combs    [2][4]combFilter
+	allpass  [2][2]allpassFilter
+	feedback float64
+}
+
+type combFilter struct {
+	buf   []float64
+	pos   int
+	store float64
+}
+
+type allpassFilter struct {
+	buf []float64
+	pos int
+}
+
+func newReverb(rate float64) *reverb {
+	// Freeverb tunings at 44100 Hz, the right channel is slightly longer
+	combLen := [4]int{1116, 1188, 1277, 1356}
+	allpassLen := [2]int{556, 441}
+	scale := rate / 44100
+	r := &reverb{}
+	for ch := range r.combs {
+		for i, l := range combLen {
+			r.combs[ch][i].buf = make([]float64, int(float64(l+23*ch)*scale)+1)
+		}
+		for i, l := range allpassLen {
+			r.allpass[ch][i].buf = make([]float64, int(float64(l+23*ch)*scale)+1)
+		}
+	}
+	return r
+}
+
+func (r *reverb) setRoom(room float64) {
+	r.feedback = 0.7 + 0.28*math.Max(0, math.Min(1, room))
+}
+
+func (r *reverb) process(s *[2]float64, wet float64) {
+	const damp = 0.2
+	input := (s[0] + s[1]) * 0.015
+	for ch := range r.combs {
+		var out float64
+		for i := range r.combs[ch] {
+			c := &r.combs[ch][i]
+			y := c.buf[c.pos]
+			c.store = y*(1-damp) + c.store*damp
+			c.buf[c.pos] = input + c.store*r.feedback
+			c.pos = (c.pos + 1) % len(c.buf)
+			out += y
+		}
+		for i := range r.allpass[ch] {
+			a := &r.allpass[ch][i]
+			y := a.buf[a.pos]
+			a.buf[a.pos] = out + y*0.5
+			a.pos = (a.pos + 1) % len(a.buf)
+			out = y - out
+		}
+		s[ch] += out * wet * 3
+	}
+}
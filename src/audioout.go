@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/speaker"
+)
+
+// ------------------------------------------------------------------
+// AudioOutput
+
+// AudioOutput is the sink of the final audio mix. The speaker output pulls
+// the mix in real time from the audio device. The other outputs pull
+// exactly one frame worth of samples per simulated frame, so the result
+// only depends on the game state and not on timing or the sound card.
+type AudioOutput interface {
+	// Start sending the stream to the output
+	Play(s beep.Streamer)
+	// Called once per simulated frame
+	Frame()
+	// Whether the output is paced by the audio device
+	Realtime() bool
+	Close()
+}
+
+// Create the output selected by AudioOutput: "speaker", "null" or the name
+// of a .wav file to write.
+func newAudioOutput(name string) (AudioOutput, error) {
+	switch strings.ToLower(name) {
+	case "", "speaker":
+		if err := speaker.Init(beep.SampleRate(sys.audioSampleRate), audioOutLen); err != nil {
+			return nil, err
+		}
+		return &speakerOutput{}, nil
+	case "null":
+		return &nullOutput{}, nil
+	}
+	if !HasExtension(name, ".wav") {
+		return nil, Error(fmt.Sprintf("unknown audio output: %v", name))
+	}
+	return newWavFileOutput(name)
+}
+
+// Number of samples in the next frame. The remainder is carried over in acc
+// so the long term rate is exactly audioSampleRate.
+func frameSampleCount(acc *int) int {
+	*acc += int(sys.audioSampleRate)
+	n := *acc / FPS
+	*acc -= n * FPS
+	return n
+}
+
+// Write samples as 16-bit stereo PCM
+func appendPcm16(buf []byte, samples [][2]float64) []byte {
+	for _, s := range samples {
+		for _, v := range s {
+			v = math.Max(-1, math.Min(1, v))
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(int16(v*math.MaxInt16)))
+		}
+	}
+	return buf
+}
+
+// ------------------------------------------------------------------
+// speakerOutput
+
+type speakerOutput struct{}
+
+func (o *speakerOutput) Play(s beep.Streamer) {
+	speaker.Play(s)
+}
+
+func (o *speakerOutput) Frame() {}
+
+func (o *speakerOutput) Realtime() bool {
+	return true
+}
+
+func (o *speakerOutput) Close() {
+	speaker.Close()
+}
+
+// ------------------------------------------------------------------
+// nullOutput
+
+// nullOutput mixes the audio and throws it away. Sounds still advance and
+// end as they would with a sound card.
+type nullOutput struct {
+	streamer  beep.Streamer
+	buf       [][2]float64
+	sampleAcc int
+}
+
+func (o *nullOutput) Play(s beep.Streamer) {
+	o.streamer = s
+}
+
+// Mix one frame of samples
+func (o *nullOutput) pull() [][2]float64 {
+	n := frameSampleCount(&o.sampleAcc)
+	if cap(o.buf) < n {
+		o.buf = make([][2]float64, n)
+	}
+	samples := o.buf[:n]
+	clear(samples)
+	if o.streamer != nil {
+		speaker.Lock()
+		o.streamer.Stream(samples)
+		speaker.Unlock()
+	}
+	return samples
+}
+
+func (o *nullOutput) Frame() {
+	o.pull()
+}
+
+func (o *nullOutput) Realtime() bool {
+	return false
+}
+
+func (o *nullOutput) Close() {}
+
+// ------------------------------------------------------------------
+// wavFileOutput
+
+// wavFileOutput writes the mix to a 16-bit PCM WAV file at the engine
+// sample rate, one frame at a time.
+type wavFileOutput struct {
+	nullOutput
+	file    *os.File
+	w       *bufio.Writer
+	pcm     []byte
+	dataLen uint32
+}
+
+func newWavFileOutput(filename string) (*wavFileOutput, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	o := &wavFileOutput{file: f, w: bufio.NewWriter(f)}
+	writeWavHeader(o.w, 0)
+	return o, nil
+}
+
+func (o *wavFileOutput) Frame() {
+	o.pcm = appendPcm16(o.pcm[:0], o.pull())
+	o.w.Write(o.pcm)
+	o.dataLen += uint32(len(o.pcm))
+}
+
+// Finish the file, filling in the data size
+func (o *wavFileOutput) Close() {
+	if o.file == nil {
+		return
+	}
+	if err := o.w.Flush(); err == nil {
+		o.file.Seek(0, 0)
+		writeWavHeader(o.file, o.dataLen)
+	} else {
+		sys.errLog.Printf("Failed to write audio output: %v", err)
+	}
+	o.file.Close()
+	o.file = nil
+}
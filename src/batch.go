@@ -0,0 +1,116 @@
+package main
+
+import (
+	mgl "github.com/go-gl/mathgl/mgl32"
+)
+
+// Maximum number of quads held by the sprite batch before it is flushed
+const maxBatchQuads = 4096
+
+// SpriteState holds every sprite shader input that is constant for a
+// whole draw call. Consecutive sprites with equal states can be batched.
+type SpriteState struct {
+	tex, paltex *Texture
+	eq          BlendEquation
+	src, dst    BlendFunc
+	window      [4]int32
+	projection  mgl.Mat4
+	mask        int32
+	neg         bool
+	gray, hue   float32
+	add, mult   [3]float32
+	tint        [4]float32
+	alpha       float32
+}
+
+// Bind the sprite pipeline and upload the state uniforms
+func (s *SpriteState) apply() {
+	gfx.SetPipeline(s.eq, s.src, s.dst)
+
+	gfx.SetUniformMatrix("projection", s.projection[:])
+	gfx.SetTexture("tex", s.tex)
+	if s.paltex == nil {
+		gfx.SetUniformI("isRgba", 1)
+	} else {
+		gfx.SetTexture("pal", s.paltex)
+		gfx.SetUniformI("isRgba", 0)
+	}
+	gfx.SetUniformI("mask", int(s.mask))
+	gfx.SetUniformI("isFlat", 0)
+
+	gfx.SetUniformI("neg", int(Btoi(s.neg)))
+	gfx.SetUniformF("gray", s.gray)
+	gfx.SetUniformF("hue", s.hue)
+	gfx.SetUniformFv("add", s.add[:])
+	gfx.SetUniformFv("mult", s.mult[:])
+	gfx.SetUniformFv("tint", s.tint[:])
+	gfx.SetUniformF("alpha", s.alpha)
+}
+
+// SpriteBatch accumulates quads sharing the same SpriteState into a single
+// vertex buffer. Vertices are transformed on the CPU, so quads with
+// different modelview matrices can still be drawn in one call. The batch
+// must be flushed before anything else is drawn to keep the draw order.
+type SpriteBatch struct {
+	state     SpriteState
+	vertices  []float32
+	recording bool
+}
+
+var batch = &SpriteBatch{}
+
+// Start recording quads with the given state, flushing the pending ones if
+// the state changed
+func (sb *SpriteBatch) Begin(state SpriteState) {
+	if len(sb.vertices) > 0 && state != sb.state {
+		sb.Flush()
+	}
+	sb.state = state
+	sb.recording = true
+}
+
+func (sb *SpriteBatch) End() {
+	sb.recording = false
+}
+
+// Append a quad as two triangles, in the same vertex order as drawQuads
+func (sb *SpriteBatch) AddQuad(modelview mgl.Mat4, uv [4]float32, x1, y1, x2, y2, x3, y3, x4, y4 float32) {
+	if len(sb.vertices) >= maxBatchQuads*24 {
+		sb.Flush()
+	}
+	xf := func(x, y float32) (float32, float32) {
+		p := modelview.Mul4x1(mgl.Vec4{x, y, 0, 1})
+		return p[0], p[1]
+	}
+	x1, y1 = xf(x1, y1)
+	x2, y2 = xf(x2, y2)
+	x3, y3 = xf(x3, y3)
+	x4, y4 = xf(x4, y4)
+	sb.vertices = append(sb.vertices,
+		x2, y2, uv[2], uv[3],
+		x3, y3, uv[2], uv[1],
+		x1, y1, uv[0], uv[3],
+		x1, y1, uv[0], uv[3],
+		x3, y3, uv[2], uv[1],
+		x4, y4, uv[0], uv[1])
+}
+
+// Draw all pending quads
+func (sb *SpriteBatch) Flush() {
+	if len(sb.vertices) == 0 {
+		return
+	}
+	s := &sb.state
+	ident := mgl.Ident4()
+
+	gfx.Scissor(s.window[0], s.window[1], s.window[2], s.window[3])
+	s.apply()
+	gfx.SetUniformMatrix("modelview", ident[:])
+	gfx.SetUniformI("isTrapez", 0)
+	gfx.SetVertexData(sb.vertices...)
+	gfx.RenderTriangles(len(sb.vertices) / 4)
+	gfx.ReleasePipeline()
+	gfx.DisableScissor()
+
+	sb.vertices = sb.vertices[:0]
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Debug breakpoints. When one triggers the game pauses at the end of the
+// frame, the debug display and the inspector switch to the character that
+// triggered it, and the character is highlighted until the game resumes.
+
+type BreakpointType int32
+
+const (
+	BP_State BreakpointType = iota // Entering a state
+	BP_Hit                         // Hitting with an attack
+	BP_Expr                        // A trigger expression becoming true
+)
+
+type Breakpoint struct {
+	typ      BreakpointType
+	enabled  bool
+	playerNo int   // -1 for any player
+	helperId int32 // -1 for any character, 0 for root characters only
+	stateNo  int32
+	expr     *debugExpr
+	last     map[int32]bool // Previous value of expr for each char id
+}
+
+func (bp *Breakpoint) matches(c *Char) bool {
+	return bp.enabled && (bp.playerNo < 0 || c.playerNo == bp.playerNo) &&
+		(bp.helperId < 0 || c.helperId == bp.helperId && (bp.helperId != 0 || c.helperIndex == 0))
+}
+
+func (bp *Breakpoint) String() string {
+	var who string
+	if bp.playerNo >= 0 {
+		who = fmt.Sprintf("P%v ", bp.playerNo+1)
+	}
+	if bp.helperId > 0 {
+		who += fmt.Sprintf("helper %v ", bp.helperId)
+	}
+	var s string
+	switch bp.typ {
+	case BP_State:
+		s = fmt.Sprintf("%venters state %v", who, bp.stateNo)
+	case BP_Hit:
+		s = fmt.Sprintf("%vhits", who)
+	default:
+		s = fmt.Sprintf("%v%v", who, bp.expr.text)
+	}
+	if !bp.enabled {
+		s += " (disabled)"
+	}
+	return s
+}
+
+type Breakpoints struct {
+	list  []*Breakpoint
+	hit   int   // Breakpoint that paused the game, counting from 1
+	hitId int32 // Char that triggered it, -1 if none
+}
+
+var breakpoints = &Breakpoints{hitId: -1}
+
+// Adds a breakpoint and returns its number, counting from 1.
+func (bps *Breakpoints) Add(bp *Breakpoint) int {
+	bp.enabled = true
+	bp.last = make(map[int32]bool)
+	bps.list = append(bps.list, bp)
+	return len(bps.list)
+}
+
+// Removes breakpoint n, or all of them if n is 0.
+func (bps *Breakpoints) Delete(n int) {
+	if n <= 0 {
+		bps.list = nil
+	} else if n <= len(bps.list) {
+		bps.list = append(bps.list[:n-1], bps.list[n:]...)
+	}
+}
+
+func (bps *Breakpoints) Enable(n int, enable bool) {
+	if n >= 1 && n <= len(bps.list) {
+		bps.list[n-1].enabled = enable
+	}
+}
+
+func (bps *Breakpoints) trigger(n int, c *Char) {
+	if bps.hitId >= 0 {
+		return // Only the first breakpoint of a frame is reported
+	}
+	bps.hit, bps.hitId = n+1, c.id
+	sys.paused, sys.step = true, false
+	if sys.allowDebugMode {
+		sys.debugDraw = true
+		sys.debugRef = [2]int{c.playerNo, int(c.helperIndex)}
+	}
+	inspector.selected = inspectorEntity{IE_Char, c.playerNo, c.id}
+	sys.appendToConsole(fmt.Sprintf("Breakpoint %v: %v (%v, %v) at frame %v",
+		n+1, bps.list[n], c.name, c.id, sys.gameTime))
+}
+
+// Called when c enters a state.
+func (bps *Breakpoints) stateChanged(c *Char, no int32) {
+	for i, bp := range bps.list {
+		if bp.typ == BP_State && bp.stateNo == no && bp.matches(c) {
+			bps.trigger(i, c)
+		}
+	}
+}
+
+// Called when a HitDef of c hits.
+func (bps *Breakpoints) hitContact(c *Char) {
+	for i, bp := range bps.list {
+		if bp.typ == BP_Hit && bp.matches(c) {
+			bps.trigger(i, c)
+		}
+	}
+}
+
+// Called once per game tick before the characters act.
+func (bps *Breakpoints) resume() {
+	bps.hit, bps.hitId = 0, -1
+}
+
+// Called once per game tick after the characters acted.
+func (bps *Breakpoints) update() {
+	for i, bp := range bps.list {
+		if bp.typ != BP_Expr {
+			continue
+		}
+		for _, c := range sys.charList.runOrder {
+			if c.csf(CSF_destroy) || !bp.matches(c) {
+				continue
+			}
+			bv, err := bp.expr.run(c)
+			if err != nil {
+				sys.appendToConsole(fmt.Sprintf("Breakpoint %v: %v", i+1, err))
+				bp.enabled = false
+				break
+			}
+			v := bv.ToB()
+			if v && !bp.last[c.id] {
+				bps.trigger(i, c)
+			}
+			bp.last[c.id] = v
+		}
+	}
+}
@@ -3199,7 +3199,7 @@ func (b StateBlock) Run(c *Char, ps []int32) (changeState bool) {
 			// Decide if while loop should be stopped
 			if !b.forLoop {
 				// While loop needs to eval conditional indefinitely until it returns false
-				if len(b.trigger) > 0 && !b.trigger.evalB(c) {
+				if len(b.trigger) > 0 && !b.triggered(c) {
 					interrupt = true
 				}
 			}
@@ -3213,7 +3213,7 @@ func (b StateBlock) Run(c *Char, ps []int32) (changeState bool) {
 							continue
 						}
 					}
-					if sc.Run(c, ps) {
+					if runController(sc, c, ps) {
 						if sys.loopBreak {
 							sys.loopBreak = false
 							interrupt = true
@@ -3252,7 +3252,7 @@ func (b StateBlock) Run(c *Char, ps []int32) (changeState bool) {
 			}
 		}
 	} else {
-		if len(b.trigger) > 0 && !b.trigger.evalB(c) {
+		if len(b.trigger) > 0 && !b.triggered(c) {
 			if b.elseBlock != nil {
 				return b.elseBlock.Run(c, ps)
 			}
@@ -3266,7 +3266,7 @@ func (b StateBlock) Run(c *Char, ps []int32) (changeState bool) {
 					continue
 				}
 			}
-			if sc.Run(c, ps) {
+			if runController(sc, c, ps) {
 				return true
 			}
 		}
@@ -3626,6 +3626,7 @@ const (
 	playSnd_loopcount
 	playSnd_stopongethit
 	playSnd_stoponchangestate
+	playSnd_bus
 )
 
 func (sc playSnd) Run(c *Char, _ []int32) bool {
@@ -3634,7 +3635,7 @@ func (sc playSnd) Run(c *Char, _ []int32) bool {
 	}
 	crun := c
 	f, lw, lp, stopgh, stopcs := "", false, false, false, false
-	var g, n, ch, vo, pri, lc int32 = -1, 0, -1, 100, 0, 0
+	var g, n, ch, vo, pri, lc, bus int32 = -1, 0, -1, 100, 0, 0, -1
 	var loopstart, loopend, startposition = 0, 0, 0
 	var p, fr float32 = 0, 1
 	x := &c.pos[0]
@@ -3682,6 +3683,8 @@ func (sc playSnd) Run(c *Char, _ []int32) bool {
 			stopgh = exp[0].evalB(c)
 		case playSnd_stoponchangestate:
 			stopcs = exp[0].evalB(c)
+		case playSnd_bus:
+			bus = exp[0].evalI(c)
 		case playSnd_redirectid:
 			if rid := sys.playerID(exp[0].evalI(c)); rid != nil {
 				crun = rid
@@ -3696,13 +3699,13 @@ func (sc playSnd) Run(c *Char, _ []int32) bool {
 	// Read the loop parameter if loopcount not specified
 	if lc == 0 {
 		if lp {
-			crun.playSound(f, lw, -1, g, n, ch, vo, p, fr, ls, x, true, pri, loopstart, loopend, startposition, stopgh, stopcs)
+			crun.playSound(f, lw, -1, g, n, ch, vo, p, fr, ls, x, true, pri, loopstart, loopend, startposition, stopgh, stopcs, bus)
 		} else {
-			crun.playSound(f, lw, 0, g, n, ch, vo, p, fr, ls, x, true, pri, loopstart, loopend, startposition, stopgh, stopcs)
+			crun.playSound(f, lw, 0, g, n, ch, vo, p, fr, ls, x, true, pri, loopstart, loopend, startposition, stopgh, stopcs, bus)
 		}
 		// Use the loopcount directly if it's been specified
 	} else {
-		crun.playSound(f, lw, lc, g, n, ch, vo, p, fr, ls, x, true, pri, loopstart, loopend, startposition, stopgh, stopcs)
+		crun.playSound(f, lw, lc, g, n, ch, vo, p, fr, ls, x, true, pri, loopstart, loopend, startposition, stopgh, stopcs, bus)
 	}
 	return false
 }
@@ -3744,7 +3747,9 @@ func (sc changeState) Run(c *Char, _ []int32) bool {
 		}
 		return true
 	})
+	stateTrace.cause = &sc[0]
 	crun.changeState(v, a, ctrl, ffx)
+	stateTrace.cause = nil
 	return stop
 }
 
@@ -3782,7 +3787,9 @@ func (sc selfState) Run(c *Char, _ []int32) bool {
 		}
 		return true
 	})
+	stateTrace.cause = &sc[0]
 	crun.selfState(v, a, r, ctrl, ffx)
+	stateTrace.cause = nil
 	return stop
 }
 
@@ -7305,7 +7312,9 @@ func (sc targetState) Run(c *Char, _ []int32) bool {
 			if len(tar) == 0 {
 				return false
 			}
+			stateTrace.cause = &sc[0]
 			crun.targetState(tar, exp[0].evalI(c))
+			stateTrace.cause = nil
 		case targetState_redirectid:
 			if rid := sys.playerID(exp[0].evalI(c)); rid != nil {
 				crun = rid
@@ -7914,7 +7923,7 @@ func (sc superPause) Run(c *Char, _ []int32) bool {
 			vo := int32(100)
 			ffx := string(*(*[]byte)(unsafe.Pointer(&exp[0])))
 			crun.playSound(ffx, false, 0, exp[1].evalI(c), n, -1,
-				vo, 0, 1, 1, nil, false, 0, 0, 0, 0, false, false)
+				vo, 0, 1, 1, nil, false, 0, 0, 0, 0, false, false, -1)
 		case superPause_redirectid:
 			if rid := sys.playerID(exp[0].evalI(c)); rid != nil {
 				crun = rid
@@ -9603,9 +9612,8 @@ func (sc loadFile) Run(c *Char, _ []int32) bool {
 		return true
 	})
 	if path != "" {
-		decodeFile, err := os.Open(filepath.Dir(c.gi().def) + "/" + path)
+		decodeFile, err := vfs.Open(filepath.Dir(c.gi().def) + "/" + path)
 		if err != nil {
-			defer decodeFile.Close()
 			return false
 		}
 		defer decodeFile.Close()
@@ -10384,6 +10392,7 @@ const (
 	playBgm_startposition
 	playBgm_freqmul
 	playBgm_redirectid
+	playBgm_crossfade
 )
 
 func (sc playBgm) Run(c *Char, _ []int32) bool {
@@ -10392,6 +10401,7 @@ func (sc playBgm) Run(c *Char, _ []int32) bool {
 	var bgm string
 	var loop, volume, loopstart, loopend, startposition int = 1, 100, 0, 0, 0
 	var freqmul float32 = 1.0
+	var crossfade int32
 	StateControllerBase(sc).run(c, func(id byte, exp []BytecodeExp) bool {
 		switch id {
 		case playBgm_bgm:
@@ -10415,6 +10425,8 @@ func (sc playBgm) Run(c *Char, _ []int32) bool {
 			startposition = int(exp[0].evalI(c))
 		case playBgm_freqmul:
 			freqmul = exp[0].evalF(c)
+		case playBgm_crossfade:
+			crossfade = exp[0].evalI(c)
 		case playBgm_redirectid:
 			if rid := sys.playerID(exp[0].evalI(c)); rid != nil {
 				crun = rid
@@ -10425,12 +10437,37 @@ func (sc playBgm) Run(c *Char, _ []int32) bool {
 		return true
 	})
 	if b {
-		sys.bgm.Open(bgm, loop, volume, loopstart, loopend, startposition, freqmul)
+		sys.bgm.Open(bgm, loop, volume, loopstart, loopend, startposition, freqmul, crossfade)
 		sys.playBgmFlg = true
 	}
 	return false
 }
 
+type bgmLayer StateControllerBase
+
+const (
+	bgmLayer_layer = iota
+	bgmLayer_volume
+	bgmLayer_time
+)
+
+func (sc bgmLayer) Run(c *Char, _ []int32) bool {
+	var layer, volume, time int32 = 1, 100, 0
+	StateControllerBase(sc).run(c, func(id byte, exp []BytecodeExp) bool {
+		switch id {
+		case bgmLayer_layer:
+			layer = exp[0].evalI(c)
+		case bgmLayer_volume:
+			volume = exp[0].evalI(c)
+		case bgmLayer_time:
+			time = exp[0].evalI(c)
+		}
+		return true
+	})
+	sys.bgm.SetLayerVolume(int(layer-1), Max(0, volume), time)
+	return false
+}
+
 type targetDizzyPointsAdd StateControllerBase
 
 const (
@@ -11284,6 +11321,7 @@ type StateBytecode struct {
 	block     StateBlock
 	ctrlsps   []int32
 	numVars   int32
+	stateNo   int32
 }
 
 // StateDef bytecode creation function
@@ -11315,6 +11353,10 @@ func (sb *StateBytecode) init(c *Char) {
 	sb.stateDef.Run(c)
 }
 func (sb *StateBytecode) run(c *Char) (changeState bool) {
+	if profiler.enabled {
+		profiler.stateBegin()
+		defer profiler.stateDone(sb)
+	}
 	sys.bcVar = sys.bcVarStack.Alloc(int(sb.numVars))
 	sys.workingState = sb
 	changeState = sb.block.Run(c, sb.ctrlsps)
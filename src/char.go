@@ -1,11 +1,10 @@
 package main
 
 import (
-	"archive/zip"
 	"fmt"
 	"io"
 	"math"
-	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -189,7 +188,7 @@ func (cr ClsnRect) draw(trans int32) {
 	paltex := PaletteToTexture(sys.clsnSpr.Pal)
 	for _, c := range cr {
 		params := RenderParams{
-			sys.clsnSpr.Tex, paltex, sys.clsnSpr.Size,
+			sys.clsnSpr.Tex, paltex, sys.clsnSpr.uv, sys.clsnSpr.Size,
 			-c[0] * sys.widthScale, -c[1] * sys.heightScale, notiling,
 			c[2] * sys.widthScale, c[2] * sys.widthScale, c[3] * sys.heightScale, 1, 0,
 			1, 1, Rotation{}, 0, trans, -1, nil, &sys.scrrect, 0, 0, 0, 0, 0, 0,
@@ -1084,7 +1083,7 @@ func (ai *AfterImage) recAndCue(sd *SprData, rec bool, hitpause bool, layer int3
 			ai.palfx[i/ai.framegap-1].remap = sd.fx.remap
 			sprs.add(&SprData{&img.anim, &ai.palfx[i/ai.framegap-1], img.pos,
 				img.scl, ai.alpha, sd.priority - 2, img.rot, img.ascl,
-				false, sd.bright, sd.oldVer, sd.facing, sd.posLocalscl, img.projection, img.fLength, sd.window}, 0, 0, 0, 0)
+				false, sd.bright, sd.oldVer, sd.facing, sd.posLocalscl, img.projection, img.fLength, sd.window, nil, nil}, 0, 0, 0, 0)
 		}
 	}
 	if rec || hitpause && ai.ignorehitpause {
@@ -1402,7 +1401,7 @@ func (e *Explod) update(oldVer bool, playerNo int) {
 	var ewin = [4]float32{e.window[0] * e.localscl * facing, e.window[1] * e.localscl * e.vfacing, e.window[2] * e.localscl * facing, e.window[3] * e.localscl * e.vfacing}
 	sprs.add(&SprData{e.anim, pfx, e.drawPos, [...]float32{(facing * scale[0]) * e.localscl,
 		(e.vfacing * scale[1]) * e.localscl}, alp, e.sprpriority, rot, [...]float32{1, 1},
-		e.space == Space_screen, playerNo == sys.superplayer, oldVer, facing, 1, int32(e.projection), fLength, ewin},
+		e.space == Space_screen, playerNo == sys.superplayer, oldVer, facing, 1, int32(e.projection), fLength, ewin, nil, nil},
 		e.shadow[0]<<16|e.shadow[1]&0xff<<8|e.shadow[2]&0xff, sdwalp, 0, 0)
 	if sys.tickNextFrame() {
 
@@ -1841,7 +1840,7 @@ func (p *Projectile) cueDraw(oldVer bool, playerNo int) {
 		sd := &SprData{p.ani, p.palfx, [...]float32{p.pos[0] * p.localscl, p.pos[1] * p.localscl},
 			[...]float32{p.facing * p.scale[0] * p.localscl, p.scale[1] * p.localscl}, [2]int32{-1},
 			p.sprpriority, Rotation{p.facing * p.angle, 0, 0}, [...]float32{1, 1}, false, playerNo == sys.superplayer,
-			sys.cgi[playerNo].mugenver[0] != 1, p.facing, 1, 0, 0, [4]float32{0, 0, 0, 0}}
+			sys.cgi[playerNo].mugenver[0] != 1, p.facing, 1, 0, 0, [4]float32{0, 0, 0, 0}, nil, nil}
 		p.aimg.recAndCue(sd, sys.tickNextFrame() && notpause, false, p.layerno)
 		sprs.add(sd, p.shadow[0]<<16|p.shadow[1]&255<<8|p.shadow[2]&255, 256, 0, 0)
 	}
@@ -1873,6 +1872,7 @@ type CharGlobalInfo struct {
 	lifebarname      string
 	palkeymap        [MaxPalNo]int32
 	sff              *Sff
+	model            *CharModel
 	palettedata      *Palette
 	snd              *Snd
 	anim             AnimationTable
@@ -2055,6 +2055,9 @@ type Char struct {
 	hoKeepState         bool
 	mctype              MoveContact
 	mctime              int32
+	frameData           FrameData
+	stateHistory        [stateHistoryLen]stateTransition
+	stateHistoryPos     int
 	children            []*Char
 	targets             []int32
 	hitdefTargets       []int32
@@ -2110,7 +2113,6 @@ type Char struct {
 	koEchoTime      int32
 	groundLevel     float32
 	sizeBox         []float32
-	zipFileName     string
 }
 
 func newChar(n int, idx int32) (c *Char) {
@@ -2340,22 +2342,15 @@ func (c *Char) load(def string) error {
 		gi.palkeymap[i] = int32(i)
 	}
 	c.mapDefault = make(map[string]float32)
-	if strings.Index(def, ".zip") == -1 {
-		fmt.Printf("[DEBUG][char.go][load] def=%v\n", def)
-		str, err = LoadText(def)
-		c.zipFileName = ""
-	} else {
-		lines := SplitAndTrim(def, ",")
-		c.zipFileName = lines[0]
-		fmt.Printf("[DEBUG][char.go][load] def=%v\n", lines)
-		str, err = LoadTextFromZip(c.zipFileName, lines[1])
-	}
-
+	// Files of zipped characters are looked up inside the archive
+	def = vfsPath(def)
+	str, err = LoadText(def)
 	if err != nil {
 		return err
 	}
 	lines, i := SplitAndTrim(str, "\n"), 0
-	cns, sprite, anim, sound := "", "", "", ""
+	cns, sprite, anim, sound, model := "", "", "", "", ""
+	var modelIs IniSection
 	info, files, keymap, mapArray, lanInfo, lanFiles, lanKeymap, lanMapArray := true, true, true, true, true, true, true, true
 	gi.localcoord = [...]float32{320, 240}
 	c.localcoord = 320 / (float32(sys.gameWidth) / 320)
@@ -2392,6 +2387,7 @@ func (c *Char) load(def string) error {
 				files = false
 				cns, sprite = is["cns"], is["sprite"]
 				anim, sound = is["anim"], is["sound"]
+				model = is["model"]
 				for i := range gi.pal {
 					gi.pal[i] = is[fmt.Sprintf("pal%v", i+1)]
 				}
@@ -2415,6 +2411,10 @@ func (c *Char) load(def string) error {
 					}
 				}
 			}
+		case "model":
+			if modelIs == nil {
+				modelIs = is
+			}
 		case "map":
 			if mapArray {
 				mapArray = false
@@ -2451,6 +2451,7 @@ func (c *Char) load(def string) error {
 				lanFiles = false
 				cns, sprite = is["cns"], is["sprite"]
 				anim, sound = is["anim"], is["sound"]
+				model = is["model"]
 				for i := range gi.pal {
 					gi.pal[i] = is[fmt.Sprintf("pal%v", i+1)]
 				}
@@ -2595,15 +2596,7 @@ func (c *Char) load(def string) error {
 
 	if len(cns) > 0 {
 		if err := LoadFile(&cns, []string{def, "", sys.motifDir, "data/"}, func(filename string) error {
-			var str string
-			var err error
-			if c.zipFileName == "" {
-				// fmt.Printf("[DEBUG][char.go][load] cns filename=%v\n", filename)
-				str, err = LoadText(filename)
-			} else {
-				// fmt.Printf("[DEBUG][char.go][load] cns c.zipFileName=%v filename=%v\n", c.zipFileName, filename)
-				str, err = LoadTextFromZip(c.zipFileName, filename)
-			}
+			str, err := LoadText(filename)
 			if err != nil {
 				return err
 			}
@@ -2838,20 +2831,12 @@ func (c *Char) load(def string) error {
 			return err
 		}
 	}
+	releaseSff(gi.sff)
 	if len(sprite) > 0 {
 		if LoadFile(&sprite, []string{def, "", sys.motifDir, "data/"}, func(filename string) error {
 			// fmt.Printf("[DEBUG][char.go][load] sprite filename=%v\n", filename)
 			var err error
-			if c.zipFileName == "" {
-				gi.sff, err = loadSff(filename, true)
-			} else {
-				path := FileExist("tmp/chars/" + filename)
-				if path == "" {
-					err = ExtractFileFromZip(c.zipFileName, filename, "tmp/chars")
-					path = "tmp/chars/" + filename
-				}
-				gi.sff, err = loadSff(path, true)
-			}
+			gi.sff, err = loadSff(filename, true)
 			return err
 		}); err != nil {
 			return err
@@ -2859,6 +2844,16 @@ func (c *Char) load(def string) error {
 	} else {
 		gi.sff = newSff()
 	}
+	gi.model = nil
+	if len(model) > 0 {
+		if LoadFile(&model, []string{def, "", sys.motifDir, "data/"}, func(filename string) error {
+			var err error
+			gi.model, err = loadCharModel(filename, modelIs)
+			return err
+		}); err != nil {
+			return err
+		}
+	}
 	gi.palettedata = newPaldata()
 	gi.palettedata.palList = PaletteList{
 		palettes:   append([][]uint32{}, gi.sff.palList.palettes...),
@@ -2878,15 +2873,8 @@ func (c *Char) load(def string) error {
 		if LoadFile(&anim, []string{def, "", sys.motifDir, "data/"}, func(filename string) error {
 			// fmt.Printf("[DEBUG][char.go][load] anim filename=%v\n", filename)
 			var err error
-			if c.zipFileName == "" {
-				str, err = LoadText(filename)
-			} else {
-				str, err = LoadTextFromZip(c.zipFileName, filename)
-			}
-			if err != nil {
-				return err
-			}
-			return nil
+			str, err = LoadText(filename)
+			return err
 		}); err != nil {
 			return err
 		}
@@ -2907,19 +2895,14 @@ func (c *Char) load(def string) error {
 	lines, i = SplitAndTrim(str, "\n"), 0
 	gi.anim = ReadAnimationTable(gi.sff, &gi.palettedata.palList, lines, &i)
 	if len(sound) > 0 {
+		// A folder of loose sound files can be used in place of a .snd file
+		if dir := filepath.Dir(def) + "/" + sound; DirExist(dir) {
+			sound = dir
+		}
 		if LoadFile(&sound, []string{def, "", sys.motifDir, "data/"}, func(filename string) error {
 			var err error
 			// fmt.Printf("[DEBUG][char.go][load] sound filename=%v\n", filename)
-			if c.zipFileName == "" {
-				gi.snd, err = LoadSnd(filename)
-			} else {
-				path := FileExist("tmp/chars/" + filename)
-				if path == "" {
-					err = ExtractFileFromZip(c.zipFileName, filename, "tmp/chars")
-					path = "tmp/chars/" + filename
-				}
-				gi.snd, err = LoadSnd(path)
-			}
+			gi.snd, err = LoadSnd(filename)
 			return err
 		}); err != nil {
 			return err
@@ -2952,45 +2935,16 @@ func (c *Char) load(def string) error {
 	return nil
 }
 func (c *Char) loadPalette() {
-	var zipReader *zip.ReadCloser
 	gi := c.gi()
 	if gi.sff.header.Ver0 == 1 {
 		gi.palettedata.palList.ResetRemap()
 		tmp := 0
 		for i := 0; i < MaxPalNo; i++ {
 			pl := gi.palettedata.palList.Get(i)
-			// var f *os.File
-			var f io.ReadCloser
+			var f VFile
 			var err error
 			if LoadFile(&gi.pal[i], []string{gi.def, "", sys.motifDir, "data/"}, func(file string) error {
-				if c.zipFileName == "" {
-					f, err = os.Open(file)
-					// fmt.Printf("[DEBUG][char.go] loadPalette %v\n", file)
-				} else {
-					zipReader, err = zip.OpenReader(c.zipFileName)
-					if err != nil {
-						return err
-					}
-
-					// Find the specific file in the ZIP archive
-					var fileInZip *zip.File
-					for _, f := range zipReader.File {
-						if f.Name == file {
-							fileInZip = f
-							break
-						}
-					}
-					if fileInZip == nil {
-						return fmt.Errorf("file '%s' not found in the ZIP archive", file)
-					}
-					// Open the file inside the ZIP archive
-					f, err = fileInZip.Open()
-					if err != nil {
-						zipReader.Close()
-						return fmt.Errorf("failed to open file in ZIP archive: %w", err)
-					}
-					// fmt.Printf("[DEBUG][char.go] loadPalette %v from %v\n", file, c.zipFileName)
-				}
+				f, err = vfs.Open(file)
 				return err
 			}) == nil {
 				for i := 255; i >= 0; i-- {
@@ -3005,9 +2959,6 @@ func (c *Char) loadPalette() {
 					pl[i] = uint32(alpha)<<24 | uint32(rgb[2])<<16 | uint32(rgb[1])<<8 | uint32(rgb[0])
 				}
 				chk(f.Close())
-				if c.zipFileName != "" {
-					zipReader.Close()
-				}
 				if err == nil {
 					if tmp == 0 && i > 0 {
 						copy(gi.palettedata.palList.Get(0), pl)
@@ -4059,7 +4010,7 @@ func (c *Char) winType(wt WinType) bool {
 	return c.win() && sys.winTrigger[c.playerNo&1] == wt
 }
 func (c *Char) playSound(ffx string, lowpriority bool, loopCount int32, g, n, chNo, vol int32,
-	p, freqmul, ls float32, x *float32, log bool, priority int32, loopstart, loopend, startposition int, stopgh, stopcs bool) {
+	p, freqmul, ls float32, x *float32, log bool, priority int32, loopstart, loopend, startposition int, stopgh, stopcs bool, bus int32) {
 	if g < 0 {
 		return
 	}
@@ -4099,7 +4050,15 @@ func (c *Char) playSound(ffx string, lowpriority bool, loopCount int32, g, n, ch
 		crun = c.root()
 	}
 	if ch := crun.soundChannels.New(chNo, lowpriority, priority); ch != nil {
-		ch.Play(s, loopCount, freqmul, loopstart, loopend, startposition)
+		// Without an explicit bus, channel 0 is treated as the voice channel
+		if bus < 0 {
+			if (ffx == "" || ffx == "s") && chNo == 0 {
+				bus = BusVoice
+			} else {
+				bus = BusSfx
+			}
+		}
+		ch.Play(s, loopCount, freqmul, loopstart, loopend, startposition, int(bus))
 		vol = Clamp(vol, -25600, 25600)
 		//if c.gi().mugenver[0] == 1 {
 		if ffx != "" {
@@ -4150,6 +4109,9 @@ func (c *Char) stateChange1(no int32, pn int) bool {
 		return false
 	}
 	c.ss.no, c.ss.prevno, c.ss.time = Max(0, no), c.ss.no, 0
+	c.recordStateChange(c.ss.prevno, c.ss.no, pn)
+	breakpoints.stateChanged(c, c.ss.no)
+	stateTrace.stateChanged(c)
 	//if c.ss.sb.playerNo != c.playerNo && pn != c.ss.sb.playerNo {
 	//	c.enemyExplodsRemove(c.ss.sb.playerNo)
 	//}
@@ -4208,6 +4170,7 @@ func (c *Char) stateChange1(no int32, pn int) bool {
 		}
 		c.ss.sb = *newStateBytecode(pn)
 		c.ss.sb.stateType, c.ss.sb.moveType, c.ss.sb.physics = ST_U, MT_U, ST_U
+		c.ss.sb.stateNo = c.ss.no
 	}
 	// Reset persistent counters for this state (Ikemen chars)
 	// This used to belong to (*StateBytecode).init(), but was moved outside there
@@ -4313,6 +4276,7 @@ func (c *Char) destroy() {
 			}
 		}
 		c.children = c.children[:0]
+		stateTrace.helperDestroyed(c)
 		sys.charList.delete(c)
 		c.helperIndex = -1
 		c.setCSF(CSF_destroy)
@@ -4439,6 +4403,7 @@ func (c *Char) helperInit(h *Char, st int32, pt PosType, x, y float32,
 	if h.stWgi().mugenver[0] == 1 && h.stWgi().mugenver[1] == 1 && h.stWgi().ikemenver[0] == 0 && h.stWgi().ikemenver[1] == 0 {
 		h.palfx.invertblend = -2
 	}
+	stateTrace.helperSpawned(h, c, st)
 	h.changeStateEx(st, c.playerNo, 0, 1, "")
 	// Helper ID must be positive
 	if h.helperId < 0 {
@@ -4491,6 +4456,7 @@ func (c *Char) insertExplodEx(i int, rp [2]int32) {
 		return
 	}
 	e.anim.UpdateSprite()
+	stateTrace.explodSpawned(c, i)
 	if e.ownpal {
 		if e.anim.sff != sys.ffx["f"].fsff {
 			remap := make([]int, len(e.palfx.remap))
@@ -6894,6 +6860,10 @@ func (c *Char) actionRun() {
 	if c.minus != 2 || c.csf(CSF_destroy) || c.scf(SCF_disabled) {
 		return
 	}
+	if profiler.enabled {
+		profiler.chars.begin()
+		defer profiler.charDone(c)
+	}
 	// Run state -4
 	c.minus = -4
 	if sb, ok := c.gi().states[-4]; ok {
@@ -7315,7 +7285,7 @@ func (c *Char) update() {
 		} else {
 			if c.koEchoTime == 60 || c.koEchoTime == 120 {
 				vo := int32(100 * (240 - (c.koEchoTime + 60)) / 240)
-				c.playSound("", false, 0, 11, 0, -1, vo, 0, 1, c.localscl, &c.pos[0], false, 0, 0, 0, 0, false, false)
+				c.playSound("", false, 0, 11, 0, -1, vo, 0, 1, c.localscl, &c.pos[0], false, 0, 0, 0, 0, false, false, -1)
 			}
 			c.koEchoTime++
 		}
@@ -7373,8 +7343,11 @@ func (c *Char) tick() {
 		c.mctime = 1
 		if c.mctype == MC_Hit {
 			c.hitCount += c.hitdef.numhits
+			breakpoints.hitContact(c)
+			stateTrace.hitContact(c, false)
 		} else if c.mctype == MC_Guarded {
 			c.guardCount += c.hitdef.numhits
+			stateTrace.hitContact(c, true)
 		}
 	}
 	if c.csf(CSF_gethit) && !c.hoKeepState {
@@ -7483,7 +7456,7 @@ func (c *Char) tick() {
 			// KO sound
 			if !sys.gsf(GSF_nokosnd) && c.alive() {
 				vo := int32(100)
-				c.playSound("", false, 0, 11, 0, -1, vo, 0, 1, c.localscl, &c.pos[0], false, 0, 0, 0, 0, false, false)
+				c.playSound("", false, 0, 11, 0, -1, vo, 0, 1, c.localscl, &c.pos[0], false, 0, 0, 0, 0, false, false, -1)
 				if c.gi().data.ko.echo != 0 {
 					c.koEchoTime = 1
 				}
@@ -7653,6 +7626,11 @@ func (c *Char) cueDraw() {
 		y += float32(sys.debugFont.fnt.Size[1]) * sys.debugFont.yscl / sys.heightScale
 		// Name and ID
 		sys.clsnText = append(sys.clsnText, ClsnText{x: x, y: y, text: fmt.Sprintf("%s, %d", c.name, c.id), r: 255, g: 255, b: 255})
+		// Breakpoint that paused the game
+		if breakpoints.hitId == c.id && sys.paused {
+			y += float32(sys.debugFont.fnt.Size[1]) * sys.debugFont.yscl / sys.heightScale
+			sys.clsnText = append(sys.clsnText, ClsnText{x: x, y: y, text: fmt.Sprintf("Breakpoint %d", breakpoints.hit), r: 255, g: 63, b: 63})
+		}
 		// NotHitBy
 		if nhbtxt != "" {
 			y += float32(sys.debugFont.fnt.Size[1]) * sys.debugFont.yscl / sys.heightScale
@@ -7686,10 +7664,13 @@ func (c *Char) cueDraw() {
 				scl, c.alpha, c.sprPriority, Rotation{agl, 0, 0}, c.angleScale, false,
 				c.playerNo == sys.superplayer, c.gi().mugenver[0] != 1, c.facing,
 				c.localcoord / sys.chars[c.animPN][0].localcoord, // https://github.com/ikemen-engine/Ikemen-GO/issues/1459 and 1778
-				0, 0, [4]float32{0, 0, 0, 0}}
+				0, 0, [4]float32{0, 0, 0, 0}, nil, nil}
 			if !c.csf(CSF_trans) {
 				sd.alpha[0] = -1
 			}
+			if mdl := sys.cgi[c.playerNo].model; mdl.clip(c.animNo) != nil {
+				sd.model, sd.clip = mdl, mdl.clip(c.animNo)
+			}
 			return sd
 		}
 		//if rec {
@@ -7717,7 +7698,8 @@ func (c *Char) cueDraw() {
 		}
 		if !c.asf(ASF_invisible) {
 			var sc, sa int32 = -1, 255
-			if c.asf(ASF_noshadow) {
+			// Shadows are made from sprites, so models don't cast any
+			if c.asf(ASF_noshadow) || sd.clip != nil {
 				sc = 0
 			}
 			if c.csf(CSF_trans) {
@@ -8477,7 +8459,7 @@ func (cl *CharList) hitDetection(getter *Char, proj bool) {
 			if hd.hitsound[0] >= 0 {
 				vo := int32(100)
 				c.playSound(hd.hitsound_ffx, false, 0, hd.hitsound[0], hd.hitsound[1],
-					hd.hitsound_channel, vo, 0, 1, getter.localscl, &getter.pos[0], true, 0, 0, 0, 0, false, false)
+					hd.hitsound_channel, vo, 0, 1, getter.localscl, &getter.pos[0], true, 0, 0, 0, 0, false, false, -1)
 			}
 			if hitType > 0 {
 				c.powerAdd(hd.hitgetpower)
@@ -8513,7 +8495,7 @@ func (cl *CharList) hitDetection(getter *Char, proj bool) {
 			if hd.guardsound[0] >= 0 {
 				vo := int32(100)
 				c.playSound(hd.guardsound_ffx, false, 0, hd.guardsound[0], hd.guardsound[1],
-					hd.guardsound_channel, vo, 0, 1, getter.localscl, &getter.pos[0], true, 0, 0, 0, 0, false, false)
+					hd.guardsound_channel, vo, 0, 1, getter.localscl, &getter.pos[0], true, 0, 0, 0, 0, false, false, -1)
 			}
 			if hitType > 0 {
 				c.powerAdd(hd.guardgetpower)
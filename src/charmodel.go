@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	mgl "github.com/go-gl/mathgl/mgl32"
+)
+
+// ------------------------------------------------------------------
+// CharModel
+
+// CharModel is a glTF model drawn in place of a character's sprites. It is
+// enabled with "model = file.glb" in the [Files] section of the .def file.
+// AIR actions are mapped to animation clips, by default the clip named
+// after the action number. Actions without a clip keep drawing sprites, and
+// collision boxes always come from the AIR file.
+//
+// The optional [Model] section adjusts the model:
+//
+//	scale    = 1, 1, 1     ; Model units to localcoord pixels
+//	offset   = 0, 0, 0     ; In model units, before scaling
+//	rotation = 0, 0, 0     ; X, Y and Z rotation in degrees
+//	action.0 = Idle        ; Clip used by action 0
+type CharModel struct {
+	*Model
+	clips map[int32]*GLTFAnimation
+	// Node state before any clip is applied. The model is shared by every
+	// instance of the character, so the pose is rebuilt on each draw.
+	rest []Node
+}
+
+func loadCharModel(filename string, is IniSection) (*CharModel, error) {
+	mdl, err := loadglTFStage(filename)
+	if err != nil {
+		return nil, err
+	}
+	cm := &CharModel{Model: mdl, clips: make(map[int32]*GLTFAnimation)}
+	for _, anim := range mdl.animations {
+		if no, err := strconv.ParseInt(anim.name, 10, 32); err == nil {
+			cm.clips[int32(no)] = anim
+		}
+	}
+	readVec3 := func(name string, out *[3]float32) {
+		str, ok := is[name]
+		if !ok {
+			return
+		}
+		v := SplitAndTrim(str, ",")
+		for i := range out {
+			if len(v) == 1 {
+				out[i] = float32(Atof(v[0]))
+			} else if i < len(v) {
+				out[i] = float32(Atof(v[i]))
+			}
+		}
+	}
+	readVec3("scale", &mdl.scale)
+	readVec3("offset", &mdl.offset)
+	readVec3("rotation", &mdl.rotation)
+	for i := range mdl.rotation {
+		mdl.rotation[i] *= math.Pi / 180
+	}
+	for key, value := range is {
+		if !strings.HasPrefix(key, "action.") {
+			continue
+		}
+		no, err := strconv.ParseInt(key[len("action."):], 10, 32)
+		if err != nil {
+			continue
+		}
+		for _, anim := range mdl.animations {
+			if anim.name == value {
+				cm.clips[int32(no)] = anim
+				break
+			}
+		}
+	}
+	cm.rest = make([]Node, len(mdl.nodes))
+	for i, n := range mdl.nodes {
+		// Nodes may share their weights with the mesh
+		n.morphTargetWeights = append([]float32{}, n.morphTargetWeights...)
+		cm.rest[i] = *n
+		cm.rest[i].morphTargetWeights = append([]float32{}, n.morphTargetWeights...)
+	}
+	return cm, nil
+}
+
+// Clip for an action, or nil if the action is drawn with sprites
+func (cm *CharModel) clip(animNo int32) *GLTFAnimation {
+	if cm == nil {
+		return nil
+	}
+	return cm.clips[animNo]
+}
+
+// Reset the nodes to the rest pose and apply a clip at the given time
+func (cm *CharModel) pose(clip *GLTFAnimation, time float32) {
+	for i, n := range cm.nodes {
+		r := &cm.rest[i]
+		n.transition, n.rotation, n.scale = r.transition, r.rotation, r.scale
+		copy(n.morphTargetWeights, r.morphTargetWeights)
+		n.transformChanged = true
+	}
+	clip.time = ClampF(time, 0, clip.duration)
+	cm.animate(clip)
+}
+
+// Draw the model with its origin at the same screen position as the axis
+// of a sprite drawn from the same SprData
+func (cm *CharModel) draw(sd *SprData, x, y, cs float32) {
+	if len(cm.scenes) == 0 || len(sd.anim.frames) == 0 {
+		return
+	}
+	batch.Flush()
+	// AIR frame timing drives the clip, one tick per 1/60 second
+	cm.pose(sd.clip, float32(animElapsedTime(sd.anim))/60)
+	cm.pfx = sd.fx
+
+	h, v, angle := sd.anim.drawSub1(sd.rot.angle, sd.facing)
+	xs := sd.scl[0] * cs * h * sys.widthScale
+	ys := sd.scl[1] * cs * v * sys.heightScale
+	sx := (cs*x + float32(sys.gameWidth)/2) * sys.widthScale
+	sy := cs * y * sys.heightScale
+
+	proj := mgl.Ortho(0, float32(sys.scrrect[2]), 0, float32(sys.scrrect[3]), -65535, 65535)
+	view := mgl.Translate3D(sx, float32(sys.scrrect[3])-sy, 0)
+	view = view.Mul4(mgl.HomogRotate3DX(-sd.rot.xangle * math.Pi / 180))
+	view = view.Mul4(mgl.HomogRotate3DY(sd.rot.yangle * math.Pi / 180))
+	view = view.Mul4(mgl.HomogRotate3DZ(angle * math.Pi / 180))
+	view = view.Mul4(mgl.Scale3D(xs, ys, AbsF(xs)))
+	view = view.Mul4(mgl.HomogRotate3DX(cm.rotation[0]))
+	view = view.Mul4(mgl.HomogRotate3DY(cm.rotation[1]))
+	view = view.Mul4(mgl.HomogRotate3DZ(cm.rotation[2]))
+	view = view.Mul4(mgl.Scale3D(cm.scale[0], cm.scale[1], cm.scale[2]))
+	view = view.Mul4(mgl.Translate3D(cm.offset[0], cm.offset[1], cm.offset[2]))
+
+	if sd.window != [4]float32{} {
+		w := sd.window
+		if w[0] > w[2] {
+			w[0], w[2] = w[2], w[0]
+		}
+		if w[1] > w[3] {
+			w[1], w[3] = w[3], w[1]
+		}
+		gfx.Scissor(int32((cs*(x+w[0])+float32(sys.gameWidth)/2)*sys.widthScale),
+			int32(cs*(y+w[1])*sys.heightScale),
+			int32(cs*(w[2]-w[0])*sys.widthScale), int32(cs*(w[3]-w[1])*sys.heightScale))
+		defer gfx.DisableScissor()
+	}
+	// Characters are layered by sprite priority, not by depth
+	gfx.ClearDepth()
+	scene := cm.scenes[0]
+	for _, index := range scene.nodes {
+		cm.nodes[index].calculateWorldTransform(mgl.Ident4(), cm.nodes)
+	}
+	for _, index := range scene.nodes {
+		drawNode(cm.Model, cm.nodes[index], proj, view, false)
+	}
+	for _, index := range scene.nodes {
+		drawNode(cm.Model, cm.nodes[index], proj, view, true)
+	}
+}
+
+// Ticks elapsed since the start of the action
+func animElapsedTime(a *Animation) int32 {
+	t := a.time
+	for i := int32(0); i < a.current && int(i) < len(a.frames); i++ {
+		t += Max(0, a.frames[i].Time)
+	}
+	return t
+}
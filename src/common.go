@@ -1,11 +1,8 @@
 package main
 
 import (
-	"archive/zip"
 	"fmt"
-	"io"
 	"math"
-	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -254,7 +251,7 @@ func I32ToU16(i32 int32) uint16 {
 	return uint16(i32)
 }
 func LoadText(filename string) (string, error) {
-	bytes, err := os.ReadFile(filename)
+	bytes, err := vfs.ReadFile(filename)
 	if err != nil {
 		return "", err
 	}
@@ -264,153 +261,13 @@ func LoadText(filename string) (string, error) {
 	}
 	return string(bytes), nil
 }
-func LoadTextFromZip(zipname string, filename string) (string, error) {
-	// Open the ZIP file
-	zipReader, err := zip.OpenReader(zipname)
-	if err != nil {
-		return "", err
-	}
-	defer zipReader.Close()
-
-	// Iterate through the files in the archive and find matched filename
-	for _, file := range zipReader.File {
-		if file.Name == filename {
-			// Open the file
-			zippedFile, err := file.Open()
-			if err != nil {
-				return "", err
-			}
-			defer zippedFile.Close()
-
-			// Read the file content into a byte slice
-			bytes, err := io.ReadAll(zippedFile)
-			if err != nil {
-				return "", err
-			}
-
-			if len(bytes) >= 3 && bytes[0] == 0xef && bytes[1] == 0xbb && bytes[2] == 0xbf {
-				bytes = bytes[3:]
-			}
-			return string(bytes), nil
-		}
-	}
-
-	return "", Error("File " + filename + " not found in " + zipname)
-}
-
-// ExtractFileFromZip extracts a specific file from a ZIP archive and saves it to the specified destination directory.
-func ExtractFileFromZip(zipname string, filename string, destdir string) error {
-	// Open the ZIP file
-	zipReader, err := zip.OpenReader(zipname)
-	if err != nil {
-		return fmt.Errorf("failed to open ZIP file: %w", err)
-	}
-	defer zipReader.Close()
-
-	// Find the specific file in the ZIP archive
-	var extractedFile *zip.File
-	for _, file := range zipReader.File {
-		if file.Name == filename {
-			extractedFile = file
-			break
-		}
-	}
-
-	if extractedFile == nil {
-		return fmt.Errorf("file '%s' not found in the ZIP archive", filename)
-	}
-
-	// Create the destination directory if it doesn't exist
-	if err := os.MkdirAll(destdir, os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
-	}
-
-	// Create the destination file path
-	destFilePath := filepath.Join(destdir, extractedFile.Name)
-
-	// Open the file inside the ZIP archive
-	srcFile, err := extractedFile.Open()
-	if err != nil {
-		return fmt.Errorf("failed to open file in ZIP archive: %w", err)
-	}
-	defer srcFile.Close()
-
-	// Create the destination file
-	destFile, err := os.Create(destFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to create destination file: %w", err)
-	}
-	defer destFile.Close()
-
-	// Copy the content from the source file to the destination file
-	_, err = io.Copy(destFile, srcFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy file content: %w", err)
-	}
-
-	fmt.Printf("File '%s' extracted to '%s'\n", extractedFile.Name, destFilePath)
-	return nil
+func FileExist(filename string) string {
+	return vfs.FileExist(filename)
 }
 
-// ReadFileFromZip reads the contents of a specific file from a ZIP archive.
-func ReadFileFromZip(zipname string, filename string) ([]byte, error) {
-	// Open the ZIP file
-	zipReader, err := zip.OpenReader(zipname)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open ZIP file: %w", err)
-	}
-	defer zipReader.Close()
-
-	// Find the specific file in the ZIP archive
-	var fileInZip *zip.File
-	for _, file := range zipReader.File {
-		if file.Name == filename {
-			fileInZip = file
-			break
-		}
-	}
-
-	if fileInZip == nil {
-		return nil, fmt.Errorf("file '%s' not found in the ZIP archive", filename)
-	}
-
-	// Open the file inside the ZIP archive
-	srcFile, err := fileInZip.Open()
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file in ZIP archive: %w", err)
-	}
-	defer srcFile.Close()
-
-	// Read the file's content into a byte slice
-	content, err := io.ReadAll(srcFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file content: %w", err)
-	}
-
-	return content, nil
-}
-func FileExist(filename string) string {
-	if info, err := os.Stat(filename); !os.IsNotExist(err) {
-		if info == nil || info.IsDir() {
-			return ""
-		}
-		return filename
-	}
-	var pattern string
-	for _, r := range filename {
-		if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' {
-			pattern += "[" + string(unicode.ToLower(r)) +
-				string(unicode.ToLower(r)+'A'-'a') + "]"
-		} else if r == '*' || r == '?' || r == '[' {
-			pattern += "\\" + string(r)
-		} else {
-			pattern += string(r)
-		}
-	}
-	if m, _ := filepath.Glob(pattern); len(m) > 0 {
-		return m[0]
-	}
-	return ""
+// Whether the path exists and is a directory, or is an archive
+func DirExist(dirname string) bool {
+	return vfs.DirExist(dirname)
 }
 
 func NormalizeFile(file string) string {
@@ -784,6 +641,8 @@ type Layout struct {
 	scale   [2]float32
 	angle   float32
 	window  [4]int32
+	// Text elements wrap lines wider than this, 0 to disable
+	wrapwidth float32
 }
 
 func newLayout(ln int16) *Layout {
@@ -815,6 +674,7 @@ func (l *Layout) Read(pre string, is IniSection) {
 	l.layerno = I32ToI16(Min(2, ln))
 	is.ReadF32(pre+"scale", &l.scale[0], &l.scale[1])
 	is.ReadF32(pre+"angle", &l.angle)
+	is.ReadF32(pre+"wrapwidth", &l.wrapwidth)
 	if is.ReadI32(pre+"window", &l.window[0], &l.window[1], &l.window[2], &l.window[3]) {
 		l.window[0] = int32(float32(l.window[0]) * float32(sys.scrrect[2]) / float32(sys.lifebarLocalcoord[0]))
 		l.window[1] = int32(float32(l.window[1]) * float32(sys.scrrect[3]) / float32(sys.lifebarLocalcoord[1]))
@@ -878,10 +738,10 @@ func (l *Layout) DrawText(x, y, scl float32, ln int16,
 		if l.vfacing < 0 {
 			y += sys.lifebar.fnt_scale
 		}
-		f.Print(text, (x+l.offset[0])*scl, (y+l.offset[1])*scl,
+		f.PrintWrapped(lang.Expand(text), (x+l.offset[0])*scl, (y+l.offset[1])*scl,
 			l.scale[0]*sys.lifebar.fnt_scale*float32(l.facing)*scl,
-			l.scale[1]*sys.lifebar.fnt_scale*float32(l.vfacing)*scl, b, a,
-			&l.window, palfx, frgba)
+			l.scale[1]*sys.lifebar.fnt_scale*float32(l.vfacing)*scl,
+			l.wrapwidth*scl, b, a, &l.window, palfx, frgba)
 	}
 }
 
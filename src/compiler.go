@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"math"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -26,6 +25,8 @@ type Compiler struct {
 	lines            []string
 	i                int
 	linechan         chan *string
+	zssFile          string
+	zssLine          int // Number of the last ZSS line read
 	vars             map[string]uint8
 	funcs            map[string]bytecodeFunction
 	funcUsed         map[string]bool
@@ -47,6 +48,7 @@ func newCompiler() *Compiler {
 		"assertspecial":      c.assertSpecial,
 		"attackdist":         c.attackDist,
 		"attackmulset":       c.attackMulSet,
+		"bgmlayer":           c.bgmLayer,
 		"bgpalfx":            c.bgPalFX,
 		"bindtoparent":       c.bindToParent,
 		"bindtoroot":         c.bindToRoot,
@@ -4993,28 +4995,16 @@ func cnsStringArray(arg string) ([]string, error) {
 // Compile a state file
 func (c *Compiler) stateCompile(def string, states map[int32]StateBytecode,
 	filename string, dirs []string, negoverride bool, constants map[string]float32) error {
-	var zipFileName, str string
+	var str string
 	zss := HasExtension(filename, ".zss")
 	fnz := filename
 
-	if strings.Index(def, ".zip") == -1 {
-		zipFileName = ""
-	} else {
-		lines := SplitAndTrim(def, ",")
-		zipFileName = lines[0]
-	}
 	// Load state file
 	if err := LoadFile(&filename, dirs, func(filename string) error {
 		var err error
 		// If this is a zss file
 		if zss {
-			var b []byte
-			var err error
-			if zipFileName == "" {
-				b, err = os.ReadFile(filename)
-			} else {
-				b, err = ReadFileFromZip(zipFileName, filename)
-			}
+			b, err := vfs.ReadFile(filename)
 			if err != nil {
 				return err
 			}
@@ -5023,11 +5013,7 @@ func (c *Compiler) stateCompile(def string, states map[int32]StateBytecode,
 		}
 
 		// Try reading as an st file
-		if zipFileName == "" {
-			str, err = LoadText(filename)
-		} else {
-			str, err = LoadTextFromZip(zipFileName, filename)
-		}
+		str, err = LoadText(filename)
 		return err
 	}); err != nil {
 		// If filename doesn't exist, see if a zss file exists
@@ -5035,11 +5021,7 @@ func (c *Compiler) stateCompile(def string, states map[int32]StateBytecode,
 		fnz += ".zss"
 		if err := LoadFile(&fnz, dirs, func(filename string) error {
 			var b []byte
-			if zipFileName == "" {
-				b, err = os.ReadFile(filename)
-			} else {
-				b, err = ReadFileFromZip(zipFileName, filename)
-			}
+			b, err = vfs.ReadFile(filename)
 			if err != nil {
 				return err
 			}
@@ -5093,6 +5075,7 @@ func (c *Compiler) stateCompile(def string, states map[int32]StateBytecode,
 		if _, ok := states[c.stateNo]; ok && c.stateNo < 0 {
 			*sbc = states[c.stateNo]
 		}
+		sbc.stateNo = c.stateNo
 		// Interpret the statedef properties
 		if err := c.stateDef(is, sbc); err != nil {
 			return errmes(err)
@@ -5112,6 +5095,8 @@ func (c *Compiler) stateCompile(def string, states map[int32]StateBytecode,
 				break
 			}
 			c.i++
+			// Line of the sctrl header, counting from 1
+			sctrlLine := c.i
 
 			// Create this sctrl and get its properties
 			c.block = newStateBlock()
@@ -5294,6 +5279,7 @@ func (c *Compiler) stateCompile(def string, states map[int32]StateBytecode,
 			if err != nil {
 				return errmes(err)
 			}
+			registerTraceSource(c.playerNo, sctrl, filename, sctrlLine)
 
 			// Check if the triggers can ever be true before appending the new sctrl
 			appending := true
@@ -5348,6 +5334,7 @@ func (c *Compiler) nextLine() (string, bool) {
 	if s == nil {
 		return "", false
 	}
+	c.zssLine++
 	return *s, true
 }
 func (c *Compiler) scan(line *string) string {
@@ -6084,7 +6071,7 @@ func (c *Compiler) stateBlock(line *string, bl *StateBlock, root bool,
 				c.token = "helper"
 			}
 			if ok {
-				scname := c.token
+				scname, scline := c.token, c.zssLine
 				c.scan(line)
 				if err := c.needToken("{"); err != nil {
 					return err
@@ -6117,6 +6104,7 @@ func (c *Compiler) stateBlock(line *string, bl *StateBlock, root bool,
 				if sctrl, err := scf(is, sc, -1); err != nil {
 					return err
 				} else {
+					registerTraceSource(c.playerNo, sctrl, c.zssFile, scline)
 					*ctrls = append(*ctrls, sctrl)
 				}
 				c.scan(line)
@@ -6163,6 +6151,7 @@ func (c *Compiler) stateCompileZ(states map[int32]StateBytecode,
 	sys.ignoreMostErrors = false
 	c.block = nil
 	c.lines, c.i = SplitAndTrim(src, "\n"), 0
+	c.zssFile, c.zssLine = filename, 0
 	c.linechan = make(chan *string)
 	endchan := make(chan bool, 1)
 	stop := func() int {
@@ -6251,6 +6240,7 @@ func (c *Compiler) stateCompileZ(states map[int32]StateBytecode,
 			if _, ok := states[c.stateNo]; ok && c.stateNo < 0 {
 				*sbc = states[c.stateNo]
 			}
+			sbc.stateNo = c.stateNo
 			c.vars = make(map[string]uint8)
 			if err := c.stateDef(is, sbc); err != nil {
 				return errmes(err)
@@ -6330,21 +6320,16 @@ func (c *Compiler) stateCompileZ(states map[int32]StateBytecode,
 
 // Compile a character definition file
 func (c *Compiler) Compile(pn int, def string, constants map[string]float32) (map[int32]StateBytecode, error) {
-	var str, zipFileName string
+	var str string
 	var err error
 	// fmt.Printf("[DEBUG][compiler.go] Compile: dev=[%v]\n", def)
 	c.playerNo = pn
 	states := make(map[int32]StateBytecode)
+	clearTraceSources(pn)
 
 	/* Load initial data from definition file */
-	if strings.Index(def, ".zip") == -1 {
-		str, err = LoadText(def)
-	} else {
-		lines := SplitAndTrim(def, ",")
-		zipFileName = lines[0]
-		str, err = LoadTextFromZip(zipFileName, lines[1])
-	}
-	if err != nil {
+	def = vfsPath(def)
+	if str, err = LoadText(def); err != nil {
 		return nil, err
 	}
 	lines, i, cmd, stcommon := SplitAndTrim(str, "\n"), 0, "", ""
@@ -6412,15 +6397,8 @@ func (c *Compiler) Compile(pn int, def string, constants map[string]float32) (ma
 	if len(cmd) > 0 {
 		if err := LoadFile(&cmd, []string{def, "", sys.motifDir, "data/"}, func(filename string) error {
 			var err error
-			if zipFileName == "" {
-				str, err = LoadText(filename)
-			} else {
-				str, err = LoadTextFromZip(zipFileName, filename)
-			}
-			if err != nil {
-				return err
-			}
-			return nil
+			str, err = LoadText(filename)
+			return err
 		}); err != nil {
 			return nil, err
 		}
@@ -327,6 +327,19 @@ func (c *Compiler) playSnd(is IniSection, sc *StateControllerBase, _ int8) (Stat
 			playSnd_stoponchangestate, VT_Bool, 1, false); err != nil {
 			return err
 		}
+		if err := c.stateParam(is, "bus", false, func(data string) error {
+			if len(data) == 0 {
+				return Error("Value not specified")
+			}
+			bus := audioBusIndex(strings.Trim(data, "\""))
+			if bus < 0 {
+				return Error("Invalid value: " + data)
+			}
+			sc.add(playSnd_bus, sc.iToExp(int32(bus)))
+			return nil
+		}); err != nil {
+			return err
+		}
 		return nil
 	})
 	return *ret, err
@@ -4430,6 +4443,28 @@ func (c *Compiler) playBgm(is IniSection, sc *StateControllerBase, _ int8) (Stat
 			playBgm_startposition, VT_Int, 1, false); err != nil {
 			return err
 		}
+		if err := c.paramValue(is, sc, "crossfade",
+			playBgm_crossfade, VT_Int, 1, false); err != nil {
+			return err
+		}
+		return nil
+	})
+	return *ret, err
+}
+func (c *Compiler) bgmLayer(is IniSection, sc *StateControllerBase, _ int8) (StateController, error) {
+	ret, err := (*bgmLayer)(sc), c.stateSec(is, func() error {
+		if err := c.paramValue(is, sc, "layer",
+			bgmLayer_layer, VT_Int, 1, true); err != nil {
+			return err
+		}
+		if err := c.paramValue(is, sc, "volume",
+			bgmLayer_volume, VT_Int, 1, false); err != nil {
+			return err
+		}
+		if err := c.paramValue(is, sc, "time",
+			bgmLayer_time, VT_Int, 1, false); err != nil {
+			return err
+		}
 		return nil
 	})
 	return *ret, err
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Content packs are .zip archives or folders in packDir with a pack.json
+// manifest at their root. Enabled packs are mounted on the root of the
+// virtual filesystem, and their characters and stages are added to
+// select.def inside marked blocks, so the rest of the file is left alone.
+const (
+	packDir          = "packs"
+	packManifestName = "pack.json"
+	packStateFile    = "save/packs.json"
+	packSelectDef    = "data/select.def"
+)
+
+type PackManifest struct {
+	Name        string
+	Version     string
+	Author      string
+	Description string
+	// Minimum engine version
+	Engine string
+	// Lines for the [Characters] and [ExtraStages] sections of select.def
+	Chars  []string
+	Stages []string
+	// Other packs, as "name" or "name >= version"
+	Requires []string
+	// Files the pack uses from the game or from other packs, such as common
+	// files or fonts
+	Files []string
+}
+
+type ContentPack struct {
+	PackManifest
+	path    string // Archive or folder
+	enabled bool
+}
+
+var (
+	packNameRegexp    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	versionPartRegexp = regexp.MustCompile(`[0-9]+`)
+)
+
+// Compare two version strings by their numeric parts, so "1.10" is newer
+// than "1.9" and "202408-dev" equals "202408"
+func compareVersions(a, b string) int {
+	split := func(v string) []int {
+		var n []int
+		for _, s := range versionPartRegexp.FindAllString(v, -1) {
+			i, _ := strconv.Atoi(s)
+			n = append(n, i)
+		}
+		return n
+	}
+	va, vb := split(a), split(b)
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Parse a "name" or "name >= version" requirement
+func parsePackRequirement(req string) (name, version string) {
+	if i := strings.Index(req, ">="); i >= 0 {
+		return strings.TrimSpace(req[:i]), strings.TrimSpace(req[i+2:])
+	}
+	return strings.TrimSpace(req), ""
+}
+
+func (m *PackManifest) validate() error {
+	if !packNameRegexp.MatchString(m.Name) {
+		return Error(fmt.Sprintf("invalid pack name: \"%v\"", m.Name))
+	}
+	if m.Engine != "" && compareVersions(Version, m.Engine) < 0 {
+		return Error(fmt.Sprintf("%v %v needs engine version %v or newer, this is %v",
+			m.Name, m.Version, m.Engine, Version))
+	}
+	return nil
+}
+
+// Read the manifest of a pack archive or folder
+func readPackManifest(path string) (*PackManifest, error) {
+	var data []byte
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		if data, err = os.ReadFile(filepath.Join(path, packManifestName)); err != nil {
+			return nil, err
+		}
+	} else {
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		f, err := zr.Open(packManifestName)
+		if err != nil {
+			return nil, Error(fmt.Sprintf("%v: no %v in the archive", path, packManifestName))
+		}
+		data, err = io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := &PackManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, Error(fmt.Sprintf("%v: %v", path, err))
+	}
+	return m, nil
+}
+
+// ------------------------------------------------------------------
+// PackManager
+
+type PackManager struct {
+	packs   []*ContentPack
+	enabled []string // In mount order
+}
+
+// Read the installed packs and the list of enabled ones
+func loadPackManager() *PackManager {
+	pm := &PackManager{}
+	if data, err := os.ReadFile(packStateFile); err == nil {
+		json.Unmarshal(data, &pm.enabled)
+	}
+	entries, _ := os.ReadDir(packDir)
+	for _, e := range entries {
+		path := filepath.Join(packDir, e.Name())
+		if !e.IsDir() && !isZipName(e.Name()) {
+			continue
+		}
+		m, err := readPackManifest(path)
+		if err != nil {
+			sys.errLog.Printf("Failed to read content pack: %v", err)
+			continue
+		}
+		p := &ContentPack{PackManifest: *m, path: path}
+		for _, name := range pm.enabled {
+			p.enabled = p.enabled || strings.EqualFold(name, p.Name)
+		}
+		pm.packs = append(pm.packs, p)
+	}
+	return pm
+}
+
+func (pm *PackManager) get(name string) *ContentPack {
+	for _, p := range pm.packs {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (pm *PackManager) save() error {
+	data, err := json.MarshalIndent(pm.enabled, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(packStateFile, data, 0644)
+}
+
+// Problems that prevent the pack from being used. Files are looked up in the
+// pack itself and in the virtual filesystem, so the enabled packs must be
+// mounted first.
+func (pm *PackManager) check(p *ContentPack) []string {
+	var errs []string
+	if err := p.validate(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, req := range p.Requires {
+		name, version := parsePackRequirement(req)
+		dep := pm.get(name)
+		switch {
+		case dep == nil:
+			errs = append(errs, fmt.Sprintf("requires pack %v, which is not installed", req))
+		case version != "" && compareVersions(dep.Version, version) < 0:
+			errs = append(errs, fmt.Sprintf("requires pack %v, %v is installed", req, dep.Version))
+		case !dep.enabled:
+			errs = append(errs, fmt.Sprintf("requires pack %v, which is disabled", dep.Name))
+		}
+	}
+	// A pack that is not mounted yet is looked into on its own
+	var own *VirtualFS
+	if len(p.Files) > 0 && !p.enabled {
+		own = newVirtualFS()
+		if err := own.Mount(p.path, ""); err != nil {
+			errs = append(errs, err.Error())
+		}
+		defer own.Close()
+	}
+	for _, f := range p.Files {
+		if FileExist(f) == "" && (own == nil || own.FileExist(f) == "") {
+			errs = append(errs, fmt.Sprintf("requires file %v, which was not found", f))
+		}
+	}
+	return errs
+}
+
+// Mount the enabled packs that can be used. Called at startup.
+func (pm *PackManager) mount() {
+	for _, name := range pm.enabled {
+		p := pm.get(name)
+		if p == nil {
+			sys.errLog.Printf("Enabled content pack %v is not installed", name)
+			continue
+		}
+		if err := p.validate(); err != nil {
+			sys.errLog.Printf("Content pack not loaded: %v", err)
+			continue
+		}
+		if err := vfs.Mount(p.path, ""); err != nil {
+			sys.errLog.Printf("Failed to mount content pack %v: %v", p.Name, err)
+		}
+	}
+}
+
+// Print the installed packs
+func (pm *PackManager) List() {
+	if len(pm.packs) == 0 {
+		fmt.Printf("No content packs installed in %v/\n", packDir)
+		return
+	}
+	for _, p := range pm.packs {
+		state := "disabled"
+		if p.enabled {
+			state = "enabled"
+		}
+		fmt.Printf("%v %v by %v [%v]\n", p.Name, p.Version, p.Author, state)
+		if p.Description != "" {
+			fmt.Printf("    %v\n", p.Description)
+		}
+		for _, e := range pm.check(p) {
+			fmt.Printf("    ERROR: %v\n", e)
+		}
+	}
+}
+
+// Copy a pack archive into the pack folder, replacing an older version
+func (pm *PackManager) Install(archive string) error {
+	if !isZipName(archive) {
+		return Error(fmt.Sprintf("%v is not a .zip archive", archive))
+	}
+	m, err := readPackManifest(archive)
+	if err != nil {
+		return err
+	}
+	if err := m.validate(); err != nil {
+		return err
+	}
+	dst := filepath.Join(packDir, m.Name+".zip")
+	if old := pm.get(m.Name); old != nil {
+		if compareVersions(m.Version, old.Version) < 0 {
+			return Error(fmt.Sprintf("%v %v is older than the installed version %v",
+				m.Name, m.Version, old.Version))
+		}
+		if old.path != dst {
+			return Error(fmt.Sprintf("%v is installed as a folder: %v", m.Name, old.path))
+		}
+	}
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(packDir, 0755); err != nil {
+		return err
+	}
+	// Release the mounted archives so the old version can be replaced
+	vfs.Close()
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(dst+".tmp", dst)
+	}
+	if err != nil {
+		os.Remove(dst + ".tmp")
+		return err
+	}
+	fmt.Printf("Installed %v %v\n", m.Name, m.Version)
+	if p := pm.get(m.Name); p != nil && p.enabled {
+		// Update the select.def entries of the new version
+		p.PackManifest = *m
+		return pm.updateSelectDef(p, true)
+	}
+	fmt.Printf("Enable it with -packenable %v\n", m.Name)
+	return nil
+}
+
+func (pm *PackManager) Enable(name string) error {
+	p := pm.get(name)
+	if p == nil {
+		return Error(fmt.Sprintf("content pack %v is not installed", name))
+	}
+	if p.enabled {
+		return nil
+	}
+	if errs := pm.check(p); len(errs) > 0 {
+		return Error(fmt.Sprintf("cannot enable %v:\n  %v", p.Name, strings.Join(errs, "\n  ")))
+	}
+	if err := vfs.Mount(p.path, ""); err != nil {
+		return err
+	}
+	if err := pm.updateSelectDef(p, true); err != nil {
+		return err
+	}
+	p.enabled = true
+	pm.enabled = append(pm.enabled, p.Name)
+	fmt.Printf("Enabled %v %v\n", p.Name, p.Version)
+	return pm.save()
+}
+
+func (pm *PackManager) Disable(name string) error {
+	p := pm.get(name)
+	if p == nil {
+		return Error(fmt.Sprintf("content pack %v is not installed", name))
+	}
+	if !p.enabled {
+		return nil
+	}
+	for _, other := range pm.packs {
+		for _, req := range other.Requires {
+			if dep, _ := parsePackRequirement(req); other.enabled && strings.EqualFold(dep, p.Name) {
+				return Error(fmt.Sprintf("cannot disable %v, %v requires it", p.Name, other.Name))
+			}
+		}
+	}
+	if err := pm.updateSelectDef(p, false); err != nil {
+		return err
+	}
+	p.enabled = false
+	for i, n := range pm.enabled {
+		if strings.EqualFold(n, p.Name) {
+			pm.enabled = append(pm.enabled[:i], pm.enabled[i+1:]...)
+			break
+		}
+	}
+	fmt.Printf("Disabled %v\n", p.Name)
+	return pm.save()
+}
+
+func (pm *PackManager) Uninstall(name string) error {
+	p := pm.get(name)
+	if p == nil {
+		return Error(fmt.Sprintf("content pack %v is not installed", name))
+	}
+	if err := pm.Disable(name); err != nil {
+		return err
+	}
+	vfs.Close()
+	if err := os.RemoveAll(p.path); err != nil {
+		return err
+	}
+	fmt.Printf("Uninstalled %v\n", p.Name)
+	return nil
+}
+
+// ------------------------------------------------------------------
+// select.def blocks
+
+func packBlockStart(name string) string {
+	return ";<pack " + name + ">"
+}
+
+func packBlockEnd(name string) string {
+	return ";</pack " + name + ">"
+}
+
+// Remove the block of the pack from select.def and, if add is set, insert
+// the current entries at the end of their sections. Every other line is
+// kept as is.
+func (pm *PackManager) updateSelectDef(p *ContentPack, add bool) error {
+	data, err := os.ReadFile(packSelectDef)
+	if err != nil {
+		return err
+	}
+	eol := "\n"
+	if strings.Contains(string(data), "\r\n") {
+		eol = "\r\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	lines = removePackBlocks(lines, p.Name)
+	if add {
+		lines = insertPackBlock(lines, "Characters", p.Name, p.Chars)
+		lines = insertPackBlock(lines, "ExtraStages", p.Name, p.Stages)
+	}
+	tmp := packSelectDef + ".update"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, eol)), 0644); err != nil {
+		return err
+	}
+	os.Remove(packSelectDef + ".bak")
+	if err := os.Rename(packSelectDef, packSelectDef+".bak"); err != nil {
+		return err
+	}
+	return os.Rename(tmp, packSelectDef)
+}
+
+func removePackBlocks(lines []string, name string) []string {
+	start, end := strings.ToLower(packBlockStart(name)), strings.ToLower(packBlockEnd(name))
+	out := lines[:0:0]
+	inside := false
+	for _, l := range lines {
+		t := strings.ToLower(strings.TrimSpace(l))
+		if t == start {
+			inside = true
+		} else if t == end && inside {
+			inside = false
+		} else if !inside {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Insert the entries after the last entry of the section, adding the
+// section at the end of the file if it is missing
+func insertPackBlock(lines []string, section, name string, entries []string) []string {
+	if len(entries) == 0 {
+		return lines
+	}
+	block := append([]string{packBlockStart(name)}, entries...)
+	block = append(block, packBlockEnd(name))
+	at, found := len(lines), false
+	for i, l := range lines {
+		t := strings.TrimSpace(l)
+		if !strings.HasPrefix(t, "[") {
+			if found && t != "" && !strings.HasPrefix(t, ";") {
+				at = i + 1
+			} else if found && strings.HasPrefix(t, ";</pack ") {
+				at = i + 1
+			}
+			continue
+		}
+		if found {
+			break
+		}
+		if header, _, _ := strings.Cut(t[1:], "]"); strings.EqualFold(strings.TrimSpace(header), section) {
+			found, at = true, i+1
+		}
+	}
+	if !found {
+		for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+			lines = lines[:len(lines)-1]
+		}
+		return append(append(lines, "", "["+section+"]"), append(block, "")...)
+	}
+	return append(lines[:at:at], append(block, lines[at:]...)...)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.10", "1.9", 1},
+		{"1.9", "1.10", -1},
+		{"1", "1.0.0", 0},
+		{"1.0.1", "1", 1},
+		{"202408-dev", "202408", 0},
+		{"v0.99", "0.99.0", 0},
+		{"2.0 beta 3", "2.0 beta 12", -1},
+		{"", "0", 0},
+		{"", "0.1", -1},
+	}
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); got != c.want {
+			t.Errorf("compareVersions(%q, %q) = %v, want %v", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestPackBlocks(t *testing.T) {
+	split := func(s string) []string {
+		return strings.Split(s, "\n")
+	}
+	cases := []struct {
+		name    string
+		in      string
+		section string
+		entries []string
+		want    string
+	}{
+		{"after the last entry", "[Characters]\nkfm\n\nsuave ; comment\n\n[ExtraStages]\nstage0.def",
+			"Characters", []string{"pack/a", "pack/b"},
+			"[Characters]\nkfm\n\nsuave ; comment\n;<pack p>\npack/a\npack/b\n;</pack p>\n\n[ExtraStages]\nstage0.def"},
+		{"empty section", "[Characters]\n; nothing yet\n\n[Options]",
+			"characters", []string{"pack/a"},
+			"[Characters]\n;<pack p>\npack/a\n;</pack p>\n; nothing yet\n\n[Options]"},
+		{"last section", "[ExtraStages]\nstage0.def\n",
+			"ExtraStages", []string{"stages/p.def"},
+			"[ExtraStages]\nstage0.def\n;<pack p>\nstages/p.def\n;</pack p>\n"},
+		{"missing section", "[Characters]\nkfm\n\n",
+			"ExtraStages", []string{"stages/p.def"},
+			"[Characters]\nkfm\n\n[ExtraStages]\n;<pack p>\nstages/p.def\n;</pack p>\n"},
+		{"no entries", "[Characters]\nkfm", "Characters", nil, "[Characters]\nkfm"},
+	}
+	for _, c := range cases {
+		got := strings.Join(insertPackBlock(split(c.in), c.section, "p", c.entries), "\n")
+		if got != c.want {
+			t.Errorf("%v: got %q, want %q", c.name, got, c.want)
+			continue
+		}
+		// Removing the block gives the file back, apart from a section
+		// that had to be added
+		if c.name != "missing section" {
+			if back := strings.Join(removePackBlocks(split(got), "p"), "\n"); back != c.in {
+				t.Errorf("%v: removing the block gave %q, want %q", c.name, back, c.in)
+			}
+		}
+	}
+
+	// A later pack goes after the blocks of the earlier ones, and only the
+	// blocks of the named pack are removed, whatever their case
+	lines := split("[Characters]\nkfm\n\n[ExtraStages]")
+	lines = insertPackBlock(lines, "Characters", "a", []string{"a/one"})
+	lines = insertPackBlock(lines, "Characters", "b", []string{"b/one"})
+	lines = insertPackBlock(lines, "ExtraStages", "a", []string{"a/stage.def"})
+	want := split("[Characters]\nkfm\n;<pack a>\na/one\n;</pack a>\n;<pack b>\nb/one\n;</pack b>\n\n" +
+		"[ExtraStages]\n;<pack a>\na/stage.def\n;</pack a>")
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("inserting two packs gave %q, want %q", lines, want)
+	}
+	lines[2], lines[4] = " ;<PACK A>", ";</Pack A>  "
+	want = split("[Characters]\nkfm\n;<pack b>\nb/one\n;</pack b>\n\n[ExtraStages]")
+	if got := removePackBlocks(lines, "a"); !reflect.DeepEqual(got, want) {
+		t.Errorf("removing a pack gave %q, want %q", got, want)
+	}
+}
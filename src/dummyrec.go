@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Training dummy recording and playback. Inputs are recorded from the dummy's
+// command buffer, relative to its facing (IB_PL is back and IB_PR forward), so
+// a slot plays back the same way on either side of the screen.
+
+const (
+	DummySlots     = 5
+	dummyMaxFrames = 60 * 60
+	dummySaveDir   = "save/training"
+)
+
+type DummyPlayback int32
+
+const (
+	DP_Off DummyPlayback = iota
+	DP_Loop
+	DP_Random
+	DP_Wakeup
+	DP_Block
+	DP_Hitstun
+)
+
+var dummyPlaybackNames = []string{"off", "loop", "random", "wakeup", "block", "hitstun"}
+
+type DummyRecorder struct {
+	slots      [DummySlots][]InputBits
+	dummy      int // Player index of the dummy
+	controller int // Player whose controller drives the dummy while recording
+	recording  int // Slot being recorded, -1 if none
+	mode       DummyPlayback
+	slot       int // Slot to play back, -1 picks a random recorded slot
+	playing    []InputBits
+	pos        int
+	pending    DummyPlayback // Trigger waiting for the dummy to recover
+	charDef    string        // Character the slots belong to
+}
+
+var dummyRec = newDummyRecorder()
+
+func newDummyRecorder() *DummyRecorder {
+	return &DummyRecorder{dummy: 1, recording: -1, slot: -1}
+}
+
+// Whether recording or playback can take over the dummy's inputs
+func (dr *DummyRecorder) enabled() bool {
+	return sys.gameMode == "training" && sys.fileInput == nil && sys.netInput == nil &&
+		dr.dummy < len(sys.chars) && len(sys.chars[dr.dummy]) > 0
+}
+
+// Whether the dummy's inputs come from a slot this frame
+func (dr *DummyRecorder) playingBack(pn int) bool {
+	return pn == dr.dummy && dr.playing != nil && dr.enabled()
+}
+
+// Whether the dummy is driven by another controller this frame
+func (dr *DummyRecorder) controlling(pn int) bool {
+	return dr.recording >= 0 && (pn == dr.dummy || pn == dr.controller) && dr.enabled()
+}
+
+// Starts recording into slot. The dummy is controlled with the controller of
+// player pn, unless pn is the dummy itself.
+func (dr *DummyRecorder) Record(slot, pn int) error {
+	if slot < 0 || slot >= DummySlots {
+		return Error(fmt.Sprintf("Invalid recording slot: %v", slot+1))
+	}
+	if pn < 0 || pn >= len(sys.chars) || len(sys.chars[pn]) == 0 {
+		return Error(fmt.Sprintf("Invalid player number: %v", pn+1))
+	}
+	if !dr.enabled() {
+		return Error("Recording is only available in training mode")
+	}
+	dr.sync()
+	dr.playing = nil
+	dr.recording = slot
+	dr.controller = pn
+	dr.slots[slot] = dr.slots[slot][:0]
+	return nil
+}
+
+// Stops recording and saves the slots of the dummy's character.
+func (dr *DummyRecorder) Stop() {
+	if dr.recording < 0 {
+		return
+	}
+	dr.recording = -1
+	if err := dr.save(); err != nil {
+		sys.errLog.Printf("Failed to save training recordings: %v", err)
+	}
+}
+
+// Stops recording and playback when the match ends. The slots are loaded
+// again from the save file by the next training match.
+func (dr *DummyRecorder) reset() {
+	dr.Stop()
+	dr.SetPlayback(DP_Off, -1)
+	dr.slots = [DummySlots][]InputBits{}
+	dr.charDef = ""
+}
+
+func (dr *DummyRecorder) SetPlayback(mode DummyPlayback, slot int) {
+	dr.mode = mode
+	dr.slot = slot
+	dr.playing = nil
+	dr.pending = DP_Off
+}
+
+func (dr *DummyRecorder) Clear(slot int) {
+	if slot >= 0 && slot < DummySlots {
+		dr.slots[slot] = nil
+		if err := dr.save(); err != nil {
+			sys.errLog.Printf("Failed to save training recordings: %v", err)
+		}
+	}
+}
+
+// Chooses the slot to play: the selected one, or a random recorded one.
+func (dr *DummyRecorder) pick() []InputBits {
+	if dr.slot >= 0 && dr.slot < DummySlots {
+		if len(dr.slots[dr.slot]) == 0 {
+			return nil
+		}
+		return dr.slots[dr.slot]
+	}
+	var recorded []int
+	for i := range dr.slots {
+		if len(dr.slots[i]) > 0 {
+			recorded = append(recorded, i)
+		}
+	}
+	if len(recorded) == 0 {
+		return nil
+	}
+	return dr.slots[recorded[Rand(0, int32(len(recorded))-1)]]
+}
+
+// Called before the inputs of a frame are read.
+func (dr *DummyRecorder) prepare() {
+	if !dr.enabled() {
+		return
+	}
+	dr.sync()
+	c := sys.chars[dr.dummy][0]
+	if dr.recording >= 0 || sys.roundState() != 2 {
+		dr.playing, dr.pending = nil, DP_Off
+		return
+	}
+	switch dr.mode {
+	case DP_Loop, DP_Random:
+		if dr.playing == nil {
+			dr.playing, dr.pos = dr.pick(), 0
+		}
+	case DP_Wakeup, DP_Block, DP_Hitstun:
+		// Remember what the dummy is recovering from, and play once it can act
+		switch {
+		case c.ss.no == 5110 || c.ss.no == 5120:
+			dr.pending = DP_Wakeup
+		case c.ss.no >= 150 && c.ss.no <= 155:
+			dr.pending = DP_Block
+		case c.ss.moveType == MT_H && dr.pending != DP_Wakeup:
+			dr.pending = DP_Hitstun
+		}
+		if dr.pending != DP_Off && frameDataFree(c) {
+			if dr.pending == dr.mode && dr.playing == nil {
+				dr.playing, dr.pos = dr.pick(), 0
+			}
+			dr.pending = DP_Off
+		}
+	}
+}
+
+// Feeds the command buffer of c, a character of player pn, replacing the
+// controller input while the dummy records or plays back, or while a
+// scenario test drives the player.
+func (dr *DummyRecorder) input(c *Char, pn int) bool {
+	cl := &c.cmd[0]
+	if cl.Buffer == nil {
+		return false
+	}
+	if scenario.driving(pn) {
+		return scenario.input(c, pn)
+	}
+	if dr.controlling(pn) && dr.controller != dr.dummy {
+		if pn == dr.dummy {
+			return cl.Input(sys.chars[dr.controller][0].key, int32(c.facing), 0, c.inputFlag)
+		}
+		// The recording player's controller is lent to the dummy
+		step := cl.Buffer.Bb != 0
+		InputBits(0).BitsToKeys(cl.Buffer, int32(c.facing))
+		return step
+	}
+	if dr.playingBack(pn) {
+		step := cl.Buffer.Bb != 0
+		ib := dr.playing[dr.pos]
+		if c.facing < 0 {
+			ib = ib&^(IB_PL|IB_PR) | (ib&IB_PL)<<1 | (ib&IB_PR)>>1
+		}
+		ib.BitsToKeys(cl.Buffer, int32(c.facing))
+		return step
+	}
+	return cl.Input(c.key, int32(c.facing), sys.com[pn], c.inputFlag)
+}
+
+// Called after the inputs of a frame are read.
+func (dr *DummyRecorder) advance() {
+	if !dr.enabled() {
+		return
+	}
+	if dr.recording >= 0 {
+		cb := sys.chars[dr.dummy][0].cmd[0].Buffer
+		var ib InputBits
+		ib.KeysToBits(cb.U > 0, cb.D > 0, cb.B > 0, cb.F > 0, cb.a > 0, cb.b > 0, cb.c > 0,
+			cb.x > 0, cb.y > 0, cb.z > 0, cb.s > 0, cb.d > 0, cb.w > 0, cb.m > 0)
+		dr.slots[dr.recording] = append(dr.slots[dr.recording], ib)
+		if len(dr.slots[dr.recording]) >= dummyMaxFrames {
+			dr.Stop()
+		}
+	} else if dr.playing != nil {
+		dr.pos++
+		if dr.pos >= len(dr.playing) {
+			dr.playing = nil
+			if dr.mode == DP_Loop || dr.mode == DP_Random {
+				dr.playing, dr.pos = dr.pick(), 0
+			}
+		}
+	}
+}
+
+// Loads the slots saved for the dummy's character when it changes.
+func (dr *DummyRecorder) sync() {
+	def := sys.chars[dr.dummy][0].gi().def
+	if def == dr.charDef {
+		return
+	}
+	dr.charDef = def
+	dr.slots = [DummySlots][]InputBits{}
+	dr.playing = nil
+	dr.recording = -1
+	if err := dr.load(); err != nil && !os.IsNotExist(err) {
+		sys.errLog.Printf("Failed to load training recordings: %v", err)
+	}
+}
+
+// Recordings are saved per character, run-length encoded as [bits, frames]
+// pairs.
+type dummySaveData struct {
+	Def   string
+	Slots [][][2]int32
+}
+
+func (dr *DummyRecorder) saveFile() string {
+	name := strings.TrimSuffix(filepath.ToSlash(dr.charDef), filepath.Ext(dr.charDef))
+	name = strings.NewReplacer("/", "_", ":", "_", " ", "_").Replace(strings.ToLower(name))
+	return filepath.Join(dummySaveDir, name+".json")
+}
+
+func (dr *DummyRecorder) save() error {
+	if dr.charDef == "" {
+		return nil
+	}
+	data := dummySaveData{Def: dr.charDef, Slots: make([][][2]int32, DummySlots)}
+	for i, s := range dr.slots {
+		data.Slots[i] = [][2]int32{}
+		for _, ib := range s {
+			if n := len(data.Slots[i]); n > 0 && data.Slots[i][n-1][0] == int32(ib) {
+				data.Slots[i][n-1][1]++
+			} else {
+				data.Slots[i] = append(data.Slots[i], [2]int32{int32(ib), 1})
+			}
+		}
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dummySaveDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dr.saveFile(), b, 0644)
+}
+
+func (dr *DummyRecorder) load() error {
+	b, err := os.ReadFile(dr.saveFile())
+	if err != nil {
+		return err
+	}
+	var data dummySaveData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	for i := 0; i < len(data.Slots) && i < DummySlots; i++ {
+		for _, run := range data.Slots[i] {
+			for j := int32(0); j < run[1] && len(dr.slots[i]) < dummyMaxFrames; j++ {
+				dr.slots[i] = append(dr.slots[i], InputBits(run[0]))
+			}
+		}
+	}
+	return nil
+}
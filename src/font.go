@@ -3,9 +3,10 @@ package main
 import (
 	"encoding/binary"
 	"math"
-	"os"
 	"regexp"
 	"strings"
+
+	"github.com/golang/freetype/truetype"
 )
 
 // FntCharImage stores sprite and position
@@ -35,6 +36,12 @@ type Fnt struct {
 	offset    [2]int32
 	ttf       TtfFont
 	paltex    *Texture
+	// Metrics of TrueType fonts, nil if unavailable
+	metrics *truetype.Font
+	// Fonts searched for missing glyphs, and their names in the definition
+	fallback      []*Fnt
+	fallbackNames []string
+	layouts       map[layoutKey]*TextLayout
 }
 
 func newFnt() *Fnt {
@@ -45,6 +52,16 @@ func newFnt() *Fnt {
 }
 
 func loadFnt(filename string, height int32) (*Fnt, error) {
+	f, err := loadFntFile(filename, height)
+	if err != nil {
+		return nil, err
+	}
+	f.loadFallbacks(filename, f.fallbackNames, height)
+	return f, nil
+}
+
+// Load a font without its fallback fonts
+func loadFntFile(filename string, height int32) (*Fnt, error) {
 	if HasExtension(filename, ".fnt") {
 		return loadFntV1(filename)
 	}
@@ -56,7 +73,7 @@ func loadFntV1(filename string) (*Fnt, error) {
 	f := newFnt()
 	f.images[0] = make(map[rune]*FntCharImage)
 
-	fp, err := os.Open(filename)
+	fp, err := vfs.Open(filename)
 
 	if err != nil {
 		return nil, Error("File not found")
@@ -307,6 +324,12 @@ func loadDefInfo(f *Fnt, filename string, is IniSection, height int32) {
 		f.offset[1] = Atoi(ary[1])
 	}
 
+	for _, name := range SplitAndTrim(is["fallback"], ",") {
+		if name != "" {
+			f.fallbackNames = append(f.fallbackNames, name)
+		}
+	}
+
 	if len(is["file"]) > 0 {
 		if f.Type == "truetype" {
 			LoadFntTtf(f, filename, is["file"], height)
@@ -392,25 +415,7 @@ func (f *Fnt) CharWidth(c rune, bt int32) int32 {
 // TextWidth returns the width that has a specified text.
 // This depends on each char's width and font spacing
 func (f *Fnt) TextWidth(txt string, bank int32) (w int32) {
-	if f.BankType != "sprite" {
-		bank = 0
-	}
-	for i, c := range txt {
-		if f.Type == "truetype" {
-			w += int32(f.ttf.Width(1, string(c)))
-		} else {
-			cw := f.CharWidth(c, bank)
-			// in mugen negative spacing matching char width seems to skip calc,
-			// even for 1 symbol string (which normally shouldn't use spacing)
-			if cw+f.Spacing[0] > 0 {
-				w += cw
-				if i < len(txt)-1 {
-					w += f.Spacing[0]
-				}
-			}
-		}
-	}
-	return
+	return int32(f.Layout(txt, bank, 0).Width())
 }
 
 func (f *Fnt) getCharSpr(c rune, bank, bt int32) *Sprite {
@@ -457,7 +462,7 @@ func (f *Fnt) drawChar(
 		f.paltex = spr.CachePalette(pal)
 	}
 	rp := RenderParams{
-		spr.Tex, f.paltex, spr.Size,
+		spr.Tex, f.paltex, spr.uv, spr.Size,
 		-x * sys.widthScale, -y * sys.heightScale, notiling,
 		xscl * sys.widthScale, xscl * sys.widthScale,
 		yscl * sys.heightScale, 1, 0, 1, 1,
@@ -471,75 +476,89 @@ func (f *Fnt) drawChar(
 }
 
 func (f *Fnt) Print(txt string, x, y, xscl, yscl float32, bank, align int32,
+	window *[4]int32, palfx *PalFX, frgba [4]float32) {
+	f.PrintWrapped(txt, x, y, xscl, yscl, 0, bank, align, window, palfx, frgba)
+}
+
+// PrintWrapped prints a text wrapping lines wider than wrap, in the same
+// units as x
+func (f *Fnt) PrintWrapped(txt string, x, y, xscl, yscl, wrap float32, bank, align int32,
 	window *[4]int32, palfx *PalFX, frgba [4]float32) {
 	if !sys.frameSkip {
-		if f.Type == "truetype" {
-			f.DrawTtf(txt, x, y, xscl, yscl, align, true, window, frgba)
-		} else {
-			f.DrawText(txt, x, y, xscl, yscl, bank, align, window, palfx)
-		}
+		f.DrawText(txt, x, y, xscl, yscl, wrap, bank, align, window, palfx, frgba)
 	}
 }
 
-// DrawText prints on screen a specified text with the current font sprites
-func (f *Fnt) DrawText(txt string, x, y, xscl, yscl float32, bank, align int32,
-	window *[4]int32, palfx *PalFX) {
+// DrawText prints on screen a specified text, taking the glyphs missing
+// from this font from its fallback fonts
+func (f *Fnt) DrawText(txt string, x, y, xscl, yscl, wrap float32, bank, align int32,
+	window *[4]int32, palfx *PalFX, frgba [4]float32) {
 
 	if len(txt) == 0 {
 		return
 	}
 
-	var bt int32
-	if f.BankType == "sprite" {
-		bt = bank
-		bank = 0
-	} else if bank < 0 || len(f.palettes) <= int(bank) {
-		bank = 0
-	}
-
-	// not existing characters treated as space
-	for i, c := range txt {
-		if c != ' ' && f.images[bt][c] == nil {
-			//txt = strings.Replace(txt, string(c), " ", -1)
-			txt = txt[:i] + string(' ') + txt[i+1:]
-		}
+	if f.Type == "truetype" {
+		// TrueType glyphs are drawn with a uniform scale
+		xscl = (xscl + yscl) / 2
+		yscl = xscl
 	}
-
-	x += float32(f.offset[0])*xscl + float32(sys.gameWidth-320)/2
-	y += float32(f.offset[1]-int32(f.Size[1])+1)*yscl + float32(sys.gameHeight-240)
-
-	if align == 0 {
-		x -= float32(f.TextWidth(txt, bank)) * xscl * 0.5
-	} else if align < 0 {
-		x -= float32(f.TextWidth(txt, bank)) * xscl
+	if wrap > 0 && xscl != 0 {
+		wrap /= float32(math.Abs(float64(xscl)))
 	}
+	tl := f.Layout(txt, bank, wrap)
 
-	var pal []uint32
-	if len(f.palettes) != 0 {
-		pal = f.palettes[bank][:] //palfx.getFxPal(f.palettes[bank][:], false)
+	if f.BankType == "sprite" || bank < 0 || len(f.palettes) <= int(bank) {
+		bank = 0
 	}
+	x += float32(sys.gameWidth-320) / 2
 
 	f.paltex = nil
-	for _, c := range txt {
-		x += f.drawChar(x, y, xscl, yscl, bank, bt, c, pal, window, palfx) + xscl*float32(f.Spacing[0])
-	}
-}
-
-func (f *Fnt) DrawTtf(txt string, x, y, xscl, yscl float32, align int32,
-	blend bool, window *[4]int32, frgba [4]float32) {
-
-	if len(txt) == 0 {
-		return
+	for _, fb := range f.fallback {
+		fb.paltex = nil
 	}
-
-	x += float32(f.offset[0])*xscl + float32(sys.gameWidth-320)/2
-	//y += float32(f.offset[1]-int32(f.Size[1])+1)*yscl + float32(sys.gameHeight-240)
-
 	win := [4]int32{(*window)[0], sys.scrrect[3] - ((*window)[1] + (*window)[3]),
 		(*window)[2], (*window)[3]}
-
-	f.ttf.SetColor(frgba[0], frgba[1], frgba[2], frgba[3])
-	f.ttf.Printf(x, y, (xscl+yscl)/2, align, blend, win, "%s", txt) //x, y, scale, align, blend, window, string, printf args
+	for i := 0; i < len(tl.Glyphs); i++ {
+		g := tl.Glyphs[i]
+		gf := g.Font
+		gx := x + (g.X+float32(gf.offset[0]))*xscl
+		if align == 0 {
+			gx -= tl.Lines[g.Line] * xscl * 0.5
+		} else if align < 0 {
+			gx -= tl.Lines[g.Line] * xscl
+		}
+		if gf.Type == "truetype" {
+			if gf.ttf == nil {
+				continue
+			}
+			// Glyphs of the same font placed one after another without
+			// kerning are drawn with a single call
+			run := []rune{g.Rune}
+			for i+1 < len(tl.Glyphs) {
+				n, p := tl.Glyphs[i+1], tl.Glyphs[i]
+				if n.Font != gf || n.Line != g.Line || n.X != p.X+p.Advance {
+					break
+				}
+				run = append(run, n.Rune)
+				i++
+			}
+			batch.Flush()
+			gf.ttf.SetColor(frgba[0], frgba[1], frgba[2], frgba[3])
+			gf.ttf.Printf(gx, y+g.Y*yscl, xscl, 1, true, win, "%s", string(run))
+			continue
+		}
+		gy := y + (g.Y+float32(gf.offset[1]-int32(gf.Size[1])+1))*yscl + float32(sys.gameHeight-240)
+		gbank := bank
+		if gf != f {
+			gbank = 0
+		}
+		var pal []uint32
+		if len(gf.palettes) > int(gbank) {
+			pal = gf.palettes[gbank][:] //palfx.getFxPal(f.palettes[bank][:], false)
+		}
+		gf.drawChar(gx, gy, xscl, yscl, gbank, g.bt, g.Rune, pal, window, palfx)
+	}
 }
 
 type TextSprite struct {
@@ -550,6 +569,7 @@ type TextSprite struct {
 	window           [4]int32
 	palfx            *PalFX
 	frgba            [4]float32 // ttf fonts
+	wrap             float32    // 0 to disable line wrapping
 	removetime       int32      // text sctrl
 	layerno          int16      // text sctrl
 	localScale       float32    // text sctrl
@@ -598,10 +618,7 @@ func (ts *TextSprite) SetColor(r, g, b int32) {
 
 func (ts *TextSprite) Draw() {
 	if !sys.frameSkip && ts.fnt != nil {
-		if ts.fnt.Type == "truetype" {
-			ts.fnt.DrawTtf(ts.text, ts.x, ts.y, ts.xscl, ts.yscl, ts.align, true, &ts.window, ts.frgba)
-		} else {
-			ts.fnt.DrawText(ts.text, ts.x, ts.y, ts.xscl, ts.yscl, ts.bank, ts.align, &ts.window, ts.palfx)
-		}
+		ts.fnt.DrawText(lang.Expand(ts.text), ts.x, ts.y, ts.xscl, ts.yscl, ts.wrap, ts.bank, ts.align,
+			&ts.window, ts.palfx, ts.frgba)
 	}
 }
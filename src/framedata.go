@@ -0,0 +1,155 @@
+package main
+
+// Frame data of the last attack performed by a root character. Frames are
+// counted while the attacker is neither paused nor in hitpause, so hitstop is
+// left out of startup, active and recovery. Advantage compares when the
+// attacker and the target regain control on the global clock, which does
+// include hitstop. Unknown values are -1.
+type FrameData struct {
+	done      bool // Whether an attack was measured yet
+	stateNo   int32
+	startup   int32
+	active    int32
+	recovery  int32
+	contact   int32 // -1 whiff, otherwise MC_Hit, MC_Guarded or MC_Reversed
+	advantage int32 // Positive when the attacker recovers first
+	hasAdv    bool
+	cur       frameDataMeasure
+	clock     int32
+}
+
+// Attack being measured, published to FrameData once both sides recover
+type frameDataMeasure struct {
+	tracking     bool
+	stateNo      int32
+	frame        int32
+	firstActive  int32
+	lastActive   int32
+	recovery     int32
+	contact      int32
+	targetId     int32
+	started      int32
+	attackerFree int32
+	targetFree   int32
+}
+
+// Frames to wait for both sides to recover before giving up on advantage
+const frameDataTimeout = 600
+
+func (fd *FrameData) clear() {
+	*fd = FrameData{clock: fd.clock}
+}
+
+func (fd *FrameData) start(c *Char) {
+	fd.cur = frameDataMeasure{tracking: true, stateNo: c.ss.no, firstActive: -1,
+		lastActive: -1, contact: -1, targetId: -1, started: fd.clock,
+		attackerFree: -1, targetFree: -1}
+}
+
+func (fd *FrameData) finish() {
+	m := &fd.cur
+	m.tracking = false
+	fd.done = true
+	fd.stateNo = m.stateNo
+	fd.startup, fd.active, fd.recovery = -1, -1, -1
+	if m.firstActive >= 0 {
+		fd.startup = m.firstActive
+		fd.active = m.lastActive - m.firstActive + 1
+		if m.attackerFree >= 0 {
+			fd.recovery = m.recovery
+		}
+	}
+	fd.contact = m.contact
+	fd.hasAdv = m.contact >= 0 && m.attackerFree >= 0 && m.targetFree >= 0
+	if fd.hasAdv {
+		fd.advantage = m.targetFree - m.attackerFree
+	}
+}
+
+// Whether c can act again after an attack or after being hit or guarding
+func frameDataFree(c *Char) bool {
+	return c.ctrl() && c.ss.moveType != MT_H && !c.inGuardState()
+}
+
+// Called once per game tick for each root character.
+func (fd *FrameData) update(c *Char) {
+	if sys.super <= 0 && sys.pause <= 0 {
+		fd.clock++
+	}
+	m := &fd.cur
+	// A new attack starts when entering an attack state. Cancels after
+	// contact belong to the same attack, so advantage covers the whole string
+	if c.ss.moveType == MT_A && (!m.tracking || m.stateNo != c.ss.no) {
+		if m.tracking && m.contact >= 0 && m.attackerFree < 0 {
+			m.stateNo = c.ss.no
+		} else {
+			fd.start(c)
+		}
+	}
+	if !m.tracking {
+		return
+	}
+	if c.ss.moveType == MT_H {
+		// Interrupted by a hit
+		fd.finish()
+		return
+	}
+	if m.attackerFree < 0 {
+		if !c.pause() && !c.hitPause() {
+			m.frame++
+			if c.ss.moveType == MT_A && c.curFrame != nil && len(c.curFrame.Clsn1()) > 0 {
+				if m.firstActive < 0 {
+					m.firstActive = m.frame
+				}
+				m.lastActive = m.frame
+			}
+		}
+		if m.contact < 0 && c.moveContact() > 0 {
+			m.contact = int32(c.mctype)
+			if len(c.targets) > 0 {
+				m.targetId = c.targets[0]
+			} else if p2 := c.p2(); p2 != nil {
+				m.targetId = p2.id
+			}
+		}
+		if frameDataFree(c) {
+			m.attackerFree = fd.clock
+			if m.lastActive >= 0 {
+				m.recovery = m.frame - m.lastActive - 1
+			}
+			if m.contact < 0 {
+				fd.finish()
+				return
+			}
+		}
+	}
+	if m.contact >= 0 {
+		t := sys.playerID(m.targetId)
+		if t == nil || fd.clock-m.started > frameDataTimeout {
+			fd.finish()
+			return
+		}
+		if m.targetFree < 0 && frameDataFree(t) {
+			m.targetFree = fd.clock
+		}
+		if m.attackerFree >= 0 && m.targetFree >= 0 {
+			fd.finish()
+		}
+	}
+}
+
+func (s *System) updateFrameData() {
+	for i := range s.chars {
+		if len(s.chars[i]) > 0 {
+			s.chars[i][0].frameData.update(s.chars[i][0])
+		}
+	}
+}
+
+func (s *System) resetFrameData() {
+	for i := range s.chars {
+		if len(s.chars[i]) > 0 {
+			s.chars[i][0].frameData.clear()
+		}
+	}
+}
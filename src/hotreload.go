@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Hot reload, enabled with -hotreload. The files of the characters, stage
+// and lifebar of the running match are polled for changes once per second.
+// Editing a character's def, state or command files recompiles only that
+// character and swaps its states in place, while editing its .air file swaps
+// its animations, so its position, life and other variables are kept. The
+// [Data], [Size], [Velocity] and [Movement] of the .cns are not reloaded.
+// Editing the stage def or fight.def reloads only that asset. Errors are
+// shown in the console and the previous version keeps running. Files read
+// from zip archives or content packs are not on the disk at their path, so
+// they have no modification time and are not watched.
+
+type hotReloadFile struct {
+	path    string
+	modTime time.Time
+	anim    bool // Only the animations are reloaded when an .air file changes
+}
+
+type hotReloadAsset struct {
+	def   string
+	files []hotReloadFile
+}
+
+// Modification time of a file, or zero if it is not on the disk.
+func fileModTime(path string) time.Time {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+func (a *hotReloadAsset) watch(def string, files []hotReloadFile) {
+	a.def, a.files = def, files
+	for i := range a.files {
+		a.files[i].modTime = fileModTime(a.files[i].path)
+	}
+}
+
+// Returns whether any state or animation file changed since the last call.
+func (a *hotReloadAsset) changed() (states, anims bool) {
+	for i := range a.files {
+		f := &a.files[i]
+		// Files without a modification time are skipped
+		if t := fileModTime(f.path); !t.IsZero() && !t.Equal(f.modTime) {
+			f.modTime = t
+			if i == 0 || !f.anim {
+				states = true
+			}
+			if i == 0 || f.anim {
+				anims = true
+			}
+		}
+	}
+	return
+}
+
+// Returns the def file of a character followed by the files of its [Files]
+// section that Compile and the animation loader read.
+func hotReloadCharFiles(def string) []hotReloadFile {
+	def = vfsPath(def)
+	files := []hotReloadFile{{path: def}}
+	str, err := LoadText(def)
+	if err != nil {
+		return files
+	}
+	lines, i := SplitAndTrim(str, "\n"), 0
+	for i < len(lines) {
+		is, name, _ := ReadIniSection(lines, &i)
+		if name != "files" {
+			continue
+		}
+		for k, v := range is {
+			if v != "" && (k == "cns" || k == "cmd" || k == "anim" || strings.HasPrefix(k, "st")) {
+				files = append(files, hotReloadFile{
+					path: SearchFile(v, []string{def, "", sys.motifDir, "data/"}),
+					anim: k == "anim"})
+			}
+		}
+		break
+	}
+	return files
+}
+
+type HotReload struct {
+	enabled  bool
+	chars    [MaxSimul*2 + MaxAttachedChar]hotReloadAsset
+	stage    hotReloadAsset
+	lifebar  hotReloadAsset
+	lastPoll time.Time
+}
+
+var hotReload = &HotReload{}
+
+// Called once per frame during a match.
+func (hr *HotReload) update() {
+	if !hr.enabled || sys.netInput != nil || sys.fileInput != nil ||
+		time.Since(hr.lastPoll) < time.Second {
+		return
+	}
+	hr.lastPoll = time.Now()
+	for pn, p := range sys.chars {
+		a := &hr.chars[pn]
+		def := sys.cgi[pn].def
+		if len(p) == 0 || def == "" {
+			a.def = ""
+			continue
+		}
+		if a.def != def {
+			a.watch(def, hotReloadCharFiles(def))
+			continue
+		}
+		states, anims := a.changed()
+		if states && anims {
+			// The def itself changed, so its [Files] may have too
+			a.watch(def, hotReloadCharFiles(def))
+		}
+		if states {
+			hr.report(def, hr.reloadStates(pn))
+		}
+		if anims {
+			hr.report(def+" animations", hr.reloadAnims(pn, a.files))
+		}
+	}
+	if sys.stage != nil {
+		if hr.stage.def != sys.stage.def {
+			hr.stage.watch(sys.stage.def, []hotReloadFile{{path: sys.stage.def}})
+		} else if states, _ := hr.stage.changed(); states {
+			hr.report(sys.stage.def, hr.reloadStage())
+		}
+	}
+	if hr.lifebar.def != sys.lifebar.def {
+		hr.lifebar.watch(sys.lifebar.def, []hotReloadFile{{path: sys.lifebar.def}})
+	} else if states, _ := hr.lifebar.changed(); states {
+		hr.report(sys.lifebar.def, hr.reloadLifebar())
+	}
+}
+
+func (hr *HotReload) report(name string, err error) {
+	msg := "Reloaded " + name
+	if err != nil {
+		msg = fmt.Sprintf("Failed to reload %v:\n%v", name, err)
+	}
+	for _, str := range strings.Split(msg, "\n") {
+		sys.appendToConsole(str)
+	}
+}
+
+// Runs f, turning a panic into an error.
+func hotReloadCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return f()
+}
+
+// Recompiles the states and commands of player pn and moves every character
+// running one of its states to the new version of that state.
+func (hr *HotReload) reloadStates(pn int) error {
+	gi, root := &sys.cgi[pn], sys.chars[pn][0]
+	pool, waka := sys.stringPool[pn], gi.wakewakaLength
+	var cmd CommandList
+	if root.cmd != nil {
+		cmd = root.cmd[pn]
+		root.cmd[pn] = *NewCommandList(cmd.Buffer)
+	}
+	var states map[int32]StateBytecode
+	if err := hotReloadCall(func() (err error) {
+		states, err = newCompiler().Compile(pn, gi.def, gi.constants)
+		return
+	}); err != nil {
+		sys.stringPool[pn], gi.wakewakaLength = pool, waka
+		if cmd.Buffer != nil {
+			root.cmd[pn] = cmd
+		}
+		return err
+	}
+	gi.states = states
+	// Helpers share the command lists of their root
+	for j, p := range sys.chars {
+		if j != pn && len(p) > 0 && len(p[0].cmd) > pn {
+			p[0].cmd[pn].CopyList(root.cmd[pn])
+		}
+	}
+	for _, p := range sys.chars {
+		for _, c := range p {
+			if c.ss.sb.playerNo != pn {
+				continue
+			}
+			if sb, ok := states[c.ss.no]; ok {
+				ctrlsps := make([]int32, len(sb.ctrlsps))
+				copy(ctrlsps, c.ss.sb.ctrlsps)
+				c.ss.sb = sb
+				c.ss.sb.ctrlsps = ctrlsps
+			}
+		}
+	}
+	return nil
+}
+
+// Reads the animations of player pn again and restarts the current
+// animation of its characters at the element they were showing.
+func (hr *HotReload) reloadAnims(pn int, files []hotReloadFile) error {
+	gi := &sys.cgi[pn]
+	if gi.sff == nil || gi.palettedata == nil {
+		return nil
+	}
+	def := vfsPath(gi.def)
+	var str string
+	for _, f := range files {
+		if f.anim {
+			txt, err := LoadText(f.path)
+			if err != nil {
+				return err
+			}
+			str += txt
+		}
+	}
+	for _, s := range sys.commonAir {
+		if err := LoadFile(&s, []string{def, sys.motifDir, sys.lifebar.def, "", "data/"}, func(filename string) error {
+			txt, err := LoadText(filename)
+			if err != nil {
+				return err
+			}
+			str += "\n" + txt
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+	var at AnimationTable
+	if err := hotReloadCall(func() error {
+		lines, i := SplitAndTrim(str, "\n"), 0
+		at = ReadAnimationTable(gi.sff, &gi.palettedata.palList, lines, &i)
+		return nil
+	}); err != nil {
+		return err
+	}
+	gi.anim = at
+	for _, c := range sys.chars[pn] {
+		if c.anim == nil || c.animPN != pn || c.ss.sb.playerNo != pn {
+			continue
+		}
+		if a := at.get(c.animNo); a != nil {
+			a.remap = c.remapSpr
+			a.SetAnimElem(c.anim.current + 1)
+			c.anim = a
+			c.curFrame = a.CurrentFrame()
+		}
+	}
+	return nil
+}
+
+// Loads the current stage again, keeping the camera where it is.
+func (hr *HotReload) reloadStage() error {
+	old := sys.stage
+	var st *Stage
+	if err := hotReloadCall(func() (err error) {
+		st, err = loadStage(old.def, false)
+		return
+	}); err != nil {
+		return err
+	}
+	st.mainstage = old.mainstage
+	st.reset()
+	for k, v := range sys.stageList {
+		if v == old {
+			sys.stageList[k] = st
+		}
+	}
+	sys.stage = st
+	releaseSff(old.sff)
+	if st.model != nil {
+		sys.uploadModels()
+	}
+	sys.cam.stageCamera = st.stageCamera
+	sys.cam.Reset()
+	sys.screenleft = float32(st.screenleft) * st.localscl
+	sys.screenright = float32(st.screenright) * st.localscl
+	return nil
+}
+
+// Loads the lifebar again, keeping the progress of the round display.
+func (hr *HotReload) reloadLifebar() error {
+	old := *sys.lifebar.ro
+	if err := hotReloadCall(sys.lifebar.reloadLifebar); err != nil {
+		return err
+	}
+	sys.lifebar.reset()
+	ro := sys.lifebar.ro
+	ro.cur, ro.wt, ro.swt, ro.dt = old.cur, old.wt, old.swt, old.dt
+	ro.timerActive, ro.introState = old.timerActive, old.introState
+	return nil
+}
@@ -10,6 +10,8 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"sort"
+	"sync"
 	"unsafe"
 )
 
@@ -516,6 +518,11 @@ type Sprite struct {
 	coldepth      byte
 	paltemp       []uint32
 	PalTex        *Texture
+	// Texture coordinates inside a shared atlas page, zero if the sprite
+	// has its own texture
+	uv [4]float32
+	// Atlas collecting the pixel data while the SFF is being loaded
+	atlas *TextureAtlas
 }
 
 func newSprite() *Sprite {
@@ -651,6 +658,7 @@ func newSprite() *Sprite {
 func (s *Sprite) shareCopy(src *Sprite) {
 	s.Pal = src.Pal
 	s.Tex = src.Tex
+	s.uv = src.uv
 	s.Size = src.Size
 	if s.palidx < 0 {
 		s.palidx = src.palidx
@@ -679,6 +687,9 @@ func (s *Sprite) SetPxl(px []byte) {
 	if int64(len(px)) != int64(s.Size[0])*int64(s.Size[1]) {
 		return
 	}
+	if s.atlas != nil && s.atlas.Add(s, px) {
+		return
+	}
 	sys.mainThreadTask <- func() {
 		s.Tex = newTexture(int32(s.Size[0]), int32(s.Size[1]), 8, false)
 		s.Tex.SetData(px)
@@ -717,7 +728,7 @@ func (s *Sprite) readHeader(r io.Reader, ofs, size *uint32,
 	}
 	return nil
 }
-func (s *Sprite) readPcxHeader(f *os.File, offset int64) error {
+func (s *Sprite) readPcxHeader(f VFile, offset int64) error {
 	f.Seek(offset, 0)
 	read := func(x interface{}) error {
 		return binary.Read(f, binary.LittleEndian, x)
@@ -787,7 +798,7 @@ func (s *Sprite) RlePcxDecode(rle []byte) (p []byte) {
 	s.rle = 0
 	return
 }
-func (s *Sprite) read(f *os.File, sh *SffHeader, offset int64, datasize uint32,
+func (s *Sprite) read(f VFile, sh *SffHeader, offset int64, datasize uint32,
 	nextSubheader uint32, prev *Sprite, pl *PaletteList, c00 bool) error {
 	if int64(nextSubheader) > offset {
 		// Ignore datasize except last
@@ -847,7 +858,7 @@ func (s *Sprite) read(f *os.File, sh *SffHeader, offset int64, datasize uint32,
 	return nil
 }
 
-func (s *Sprite) preloadSetPalRead(f *os.File, sh *SffHeader, offset int64, datasize uint32,
+func (s *Sprite) preloadSetPalRead(f VFile, sh *SffHeader, offset int64, datasize uint32,
 	nextSubheader uint32, prev *Sprite, pl *PaletteList, c00 bool, current *Sprite) error {
 	if int64(nextSubheader) > offset {
 		// 最後以外datasizeを無視 / Ignore datasize except last
@@ -1102,7 +1113,7 @@ func (s *Sprite) Lz5Decode(rle []byte) (p []byte) {
 	}
 	return
 }
-func (s *Sprite) readV2(f *os.File, offset int64, datasize uint32) error {
+func (s *Sprite) readV2(f VFile, offset int64, datasize uint32) error {
 	var px []byte
 	var isRaw bool = false
 
@@ -1219,7 +1230,7 @@ func (s *Sprite) Draw(x, y, xscale, yscale, angle float32, fx *PalFX, window *[4
 		y *= -1
 	}
 	rp := RenderParams{
-		s.Tex, s.PalTex, s.Size,
+		s.Tex, s.PalTex, s.uv, s.Size,
 		-x * sys.widthScale, -y * sys.heightScale, notiling,
 		xscale * sys.widthScale, xscale * sys.widthScale, yscale * sys.heightScale, 1, 0, 1, 1,
 		Rotation{angle, 0, 0}, 0, sys.brightness*255>>8 | 1<<9, 0, fx, window, 0, 0, 0, 0,
@@ -1228,17 +1239,145 @@ func (s *Sprite) Draw(x, y, xscale, yscale, angle float32, fx *PalFX, window *[4
 	RenderSprite(rp)
 }
 
+// Sprites up to this size are packed into atlas pages
+const atlasMaxSpriteSize = 128
+const atlasPageSize = 1024
+
+// TextureAtlas packs the pixels of small paletted sprites of one SFF into
+// shared 8-bit textures, so that stage tiles, font glyphs and small
+// effects drawn one after another can be merged into a single draw call.
+type TextureAtlas struct {
+	pages []*atlasPage
+	// Linked sprites sharing the data of an atlased sprite (dst, src)
+	links  [][2]*Sprite
+	placed map[*Sprite]bool
+}
+
+type atlasPage struct {
+	px []byte
+	// Shelf packing cursor
+	x, y, shelfHeight int32
+	sprites           []*Sprite
+	rects             [][4]int32
+}
+
+func newTextureAtlas() *TextureAtlas {
+	return &TextureAtlas{placed: make(map[*Sprite]bool)}
+}
+
+// Copy the sprite pixels into a page. Each sprite gets a one pixel border
+// repeating its edges, so sampling behaves like CLAMP_TO_EDGE.
+func (ta *TextureAtlas) Add(s *Sprite, px []byte) bool {
+	w, h := int32(s.Size[0]), int32(s.Size[1])
+	if w > atlasMaxSpriteSize || h > atlasMaxSpriteSize || w == 0 || h == 0 {
+		return false
+	}
+	pw, ph := w+2, h+2
+	var pg *atlasPage
+	if len(ta.pages) > 0 {
+		pg = ta.pages[len(ta.pages)-1]
+		if pg.x+pw > atlasPageSize {
+			pg.x, pg.y, pg.shelfHeight = 0, pg.y+pg.shelfHeight, 0
+		}
+		if pg.y+ph > atlasPageSize {
+			pg = nil
+		}
+	}
+	if pg == nil {
+		pg = &atlasPage{px: make([]byte, atlasPageSize*atlasPageSize)}
+		ta.pages = append(ta.pages, pg)
+	}
+	for y := int32(0); y < ph; y++ {
+		sy := Clamp(y-1, 0, h-1)
+		row := pg.px[(pg.y+y)*atlasPageSize+pg.x:]
+		for x := int32(0); x < pw; x++ {
+			row[x] = px[sy*w+Clamp(x-1, 0, w-1)]
+		}
+	}
+	pg.sprites = append(pg.sprites, s)
+	pg.rects = append(pg.rects, [4]int32{pg.x + 1, pg.y + 1, w, h})
+	pg.x += pw
+	pg.shelfHeight = Max(pg.shelfHeight, ph)
+	ta.placed[s] = true
+	return true
+}
+
+// Texture memory of the pages in bytes, once trimmed by Build
+func (ta *TextureAtlas) size() (n int64) {
+	for _, pg := range ta.pages {
+		n += int64(atlasPageSize) * int64(pg.y+pg.shelfHeight)
+	}
+	return
+}
+
+// Defer a shareCopy of an atlased sprite until its page is uploaded
+func (ta *TextureAtlas) Link(dst, src *Sprite) bool {
+	if !ta.placed[src] {
+		return false
+	}
+	ta.links = append(ta.links, [2]*Sprite{dst, src})
+	// Sprites linking to dst must also wait for the page
+	ta.placed[dst] = true
+	return true
+}
+
+// Upload the pages and assign them to their sprites on the main thread
+func (ta *TextureAtlas) Build() {
+	if len(ta.pages) == 0 {
+		return
+	}
+	pages, links := ta.pages, ta.links
+	sys.mainThreadTask <- func() {
+		for _, pg := range pages {
+			// Trim the unused rows at the bottom of the page
+			height := pg.y + pg.shelfHeight
+			tex := newTexture(atlasPageSize, height, 8, false)
+			tex.SetData(pg.px[:atlasPageSize*height])
+			for i, s := range pg.sprites {
+				r := pg.rects[i]
+				s.Tex = tex
+				s.uv = [4]float32{float32(r[0]) / atlasPageSize, float32(r[1]) / float32(height),
+					float32(r[0]+r[2]) / atlasPageSize, float32(r[1]+r[3]) / float32(height)}
+			}
+		}
+		for _, l := range links {
+			l[0].shareCopy(l[1])
+		}
+	}
+	ta.pages, ta.links, ta.placed = nil, nil, nil
+}
+
 type Sff struct {
 	header  SffHeader
 	sprites map[[2]int16]*Sprite
 	palList PaletteList
 	// This is the sffCache key
 	filename string
+	// Reference to the cache entry, shared by the copies of this SFF
+	ref *sffCacheRef
 }
 type Palette struct {
 	palList PaletteList
 }
 
+// Free the textures of an SFF dropped from the cache without waiting for
+// the garbage collector
+func (s *Sff) freeTextures() {
+	texs := make(map[*Texture]bool)
+	for _, spr := range s.sprites {
+		texs[spr.Tex], texs[spr.PalTex] = true, true
+	}
+	for _, t := range s.palList.PalTex {
+		texs[t] = true
+	}
+	delete(texs, nil)
+	sys.mainThreadTask <- func() {
+		for t := range texs {
+			t.Delete()
+		}
+	}
+}
+
 func newSff() (s *Sff) {
 	s = &Sff{sprites: make(map[[2]int16]*Sprite)}
 	s.palList.init()
@@ -1256,29 +1395,129 @@ func newPaldata() (p *Palette) {
 	return
 }
 
-// A simple SFF cache storing shallow copies
+// SFF cache storing shallow copies. Entries no longer referenced by any
+// character, stage or script stay cached until the total size goes over
+// the configured limit, then the least recently used ones are dropped and
+// their textures are freed along with the last copy.
 type SffCacheEntry struct {
 	sffData  Sff
 	refCount int
+	// Estimated texture memory in bytes
+	size    int64
+	lastUse uint64
 }
 
 var SffCache = map[string]*SffCacheEntry{}
+var sffCacheMu sync.Mutex
+var sffCacheTick uint64
 
-func removeSFFCache(filename string) {
-	if _, ok := SffCache[filename]; ok {
-		delete(SffCache, filename)
+// Reference to a cache entry. Released explicitly when a character or
+// stage is unloaded, or when every copy of the SFF has been collected.
+type sffCacheRef struct {
+	filename string
+	released bool
+}
+
+func newSffCacheRef(filename string) *sffCacheRef {
+	r := &sffCacheRef{filename: filename}
+	runtime.SetFinalizer(r, func(r *sffCacheRef) {
+		r.release()
+	})
+	return r
+}
+
+func (r *sffCacheRef) release() {
+	sffCacheMu.Lock()
+	defer sffCacheMu.Unlock()
+	if r.released {
+		return
+	}
+	r.released = true
+	if cached, ok := SffCache[r.filename]; ok {
+		cached.refCount--
+		sffCacheTick++
+		cached.lastUse = sffCacheTick
 	}
+	trimSFFCache()
+}
+
+// Release the cache reference held by a loaded SFF
+func releaseSff(s *Sff) {
+	if s != nil && s.ref != nil {
+		s.ref.release()
+	}
+}
+
+// Drop unreferenced entries until the cache fits in its size limit.
+// Called with sffCacheMu locked.
+func trimSFFCache() {
+	if sys.sffCacheSize <= 0 {
+		return
+	}
+	used := int64(0)
+	for _, e := range SffCache {
+		used += e.size
+	}
+	for used > sys.sffCacheSize {
+		var oldest string
+		for k, e := range SffCache {
+			if e.refCount <= 0 && (oldest == "" || e.lastUse < SffCache[oldest].lastUse) {
+				oldest = k
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		used -= SffCache[oldest].size
+		SffCache[oldest].sffData.freeTextures()
+		delete(SffCache, oldest)
+	}
+}
+
+type SffCacheInfo struct {
+	Filename string
+	Size     int64
+	RefCount int
+}
+
+// Current cache usage per file, largest first
+func sffCacheUsage() (files []SffCacheInfo, used int64) {
+	sffCacheMu.Lock()
+	defer sffCacheMu.Unlock()
+	for k, e := range SffCache {
+		files = append(files, SffCacheInfo{k, e.size, e.refCount})
+		used += e.size
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Size != files[j].Size {
+			return files[i].Size > files[j].Size
+		}
+		return files[i].Filename < files[j].Filename
+	})
+	return
+}
+
+func removeSFFCache(filename string) {
+	sffCacheMu.Lock()
+	defer sffCacheMu.Unlock()
+	delete(SffCache, filename)
 }
 func loadSff(filename string, char bool) (*Sff, error) {
 	// If this SFF is already in the cache, just return a copy
+	sffCacheMu.Lock()
 	if cached, ok := SffCache[filename]; ok {
 		cached.refCount++
+		sffCacheTick++
+		cached.lastUse = sffCacheTick
 		s := cached.sffData
+		s.ref = newSffCacheRef(filename)
+		sffCacheMu.Unlock()
 		return &s, nil
 	}
+	sffCacheMu.Unlock()
 	s := newSff()
 	s.filename = filename
-	f, err := os.Open(filename)
+	f, err := vfs.Open(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -1353,12 +1592,18 @@ func loadSff(filename string, char bool) (*Sff, error) {
 			}
 		}
 	}
+	var atlas *TextureAtlas
+	if sys.spriteAtlas {
+		atlas = newTextureAtlas()
+	}
 	spriteList := make([]*Sprite, int(s.header.NumberOfSprites))
 	var prev *Sprite
+	cacheSize := int64(s.header.NumberOfPalettes) * 256 * 4
 	shofs := int64(s.header.FirstSpriteHeaderOffset)
 	for i := 0; i < len(spriteList); i++ {
 		f.Seek(shofs, 0)
 		spriteList[i] = newSprite()
+		spriteList[i].atlas = atlas
 		var xofs, size uint32
 		var indexOfPrevious uint16
 		switch s.header.Ver0 {
@@ -1376,8 +1621,10 @@ func loadSff(filename string, char bool) (*Sff, error) {
 		if size == 0 {
 			if int(indexOfPrevious) < i {
 				dst, src := spriteList[i], spriteList[int(indexOfPrevious)]
-				sys.mainThreadTask <- func() {
-					dst.shareCopy(src)
+				if atlas == nil || !atlas.Link(dst, src) {
+					sys.mainThreadTask <- func() {
+						dst.shareCopy(src)
+					}
 				}
 			} else {
 				spriteList[i].palidx = 0 // index out of range
@@ -1396,6 +1643,14 @@ func loadSff(filename string, char bool) (*Sff, error) {
 					return nil, err
 				}
 			}
+			// Atlased sprites are counted with their pages
+			if atlas == nil || !atlas.placed[spriteList[i]] {
+				bpp := int64(1)
+				if spriteList[i].coldepth > 8 {
+					bpp = 4
+				}
+				cacheSize += int64(spriteList[i].Size[0]) * int64(spriteList[i].Size[1]) * bpp
+			}
 			prev = spriteList[i]
 		}
 		if s.sprites[[...]int16{spriteList[i].Group, spriteList[i].Number}] ==
@@ -1409,19 +1664,32 @@ func loadSff(filename string, char bool) (*Sff, error) {
 			shofs += 28
 		}
 	}
-	SffCache[filename] = &SffCacheEntry{*s, 1}
-	runtime.SetFinalizer(s, func(s *Sff) {
-		if cached, ok := SffCache[filename]; ok {
-			cached.refCount--
-			if cached.refCount == 0 {
-				delete(SffCache, filename)
-			}
+	if atlas != nil {
+		for _, spr := range spriteList {
+			spr.atlas = nil
 		}
-	})
+		cacheSize += atlas.size()
+		atlas.Build()
+	}
+	sffCacheMu.Lock()
+	sffCacheTick++
+	// Another goroutine may have loaded the same file in the meantime
+	if cached, ok := SffCache[filename]; ok {
+		cached.refCount++
+		cached.lastUse = sffCacheTick
+		c := cached.sffData
+		c.ref = newSffCacheRef(filename)
+		sffCacheMu.Unlock()
+		return &c, nil
+	}
+	SffCache[filename] = &SffCacheEntry{*s, 1, cacheSize, sffCacheTick}
+	trimSFFCache()
+	sffCacheMu.Unlock()
+	s.ref = newSffCacheRef(filename)
 	return s, nil
 }
 
-func (s *Sprite) preloadRead(f *os.File, sh *SffHeader, offset int64, datasize uint32,
+func (s *Sprite) preloadRead(f VFile, sh *SffHeader, offset int64, datasize uint32,
 	nextSubheader uint32, prev *Sprite, pl *PaletteList, c00 bool) error {
 	if int64(nextSubheader) > offset {
 		// 最後以外datasizeを無視 / Ignore datasize except last
@@ -1481,7 +1749,7 @@ func (s *Sprite) preloadRead(f *os.File, sh *SffHeader, offset int64, datasize u
 	return nil
 }
 
-func (s *Sprite) preloadPaletteRead(f *os.File, sh *SffHeader, offset int64, datasize uint32,
+func (s *Sprite) preloadPaletteRead(f VFile, sh *SffHeader, offset int64, datasize uint32,
 	nextSubheader uint32, prev *Sprite, pl *PaletteList, c00 bool) error {
 	if int64(nextSubheader) > offset {
 		// 最後以外datasizeを無視 / Ignore datasize except last
@@ -1532,7 +1800,7 @@ func (s *Sprite) preloadPaletteRead(f *os.File, sh *SffHeader, offset int64, dat
 func preloadSff(filename string, char bool, preloadSpr map[[2]int16]bool, s map[[2]int16]bool, palFiles []string, palNumsToGen []int32) (*Sff, []int32, error) {
 	var selPal []int32
 	sff := newSff()
-	f, err := os.Open(filename)
+	f, err := vfs.Open(filename)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -1769,7 +2037,7 @@ func preloadSff(filename string, char bool, preloadSpr map[[2]int16]bool, s map[
 		}
 	}
 
-	var U *os.File
+	var U VFile
 	x := 0
 	if h.Ver0 == 1 {
 		var fullpath string
@@ -1781,7 +2049,7 @@ func preloadSff(filename string, char bool, preloadSpr map[[2]int16]bool, s map[
 			for x < len(palNumsToGen) {
 				replaceCondition := true
 				fullpath = pathname + NormalizeFile(palFiles[x])
-				U, err = os.Open(fullpath)
+				U, err = vfs.Open(fullpath)
 				if err != nil {
 					fmt.Printf("[image.go] Failed to open [%v]\n", fullpath)
 					replaceCondition = false
@@ -1832,7 +2100,11 @@ func captureScreen() {
 	width, height := sys.window.GetSize()
 	pixdata := make([]uint8, 4*width*height)
 	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	batch.Flush()
+	gfx.EndFrame()
 	gfx.ReadPixels(pixdata, width, height)
+	sys.window.SwapBuffers()
+	gfx.BeginFrame(false)
 	for i := 0; i < 4*width*height; i++ {
 		var x, y, j int
 		x = i % (width * 4)
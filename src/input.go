@@ -8,6 +8,7 @@ import (
 	"time"
 )
 
+var ModCtrl = NewModifierKey(true, false, false)
 var ModAlt = NewModifierKey(false, true, false)
 var ModCtrlAlt = NewModifierKey(true, true, false)
 var ModCtrlAltShift = NewModifierKey(true, true, true)
@@ -165,7 +166,11 @@ func OnKeyPressed(key Key, mk ModifierKey) {
 			}
 		}
 		if key == KeyF12 {
-			captureScreen()
+			if (mk & ModCtrlAltShift) == ModCtrl {
+				toggleRecording()
+			} else {
+				captureScreen()
+			}
 		}
 		if key == KeyEnter && (mk&ModAlt) != 0 {
 			sys.window.toggleFullscreen()
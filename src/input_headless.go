@@ -0,0 +1,110 @@
+//go:build headless
+
+package main
+
+// Headless input: there are no keyboards or joysticks, so only scripted
+// inputs such as scenarios and replays drive the players.
+
+type Input struct{}
+
+type Key int32
+type ModifierKey int32
+
+const (
+	KeyUnknown Key = -1
+	KeyEscape  Key = 256
+	KeyEnter   Key = 257
+	KeyInsert  Key = 260
+	KeyF12     Key = 301
+)
+
+const (
+	modShift ModifierKey = 1 << iota
+	modControl
+	modAlt
+)
+
+var KeyToStringLUT = map[Key]string{
+	KeyEscape: "ESCAPE",
+	KeyEnter:  "RETURN",
+	KeyInsert: "INSERT",
+	KeyF12:    "F12",
+}
+
+var StringToKeyLUT = map[string]Key{}
+
+func init() {
+	for k, v := range KeyToStringLUT {
+		StringToKeyLUT[v] = k
+	}
+}
+
+func StringToKey(s string) Key {
+	if key, ok := StringToKeyLUT[s]; ok {
+		return key
+	}
+	return KeyUnknown
+}
+
+func KeyToString(k Key) string {
+	return KeyToStringLUT[k]
+}
+
+func NewModifierKey(ctrl, alt, shift bool) (mod ModifierKey) {
+	if ctrl {
+		mod |= modControl
+	}
+	if alt {
+		mod |= modAlt
+	}
+	if shift {
+		mod |= modShift
+	}
+	return
+}
+
+var input = Input{}
+
+func (input *Input) GetMaxJoystickCount() int {
+	return 0
+}
+
+func (input *Input) IsJoystickPresent(joy int) bool {
+	return false
+}
+
+func (input *Input) GetJoystickName(joy int) string {
+	return ""
+}
+
+func (input *Input) GetJoystickAxes(joy int) []float32 {
+	return []float32{}
+}
+
+func (input *Input) GetJoystickButtons(joy int) []int32 {
+	return []int32{}
+}
+
+func (input *Input) GetJoystickHats(joy int) []int32 {
+	return []int32{}
+}
+
+func JoystickState(joy, button int) bool {
+	if joy < 0 {
+		return sys.keyState[Key(button)]
+	}
+	return false
+}
+
+func checkAxisForDpad(joy int, axes *[]float32, base int) string {
+	return ""
+}
+
+func checkAxisForTrigger(joy int, axes *[]float32) string {
+	return ""
+}
+
+// Reads the keyboard, which is never pressed without a window
+func (ir *InputReader) LocalInput(in int) (bool, bool, bool, bool, bool, bool, bool, bool, bool, bool, bool, bool, bool, bool) {
+	return false, false, false, false, false, false, false, false, false, false, false, false, false, false
+}
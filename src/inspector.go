@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Debug inspector. Lists every live entity (players, helpers, projectiles and
+// explods) and shows the state of the selected one, one page at a time.
+// Character values can be edited while the game is paused, and watch
+// expressions are compiled with the trigger compiler and evaluated on the
+// selected character every frame.
+
+const stateHistoryLen = 16
+
+type stateTransition struct {
+	gameTime int32
+	from, to int32
+	playerNo int // Owner of the new state, differs from the char under custom states
+}
+
+// Keeps the last state transitions of c.
+func (c *Char) recordStateChange(from, to int32, pn int) {
+	c.stateHistory[c.stateHistoryPos%stateHistoryLen] = stateTransition{
+		gameTime: sys.gameTime, from: from, to: to, playerNo: pn}
+	c.stateHistoryPos++
+}
+
+type InspectorPage int32
+
+const (
+	IP_State InspectorPage = iota
+	IP_Vars
+	IP_Maps
+	IP_HitDef
+	IP_GetHitVar
+	IP_Flags
+	IP_History
+	IP_Watch
+)
+
+var inspectorPageNames = []string{"state", "vars", "maps", "hitdef",
+	"gethitvar", "flags", "history", "watch"}
+
+var assertSpecialNames = []string{"invisible", "noairguard", "noautoturn",
+	"nocrouchguard", "nojugglecheck", "noko", "noshadow", "nostandguard",
+	"nowalk", "unguardable", "nobrake", "nocrouch", "nostand", "nojump",
+	"noairjump", "nohardcodedkeys", "nogetupfromliedown",
+	"nofastrecoverfromliedown", "nofallcount", "nofalldefenceup",
+	"noturntarget", "noinput", "nopowerbardisplay", "autoguard", "animfreeze",
+	"postroundinput", "nohitdamage", "noguarddamage", "nodizzypointsdamage",
+	"noguardpointsdamage", "noredlifedamage", "nomakedust", "noguardko",
+	"nokovelocity", "noailevel", "nointroreset", "immovable", "sizepushonly",
+	"animatehitpause", "cornerpriority", "drawunder", "runfirst", "runlast",
+	"projtypecollision", "nofallhitflag"}
+
+var globalSpecialNames = []string{"globalnoko", "globalnoshadow", "intro",
+	"nobardisplay", "nobg", "nofg", "nokoslow", "nokosnd", "nomusic",
+	"roundnotover", "timerfreeze", "roundnotskip", "roundfreeze"}
+
+type InspectorEntityType int32
+
+const (
+	IE_Char InspectorEntityType = iota
+	IE_Projectile
+	IE_Explod
+)
+
+type inspectorEntity struct {
+	typ      InspectorEntityType
+	playerNo int
+	id       int32 // Char id, or index in sys.projs or sys.explods
+}
+
+func (ie inspectorEntity) char() *Char {
+	if ie.typ == IE_Char {
+		return sys.playerID(ie.id)
+	}
+	if ie.playerNo < len(sys.chars) && len(sys.chars[ie.playerNo]) > 0 {
+		return sys.chars[ie.playerNo][0]
+	}
+	return nil
+}
+
+func (ie inspectorEntity) proj() *Projectile {
+	if ie.typ == IE_Projectile && int(ie.id) < len(sys.projs[ie.playerNo]) {
+		if p := &sys.projs[ie.playerNo][ie.id]; p.id >= 0 {
+			return p
+		}
+	}
+	return nil
+}
+
+func (ie inspectorEntity) explod() *Explod {
+	if ie.typ == IE_Explod && int(ie.id) < len(sys.explods[ie.playerNo]) {
+		if e := &sys.explods[ie.playerNo][ie.id]; e.id != IErr {
+			return e
+		}
+	}
+	return nil
+}
+
+func (ie inspectorEntity) String() string {
+	switch ie.typ {
+	case IE_Projectile:
+		if p := ie.proj(); p != nil {
+			return fmt.Sprintf("P%v projectile %v", ie.playerNo+1, p.id)
+		}
+	case IE_Explod:
+		if e := ie.explod(); e != nil {
+			return fmt.Sprintf("P%v explod %v", ie.playerNo+1, e.id)
+		}
+	default:
+		if c := ie.char(); c != nil {
+			if c.helperIndex == 0 {
+				return fmt.Sprintf("P%v %v (%v)", c.playerNo+1, c.name, c.id)
+			}
+			return fmt.Sprintf("P%v helper %v %v (%v)", c.playerNo+1, c.helperId, c.name, c.id)
+		}
+	}
+	return "none"
+}
+
+// Trigger expression typed in by the user. It is compiled separately for
+// each player that evaluates it, as constants and strings are per player.
+type debugExpr struct {
+	text string
+	exp  map[int]BytecodeExp
+	err  map[int]error
+}
+
+func newDebugExpr(text string) *debugExpr {
+	return &debugExpr{text: text, exp: make(map[int]BytecodeExp),
+		err: make(map[int]error)}
+}
+
+// Compiles the expression for the player of c on first use and evaluates it
+// on c. A panic while compiling or running is returned as an error.
+func (de *debugExpr) run(c *Char) (bv BytecodeValue, err error) {
+	pn := c.playerNo
+	defer func() {
+		if r := recover(); r != nil {
+			bv, err = BytecodeSF(), fmt.Errorf("%v", r)
+			// Don't compile it again every frame
+			if _, ok := de.exp[pn]; !ok {
+				de.err[pn] = err
+			}
+		}
+	}()
+	if _, ok := de.exp[pn]; !ok && de.err[pn] == nil {
+		comp := newCompiler()
+		comp.playerNo = pn
+		// Command triggers look up the command list of the root
+		if len(sys.chars[pn]) > 0 && len(sys.chars[pn][0].cmd) > pn {
+			comp.cmdl = &sys.chars[pn][0].cmd[pn]
+		}
+		text := de.text
+		if be, err := comp.fullExpression(&text, VT_None); err != nil {
+			de.err[pn] = err
+		} else {
+			de.exp[pn] = be
+		}
+	}
+	if err := de.err[pn]; err != nil {
+		return BytecodeSF(), err
+	}
+	return de.exp[pn].run(c), nil
+}
+
+type Inspector struct {
+	enabled  bool
+	selected inspectorEntity
+	page     InspectorPage
+	scroll   int
+	watches  []*debugExpr
+}
+
+var inspector = &Inspector{}
+
+// Live entities, in run order followed by projectiles and explods.
+func (in *Inspector) entities() (list []inspectorEntity) {
+	for _, c := range sys.charList.runOrder {
+		if !c.csf(CSF_destroy) {
+			list = append(list, inspectorEntity{IE_Char, c.playerNo, c.id})
+		}
+	}
+	for pn := range sys.projs {
+		for i := range sys.projs[pn] {
+			if sys.projs[pn][i].id >= 0 {
+				list = append(list, inspectorEntity{IE_Projectile, pn, int32(i)})
+			}
+		}
+	}
+	for pn := range sys.explods {
+		for i := range sys.explods[pn] {
+			if sys.explods[pn][i].id != IErr {
+				list = append(list, inspectorEntity{IE_Explod, pn, int32(i)})
+			}
+		}
+	}
+	return
+}
+
+// Moves the selection by delta entities.
+func (in *Inspector) Select(delta int) {
+	list := in.entities()
+	if len(list) == 0 {
+		return
+	}
+	i := -1
+	for j, e := range list {
+		if e == in.selected {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		i = 0
+	} else {
+		i = ((i+delta)%len(list) + len(list)) % len(list)
+	}
+	in.selected, in.scroll = list[i], 0
+}
+
+func (in *Inspector) SetPage(delta int) {
+	n := len(inspectorPageNames)
+	in.page = InspectorPage(((int(in.page)+delta)%n + n) % n)
+	in.scroll = 0
+}
+
+func (in *Inspector) Scroll(delta int) {
+	in.scroll = int(Max(0, int32(in.scroll+delta)))
+}
+
+// Selects the character the debug display follows when nothing is selected.
+func (in *Inspector) current() inspectorEntity {
+	if in.selected.String() == "none" {
+		if c := sys.debugWC; c != nil && !c.csf(CSF_destroy) {
+			in.selected = inspectorEntity{IE_Char, c.playerNo, c.id}
+		} else if list := in.entities(); len(list) > 0 {
+			in.selected = list[0]
+		}
+	}
+	return in.selected
+}
+
+var inspectorField = regexp.MustCompile(`^([a-z]+)\s*(?:\(\s*([^)]*)\s*\))?$`)
+
+// Sets a value of the selected character. Only allowed while paused, so the
+// change is seen before the next frame runs.
+func (in *Inspector) Set(field string, value float64) error {
+	if !sys.paused {
+		return Error("Pause the game to edit values")
+	}
+	c := in.current().char()
+	if c == nil || in.selected.typ != IE_Char {
+		return Error("No character selected")
+	}
+	m := inspectorField.FindStringSubmatch(strings.ToLower(strings.TrimSpace(field)))
+	if m == nil {
+		return Error("Invalid field: " + field)
+	}
+	idx := int32(-1)
+	if m[2] != "" && m[1] != "map" {
+		i, err := strconv.Atoi(m[2])
+		if err != nil {
+			return Error("Invalid index: " + m[2])
+		}
+		idx = int32(i)
+	}
+	var ok BytecodeValue
+	switch m[1] {
+	case "var":
+		ok = c.varSet(idx, int32(value))
+	case "fvar":
+		ok = c.fvarSet(idx, float32(value))
+	case "sysvar":
+		ok = c.sysVarSet(idx, int32(value))
+	case "sysfvar":
+		ok = c.sysFvarSet(idx, float32(value))
+	case "map":
+		ok = c.mapSet(strings.TrimSpace(m[2]), float32(value), 0)
+	case "life":
+		c.lifeSet(int32(value))
+	case "power":
+		c.setPower(int32(value))
+	case "dizzypoints":
+		c.dizzyPointsSet(int32(value))
+	case "guardpoints":
+		c.guardPointsSet(int32(value))
+	case "redlife":
+		c.redLifeSet(int32(value))
+	case "ctrl":
+		c.setCtrl(value != 0)
+	case "facing":
+		c.setFacing(float32(value))
+	case "posx":
+		c.setPosX(float32(value))
+	case "posy":
+		c.setPosY(float32(value))
+	case "posz":
+		c.setPosZ(float32(value))
+	case "velx", "vely", "velz":
+		c.vel[m[1][3]-'x'] = float32(value)
+	case "stateno":
+		c.changeState(int32(value), -1, -1, "")
+	default:
+		return Error("Unknown field: " + field)
+	}
+	if ok.IsSF() {
+		return Error("Invalid field: " + field)
+	}
+	return nil
+}
+
+func (in *Inspector) Watch(text string) {
+	in.watches = append(in.watches, newDebugExpr(text))
+}
+
+// Removes watch n, counting from 1, or all of them if n is 0.
+func (in *Inspector) Unwatch(n int) {
+	if n <= 0 {
+		in.watches = nil
+	} else if n <= len(in.watches) {
+		in.watches = append(in.watches[:n-1], in.watches[n:]...)
+	}
+}
+
+func bytecodeValueString(bv BytecodeValue) string {
+	switch bv.t {
+	case VT_Float:
+		return strconv.FormatFloat(float64(bv.ToF()), 'g', -1, 32)
+	case VT_Int:
+		return strconv.Itoa(int(bv.ToI()))
+	case VT_Bool:
+		return strconv.FormatBool(bv.ToB())
+	case VT_SFalse:
+		return "SFalse"
+	}
+	return "none"
+}
+
+func stateTypeName(st StateType) string {
+	for i, n := range "SCALNU" {
+		if st == StateType(1<<uint(i)) {
+			return string(n)
+		}
+	}
+	return "?"
+}
+
+func moveTypeName(mt MoveType) string {
+	for i, n := range "IHAU" {
+		if mt == MT_I<<uint(i) {
+			return string(n)
+		}
+	}
+	return "?"
+}
+
+// Lines of name = value for the scalar fields of a struct, and for arrays of
+// scalars.
+func inspectFields(v interface{}) (lines []string) {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := rv.Field(i)
+		k := f.Kind()
+		if k == reflect.Array {
+			k = f.Type().Elem().Kind()
+		}
+		switch k {
+		case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+			reflect.Int64, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+			reflect.Uint64, reflect.Float32, reflect.Float64, reflect.String:
+			lines = append(lines, fmt.Sprintf("%v = %v", t.Field(i).Name, f))
+		}
+	}
+	return
+}
+
+// Lines shown for the selected entity on the current page.
+func (in *Inspector) lines() []string {
+	sel := in.current()
+	if p := sel.proj(); p != nil {
+		if in.page == IP_HitDef {
+			return inspectFields(&p.hitdef)
+		}
+		return inspectFields(p)
+	}
+	if e := sel.explod(); e != nil {
+		return inspectFields(e)
+	}
+	c := sel.char()
+	if c == nil {
+		return nil
+	}
+	var lines []string
+	add := func(format string, a ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, a...))
+	}
+	switch in.page {
+	case IP_State:
+		add("stateno %v (P%v)  prevstateno %v  time %v", c.ss.no,
+			c.ss.sb.playerNo+1, c.ss.prevno, c.ss.time)
+		add("statetype %v  movetype %v  physics %v  ctrl %v",
+			stateTypeName(c.ss.stateType), moveTypeName(c.ss.moveType),
+			stateTypeName(c.ss.physics), c.ctrl())
+		add("anim %v (P%v)  animelem %v  animtime %v", c.animNo, c.animPN+1,
+			c.animElemNo(0).ToI(), c.animTime())
+		add("pos %.2f, %.2f, %.2f", c.pos[0], c.pos[1], c.pos[2])
+		add("vel %.2f, %.2f, %.2f  facing %v", c.vel[0], c.vel[1], c.vel[2],
+			c.facing)
+		add("life %v/%v  power %v/%v  redlife %v", c.life, c.lifeMax, c.power,
+			c.powerMax, c.redLife)
+		add("dizzypoints %v/%v  guardpoints %v/%v", c.dizzyPoints,
+			c.dizzyPointsMax, c.guardPoints, c.guardPointsMax)
+		add("hitpause %v  juggle %v  movecontact %v", c.hitPause(), c.juggle,
+			c.moveContact())
+		add("targets %v  children %v", c.targets, len(c.children))
+		if c.helperIndex != 0 {
+			add("helperid %v  parent %v  root %v", c.helperId, c.parentIndex,
+				sys.chars[c.playerNo][0].id)
+		}
+	case IP_Vars:
+		cols := func(name string, n int, get func(i int) string) {
+			for i := 0; i < n; i += 4 {
+				var s []string
+				for j := i; j < i+4 && j < n; j++ {
+					s = append(s, fmt.Sprintf("%v(%v)=%v", name, j, get(j)))
+				}
+				lines = append(lines, strings.Join(s, "  "))
+			}
+		}
+		cols("var", NumVar, func(i int) string { return fmt.Sprint(c.ivar[i]) })
+		cols("fvar", NumFvar, func(i int) string { return fmt.Sprint(c.fvar[i]) })
+		cols("sysvar", NumSysVar, func(i int) string { return fmt.Sprint(c.ivar[NumVar+i]) })
+		cols("sysfvar", NumSysFvar, func(i int) string { return fmt.Sprint(c.fvar[NumFvar+i]) })
+	case IP_Maps:
+		keys := make([]string, 0, len(c.mapArray))
+		for k := range c.mapArray {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			add("%v = %v", k, c.mapArray[k])
+		}
+	case IP_HitDef:
+		lines = inspectFields(&c.hitdef)
+	case IP_GetHitVar:
+		lines = inspectFields(&c.ghv)
+	case IP_Flags:
+		var asf, gsf []string
+		for i, n := range assertSpecialNames {
+			if c.asf(AssertSpecialFlag(1) << uint(i)) {
+				asf = append(asf, n)
+			}
+		}
+		for i, n := range globalSpecialNames {
+			if sys.specialFlag&(GlobalSpecialFlag(1)<<uint(i)) != 0 {
+				gsf = append(gsf, n)
+			}
+		}
+		add("assertspecial: %v", strings.Join(asf, ", "))
+		add("global: %v", strings.Join(gsf, ", "))
+	case IP_History:
+		n := int(Min(int32(c.stateHistoryPos), stateHistoryLen))
+		for i := 0; i < n; i++ {
+			t := c.stateHistory[(c.stateHistoryPos-1-i)%stateHistoryLen]
+			add("%v: %v -> %v (P%v)", t.gameTime, t.from, t.to, t.playerNo+1)
+		}
+	case IP_Watch:
+		for i, w := range in.watches {
+			if bv, err := w.run(c); err != nil {
+				add("%v. %v: %v", i+1, w.text, err)
+			} else {
+				add("%v. %v = %v", i+1, w.text, bytecodeValueString(bv))
+			}
+		}
+	}
+	return lines
+}
+
+func (in *Inspector) draw() {
+	fnt, ts := sys.debugFont.fnt, sys.debugFont
+	lh := float32(fnt.Size[1]) * ts.yscl / sys.heightScale
+	FillRect([4]int32{sys.scrrect[2] / 2, 0, sys.scrrect[2] / 2, sys.scrrect[3]}, 0, 160)
+	x := float32(160)
+	y := 240 - float32(sys.gameHeight)
+	maxW := float32(sys.gameWidth) / 2
+	put := func(txt string) {
+		// Lines are cut to the width of the panel
+		w := float32(0)
+		for i, r := range txt {
+			w += float32(fnt.CharWidth(r, 0)+fnt.Spacing[0]) * ts.xscl / sys.widthScale
+			if w > maxW {
+				txt = txt[:i]
+				break
+			}
+		}
+		y += lh
+		fnt.Print(txt, x, y, ts.xscl/sys.widthScale, ts.yscl/sys.heightScale, 0, 1,
+			&sys.scrrect, ts.palfx, ts.frgba)
+	}
+	sel := in.current()
+	ts.SetColor(255, 255, 127)
+	put(fmt.Sprintf("%v [%v/%v]", sel, in.page+1, len(inspectorPageNames)))
+	ts.SetColor(199, 199, 219)
+	put(inspectorPageNames[in.page])
+	ts.SetColor(255, 255, 255)
+	lines := in.lines()
+	rows := int((float32(sys.gameHeight)-2*lh)/lh) - 1
+	if in.scroll > len(lines)-rows {
+		in.scroll = int(Max(0, int32(len(lines)-rows)))
+	}
+	for i := in.scroll; i < len(lines) && i < in.scroll+rows; i++ {
+		put(lines[i])
+	}
+}
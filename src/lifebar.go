@@ -2950,6 +2950,98 @@ func (wc *LifeBarWinCount) draw(layerno int16, f []*Fnt, side int) {
 	}
 }
 
+type LifeBarFrameData struct {
+	pos     [2]int32
+	text    LbText
+	bg      AnimLayout
+	top     AnimLayout
+	contact [4]string // Hit, guarded, reversed, whiff
+	unknown string
+	enabled map[string]bool
+	active  bool
+}
+
+func newLifeBarFrameData() *LifeBarFrameData {
+	return &LifeBarFrameData{contact: [...]string{"Hit", "Block", "Reversal", "Whiff"},
+		unknown: "-", enabled: map[string]bool{"training": true}}
+}
+func readLifeBarFrameData(pre string, is IniSection,
+	sff *Sff, at AnimationTable, f []*Fnt) *LifeBarFrameData {
+	fd := newLifeBarFrameData()
+	// Usable without a [FrameData] section in the lifebar def
+	if pre == "p1." {
+		fd.pos = [...]int32{5, 50}
+	} else {
+		fd.pos = [...]int32{315, 50}
+	}
+	is.ReadI32(pre+"pos", &fd.pos[0], &fd.pos[1])
+	align := int32(1)
+	if pre == "p2." {
+		align = -1
+	}
+	fd.text = *readLbText(pre+"text.", is, "Startup %s  Active %a  Recovery %r\n%c %v", 0, f, align)
+	if _, ok := is[pre+"text.font"]; !ok {
+		fd.text.font[0] = 0
+	}
+	for i, k := range []string{"hit", "guard", "reversal", "whiff", "unknown"} {
+		if str, ok, _ := is.getText(k + ".text"); ok {
+			if i < len(fd.contact) {
+				fd.contact[i] = str
+			} else {
+				fd.unknown = str
+			}
+		}
+	}
+	fd.bg = *ReadAnimLayout(pre+"bg.", is, sff, at, 0)
+	fd.top = *ReadAnimLayout(pre+"top.", is, sff, at, 0)
+	for k := range is {
+		sp := strings.Split(k, ".")
+		if len(sp) == 3 && pre == fmt.Sprintf("%v.", sp[0]) && sp[1] == "enabled" {
+			var b bool
+			if is.ReadBool(k, &b) {
+				fd.enabled[sp[2]] = b
+			}
+		}
+	}
+	return fd
+}
+func (fd *LifeBarFrameData) step() {
+	fd.bg.Action()
+	fd.top.Action()
+}
+func (fd *LifeBarFrameData) reset() {
+	fd.bg.Reset()
+	fd.top.Reset()
+}
+func (fd *LifeBarFrameData) bgDraw(layerno int16, data *FrameData) {
+	if fd.active && data.done {
+		fd.bg.Draw(float32(fd.pos[0])+sys.lifebarOffsetX, float32(fd.pos[1]), layerno, sys.lifebarScale)
+	}
+}
+func (fd *LifeBarFrameData) draw(layerno int16, f []*Fnt, data *FrameData) {
+	if fd.active && data.done && fd.text.font[0] >= 0 && int(fd.text.font[0]) < len(f) && f[fd.text.font[0]] != nil {
+		num := func(v int32) string {
+			if v < 0 {
+				return fd.unknown
+			}
+			return fmt.Sprintf("%v", v)
+		}
+		adv := fd.unknown
+		if data.hasAdv {
+			adv = fmt.Sprintf("%+d", data.advantage)
+		}
+		contact := fd.contact[len(fd.contact)-1]
+		if data.contact >= 0 && int(data.contact) < len(fd.contact)-1 {
+			contact = fd.contact[data.contact]
+		}
+		text := strings.NewReplacer("%s", num(data.startup), "%a", num(data.active),
+			"%r", num(data.recovery), "%v", adv, "%c", contact, "\\n", "\n").Replace(fd.text.text)
+		fd.text.lay.DrawText(float32(fd.pos[0])+sys.lifebarOffsetX, float32(fd.pos[1]), sys.lifebarScale, layerno,
+			text, f[fd.text.font[0]], fd.text.font[1], fd.text.font[2], fd.text.palfx, fd.text.frgba)
+		fd.top.Draw(float32(fd.pos[0])+sys.lifebarOffsetX, float32(fd.pos[1]), layerno, sys.lifebarScale)
+	}
+}
+
 type LifeBarMode struct {
 	pos  [2]int32
 	text LbText
@@ -3024,6 +3116,7 @@ type Lifebar struct {
 	ma         *LifeBarMatch
 	ai         [2]*LifeBarAiLevel
 	wc         [2]*LifeBarWinCount
+	fd         [2]*LifeBarFrameData
 	mo         map[string]*LifeBarMode
 	missing    map[string]int
 	active     bool
@@ -3080,7 +3173,7 @@ func loadLifebar(def string) (*Lifebar, error) {
 		"[tag name]": 3, "[simul_3p name]": 4, "[simul_4p name]": 5,
 		"[tag_3p name]": 6, "[tag_4p name]": 7, "[action]": -1, "[ratio]": -1,
 		"[timer]": -1, "[score]": -1, "[match]": -1, "[ailevel]": -1,
-		"[wincount]": -1, "[mode]": -1,
+		"[wincount]": -1, "[mode]": -1, "[framedata]": -1,
 	}
 	strc := strings.ToLower(strings.TrimSpace(str))
 	for k := range l.missing {
@@ -3590,6 +3683,13 @@ func loadLifebar(def string) (*Lifebar, error) {
 			if l.wc[1] == nil {
 				l.wc[1] = readLifeBarWinCount("p2.", is, l.sff, l.at, l.fnt[:])
 			}
+		case "framedata":
+			if l.fd[0] == nil {
+				l.fd[0] = readLifeBarFrameData("p1.", is, l.sff, l.at, l.fnt[:])
+			}
+			if l.fd[1] == nil {
+				l.fd[1] = readLifeBarFrameData("p2.", is, l.sff, l.at, l.fnt[:])
+			}
 		case "mode":
 			if l.mo == nil {
 				l.mo = readLifeBarMode(is, l.sff, l.at, l.fnt[:])
@@ -3714,6 +3814,8 @@ func (l *Lifebar) reloadLifebar() error {
 	lb.ai[1].active = l.ai[1].active
 	lb.wc[0].active = l.wc[0].active
 	lb.wc[1].active = l.wc[1].active
+	lb.fd[0].active = l.fd[0].active
+	lb.fd[1].active = l.fd[1].active
 	lb.active = l.active
 	lb.bars = l.bars
 	lb.mode = l.mode
@@ -3820,6 +3922,10 @@ func (l *Lifebar) step() {
 	for i := range l.wc {
 		l.wc[i].step()
 	}
+	// LifeBarFrameData
+	for i := range l.fd {
+		l.fd[i].step()
+	}
 	// LifeBarMode
 	if _, ok := l.mo[sys.gameMode]; ok {
 		l.mo[sys.gameMode].step()
@@ -3927,6 +4033,9 @@ func (l *Lifebar) reset() {
 	for i := range l.wc {
 		l.wc[i].reset()
 	}
+	for i := range l.fd {
+		l.fd[i].reset()
+	}
 	if _, ok := l.mo[sys.gameMode]; ok {
 		l.mo[sys.gameMode].reset()
 	}
@@ -4069,6 +4178,17 @@ func (l *Lifebar) draw(layerno int16) {
 			for i := range l.wc {
 				l.wc[i].draw(layerno, l.fnt[:], i)
 			}
+			// LifeBarFrameData
+			for i := range l.fd {
+				if len(sys.chars[i]) > 0 {
+					l.fd[i].bgDraw(layerno, &sys.chars[i][0].frameData)
+				}
+			}
+			for i := range l.fd {
+				if len(sys.chars[i]) > 0 {
+					l.fd[i].draw(layerno, l.fnt[:], &sys.chars[i][0].frameData)
+				}
+			}
 		}
 		// LifeBarCombo
 		for i := range l.co {
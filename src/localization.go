@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// String tables hold translated text for screenpacks, lifebars and scripts.
+// Each language is a <code>.ini file inside data/lang/ or <motif>/lang/
+// (motif entries override data ones):
+//
+//	[Info]
+//	name   = "English"
+//	plural = en          ; optional, plural rule to use, defaults to the code
+//	[Strings]
+//	menu.arcade      = "Arcade"
+//	result.wins.one   = "{1} win"
+//	result.wins.other = "{1} wins"
+//
+// {1}, {2}... are replaced by arguments. Plural variants are the key plus a
+// .zero, .one, .two, .few, .many or .other suffix; .zero is also honoured for
+// a count of 0 in languages that have no such category. Text elements
+// reference keys as $(key), resolved when they are drawn.
+
+var pluralSuffixes = []string{".zero", ".one", ".two", ".few", ".many", ".other"}
+
+var langEscapes = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`)
+
+type StringTable struct {
+	code    string
+	name    string
+	plural  string
+	strings map[string]string
+}
+
+func newStringTable(code string) *StringTable {
+	return &StringTable{code: code, plural: code, strings: make(map[string]string)}
+}
+
+// Reads a language file. Later files override keys of earlier ones.
+func (st *StringTable) parse(text string) {
+	section := ""
+	for _, line := range SplitAndTrim(strings.ReplaceAll(text, "\r", ""), "\n") {
+		if len(line) == 0 || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			name, _ := SectionName(line)
+			section = strings.TrimSpace(name)
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+		if len(val) > 0 && val[0] == '"' {
+			if j := strings.LastIndex(val, `"`); j > 0 {
+				val = val[1:j]
+			} else {
+				val = val[1:]
+			}
+			val = langEscapes.Replace(val)
+		} else {
+			val = strings.TrimSpace(strings.SplitN(val, ";", 2)[0])
+		}
+		switch section {
+		case "info":
+			switch key {
+			case "name":
+				st.name = val
+			case "plural":
+				st.plural = strings.ToLower(val)
+			}
+		case "strings":
+			st.strings[key] = val
+		}
+	}
+}
+
+// Key with any plural suffix removed
+func baseLangKey(key string) string {
+	for _, s := range pluralSuffixes {
+		if strings.HasSuffix(key, s) {
+			return key[:len(key)-len(s)]
+		}
+	}
+	return key
+}
+
+// CLDR plural category of the integer n for the given language.
+func pluralCategory(rule string, n int64) string {
+	if n < 0 {
+		n = -n
+	}
+	if i := strings.IndexAny(rule, "-_"); i > 0 {
+		rule = rule[:i]
+	}
+	n10, n100 := n%10, n%100
+	switch rule {
+	case "ja", "zh", "ko", "th", "vi", "id", "ms":
+		return "other"
+	case "fr":
+		if n <= 1 {
+			return "one"
+		}
+	case "ru", "uk", "be", "sr", "hr", "bs":
+		switch {
+		case n10 == 1 && n100 != 11:
+			return "one"
+		case n10 >= 2 && n10 <= 4 && (n100 < 12 || n100 > 14):
+			return "few"
+		}
+		return "many"
+	case "pl":
+		switch {
+		case n == 1:
+			return "one"
+		case n10 >= 2 && n10 <= 4 && (n100 < 12 || n100 > 14):
+			return "few"
+		}
+		return "many"
+	case "cs", "sk":
+		switch {
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		}
+	case "ar":
+		switch {
+		case n == 0:
+			return "zero"
+		case n == 1:
+			return "one"
+		case n == 2:
+			return "two"
+		case n100 >= 3 && n100 <= 10:
+			return "few"
+		case n100 >= 11:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+	}
+	return "other"
+}
+
+// Replaces {1}, {2}... with args. Unknown placeholders are kept.
+func formatLangArgs(text string, args []string) string {
+	if len(args) == 0 || !strings.Contains(text, "{") {
+		return text
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(text, "{")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(text[i:], "}")
+		if j < 0 {
+			break
+		}
+		b.WriteString(text[:i])
+		if n, err := strconv.Atoi(text[i+1 : i+j]); err == nil && n >= 1 && n <= len(args) {
+			b.WriteString(args[n-1])
+		} else {
+			b.WriteString(text[i : i+j+1])
+		}
+		text = text[i+j+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+type Localization struct {
+	dirs   []string
+	chain  []*StringTable // Current language first, then the fallback
+	loaded bool
+}
+
+var lang = &Localization{}
+
+// Folders searched for language files
+func langDirs(motifDir string) []string {
+	dirs := []string{"data/lang"}
+	if motifDir != "" {
+		if d := path.Join(strings.ReplaceAll(motifDir, "\\", "/"), "lang"); d != dirs[0] {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+func loadStringTable(dirs []string, code string) *StringTable {
+	st := newStringTable(strings.ToLower(code))
+	found := false
+	for _, d := range dirs {
+		if file := FileExist(d + "/" + code + ".ini"); file != "" {
+			if text, err := LoadText(file); err == nil {
+				st.parse(text)
+				found = true
+			} else {
+				sys.errLog.Printf("Failed to load language file %v: %v", file, err)
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return st
+}
+
+// Marks the tables for reloading, e.g. after the language or motif changed.
+func (l *Localization) Reload() {
+	l.loaded = false
+}
+
+func (l *Localization) load() {
+	if l.loaded {
+		return
+	}
+	l.loaded = true
+	l.dirs = langDirs(sys.motifDir)
+	l.chain = l.chain[:0]
+	for _, code := range []string{sys.language, sys.languageFallback} {
+		if code == "" || (len(l.chain) > 0 && l.chain[0].code == strings.ToLower(code)) {
+			continue
+		}
+		if st := loadStringTable(l.dirs, code); st != nil {
+			l.chain = append(l.chain, st)
+		}
+	}
+}
+
+func (l *Localization) lookup(key string) (string, bool) {
+	l.load()
+	key = strings.ToLower(key)
+	for _, st := range l.chain {
+		if s, ok := st.strings[key]; ok {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// Translated text of key, or the key itself if no table has it.
+func (l *Localization) Get(key string, args ...string) string {
+	if s, ok := l.lookup(key); ok {
+		return formatLangArgs(s, args)
+	}
+	return key
+}
+
+// Plural form of key for count n, which is passed as the first argument.
+func (l *Localization) Plural(key string, n float64, args ...string) string {
+	l.load()
+	key = strings.ToLower(key)
+	args = append([]string{strconv.FormatFloat(n, 'f', -1, 64)}, args...)
+	cat := "other"
+	for _, st := range l.chain {
+		if n == float64(int64(n)) {
+			cat = pluralCategory(st.plural, int64(n))
+		}
+		keys := []string{key + "." + cat, key + ".other", key}
+		if n == 0 {
+			keys = append([]string{key + ".zero"}, keys...)
+		}
+		for _, k := range keys {
+			if s, ok := st.strings[k]; ok {
+				return formatLangArgs(s, args)
+			}
+		}
+	}
+	return key
+}
+
+// Replaces $(key) references in text. Unknown keys are left as they are, a
+// key written in upper case gives upper case text.
+func (l *Localization) Expand(text string) string {
+	if !strings.Contains(text, "$(") {
+		return text
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(text, "$(")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(text[i:], ")")
+		if j < 0 {
+			break
+		}
+		b.WriteString(text[:i])
+		key := text[i+2 : i+j]
+		if s, ok := l.lookup(key); ok {
+			if key != strings.ToLower(key) && key == strings.ToUpper(key) {
+				s = strings.ToUpper(s)
+			}
+			b.WriteString(s)
+		} else {
+			b.WriteString(text[i : i+j+1])
+		}
+		text = text[i+j+1:]
+	}
+	b.WriteString(text)
+	return b.String()
+}
+
+// Switches the language of the tables; text is re-resolved on the next draw.
+func (l *Localization) SetLanguage(code string) {
+	sys.language = code
+	l.Reload()
+}
+
+// Codes and names of the languages that have a file in any lang folder.
+func (l *Localization) Languages() (codes, names []string) {
+	l.load()
+	return l.languages(l.dirs)
+}
+
+func (l *Localization) languages(dirs []string) (codes, names []string) {
+	seen := make(map[string]bool)
+	for _, d := range dirs {
+		entries, err := vfs.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !HasExtension(name, "^\\.ini$") {
+				continue
+			}
+			code := strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+	}
+	sort.Strings(codes)
+	for _, c := range codes {
+		name := c
+		if st := loadStringTable(dirs, c); st != nil && st.name != "" {
+			name = st.name
+		}
+		names = append(names, name)
+	}
+	return
+}
+
+// Prints, for every language, the keys that other languages define but it
+// lacks. Plural variants count as one key.
+func (l *Localization) Report(motifDir string) {
+	dirs := langDirs(motifDir)
+	codes, names := l.languages(dirs)
+	if len(codes) == 0 {
+		fmt.Printf("No language files found in %v\n", strings.Join(dirs, ", "))
+		return
+	}
+	all := make(map[string]bool)
+	keys := make([]map[string]bool, len(codes))
+	for i, c := range codes {
+		keys[i] = make(map[string]bool)
+		if st := loadStringTable(dirs, c); st != nil {
+			for k := range st.strings {
+				keys[i][baseLangKey(k)] = true
+				all[baseLangKey(k)] = true
+			}
+		}
+	}
+	for i, c := range codes {
+		var missing []string
+		for k := range all {
+			if !keys[i][k] {
+				missing = append(missing, k)
+			}
+		}
+		sort.Strings(missing)
+		fmt.Printf("%v (%v): %v keys, %v missing\n", c, names[i], len(keys[i]), len(missing))
+		for _, k := range missing {
+			fmt.Printf("  %v\n", k)
+		}
+	}
+}
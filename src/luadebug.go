@@ -0,0 +1,867 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Lua script debugger speaking the Debug Adapter Protocol, over a localhost
+// socket or stdio. gopher-lua has no debug hooks, so scripts loaded by the
+// main script, dofile, loadfile and require are compiled with a call to a
+// hook function before each statement. The hook pauses the main thread on
+// breakpoints and steps, and answers the requests that need the Lua state
+// (stack traces, variables, evaluation) until the client resumes. Code
+// loaded from strings is not instrumented.
+
+const (
+	luaDebugHook        = "__luadebug"
+	luaDebugDefaultPort = "4711"
+)
+
+type luaStepMode int32
+
+const (
+	LS_None luaStepMode = iota
+	LS_In
+	LS_Over
+	LS_Out
+)
+
+type luaBreakpoint struct {
+	line      int
+	condition string
+}
+
+type luaDebugFile struct {
+	path  string
+	lines map[int]bool // Lines with a statement, nil until the file is scanned
+	bps   map[int]*luaBreakpoint
+}
+
+type luaVarRefKind int32
+
+const (
+	LR_Locals luaVarRefKind = iota
+	LR_Upvalues
+	LR_Table
+)
+
+type luaVarRef struct {
+	kind  luaVarRefKind
+	level int
+	table *lua.LTable
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type LuaDebugger struct {
+	enabled bool
+	mu      sync.Mutex // Guards the fields below, shared with the session
+	files   []*luaDebugFile
+	fileIds map[string]int
+	paused  bool
+	pause   bool // Pause at the next statement
+	entry   bool // Pause at the first statement after configuration
+	wmu     sync.Mutex
+	w       io.Writer
+	seq     int
+	ready   chan struct{}
+	once    sync.Once
+	vmReqs  chan *dapRequest // Requests handled by the paused main thread
+	// Main thread only
+	step       luaStepMode
+	stepDepth  int
+	refs       []luaVarRef
+	evaluating bool
+}
+
+var luaDebugger = &LuaDebugger{fileIds: make(map[string]int),
+	ready: make(chan struct{}), vmReqs: make(chan *dapRequest, 16)}
+
+// Installs the instrumented loaders in l and waits for a client to finish
+// its configuration. addr is a port number or "stdio".
+func (d *LuaDebugger) Start(l *lua.LState, addr string) error {
+	if addr == "stdio" {
+		// Everything else printed to stdout goes to stderr instead
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		go d.serve(os.Stdin, out)
+	} else {
+		if addr == "" {
+			addr = luaDebugDefaultPort
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:"+addr)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Waiting for a Lua debugger on %v\n", ln.Addr())
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				d.serve(conn, conn)
+				conn.Close()
+			}
+		}()
+	}
+	d.enabled = true
+	d.install(l)
+	<-d.ready
+	return nil
+}
+
+func (d *LuaDebugger) install(l *lua.LState) {
+	l.SetGlobal(luaDebugHook, l.NewFunction(d.hook))
+	l.SetGlobal("dofile", l.NewFunction(func(l *lua.LState) int {
+		fn, err := d.loadFile(l, l.CheckString(1))
+		if err != nil {
+			l.RaiseError("%v", err)
+		}
+		top := l.GetTop()
+		l.Push(fn)
+		l.Call(0, lua.MultRet)
+		return l.GetTop() - top
+	}))
+	l.SetGlobal("loadfile", l.NewFunction(func(l *lua.LState) int {
+		fn, err := d.loadFile(l, l.CheckString(1))
+		if err != nil {
+			l.Push(lua.LNil)
+			l.Push(lua.LString(err.Error()))
+			return 2
+		}
+		l.Push(fn)
+		return 1
+	}))
+	// Replaces the Lua file loader used by require
+	if loaders, ok := l.GetField(l.GetGlobal("package"), "loaders").(*lua.LTable); ok {
+		loaders.RawSetInt(2, l.NewFunction(func(l *lua.LState) int {
+			name := strings.Replace(l.CheckString(1), ".", "/", -1)
+			var msg string
+			for _, p := range strings.Split(lua.LVAsString(
+				l.GetField(l.GetGlobal("package"), "path")), ";") {
+				file := strings.Replace(p, "?", name, -1)
+				if _, err := os.Stat(file); err != nil {
+					msg += fmt.Sprintf("\n\tno file '%v'", file)
+					continue
+				}
+				fn, err := d.loadFile(l, file)
+				if err != nil {
+					l.RaiseError("%v", err)
+				}
+				l.Push(fn)
+				return 1
+			}
+			l.Push(lua.LString(msg))
+			return 1
+		}))
+	}
+	// Sends print output to the client as well
+	luaPrint := l.GetGlobal("print")
+	l.SetGlobal("print", l.NewFunction(func(l *lua.LState) int {
+		args := make([]lua.LValue, l.GetTop())
+		strs := make([]string, len(args))
+		for i := range args {
+			args[i] = l.Get(i + 1)
+			strs[i] = l.ToStringMeta(args[i]).String()
+		}
+		d.output("stdout", strings.Join(strs, "\t")+"\n")
+		l.CallByParam(lua.P{Fn: luaPrint, NRet: 0, Protect: false}, args...)
+		return 0
+	}))
+}
+
+// Runs a script file like DoFile, instrumented if the debugger is enabled.
+func (d *LuaDebugger) DoFile(l *lua.LState, file string) error {
+	if !d.enabled {
+		return l.DoFile(file)
+	}
+	fn, err := d.loadFile(l, file)
+	if err != nil {
+		return err
+	}
+	l.Push(fn)
+	return l.PCall(0, lua.MultRet, nil)
+}
+
+func luaDebugKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.Clean(path)
+	if runtime.GOOS == "windows" {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+// Returns the id of a file, registering it if needed. Call with mu locked.
+func (d *LuaDebugger) fileId(path string) int {
+	key := luaDebugKey(path)
+	if id, ok := d.fileIds[key]; ok {
+		return id
+	}
+	abs, _ := filepath.Abs(path)
+	d.files = append(d.files, &luaDebugFile{path: abs, bps: make(map[int]*luaBreakpoint)})
+	d.fileIds[key] = len(d.files) - 1
+	return len(d.files) - 1
+}
+
+func luaDebugParse(file string) ([]ast.Stmt, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// Skip a #! line, keeping the line numbers
+	if len(b) > 0 && b[0] == '#' {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[i:]
+		} else {
+			b = nil
+		}
+	}
+	return parse.Parse(bytes.NewReader(b), file)
+}
+
+func (d *LuaDebugger) loadFile(l *lua.LState, file string) (*lua.LFunction, error) {
+	chunk, err := luaDebugParse(file)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	id := d.fileId(file)
+	d.mu.Unlock()
+	lines := make(map[int]bool)
+	chunk = instrumentBlock(chunk, id, lines)
+	d.mu.Lock()
+	d.files[id].lines = lines
+	d.mu.Unlock()
+	proto, err := lua.Compile(chunk, file)
+	if err != nil {
+		return nil, err
+	}
+	return l.NewFunctionFromProto(proto), nil
+}
+
+// Puts a hook call before each statement of a block, and of the blocks and
+// functions nested in it.
+func instrumentBlock(stmts []ast.Stmt, id int, lines map[int]bool) []ast.Stmt {
+	out := make([]ast.Stmt, 0, len(stmts)*2)
+	for _, st := range stmts {
+		instrumentStmt(st, id, lines)
+		line := st.Line()
+		lines[line] = true
+		fn := &ast.IdentExpr{Value: luaDebugHook}
+		fid := &ast.NumberExpr{Value: strconv.Itoa(id)}
+		fline := &ast.NumberExpr{Value: strconv.Itoa(line)}
+		call := &ast.FuncCallExpr{Func: fn, Args: []ast.Expr{fid, fline}}
+		hook := &ast.FuncCallStmt{Expr: call}
+		for _, n := range [...]ast.PositionHolder{fn, fid, fline, call, hook} {
+			n.SetLine(line)
+			n.SetLastLine(line)
+		}
+		out = append(out, hook, st)
+	}
+	return out
+}
+
+func instrumentStmt(st ast.Stmt, id int, lines map[int]bool) {
+	exprs := func(es []ast.Expr) {
+		for _, e := range es {
+			instrumentExpr(e, id, lines)
+		}
+	}
+	switch s := st.(type) {
+	case *ast.AssignStmt:
+		exprs(s.Lhs)
+		exprs(s.Rhs)
+	case *ast.LocalAssignStmt:
+		exprs(s.Exprs)
+	case *ast.FuncCallStmt:
+		instrumentExpr(s.Expr, id, lines)
+	case *ast.DoBlockStmt:
+		s.Stmts = instrumentBlock(s.Stmts, id, lines)
+	case *ast.WhileStmt:
+		instrumentExpr(s.Condition, id, lines)
+		s.Stmts = instrumentBlock(s.Stmts, id, lines)
+	case *ast.RepeatStmt:
+		instrumentExpr(s.Condition, id, lines)
+		s.Stmts = instrumentBlock(s.Stmts, id, lines)
+	case *ast.IfStmt:
+		instrumentExpr(s.Condition, id, lines)
+		s.Then = instrumentBlock(s.Then, id, lines)
+		s.Else = instrumentBlock(s.Else, id, lines)
+	case *ast.NumberForStmt:
+		exprs([]ast.Expr{s.Init, s.Limit, s.Step})
+		s.Stmts = instrumentBlock(s.Stmts, id, lines)
+	case *ast.GenericForStmt:
+		exprs(s.Exprs)
+		s.Stmts = instrumentBlock(s.Stmts, id, lines)
+	case *ast.FuncDefStmt:
+		instrumentExpr(s.Func, id, lines)
+	case *ast.ReturnStmt:
+		exprs(s.Exprs)
+	}
+}
+
+func instrumentExpr(e ast.Expr, id int, lines map[int]bool) {
+	if e == nil {
+		return
+	}
+	switch x := e.(type) {
+	case *ast.FunctionExpr:
+		x.Stmts = instrumentBlock(x.Stmts, id, lines)
+	case *ast.FuncCallExpr:
+		instrumentExpr(x.Func, id, lines)
+		instrumentExpr(x.Receiver, id, lines)
+		for _, a := range x.Args {
+			instrumentExpr(a, id, lines)
+		}
+	case *ast.AttrGetExpr:
+		instrumentExpr(x.Object, id, lines)
+		instrumentExpr(x.Key, id, lines)
+	case *ast.TableExpr:
+		for _, f := range x.Fields {
+			instrumentExpr(f.Key, id, lines)
+			instrumentExpr(f.Value, id, lines)
+		}
+	case *ast.LogicalOpExpr:
+		instrumentExpr(x.Lhs, id, lines)
+		instrumentExpr(x.Rhs, id, lines)
+	case *ast.RelationalOpExpr:
+		instrumentExpr(x.Lhs, id, lines)
+		instrumentExpr(x.Rhs, id, lines)
+	case *ast.StringConcatOpExpr:
+		instrumentExpr(x.Lhs, id, lines)
+		instrumentExpr(x.Rhs, id, lines)
+	case *ast.ArithmeticOpExpr:
+		instrumentExpr(x.Lhs, id, lines)
+		instrumentExpr(x.Rhs, id, lines)
+	case *ast.UnaryMinusOpExpr:
+		instrumentExpr(x.Expr, id, lines)
+	case *ast.UnaryNotOpExpr:
+		instrumentExpr(x.Expr, id, lines)
+	case *ast.UnaryLenOpExpr:
+		instrumentExpr(x.Expr, id, lines)
+	}
+}
+
+// Number of frames on the Lua stack.
+func luaDebugDepth(l *lua.LState) (n int) {
+	for {
+		if _, ok := l.GetStack(n); !ok {
+			return
+		}
+		n++
+	}
+}
+
+// Called before each instrumented statement.
+func (d *LuaDebugger) hook(l *lua.LState) int {
+	if d.evaluating {
+		return 0
+	}
+	id, line := int(l.CheckNumber(1)), int(l.CheckNumber(2))
+	d.mu.Lock()
+	bp := d.files[id].bps[line]
+	pause := d.pause
+	d.mu.Unlock()
+	reason := ""
+	switch {
+	case pause:
+		reason = "pause"
+	case d.step == LS_In:
+		reason = "step"
+	case d.step == LS_Over && luaDebugDepth(l) <= d.stepDepth,
+		d.step == LS_Out && luaDebugDepth(l) < d.stepDepth:
+		reason = "step"
+	}
+	if reason == "" && bp != nil {
+		if bp.condition == "" {
+			reason = "breakpoint"
+		} else if v, err := d.eval(l, 1, bp.condition); err != nil {
+			d.output("stderr", fmt.Sprintf("Breakpoint condition %v: %v\n", bp.condition, err))
+		} else if lua.LVAsBool(v) {
+			reason = "breakpoint"
+		}
+	}
+	if reason != "" {
+		d.step = LS_None
+		d.stop(l, reason)
+	}
+	return 0
+}
+
+// Blocks the main thread until the client resumes.
+func (d *LuaDebugger) stop(l *lua.LState, reason string) {
+	d.mu.Lock()
+	d.paused, d.pause = true, false
+	d.mu.Unlock()
+	d.event("stopped", map[string]interface{}{"reason": reason, "threadId": 1,
+		"allThreadsStopped": true})
+	for req := range d.vmReqs {
+		if req == nil || d.handlePaused(l, req) {
+			break
+		}
+	}
+	d.mu.Lock()
+	d.paused = false
+	d.mu.Unlock()
+	// Requests sent before the client noticed the resume
+	for drained := false; !drained; {
+		select {
+		case req := <-d.vmReqs:
+			if req != nil {
+				d.respond(req, false, "The scripts are running", nil)
+			}
+		default:
+			drained = true
+		}
+	}
+	d.refs = d.refs[:0]
+}
+
+// Evaluates an expression, or runs a statement, with the locals and
+// upvalues of a stack level visible.
+func (d *LuaDebugger) eval(l *lua.LState, level int, expr string) (lua.LValue, error) {
+	dbg, ok := l.GetStack(level)
+	if !ok {
+		return lua.LNil, Error("Invalid stack frame")
+	}
+	env := l.NewTable()
+	mt := l.NewTable()
+	mt.RawSetString("__index", l.Get(lua.GlobalsIndex))
+	mt.RawSetString("__newindex", l.Get(lua.GlobalsIndex))
+	l.SetMetatable(env, mt)
+	if fn, err := l.GetInfo("f", dbg, lua.LNil); err == nil {
+		if f, ok := fn.(*lua.LFunction); ok {
+			for i := 1; ; i++ {
+				name, v := l.GetUpvalue(f, i)
+				if name == "" {
+					break
+				}
+				env.RawSetString(name, v)
+			}
+		}
+	}
+	for i := 1; ; i++ {
+		name, v := l.GetLocal(dbg, i)
+		if name == "" {
+			break
+		}
+		if name[0] != '(' {
+			env.RawSetString(name, v)
+		}
+	}
+	fn, err := l.LoadString("return " + expr)
+	if err != nil {
+		if fn, err = l.LoadString(expr); err != nil {
+			return lua.LNil, err
+		}
+	}
+	l.SetFEnv(fn, env)
+	d.evaluating = true
+	defer func() { d.evaluating = false }()
+	top := l.GetTop()
+	defer l.SetTop(top)
+	l.Push(fn)
+	if err := l.PCall(0, 1, nil); err != nil {
+		return lua.LNil, err
+	}
+	return l.Get(-1), nil
+}
+
+func (d *LuaDebugger) newRef(r luaVarRef) int {
+	d.refs = append(d.refs, r)
+	return len(d.refs)
+}
+
+func (d *LuaDebugger) variable(name string, v lua.LValue) map[string]interface{} {
+	value := v.String()
+	ref := 0
+	switch t := v.(type) {
+	case lua.LString:
+		value = strconv.Quote(string(t))
+	case *lua.LTable:
+		value = fmt.Sprintf("%v [%v]", value, t.Len())
+		ref = d.newRef(luaVarRef{kind: LR_Table, table: t})
+	}
+	return map[string]interface{}{"name": name, "value": value,
+		"type": v.Type().String(), "variablesReference": ref}
+}
+
+func luaDebugSource(file string) map[string]interface{} {
+	abs, _ := filepath.Abs(file)
+	return map[string]interface{}{"name": filepath.Base(file), "path": abs}
+}
+
+// Handles a request that needs the Lua state. Returns true to resume.
+func (d *LuaDebugger) handlePaused(l *lua.LState, req *dapRequest) bool {
+	var args struct {
+		FrameId            int
+		VariablesReference int
+		Expression         string
+	}
+	json.Unmarshal(req.Arguments, &args)
+	switch req.Command {
+	case "continue":
+		d.respond(req, true, "", map[string]interface{}{"allThreadsContinued": true})
+		return true
+	case "next", "stepIn", "stepOut":
+		d.step = map[string]luaStepMode{"next": LS_Over, "stepIn": LS_In,
+			"stepOut": LS_Out}[req.Command]
+		d.stepDepth = luaDebugDepth(l)
+		d.respond(req, true, "", nil)
+		return true
+	case "stackTrace":
+		var frames []map[string]interface{}
+		// Level 0 is the hook
+		for level := 1; ; level++ {
+			dbg, ok := l.GetStack(level)
+			if !ok {
+				break
+			}
+			l.GetInfo("Sln", dbg, lua.LNil)
+			f := map[string]interface{}{"id": level, "name": dbg.Name,
+				"line": dbg.CurrentLine, "column": 1}
+			switch {
+			case dbg.What == "G":
+				f["name"] = "[Go] " + dbg.Name
+				f["line"] = 0
+				f["presentationHint"] = "subtle"
+			case dbg.What == "main":
+				f["name"] = "main chunk"
+				fallthrough
+			default:
+				if f["name"] == "" {
+					f["name"] = "?"
+				}
+				// Chunks loaded from strings have no file
+				if !strings.HasPrefix(dbg.Source, "<") {
+					f["source"] = luaDebugSource(dbg.Source)
+				}
+			}
+			frames = append(frames, f)
+		}
+		d.respond(req, true, "", map[string]interface{}{"stackFrames": frames,
+			"totalFrames": len(frames)})
+	case "scopes":
+		scopes := []map[string]interface{}{
+			{"name": "Locals", "presentationHint": "locals",
+				"variablesReference": d.newRef(luaVarRef{kind: LR_Locals, level: args.FrameId})},
+			{"name": "Upvalues",
+				"variablesReference": d.newRef(luaVarRef{kind: LR_Upvalues, level: args.FrameId})},
+			{"name": "Globals", "expensive": true,
+				"variablesReference": d.newRef(luaVarRef{kind: LR_Table,
+					table: l.Get(lua.GlobalsIndex).(*lua.LTable)})},
+		}
+		d.respond(req, true, "", map[string]interface{}{"scopes": scopes})
+	case "variables":
+		if args.VariablesReference < 1 || args.VariablesReference > len(d.refs) {
+			d.respond(req, false, "Invalid variables reference", nil)
+			break
+		}
+		r := d.refs[args.VariablesReference-1]
+		vars := []map[string]interface{}{}
+		switch r.kind {
+		case LR_Locals:
+			if dbg, ok := l.GetStack(r.level); ok {
+				for i := 1; ; i++ {
+					name, v := l.GetLocal(dbg, i)
+					if name == "" {
+						break
+					}
+					if name[0] != '(' {
+						vars = append(vars, d.variable(name, v))
+					}
+				}
+			}
+		case LR_Upvalues:
+			if dbg, ok := l.GetStack(r.level); ok {
+				if fn, err := l.GetInfo("f", dbg, lua.LNil); err == nil {
+					if f, ok := fn.(*lua.LFunction); ok {
+						for i := 1; ; i++ {
+							name, v := l.GetUpvalue(f, i)
+							if name == "" {
+								break
+							}
+							vars = append(vars, d.variable(name, v))
+						}
+					}
+				}
+			}
+		case LR_Table:
+			type kv struct {
+				k, v lua.LValue
+			}
+			var entries []kv
+			r.table.ForEach(func(k, v lua.LValue) {
+				entries = append(entries, kv{k, v})
+			})
+			// Array part first, then the other keys by name
+			sort.Slice(entries, func(i, j int) bool {
+				ni, iok := entries[i].k.(lua.LNumber)
+				nj, jok := entries[j].k.(lua.LNumber)
+				if iok != jok {
+					return iok
+				}
+				if iok {
+					return ni < nj
+				}
+				return entries[i].k.String() < entries[j].k.String()
+			})
+			for _, e := range entries {
+				name := e.k.String()
+				if _, ok := e.k.(lua.LString); !ok {
+					name = "[" + name + "]"
+				}
+				vars = append(vars, d.variable(name, e.v))
+			}
+		}
+		d.respond(req, true, "", map[string]interface{}{"variables": vars})
+	case "evaluate":
+		level := args.FrameId
+		if level < 1 {
+			level = 1
+		}
+		v, err := d.eval(l, level, args.Expression)
+		if err != nil {
+			d.respond(req, false, err.Error(), nil)
+			break
+		}
+		res := d.variable("", v)
+		d.respond(req, true, "", map[string]interface{}{"result": res["value"],
+			"type": res["type"], "variablesReference": res["variablesReference"]})
+	}
+	return false
+}
+
+// Moves a breakpoint to the next line with a statement. Call with mu locked.
+func (d *LuaDebugger) setBreakpoints(file string, bps []luaBreakpoint) []map[string]interface{} {
+	f := d.files[d.fileId(file)]
+	if f.lines == nil {
+		f.lines = make(map[int]bool)
+		if chunk, err := luaDebugParse(f.path); err == nil {
+			instrumentBlock(chunk, -1, f.lines)
+		}
+	}
+	last := 0
+	for l := range f.lines {
+		if l > last {
+			last = l
+		}
+	}
+	f.bps = make(map[int]*luaBreakpoint)
+	res := []map[string]interface{}{}
+	for _, bp := range bps {
+		line := bp.line
+		for line <= last && !f.lines[line] {
+			line++
+		}
+		if line > last {
+			res = append(res, map[string]interface{}{"verified": false, "line": bp.line,
+				"message": "No statement on this line"})
+			continue
+		}
+		f.bps[line] = &luaBreakpoint{line: line, condition: bp.condition}
+		res = append(res, map[string]interface{}{"verified": true, "line": line})
+	}
+	return res
+}
+
+func readDapMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[len("content-length:"):]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, Error("Missing Content-Length header")
+	}
+	b := make([]byte, length)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
+
+func (d *LuaDebugger) send(msg map[string]interface{}) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if d.w == nil {
+		return
+	}
+	d.seq++
+	msg["seq"] = d.seq
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(d.w, "Content-Length: %v\r\n\r\n%s", len(b), b)
+}
+
+func (d *LuaDebugger) respond(req *dapRequest, success bool, message string, body interface{}) {
+	msg := map[string]interface{}{"type": "response", "request_seq": req.Seq,
+		"command": req.Command, "success": success}
+	if message != "" {
+		msg["message"] = message
+	}
+	if body != nil {
+		msg["body"] = body
+	}
+	d.send(msg)
+}
+
+func (d *LuaDebugger) event(name string, body interface{}) {
+	msg := map[string]interface{}{"type": "event", "event": name}
+	if body != nil {
+		msg["body"] = body
+	}
+	d.send(msg)
+}
+
+// Sends text to the debug console of the client, if one is connected.
+func (d *LuaDebugger) output(category, text string) {
+	if d.enabled {
+		d.event("output", map[string]interface{}{"category": category, "output": text})
+	}
+}
+
+// Serves one client session.
+func (d *LuaDebugger) serve(r io.Reader, w io.Writer) {
+	d.wmu.Lock()
+	d.w = w
+	d.wmu.Unlock()
+	br := bufio.NewReader(r)
+	for {
+		b, err := readDapMessage(br)
+		if err != nil {
+			break
+		}
+		var req dapRequest
+		if err := json.Unmarshal(b, &req); err != nil {
+			continue
+		}
+		if !d.handle(&req) {
+			break
+		}
+	}
+	// The client is gone: forget its breakpoints and let the scripts run
+	d.mu.Lock()
+	for _, f := range d.files {
+		f.bps = make(map[int]*luaBreakpoint)
+	}
+	d.pause, d.entry = false, false
+	if d.paused {
+		d.vmReqs <- nil
+	}
+	d.mu.Unlock()
+	d.once.Do(func() { close(d.ready) })
+	d.wmu.Lock()
+	d.w = nil
+	d.wmu.Unlock()
+}
+
+// Handles a request on the session goroutine. Returns false when the
+// session ends.
+func (d *LuaDebugger) handle(req *dapRequest) bool {
+	switch req.Command {
+	case "initialize":
+		d.respond(req, true, "", map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+		})
+		d.event("initialized", nil)
+	case "launch", "attach":
+		var args struct{ StopOnEntry bool }
+		json.Unmarshal(req.Arguments, &args)
+		d.mu.Lock()
+		d.entry = args.StopOnEntry
+		d.mu.Unlock()
+		d.respond(req, true, "", nil)
+	case "setBreakpoints":
+		var args struct {
+			Source      struct{ Path string }
+			Breakpoints []struct {
+				Line      int
+				Condition string
+			}
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil || args.Source.Path == "" {
+			d.respond(req, false, "Invalid arguments", nil)
+			break
+		}
+		bps := make([]luaBreakpoint, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			bps[i] = luaBreakpoint{line: bp.Line, condition: bp.Condition}
+		}
+		d.mu.Lock()
+		res := d.setBreakpoints(args.Source.Path, bps)
+		d.mu.Unlock()
+		d.respond(req, true, "", map[string]interface{}{"breakpoints": res})
+	case "setExceptionBreakpoints":
+		d.respond(req, true, "", nil)
+	case "configurationDone":
+		d.mu.Lock()
+		d.pause = d.pause || d.entry
+		d.mu.Unlock()
+		d.respond(req, true, "", nil)
+		d.once.Do(func() { close(d.ready) })
+	case "threads":
+		d.respond(req, true, "", map[string]interface{}{"threads": []map[string]interface{}{
+			{"id": 1, "name": "Lua"}}})
+	case "pause":
+		d.mu.Lock()
+		d.pause = true
+		d.mu.Unlock()
+		d.respond(req, true, "", nil)
+	case "disconnect", "terminate":
+		d.respond(req, true, "", nil)
+		return false
+	case "continue", "next", "stepIn", "stepOut", "stackTrace", "scopes",
+		"variables", "evaluate":
+		d.mu.Lock()
+		if d.paused {
+			d.vmReqs <- req
+		} else if req.Command == "continue" {
+			d.respond(req, true, "", map[string]interface{}{"allThreadsContinued": true})
+		} else {
+			d.respond(req, false, "The scripts are running", nil)
+		}
+		d.mu.Unlock()
+	default:
+		d.respond(req, false, "Unsupported request: "+req.Command, nil)
+	}
+	return true
+}
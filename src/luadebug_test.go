@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+func TestLuaDebugInstrument(t *testing.T) {
+	// A break or return ends its block, and the condition of repeat sees
+	// the locals of the block, so the hooks must leave both in place
+	src := strings.Join([]string{
+		"local function count(n)", // 1
+		"	local t = 0",
+		"	for i = 1, n do",
+		"		t = t + i",
+		"	end", // 5
+		"	return t",
+		"end",
+		"local i = 0",
+		"while true do",
+		"	i = i + 1", // 10
+		"	if i >= 3 then",
+		"		break",
+		"	end",
+		"end",
+		"local r = 0", // 15
+		"repeat",
+		"	local x = r + 1",
+		"	r = x",
+		"until x >= 2",
+		"local f = {g = function() return count(3) end}", // 20
+		"return {f.g(), i, r}",
+	}, "\n")
+	chunk, err := parse.Parse(strings.NewReader(src), "test.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(map[int]bool)
+	chunk = instrumentBlock(chunk, 7, lines)
+	want := map[int]bool{1: true, 2: true, 3: true, 4: true, 6: true, 8: true, 9: true,
+		10: true, 11: true, 12: true, 15: true, 16: true, 17: true, 18: true, 20: true, 21: true}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("statement lines %v, want %v", lines, want)
+	}
+	proto, err := lua.Compile(chunk, "test.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := lua.NewState()
+	defer l.Close()
+	var hits []int
+	l.SetGlobal(luaDebugHook, l.NewFunction(func(l *lua.LState) int {
+		if id := l.CheckInt(1); id != 7 {
+			t.Errorf("hook called with file id %v, want 7", id)
+		}
+		hits = append(hits, l.CheckInt(2))
+		return 0
+	}))
+	l.Push(l.NewFunctionFromProto(proto))
+	if err := l.PCall(0, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	res, ok := l.Get(-1).(*lua.LTable)
+	if !ok {
+		t.Fatalf("returned %v, want a table", l.Get(-1))
+	}
+	var got []int
+	for i := 1; i <= res.Len(); i++ {
+		got = append(got, int(lua.LVAsNumber(res.RawGetInt(i))))
+	}
+	if !reflect.DeepEqual(got, []int{6, 3, 2}) {
+		t.Errorf("returned %v, want [6 3 2]", got)
+	}
+	wantHits := []int{1, 8, 9, 10, 11, 10, 11, 10, 11, 12, 15, 16, 17, 18, 17, 18,
+		20, 21, 20, 2, 3, 4, 4, 4, 6}
+	if !reflect.DeepEqual(hits, wantHits) {
+		t.Errorf("hooks ran on lines %v, want %v", hits, wantHits)
+	}
+}
+
+func TestLuaDebugBreakpoints(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.lua")
+	src := "-- comment\n\nlocal a = 1\nif a then\n\ta = 2\nend\n\nprint(a)\n-- end\n"
+	if err := os.WriteFile(file, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &LuaDebugger{fileIds: make(map[string]int)}
+	// Breakpoints on lines without a statement move to the next one, or
+	// are rejected after the last statement
+	res := d.setBreakpoints(file, []luaBreakpoint{{1, ""}, {4, "a == 1"},
+		{6, ""}, {8, ""}, {9, ""}})
+	want := []map[string]interface{}{
+		{"verified": true, "line": 3},
+		{"verified": true, "line": 4},
+		{"verified": true, "line": 8},
+		{"verified": true, "line": 8},
+		{"verified": false, "line": 9, "message": "No statement on this line"},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("got %v, want %v", res, want)
+	}
+	bps := d.files[d.fileId(file)].bps
+	if len(bps) != 3 || bps[3] == nil || bps[4] == nil || bps[4].condition != "a == 1" ||
+		bps[8] == nil || bps[8].condition != "" {
+		t.Errorf("breakpoints %v", bps)
+	}
+	// Setting the breakpoints of a file again replaces them
+	d.setBreakpoints(file, []luaBreakpoint{{5, ""}})
+	if bps := d.files[d.fileId(file)].bps; len(bps) != 1 || bps[5] == nil {
+		t.Errorf("breakpoints %v after replacing them", bps)
+	}
+}
+
+func TestReadDapMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("Content-Length: 7\r\n\r\n{\"a\":1}" +
+		"content-length:2\r\nContent-Type: application/json\r\n\r\n{}"))
+	for _, want := range []string{`{"a":1}`, "{}"} {
+		if b, err := readDapMessage(r); err != nil || string(b) != want {
+			t.Errorf("read %q (%v), want %q", b, err, want)
+		}
+	}
+	for _, in := range []string{
+		"Content-Type: text\r\n\r\n{}",
+		"Content-Length: x\r\n\r\n{}",
+		"Content-Length: 10\r\n\r\n{}",
+		"Content-Length: 2\r\n",
+	} {
+		if b, err := readDapMessage(bufio.NewReader(strings.NewReader(in))); err == nil {
+			t.Errorf("%q: read %q, want an error", in, b)
+		}
+	}
+}
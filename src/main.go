@@ -144,6 +144,7 @@ func init() {
 // Checks if error is not null, if there is an error it displays a error dialogue box and crashes the program.
 func chk(err error) {
 	if err != nil {
+		stateTrace.crashDump()
 		ShowErrorDialog(err.Error())
 		panic(err)
 	}
@@ -477,6 +478,17 @@ func main() {
 		}
 	}
 
+	// Scenario tests run as a quick match set up by the scenario file
+	if path, ok := sys.cmdFlags["-scenario"]; ok {
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			os.Exit(runScenarioDir(path))
+		}
+		if err := scenario.Load(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Make save directories, if they don't exist
 	os.Mkdir("save", os.ModeSticky|0755)
 	os.Mkdir("save/replays", os.ModeSticky|0755)
@@ -509,12 +521,34 @@ func main() {
 	}
 
 	// Initialize game and create window
+	sys.headless = headless && scenario.active
 	sys.luaLState = sys.init(tmp.GameWidth, tmp.GameHeight)
 	defer sys.shutdown()
+	defer func() {
+		if r := recover(); r != nil {
+			stateTrace.crashDump()
+			panic(r)
+		}
+	}()
+
+	// Headless builds run scenarios without the window and the Lua scripts
+	if sys.headless {
+		code := scenario.Run(&tmp)
+		sys.shutdown()
+		os.Exit(code)
+	}
+
+	// Attach the Lua debugger before running any script
+	if addr, ok := sys.cmdFlags["-luadebug"]; ok {
+		if err := luaDebugger.Start(sys.luaLState, addr); err != nil {
+			fmt.Printf("Failed to start the Lua debugger: %v\n", err)
+		}
+	}
 
 	// Begin processing game using its lua scripts
 	fmt.Printf("[main.go][main]: Running in lua script=[%v] using motif=[%v]\n", tmp.System, tmp.Motif)
-	if err := sys.luaLState.DoFile(tmp.System); err != nil {
+	if err := luaDebugger.DoFile(sys.luaLState, tmp.System); err != nil {
+		luaDebugger.output("stderr", err.Error()+"\n")
 		// Display error logs.
 		errorLog := createLog("Ikemen.log")
 		defer closeLog(errorLog)
@@ -531,6 +565,13 @@ func main() {
 			panic(err)
 		}
 	}
+	if _, ok := sys.cmdFlags["-profile"]; ok {
+		if file, err := profiler.Report(""); err != nil {
+			fmt.Printf("Failed to write the profiler report: %v\n", err)
+		} else {
+			fmt.Printf("Profiler report saved to %v\n", file)
+		}
+	}
 	// fmt.Printf("[main.go][setupConfig] Joystick Setting Updated from options.lua\n")
 	// for _, jc := range tmp.JoystickConfig {
 	// 	fmt.Printf("sys.joystickConfig=%v [%v]\n", jc.Joystick, jc.Buttons)
@@ -575,19 +616,42 @@ Extra Feature (by leonkasovan):
 -updatechar             Add new characters from [chars] directory into select.def
 -updatestage            Add new stages from [stages] directory into select.def
 -install                Install default screenpack and Ikemen's assets
+-packlist               Lists the installed content packs
+-packinstall <archive>  Installs a content pack from a .zip archive
+-packenable <name>      Enables a content pack and adds its entries to select.def
+-packdisable <name>     Disables a content pack and removes its entries from select.def
+-packuninstall <name>   Disables and deletes a content pack
+-sffexport <sff>        Exports the sprites of <sff> to PNG files, palettes and a manifest
+-sffbuild <folder>      Packs an exported sprite folder into an SFF v2.01 file
+-sffformat <format>     Sprite format used by -sffbuild: lz5, rle8, rle5, raw or png8
+-out <path>             Output of -sffexport and -sffbuild
+-langreport             Lists the string table keys missing from each language
 -audit                  Verify (and fix) integrity of assets included in definition files
 
 Debug Options:
 -nojoy                  Disables joysticks
 -nomusic                Disables music
 -nosound                Disables all sound effects and music
+-audioout <output>      Sends audio to "speaker", "null" or a .wav file (e.g. -audioout test.wav)
 -windowed               Windowed mode (disables fullscreen)
 -togglelifebars         Disables display of the Life and Power bars
 -maxpowermode           Enables auto-refill of Power bars
 -ailevel <level>        Changes game difficulty setting to <level> (1-8)
 -speed <speed>          Changes game speed setting to <speed> (10%%-200%%)
 -stresstest <frameskip> Stability test (AI matches at speed increased by <frameskip>)
--speedtest              Speed test (match speed x100)`
+-speedtest              Speed test (match speed x100)
+-render <replay>        Renders <replay> offline to <replay>.y4m and <replay>.wav, then quits
+-luadebug <port>        Waits for a DAP debugger client on localhost:<port> (4711 if empty)
+                        or on stdin/stdout if <port> is "stdio", then debugs the Lua scripts
+-profile                Enables the CPU profiler and writes a report to save/profiles on exit
+-hotreload              Reloads the characters, stage and lifebar of a match when their files change
+-trace                  Records a state transition trace, saved to save/traces on crash
+-traceview <file>       Prints a state trace file, filtered by -traceid and -tracestate
+-traceid <id>           Only shows the trace events of entity <id>
+-tracestate <no>        Only shows the trace events involving state <no>
+-scenario <file>        Runs a scenario test and quits with status 1 if it fails. A folder
+                        runs each of its .scenario files. Headless builds run it without a
+                        window, other builds in a hidden window that still needs a display`
 				//ShowInfoDialog(text, "I.K.E.M.E.N Command line options")
 				fmt.Printf("I.K.E.M.E.N Command line options\n\n" + text + "\nPress ENTER to exit")
 				var s string
@@ -618,7 +682,9 @@ type configSettings struct {
 	AIRamping                     bool
 	AIRandomColor                 bool
 	AISurvivalColor               bool
+	AudioBuses                    map[string]AudioBusSettings
 	AudioDucking                  bool
+	AudioOutput                   string
 	AudioSampleRate               int32
 	AutoGuard                     bool
 	BarGuard                      bool
@@ -645,6 +711,7 @@ type configSettings struct {
 	EscOpensMenu                  bool
 	ExternalShaders               []string
 	FirstRun                      bool
+	FontFallback                  []string
 	FontShaderVer                 uint
 	ForceStageZoomin              float32
 	ForceStageZoomout             float32
@@ -663,6 +730,7 @@ type configSettings struct {
 	KeepAspect                    bool
 	WindowScaleMode               bool
 	Language                      string
+	LanguageFallback              string
 	LifeMul                       float32
 	ListenPort                    string
 	LoseSimul                     bool
@@ -675,6 +743,7 @@ type configSettings struct {
 	MaxPlayerProjectile           int
 	Modules                       []string
 	Motif                         string
+	Mounts                        []MountSettings
 	MSAA                          int32
 	NumSimul                      [2]int
 	NumTag                        [2]int
@@ -683,6 +752,9 @@ type configSettings struct {
 	PauseMasterVolume             int
 	Players                       int
 	PngSpriteFilter               bool
+	PostProcessingParams          map[string]float32
+	PostProcessingPreset          string
+	PostProcessingPresets         map[string][]string
 	PostProcessingShader          int32
 	QuickContinue                 bool
 	RatioAttack                   [4]float32
@@ -694,7 +766,11 @@ type configSettings struct {
 	RoundsNumTag                  int32
 	RoundTime                     int32
 	ScreenshotFolder              string
+	SffCacheSize                  int32
+	SpriteAtlas                   bool
+	SpriteBatching                bool
 	StartStage                    string
+	StateTrace                    bool
 	StereoEffects                 bool
 	System                        string
 	Team1VS2Life                  float32
@@ -871,12 +947,19 @@ func setupConfig(is_mugen_game bool) configSettings {
 		var h, _ = strconv.ParseInt(sys.cmdFlags["-height"], 10, 32)
 		tmp.GameHeight = int32(h)
 	}
+	if out, ok := sys.cmdFlags["-audioout"]; ok {
+		tmp.AudioOutput = out
+	} else if _, ok := sys.cmdFlags["-nosound"]; ok {
+		tmp.AudioOutput = "null"
+	}
 
 	// Set each config property to the system object
 	sys.afterImageMax = tmp.MaxAfterImage
 	sys.allowDebugKeys = tmp.DebugKeys
 	sys.allowDebugMode = tmp.DebugMode
+	sys.audioBusSettings = tmp.AudioBuses
 	sys.audioDucking = tmp.AudioDucking
+	sys.audioOutputName = tmp.AudioOutput
 	sys.audioSampleRate = tmp.AudioSampleRate
 	sys.bgmVolume = tmp.VolumeBgm
 	sys.maxBgmVolume = tmp.MaxBgmVolume
@@ -904,6 +987,7 @@ func setupConfig(is_mugen_game bool) configSettings {
 		tmp.FontShaderVer = max(150, tmp.FontShaderVer)
 	}
 	sys.fontShaderVer = tmp.FontShaderVer
+	sys.fontFallback = tmp.FontFallback
 	// Resoluion stuff
 	sys.fullscreen = tmp.Fullscreen
 	sys.fullscreenRefreshRate = tmp.FullscreenRefreshRate
@@ -919,6 +1003,7 @@ func setupConfig(is_mugen_game bool) configSettings {
 	sys.inputButtonAssist = tmp.InputButtonAssist
 	sys.inputSOCDresolution = Clamp(tmp.InputSOCDResolution, 0, 4)
 	sys.language = tmp.Language
+	sys.languageFallback = tmp.LanguageFallback
 	sys.lifeMul = tmp.LifeMul / 100
 	sys.lifeShare = [...]bool{tmp.TeamLifeShare, tmp.TeamLifeShare}
 	sys.listenPort = tmp.ListenPort
@@ -932,6 +1017,9 @@ func setupConfig(is_mugen_game bool) configSettings {
 	sys.pauseMasterVolume = tmp.PauseMasterVolume
 	sys.panningRange = tmp.PanningRange
 	sys.playerProjectileMax = tmp.MaxPlayerProjectile
+	sys.postProcessingParams = tmp.PostProcessingParams
+	sys.postProcessingPreset = tmp.PostProcessingPreset
+	sys.postProcessingPresets = tmp.PostProcessingPresets
 	sys.postProcessingShader = tmp.PostProcessingShader
 	sys.pngFilter = tmp.PngSpriteFilter
 	sys.powerShare = [...]bool{tmp.TeamPowerShare, tmp.TeamPowerShare}
@@ -943,7 +1031,11 @@ func setupConfig(is_mugen_game bool) configSettings {
 	} else {
 		sys.screenshotFolder = tmp.ScreenshotFolder
 	}
+	sys.spriteAtlas = tmp.SpriteAtlas
+	sys.sffCacheSize = int64(tmp.SffCacheSize) << 20
+	sys.spriteBatching = tmp.SpriteBatching
 	sys.stereoEffects = tmp.StereoEffects
+	stateTrace.enabled = tmp.StateTrace
 	sys.team1VS2Life = tmp.Team1VS2Life / 100
 	sys.vRetrace = tmp.VRetrace
 	sys.wavChannels = tmp.WavChannels
@@ -952,6 +1044,15 @@ func setupConfig(is_mugen_game bool) configSettings {
 	sys.windowMainIconLocation = tmp.WindowIcon
 	sys.windowTitle = tmp.WindowTitle
 	sys.xinputTriggerSensitivity = tmp.XinputTriggerSensitivity
+	// Content packs, then mod folders and archives, in increasing order of
+	// precedence
+	packs := loadPackManager()
+	packs.mount()
+	for _, m := range tmp.Mounts {
+		if err := vfs.Mount(m.Path, m.At); err != nil {
+			sys.errLog.Printf("Failed to mount %v: %v", m.Path, err)
+		}
+	}
 	stoki := func(key string) int {
 		return int(StringToKey(key))
 	}
@@ -994,6 +1095,78 @@ func setupConfig(is_mugen_game bool) configSettings {
 		}
 	}
 
+	// Content pack management
+	packCmds := []struct {
+		flag string
+		run  func(string) error
+	}{
+		{"-packlist", func(string) error { packs.List(); return nil }},
+		{"-packinstall", packs.Install},
+		{"-packenable", packs.Enable},
+		{"-packdisable", packs.Disable},
+		{"-packuninstall", packs.Uninstall},
+	}
+	for _, pc := range packCmds {
+		if arg, ok := sys.cmdFlags[pc.flag]; ok {
+			if err := pc.run(arg); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
+	// SFF authoring tools
+	if sff, ok := sys.cmdFlags["-sffexport"]; ok {
+		out := sys.cmdFlags["-out"]
+		if out == "" {
+			out = strings.TrimSuffix(sff, filepath.Ext(sff)) + "_sprites"
+		}
+		if err := exportSff(sff, out); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if dir, ok := sys.cmdFlags["-sffbuild"]; ok {
+		out := sys.cmdFlags["-out"]
+		if out == "" {
+			out = filepath.Clean(dir) + ".sff"
+		}
+		if err := buildSff(dir, out, sys.cmdFlags["-sffformat"]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Profiler
+	if _, ok := sys.cmdFlags["-profile"]; ok {
+		profiler.enabled = true
+	}
+
+	// Hot reload
+	if _, ok := sys.cmdFlags["-hotreload"]; ok {
+		hotReload.enabled = true
+	}
+
+	// State trace
+	if _, ok := sys.cmdFlags["-trace"]; ok {
+		stateTrace.enabled = true
+	}
+	if file, ok := sys.cmdFlags["-traceview"]; ok {
+		if err := viewTrace(file, sys.cmdFlags["-traceid"], sys.cmdFlags["-tracestate"]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		os.Exit(0)
+	}
+
+	// String table report
+	if _, ok := sys.cmdFlags["-langreport"]; ok {
+		lang.Report(filepath.Dir(tmp.Motif))
+		os.Exit(0)
+	}
+
 	if _, ok := sys.cmdFlags["-install"]; ok {
 		fmt.Printf("[main.go][setupConfig] Install default screenpack\n")
 		err := extractEmbed(screenpackZip)
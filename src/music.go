@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"strings"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/effects"
+	"github.com/gopxl/beep/v2/speaker"
+)
+
+// Number of output samples in the given number of frames
+func framesToSamples(frames int32) int {
+	return int(Max(0, frames)) * int(sys.audioSampleRate) / FPS
+}
+
+// ------------------------------------------------------------------
+// musicFade
+
+// Linear gain ramp, advanced once per sample
+type musicFade struct {
+	gain, target, step float64
+}
+
+func (f *musicFade) set(target float64, samples int) {
+	f.target = target
+	if samples <= 0 {
+		f.gain, f.step = target, 0
+	} else {
+		f.step = (target - f.gain) / float64(samples)
+	}
+}
+
+func (f *musicFade) next() float64 {
+	if f.gain != f.target {
+		f.gain += f.step
+		if (f.step > 0 && f.gain > f.target) || (f.step < 0 && f.gain < f.target) || f.step == 0 {
+			f.gain = f.target
+		}
+	}
+	return f.gain
+}
+
+// ------------------------------------------------------------------
+// musicTrack
+
+// musicTrack is one BGM file as sent to the BGM bus, together with its
+// layers. Layers are streamed in the same call as the main track so they
+// stay sample aligned.
+type musicTrack struct {
+	main      *beep.Ctrl
+	layers    []*musicLayer
+	fade      musicFade
+	fadingOut bool
+	ended     bool
+	frames    int32 // Frames since the track started, counted by Bgm.Tick
+	buf       [][2]float64
+}
+
+func newMusicTrack(main *beep.Ctrl, fadeIn int32) *musicTrack {
+	t := &musicTrack{main: main}
+	t.fade.set(1, framesToSamples(fadeIn))
+	return t
+}
+
+// Fade out and stop. Must be called with the speaker locked.
+func (t *musicTrack) fadeOut(frames int32) {
+	t.fadingOut = true
+	t.fade.set(0, framesToSamples(frames))
+}
+
+func (t *musicTrack) Stream(samples [][2]float64) (n int, ok bool) {
+	if t.fadingOut && t.fade.gain == 0 {
+		t.ended = true
+		return 0, false
+	}
+	// Keep the layers in place while the main track is paused. A paused
+	// track is already silent, so a fade out ends at once.
+	if t.main.Paused {
+		if t.fadingOut {
+			t.ended = true
+			return 0, false
+		}
+		clear(samples)
+		return len(samples), true
+	}
+	n, ok = t.main.Stream(samples)
+	if !ok {
+		t.ended = true
+	}
+	if len(t.layers) > 0 {
+		if cap(t.buf) < n {
+			t.buf = make([][2]float64, n)
+		}
+		buf := t.buf[:n]
+		for _, l := range t.layers {
+			clear(buf)
+			ln, _ := l.resampler.Stream(buf)
+			for i := range buf[:ln] {
+				g := l.fade.next()
+				samples[i][0] += buf[i][0] * g
+				samples[i][1] += buf[i][1] * g
+			}
+		}
+	}
+	for i := range samples[:n] {
+		g := t.fade.next()
+		samples[i][0] *= g
+		samples[i][1] *= g
+	}
+	return n, ok
+}
+
+func (t *musicTrack) Err() error {
+	return nil
+}
+
+// ------------------------------------------------------------------
+// musicLayer
+
+// MusicLayerDef describes a layer (stem) played in sync with a BGM track.
+// With a trigger, the layer fades between 0 and its volume as the trigger
+// condition changes:
+//
+//	lowlife     A team is at or below the stage bgmratio.life
+//	round       From round Round on
+//	finalround  During the final round
+//	superpause  During a super pause
+//	pause       During a pause
+//	always      Always at full volume
+//
+// Layers without a trigger are only changed from Lua and the BGMLayer state
+// controller.
+type MusicLayerDef struct {
+	File    string
+	Volume  int32 // Percent
+	Trigger string
+	Round   int32
+	Fade    int32 // Frames
+}
+
+type musicLayer struct {
+	def       MusicLayerDef
+	streamer  beep.StreamSeeker
+	looper    *StreamLooper
+	volctrl   *effects.Volume
+	resampler *beep.Resampler
+	fade      musicFade
+	volume    int32 // Current target volume in percent
+}
+
+// Whether the trigger condition of the layer holds
+func (l *musicLayer) triggered() bool {
+	switch l.def.Trigger {
+	case "lowlife":
+		return sys.stage != nil && teamLowLife(sys.stage.bgmratiolife)
+	case "round":
+		return sys.round >= l.def.Round
+	case "finalround":
+		return sys.roundType[0] == RT_Final
+	case "superpause":
+		return sys.super > 0
+	case "pause":
+		return sys.pause > 0
+	}
+	return true
+}
+
+// Whether every member of a team is at or below the life ratio, in percent
+func teamLowLife(ratio int32) bool {
+	for side := 0; side < 2; side++ {
+		low, found := true, false
+		for i := side; i < MaxSimul*2 && i < len(sys.chars); i += 2 {
+			if len(sys.chars[i]) == 0 {
+				continue
+			}
+			c := sys.chars[i][0]
+			found = true
+			if c.lifeMax > 0 && c.life*100 > c.lifeMax*ratio {
+				low = false
+				break
+			}
+		}
+		if found && low {
+			return true
+		}
+	}
+	return false
+}
+
+// Layers of the current track
+func (bgm *Bgm) layers() []*musicLayer {
+	if bgm.track == nil {
+		return nil
+	}
+	return bgm.track.layers
+}
+
+// Add a layer to the current track, starting at the same position. The
+// layer file must have the same sample rate as the track.
+func (bgm *Bgm) AddLayer(def MusicLayerDef) (int, error) {
+	if bgm.track == nil {
+		return -1, Error("no BGM is playing")
+	}
+	sl, ok := bgm.volctrl.Streamer.(*StreamLooper)
+	if !ok {
+		return -1, Error("no BGM is playing")
+	}
+	f, err := vfs.Open(def.File)
+	if err != nil {
+		return -1, err
+	}
+	st, format, _, err := decodeMusic(f, def.File)
+	if err != nil {
+		f.Close()
+		return -1, err
+	}
+	if format.SampleRate != bgm.sampleRate {
+		f.Close()
+		return -1, Error(fmt.Sprintf("%v: sample rate %v differs from the BGM (%v)",
+			def.File, format.SampleRate, bgm.sampleRate))
+	}
+	def.Trigger = strings.ToLower(def.Trigger)
+	l := &musicLayer{def: def, streamer: st, volume: def.Volume}
+	l.looper = newStreamLooper(st, -1, sl.loopstart, sl.loopend).(*StreamLooper)
+	l.looper.loopcount = sl.loopcount
+	l.volctrl = &effects.Volume{Streamer: l.looper, Base: 2,
+		Volume: bgm.volctrl.Volume, Silent: bgm.volctrl.Silent}
+	dstFreq := beep.SampleRate(float32(sys.audioSampleRate) / bgm.freqmul)
+	l.resampler = beep.Resample(audioResampleQuality, bgm.sampleRate, dstFreq, l.volctrl)
+	if def.Trigger != "" && !l.triggered() {
+		l.volume = 0
+	}
+	l.fade.set(float64(l.volume)/100, 0)
+
+	speaker.Lock()
+	st.Seek(bgm.streamer.Position())
+	bgm.track.layers = append(bgm.track.layers, l)
+	speaker.Unlock()
+	return len(bgm.track.layers) - 1, nil
+}
+
+// Fade a layer to a volume in percent over the given number of frames
+func (bgm *Bgm) SetLayerVolume(index int, volume, frames int32) bool {
+	layers := bgm.layers()
+	if index < 0 || index >= len(layers) {
+		return false
+	}
+	l := layers[index]
+	l.volume = volume
+	speaker.Lock()
+	l.fade.set(float64(volume)/100, framesToSamples(frames))
+	speaker.Unlock()
+	return true
+}
+
+// Add the layers defined by the stage, if the track is the stage music
+func (bgm *Bgm) addStageLayers() {
+	if sys.stage == nil || len(sys.stage.bgmlayers) == 0 ||
+		!strings.EqualFold(filepath.Base(bgm.filename), filepath.Base(sys.stage.bgmusic)) {
+		return
+	}
+	for _, def := range sys.stage.bgmlayers {
+		if _, err := bgm.AddLayer(def); err != nil {
+			sys.errLog.Printf("Failed to load bgm layer: %v", err)
+		}
+	}
+}
+
+// ------------------------------------------------------------------
+// StageAmbience
+
+// StageAmbience loops the ambience sound of a stage, set with ambience and
+// ambience.volume (percent) in its [Music] section, on the ambience bus for
+// the whole match. Unlike BGM layers it is independent of the music.
+type StageAmbience struct {
+	ctrl *beep.Ctrl
+	file VFile
+}
+
+func (a *StageAmbience) Play(filename string, volume int32) {
+	a.Stop()
+	if filename == "" || volume <= 0 {
+		return
+	}
+	f, err := vfs.Open(filename)
+	if err != nil {
+		sys.errLog.Printf("Failed to open stage ambience: %v", err)
+		return
+	}
+	st, format, _, err := decodeMusic(f, filename)
+	if err != nil {
+		f.Close()
+		sys.errLog.Printf("Failed to load stage ambience: %v", err)
+		return
+	}
+	vol := &effects.Volume{Streamer: newStreamLooper(st, -1, 0, 0), Base: 2,
+		Volume: math.Log2(float64(volume) / 100)}
+	a.ctrl = &beep.Ctrl{Streamer: beep.Resample(audioResampleQuality,
+		format.SampleRate, beep.SampleRate(sys.audioSampleRate), vol)}
+	a.file = f
+	sys.audioBuses[BusAmbience].Add(a.ctrl)
+}
+
+func (a *StageAmbience) Stop() {
+	if a.ctrl == nil {
+		return
+	}
+	// A Ctrl without a streamer drains, so the bus drops it
+	speaker.Lock()
+	a.ctrl.Streamer = nil
+	speaker.Unlock()
+	a.file.Close()
+	a.ctrl, a.file = nil, nil
+}
+
+// ------------------------------------------------------------------
+// MusicPlaylist
+
+// MusicPlaylist plays a list of tracks one after another, in order or
+// shuffled, and starts over at the end of the list.
+type MusicPlaylist struct {
+	tracks    []string
+	shuffle   bool
+	volume    int
+	crossfade int32
+	order     []int
+	pos       int
+}
+
+func (p *MusicPlaylist) next() string {
+	if p.pos >= len(p.order) {
+		last := -1
+		if len(p.order) > 0 {
+			last = p.order[len(p.order)-1]
+		}
+		p.order, p.pos = make([]int, len(p.tracks)), 0
+		for i := range p.order {
+			p.order[i] = i
+		}
+		if p.shuffle {
+			rand.Shuffle(len(p.order), func(i, j int) {
+				p.order[i], p.order[j] = p.order[j], p.order[i]
+			})
+			// Do not play the same track twice in a row
+			if len(p.order) > 1 && p.order[0] == last {
+				p.order[0], p.order[1] = p.order[1], p.order[0]
+			}
+		}
+	}
+	p.pos++
+	return p.tracks[p.order[p.pos-1]]
+}
+
+// Play a list of tracks, crossfading between them over the given number of
+// frames
+func (bgm *Bgm) OpenPlaylist(tracks []string, shuffle bool, volume int, crossfade int32) {
+	if len(tracks) == 0 {
+		bgm.Open("", 1, 100, 0, 0, 0, 1, crossfade)
+		return
+	}
+	bgm.playlist = &MusicPlaylist{tracks: tracks, shuffle: shuffle, volume: volume, crossfade: crossfade}
+	bgm.open(bgm.playlist.next(), 0, volume, 0, 0, 0, 1, crossfade)
+}
+
+// Advance the playlist and update the layers driven by game state. Called
+// once per frame.
+func (bgm *Bgm) Tick() {
+	if bgm.track == nil {
+		return
+	}
+	bgm.track.frames++
+	if p := bgm.playlist; p != nil {
+		speaker.Lock()
+		ended := bgm.track.ended
+		remaining := float64(bgm.streamer.Len()-bgm.streamer.Position()) / float64(bgm.sampleRate)
+		speaker.Unlock()
+		// Start the next track early so the crossfade ends with this one
+		early := p.crossfade > 0 && bgm.track.frames > p.crossfade &&
+			remaining*float64(FPS) <= float64(p.crossfade)
+		if ended || early {
+			bgm.open(p.next(), 0, p.volume, 0, 0, 0, 1, p.crossfade)
+			return
+		}
+	}
+	for i, l := range bgm.track.layers {
+		if l.def.Trigger == "" {
+			continue
+		}
+		volume := int32(0)
+		if l.triggered() {
+			volume = l.def.Volume
+		}
+		if volume != l.volume {
+			bgm.SetLayerVolume(i, volume, l.def.Fade)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ------------------------------------------------------------------
+// PostShader
+
+// A parameter declared in a post-processing shader manifest. It is
+// uploaded to the shader as a float uniform of the same name.
+type PostShaderParam struct {
+	Name    string
+	Label   string
+	Default float32
+	Min     float32
+	Max     float32
+	Step    float32
+}
+
+// PostShader is one external post-processing pass. The shader source is
+// read from <path>.vert and <path>.frag, and an optional <path>.json
+// manifest declares the pass settings and its parameters, e.g.
+//
+//	{
+//	  "Filter": "nearest",
+//	  "Parameters": [
+//	    {"Name": "Strength", "Label": "Scanline strength",
+//	     "Default": 0.5, "Min": 0, "Max": 1, "Step": 0.05}
+//	  ]
+//	}
+type PostShader struct {
+	path       string
+	name       string
+	vert, frag string
+	// Input texture filter, "linear" or "nearest". Follows the window
+	// scale mode if empty.
+	Filter     string
+	Parameters []PostShaderParam
+	values     []float32
+}
+
+func loadPostShader(path string) (*PostShader, error) {
+	path = strings.Replace(path, "\\", "/", -1)
+	ps := &PostShader{path: path, name: path[strings.LastIndex(path, "/")+1:]}
+	// PS: The "\x00" is what is know as Null Terminator.
+	content, err := vfs.ReadFile(path + ".vert")
+	if err != nil {
+		return nil, err
+	}
+	ps.vert = string(content) + "\x00"
+	content, err = vfs.ReadFile(path + ".frag")
+	if err != nil {
+		return nil, err
+	}
+	ps.frag = string(content) + "\x00"
+	if content, err = vfs.ReadFile(path + ".json"); err == nil {
+		if err = json.Unmarshal(content, ps); err != nil {
+			return nil, Error(fmt.Sprintf("%v.json: %v", path, err))
+		}
+	}
+	ps.values = make([]float32, len(ps.Parameters))
+	for i, p := range ps.Parameters {
+		ps.values[i] = p.Default
+	}
+	return ps, nil
+}
+
+// Find a parameter by name, returning -1 if it does not exist
+func (ps *PostShader) paramIndex(name string) int {
+	for i, p := range ps.Parameters {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ps *PostShader) setParam(i int, value float32) {
+	p := &ps.Parameters[i]
+	if p.Min < p.Max {
+		value = ClampF(value, p.Min, p.Max)
+	}
+	ps.values[i] = value
+}
+
+// ------------------------------------------------------------------
+// PostProcessing
+
+// PostProcessing holds every loaded post-processing shader and the chain
+// of passes currently applied to the frame. All shaders are compiled at
+// start up, so switching presets does not require a restart.
+type PostProcessing struct {
+	shaders []*PostShader
+	presets map[string][]int
+	preset  string
+	chain   []int
+}
+
+// Load the shaders listed in ExternalShaders and the ones referenced by
+// presets, then apply the saved parameter values ("<shader>.<param>").
+func newPostProcessing(shaderList []string, presets map[string][]string,
+	params map[string]float32) *PostProcessing {
+	pp := &PostProcessing{presets: make(map[string][]int)}
+	index := make(map[string]int)
+	add := func(path string) int {
+		if i, ok := index[path]; ok {
+			return i
+		}
+		ps, err := loadPostShader(path)
+		chk(err)
+		pp.shaders = append(pp.shaders, ps)
+		index[path] = len(pp.shaders)
+		return len(pp.shaders)
+	}
+	for _, path := range shaderList {
+		add(path)
+	}
+	for name, paths := range presets {
+		chain := make([]int, len(paths))
+		for i, path := range paths {
+			chain[i] = add(path)
+		}
+		pp.presets[name] = chain
+	}
+	for key, value := range params {
+		if dot := strings.LastIndex(key, "."); dot >= 0 {
+			pp.SetParam(key[:dot], key[dot+1:], value)
+		}
+	}
+	return pp
+}
+
+// Shader at a pass index. Index 0 is the built-in identity shader and
+// has no PostShader.
+func (pp *PostProcessing) Shader(idx int) *PostShader {
+	if idx <= 0 || idx > len(pp.shaders) {
+		return nil
+	}
+	return pp.shaders[idx-1]
+}
+
+func (pp *PostProcessing) shaderByName(name string) *PostShader {
+	for _, ps := range pp.shaders {
+		if ps.name == name || ps.path == name {
+			return ps
+		}
+	}
+	return nil
+}
+
+// Presets sorted by name
+func (pp *PostProcessing) Presets() []string {
+	names := make([]string, 0, len(pp.presets))
+	for name := range pp.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Switch to a preset. An empty name goes back to the single shader
+// selected by PostProcessingShader.
+func (pp *PostProcessing) SetPreset(name string) bool {
+	if name == "" {
+		pp.preset, pp.chain = "", nil
+		return true
+	}
+	chain, ok := pp.presets[name]
+	if !ok {
+		return false
+	}
+	pp.preset, pp.chain = name, chain
+	return true
+}
+
+// Pass indexes to run in order. Never empty.
+func (pp *PostProcessing) Passes() []int {
+	if len(pp.chain) > 0 {
+		return pp.chain
+	}
+	if idx := int(sys.postProcessingShader); idx > 0 && idx <= len(pp.shaders) {
+		return []int{idx}
+	}
+	return []int{0}
+}
+
+// Whether the input of a pass is sampled with linear filtering
+func (pp *PostProcessing) Linear(idx int) bool {
+	if ps := pp.Shader(idx); ps != nil && ps.Filter != "" {
+		return strings.ToLower(ps.Filter) != "nearest"
+	}
+	return sys.windowScaleMode
+}
+
+func (pp *PostProcessing) SetParam(shader, name string, value float32) bool {
+	ps := pp.shaderByName(shader)
+	if ps == nil {
+		return false
+	}
+	i := ps.paramIndex(name)
+	if i < 0 {
+		return false
+	}
+	ps.setParam(i, value)
+	return true
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// CPU profiler. Measures the time spent running each character, each
+// statedef and each state controller type, along with the time spent
+// evaluating the triggers of each statedef. Nested statedefs and controllers
+// (a ChangeState running the new state right away) are subtracted from their
+// caller, so each entry only counts its own time. The top entries of the last
+// second are shown in an overlay, and the totals can be written to a report.
+
+const (
+	profWindow  = 60 // Frames averaged by the overlay
+	profTopRows = 6
+	profDir     = "save/profiles"
+)
+
+type profKey struct {
+	playerNo int
+	no       int32 // Helper ID for characters, state number for statedefs
+	typ      reflect.Type
+}
+
+type profStat struct {
+	name        string
+	calls       int64
+	self        time.Duration
+	trigger     time.Duration // Statedefs only
+	window      time.Duration
+	windowCalls int64
+	recent      time.Duration // Average time per frame over the last window
+	recentCalls int64
+}
+
+type profFrame struct {
+	start   time.Time
+	child   time.Duration
+	trigger time.Duration
+}
+
+type profTable struct {
+	stats map[profKey]*profStat
+	stack []profFrame
+}
+
+func (t *profTable) begin() {
+	t.stack = append(t.stack, profFrame{start: time.Now()})
+}
+
+// Closes the innermost entry and subtracts its time from the enclosing one.
+func (t *profTable) pop() (f profFrame, d time.Duration) {
+	f = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	d = time.Since(f.start)
+	if len(t.stack) > 0 {
+		t.stack[len(t.stack)-1].child += d
+	}
+	return
+}
+
+func (t *profTable) end(k profKey) *profStat {
+	f, d := t.pop()
+	s, ok := t.stats[k]
+	if !ok {
+		s = &profStat{}
+		t.stats[k] = s
+	}
+	s.calls++
+	s.windowCalls++
+	s.self += d - f.child
+	s.window += d - f.child
+	s.trigger += f.trigger
+	return s
+}
+
+func (t *profTable) roll() {
+	for _, s := range t.stats {
+		s.recent, s.recentCalls = s.window/profWindow, s.windowCalls/profWindow
+		s.window, s.windowCalls = 0, 0
+	}
+}
+
+func (t *profTable) sorted(recent bool) []*profStat {
+	list := make([]*profStat, 0, len(t.stats))
+	for _, s := range t.stats {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if recent {
+			return list[i].recent > list[j].recent
+		}
+		return list[i].self > list[j].self
+	})
+	return list
+}
+
+type Profiler struct {
+	enabled    bool
+	chars      profTable
+	states     profTable
+	ctrls      profTable
+	frames     int64
+	total      time.Duration // Time spent in CharList.action
+	frameStart time.Time
+	window     time.Duration
+	recent     time.Duration
+}
+
+var profiler = newProfiler()
+
+func newProfiler() *Profiler {
+	p := &Profiler{}
+	p.Reset()
+	return p
+}
+
+func (p *Profiler) Reset() {
+	for _, t := range [...]*profTable{&p.chars, &p.states, &p.ctrls} {
+		t.stats = make(map[profKey]*profStat)
+		t.stack = t.stack[:0]
+	}
+	p.frames, p.total, p.window, p.recent = 0, 0, 0, 0
+}
+
+func (p *Profiler) charDone(c *Char) {
+	s := p.chars.end(profKey{playerNo: c.playerNo, no: c.helperId})
+	if s.name == "" {
+		s.name = fmt.Sprintf("P%v %v", c.playerNo+1, c.name)
+		if c.helperIndex != 0 {
+			s.name += fmt.Sprintf(" helper %v", c.helperId)
+		}
+	}
+}
+
+// A statedef run by a controller is not counted as time of that controller.
+func (p *Profiler) stateBegin() {
+	p.states.begin()
+	p.ctrls.begin()
+}
+
+func (p *Profiler) stateDone(sb *StateBytecode) {
+	p.ctrls.pop()
+	s := p.states.end(profKey{playerNo: sb.playerNo, no: sb.stateNo})
+	if s.name == "" {
+		s.name = fmt.Sprintf("P%v", sb.playerNo+1)
+		if len(sys.chars[sb.playerNo]) > 0 {
+			s.name += " " + sys.chars[sb.playerNo][0].name
+		}
+		s.name += fmt.Sprintf(" state %v", sb.stateNo)
+	}
+}
+
+// Runs a state controller, timing it unless it is a nested block.
+func runController(sc StateController, c *Char, ps []int32) bool {
+	if !profiler.enabled {
+		return sc.Run(c, ps)
+	}
+	if _, ok := sc.(StateBlock); ok {
+		return sc.Run(c, ps)
+	}
+	profiler.ctrls.begin()
+	stop := sc.Run(c, ps)
+	t := reflect.TypeOf(sc)
+	if s := profiler.ctrls.end(profKey{typ: t}); s.name == "" {
+		s.name = t.Name()
+	}
+	return stop
+}
+
+// Evaluates the trigger of a block, adding its time to the running statedef.
+func (b *StateBlock) triggered(c *Char) bool {
+	if !profiler.enabled || len(profiler.states.stack) == 0 {
+		return b.trigger.evalB(c)
+	}
+	start := time.Now()
+	v := b.trigger.evalB(c)
+	profiler.states.stack[len(profiler.states.stack)-1].trigger += time.Since(start)
+	return v
+}
+
+func (p *Profiler) frameBegin() {
+	if p.enabled {
+		p.frameStart = time.Now()
+	}
+}
+
+func (p *Profiler) frameEnd() {
+	if !p.enabled {
+		return
+	}
+	d := time.Since(p.frameStart)
+	p.total += d
+	p.window += d
+	p.frames++
+	// Entries left open by a panic recovered by the script are dropped
+	for _, t := range [...]*profTable{&p.chars, &p.states, &p.ctrls} {
+		t.stack = t.stack[:0]
+	}
+	if p.frames%profWindow == 0 {
+		p.recent, p.window = p.window/profWindow, 0
+		p.chars.roll()
+		p.states.roll()
+		p.ctrls.roll()
+	}
+}
+
+func profMs(d time.Duration) string {
+	return fmt.Sprintf("%.3fms", float64(d)/float64(time.Millisecond))
+}
+
+func (p *Profiler) draw() {
+	fnt, ts := sys.debugFont.fnt, sys.debugFont
+	lh := float32(fnt.Size[1]) * ts.yscl / sys.heightScale
+	FillRect([4]int32{0, 0, sys.scrrect[2] / 2, sys.scrrect[3]}, 0, 160)
+	x := (320-float32(sys.gameWidth))/2 + 1
+	y := 240 - float32(sys.gameHeight)
+	put := func(txt string) {
+		y += lh
+		fnt.Print(txt, x, y, ts.xscl/sys.widthScale, ts.yscl/sys.heightScale, 0, 1,
+			&sys.scrrect, ts.palfx, ts.frgba)
+	}
+	ts.SetColor(255, 255, 127)
+	put(fmt.Sprintf("Profiler: %v/frame", profMs(p.recent)))
+	for _, sec := range [...]struct {
+		title string
+		t     *profTable
+	}{{"Characters", &p.chars}, {"States", &p.states}, {"Controllers", &p.ctrls}} {
+		ts.SetColor(199, 199, 219)
+		put(sec.title)
+		ts.SetColor(255, 255, 255)
+		for i, s := range sec.t.sorted(true) {
+			if i >= profTopRows || s.recent == 0 {
+				break
+			}
+			line := fmt.Sprintf(" %v %v", profMs(s.recent), s.name)
+			if sec.t == &p.ctrls {
+				line += fmt.Sprintf(" x%v", s.recentCalls)
+			}
+			put(line)
+		}
+	}
+}
+
+// Writes the totals to file, or to a new file in save/profiles if file is
+// empty, and returns the file name.
+func (p *Profiler) Report(file string) (string, error) {
+	if file == "" {
+		file = filepath.Join(profDir,
+			"profile-"+time.Now().Format("20060102-150405")+".txt")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return file, err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "Frames: %v\nCharacter processing: %v", p.frames, profMs(p.total))
+	if p.frames > 0 {
+		fmt.Fprintf(w, " (%v/frame)", profMs(p.total/time.Duration(p.frames)))
+	}
+	fmt.Fprintln(w)
+	for _, sec := range [...]struct {
+		title string
+		t     *profTable
+	}{{"Characters", &p.chars}, {"States", &p.states}, {"Controllers", &p.ctrls}} {
+		fmt.Fprintf(w, "\n%v\n%12v %7v %10v %10v  %v\n", sec.title,
+			"Time", "%", "Calls", "Avg", "Name")
+		for _, s := range sec.t.sorted(false) {
+			pct := 0.0
+			if p.total > 0 {
+				pct = float64(s.self) * 100 / float64(p.total)
+			}
+			avg := time.Duration(0)
+			if s.calls > 0 {
+				avg = s.self / time.Duration(s.calls)
+			}
+			fmt.Fprintf(w, "%12v %6.2f%% %10v %10v  %v", profMs(s.self), pct,
+				s.calls, avg, s.name)
+			if sec.t == &p.states {
+				fmt.Fprintf(w, " (triggers %v)", profMs(s.trigger))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return file, w.Flush()
+}
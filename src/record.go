@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/gopxl/beep/v2"
+	"github.com/gopxl/beep/v2/speaker"
+)
+
+// ------------------------------------------------------------------
+// Recorder
+
+// Recorder captures every rendered frame and the mixed audio output of a
+// session. Video is written as a YUV4MPEG2 stream (.y4m) and audio as a
+// 16-bit PCM WAV file next to it, both of which can be played or muxed by
+// standard tools such as ffmpeg.
+//
+// In offline mode the game is not paced in real time. The speaker outputs
+// silence and the audio mixer is pulled by the recorder once per frame
+// instead, so audio stays in sync no matter how fast frames are rendered.
+// Outputs other than the speaker already pull once per frame, and their
+// audio is recorded as is.
+type Recorder struct {
+	recording bool
+	offline   bool
+	pullAudio bool // Offline with the speaker output
+	filename  string
+	width     int
+	height    int
+	pixels    []uint8 // Last captured frame, bottom row first
+	mixBuf    [][2]float64
+	sampleAcc int
+	frames    int
+	// Audio captured on the speaker goroutine, drained once per frame
+	audioMu  sync.Mutex
+	audioBuf []byte
+	// Encoding and file output run on their own goroutine
+	chunks chan recordChunk
+	done   chan struct{}
+}
+
+type recordChunk struct {
+	frame []uint8
+	audio []byte
+}
+
+func newRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start recording to <basename>.y4m and <basename>.wav. If basename is
+// empty, the first free ikemenNNN name in the screenshot folder is used.
+func (r *Recorder) Start(basename string, offline bool) error {
+	if r.recording {
+		return Error("already recording")
+	}
+	if basename == "" {
+		for i := 0; i < 1000; i++ {
+			name := fmt.Sprintf("%sikemen%03d", sys.screenshotFolder, i)
+			if _, err := os.Stat(name + ".y4m"); os.IsNotExist(err) {
+				basename = name
+				break
+			}
+		}
+		if basename == "" {
+			return Error("no free recording file name")
+		}
+	}
+	width, height := sys.window.GetSize()
+	video, err := os.Create(basename + ".y4m")
+	if err != nil {
+		return err
+	}
+	audio, err := os.Create(basename + ".wav")
+	if err != nil {
+		video.Close()
+		return err
+	}
+	r.filename = basename
+	r.width, r.height = width, height
+	r.pixels = make([]uint8, 4*width*height)
+	r.sampleAcc, r.frames = 0, 0
+	r.chunks = make(chan recordChunk, 8)
+	r.done = make(chan struct{})
+	go r.writer(video, audio)
+
+	speaker.Lock()
+	r.offline = offline
+	r.pullAudio = offline && sys.audioOutput.Realtime()
+	r.recording = true
+	speaker.Unlock()
+	if offline {
+		sys.window.SetSwapInterval(0)
+	}
+	return nil
+}
+
+// Stop recording and finalize the output files
+func (r *Recorder) Stop() {
+	if !r.recording {
+		return
+	}
+	speaker.Lock()
+	r.recording, r.offline, r.pullAudio = false, false, false
+	speaker.Unlock()
+	r.chunks <- recordChunk{audio: r.takeAudio()}
+	close(r.chunks)
+	<-r.done
+	if sys.vRetrace >= 0 {
+		sys.window.SetSwapInterval(sys.vRetrace)
+	}
+	r.pixels = nil
+	fmt.Printf("Recorded %v frames to %v.y4m\n", r.frames, r.filename)
+}
+
+// Present the finished frame and record it. Replaces the usual
+// EndFrame/SwapBuffers pair.
+func (r *Recorder) CaptureFrame() {
+	gfx.EndFrame()
+	if w, h := sys.window.GetSize(); w != r.width || h != r.height {
+		sys.errLog.Printf("Recording stopped: window resized to %vx%v", w, h)
+		sys.window.SwapBuffers()
+		r.Stop()
+		return
+	}
+	gfx.ReadPixels(r.pixels, r.width, r.height)
+	sys.window.SwapBuffers()
+	r.submit()
+}
+
+// Record the previous frame again. Used on skipped frames to keep video
+// and audio in sync.
+func (r *Recorder) RepeatFrame() {
+	r.submit()
+}
+
+func (r *Recorder) submit() {
+	if r.pullAudio {
+		r.mixFrameAudio()
+	}
+	frame := make([]uint8, len(r.pixels))
+	copy(frame, r.pixels)
+	r.chunks <- recordChunk{frame: frame, audio: r.takeAudio()}
+	r.frames++
+}
+
+// Pull exactly one frame worth of samples from the output mixer
+func (r *Recorder) mixFrameAudio() {
+	n := frameSampleCount(&r.sampleAcc)
+	if cap(r.mixBuf) < n {
+		r.mixBuf = make([][2]float64, n)
+	}
+	samples := r.mixBuf[:n]
+	speaker.Lock()
+	sys.outputMixer.Stream(samples)
+	speaker.Unlock()
+	r.appendAudio(samples)
+}
+
+func (r *Recorder) appendAudio(samples [][2]float64) {
+	r.audioMu.Lock()
+	r.audioBuf = appendPcm16(r.audioBuf, samples)
+	r.audioMu.Unlock()
+}
+
+func (r *Recorder) takeAudio() []byte {
+	r.audioMu.Lock()
+	defer r.audioMu.Unlock()
+	buf := r.audioBuf
+	r.audioBuf = nil
+	return buf
+}
+
+func (r *Recorder) writer(video, audio *os.File) {
+	defer close(r.done)
+	vw := bufio.NewWriterSize(video, 1<<20)
+	aw := bufio.NewWriter(audio)
+	fmt.Fprintf(vw, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420jpeg XCOLORRANGE=FULL\n",
+		r.width, r.height, FPS)
+	writeWavHeader(aw, 0)
+	var yuv []byte
+	var audioLen uint32
+	for c := range r.chunks {
+		if c.frame != nil {
+			yuv = rgbaToYuv420(yuv[:0], c.frame, r.width, r.height)
+			vw.WriteString("FRAME\n")
+			vw.Write(yuv)
+		}
+		aw.Write(c.audio)
+		audioLen += uint32(len(c.audio))
+	}
+	if err := vw.Flush(); err != nil {
+		sys.errLog.Printf("Failed to write recording: %v", err)
+	}
+	video.Close()
+	if err := aw.Flush(); err == nil {
+		audio.Seek(0, 0)
+		writeWavHeader(audio, audioLen)
+	} else {
+		sys.errLog.Printf("Failed to write recording: %v", err)
+	}
+	audio.Close()
+}
+
+// Write a 16-bit stereo PCM WAV header for dataLen bytes of samples
+func writeWavHeader(w io.Writer, dataLen uint32) {
+	rate := uint32(sys.audioSampleRate)
+	h := make([]byte, 0, 44)
+	h = append(h, "RIFF"...)
+	h = binary.LittleEndian.AppendUint32(h, 36+dataLen)
+	h = append(h, "WAVEfmt "...)
+	h = binary.LittleEndian.AppendUint32(h, 16)
+	h = binary.LittleEndian.AppendUint16(h, 1) // PCM
+	h = binary.LittleEndian.AppendUint16(h, 2)
+	h = binary.LittleEndian.AppendUint32(h, rate)
+	h = binary.LittleEndian.AppendUint32(h, rate*4)
+	h = binary.LittleEndian.AppendUint16(h, 4)
+	h = binary.LittleEndian.AppendUint16(h, 16)
+	h = append(h, "data"...)
+	h = binary.LittleEndian.AppendUint32(h, dataLen)
+	w.Write(h)
+}
+
+// Convert bottom-up RGBA pixels to planar full range YUV 4:2:0
+func rgbaToYuv420(dst, src []uint8, width, height int) []byte {
+	cw, ch := (width+1)/2, (height+1)/2
+	ysize, csize := width*height, cw*ch
+	if cap(dst) < ysize+2*csize {
+		dst = make([]byte, ysize+2*csize)
+	}
+	dst = dst[:ysize+2*csize]
+	yp, up, vp := dst[:ysize], dst[ysize:ysize+csize], dst[ysize+csize:]
+	pixel := func(x, y int) []uint8 {
+		return src[4*(width*(height-1-y)+x):]
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := pixel(x, y)
+			yp[y*width+x], _, _ = color.RGBToYCbCr(p[0], p[1], p[2])
+		}
+	}
+	// Chroma is taken from the average color of each 2x2 block
+	for cy := 0; cy < ch; cy++ {
+		for cx := 0; cx < cw; cx++ {
+			var r, g, b, n int
+			for y := 2 * cy; y < min(2*cy+2, height); y++ {
+				for x := 2 * cx; x < min(2*cx+2, width); x++ {
+					p := pixel(x, y)
+					r, g, b, n = r+int(p[0]), g+int(p[1]), b+int(p[2]), n+1
+				}
+			}
+			_, up[cy*cw+cx], vp[cy*cw+cx] = color.RGBToYCbCr(uint8(r/n), uint8(g/n), uint8(b/n))
+		}
+	}
+	return dst
+}
+
+// Start or stop a real time recording with a default file name
+func toggleRecording() {
+	if sys.recorder.recording {
+		sys.recorder.Stop()
+	} else if err := sys.recorder.Start("", false); err != nil {
+		sys.errLog.Printf("Failed to start recording: %v", err)
+	}
+}
+
+// ------------------------------------------------------------------
+// RecorderTap
+
+// RecorderTap sits between the output mixer and the audio output and copies
+// the mixed audio into the recorder. It always runs with the speaker locked.
+type RecorderTap struct {
+	streamer beep.Streamer
+	rec      *Recorder
+}
+
+func (t *RecorderTap) Stream(samples [][2]float64) (n int, ok bool) {
+	if t.rec.pullAudio {
+		// The recorder pulls the mixer itself, once per frame
+		clear(samples)
+		return len(samples), true
+	}
+	n, ok = t.streamer.Stream(samples)
+	if t.rec.recording {
+		t.rec.appendAudio(samples[:n])
+	}
+	return n, ok
+}
+
+func (t *RecorderTap) Err() error {
+	return t.streamer.Err()
+}
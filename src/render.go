@@ -47,6 +47,9 @@ type RenderParams struct {
 	// Sprite texture and palette texture
 	tex    *Texture
 	paltex *Texture
+	// Texture coordinates of the sprite inside an atlas page (u0, v0, u1,
+	// v1), or zero if it covers the whole texture
+	uv [4]float32
 	// Size, position, tiling, scaling and rotation
 	size     [2]uint16
 	x, y     float32
@@ -77,14 +80,26 @@ func (rp *RenderParams) IsValid() bool {
 		rp.rxadd+rp.rot.angle+rp.rcx+rp.rcy)
 }
 
-func drawQuads(modelview mgl.Mat4, x1, y1, x2, y2, x3, y3, x4, y4 float32) {
+// Return the sprite texture coordinates, defaulting to the whole texture
+func (rp *RenderParams) texCoords() [4]float32 {
+	if rp.uv == [4]float32{} {
+		return [4]float32{0, 0, 1, 1}
+	}
+	return rp.uv
+}
+
+func drawQuads(modelview mgl.Mat4, uv [4]float32, x1, y1, x2, y2, x3, y3, x4, y4 float32) {
+	if batch.recording {
+		batch.AddQuad(modelview, uv, x1, y1, x2, y2, x3, y3, x4, y4)
+		return
+	}
 	gfx.SetUniformMatrix("modelview", modelview[:])
 	gfx.SetUniformF("x1x2x4x3", x1, x2, x4, x3) // this uniform is optional
 	gfx.SetVertexData(
-		x2, y2, 1, 1,
-		x3, y3, 1, 0,
-		x1, y1, 0, 1,
-		x4, y4, 0, 0)
+		x2, y2, uv[2], uv[3],
+		x3, y3, uv[2], uv[1],
+		x1, y1, uv[0], uv[3],
+		x4, y4, uv[0], uv[1])
 
 	gfx.RenderQuad()
 }
@@ -134,7 +149,7 @@ func rmTileHSub(modelview mgl.Mat4, x1, y1, x2, y2, x3, y3, x4, y4, dy, width fl
 			mat = mat.Mul4(mgl.Translate3D(-(rp.rcx + float32(n)*botdist), -(rp.rcy + dy), 0))
 		}
 
-		drawQuads(mat, x1d, y1, x2d, y2, x3d, y3, x4d, y4)
+		drawQuads(mat, rp.texCoords(), x1d, y1, x2d, y2, x3d, y3, x4d, y4)
 	}
 }
 
@@ -183,7 +198,7 @@ func rmTileSub(modelview mgl.Mat4, rp RenderParams) {
 				mgl.Rotate3DZ(rp.rot.angle * math.Pi / 180.0)).Mat4())
 		modelview = modelview.Mul4(mgl.Translate3D(-rp.rcx, -rp.rcy, 0))
 
-		drawQuads(modelview, x1, y1, x2, y2, x3, y3, x4, y4)
+		drawQuads(modelview, rp.texCoords(), x1, y1, x2, y2, x3, y3, x4, y4)
 		return
 	}
 	if rp.tile.y == 1 && rp.xbs != 0 {
@@ -309,38 +324,43 @@ func RenderSprite(rp RenderParams) {
 	proj := mgl.Ortho(0, float32(sys.scrrect[2]), 0, float32(sys.scrrect[3]), -65535, 65535)
 	modelview := mgl.Translate3D(0, float32(sys.scrrect[3]), 0)
 
-	gfx.Scissor(rp.window[0], rp.window[1], rp.window[2], rp.window[3])
+	// Trapezoids need per-quad uniforms and perspective projections need the
+	// GPU to divide by w, so only flat orthographic sprites are batched
+	isTrapez := AbsF(AbsF(rp.xts)-AbsF(rp.xbs)) > 0.001
+	batched := sys.spriteBatching && !isTrapez && rp.projectionMode == 0
+	if !batched {
+		batch.Flush()
+		gfx.Scissor(rp.window[0], rp.window[1], rp.window[2], rp.window[3])
+	}
 
 	renderWithBlending(func(eq BlendEquation, src, dst BlendFunc, a float32) {
-
-		gfx.SetPipeline(eq, src, dst)
-
-		gfx.SetUniformMatrix("projection", proj[:])
-		gfx.SetTexture("tex", rp.tex)
-		if rp.paltex == nil {
-			gfx.SetUniformI("isRgba", 1)
-		} else {
-			gfx.SetTexture("pal", rp.paltex)
-			gfx.SetUniformI("isRgba", 0)
+		state := SpriteState{
+			tex: rp.tex, paltex: rp.paltex,
+			eq: eq, src: src, dst: dst,
+			window: *rp.window, projection: proj,
+			mask: rp.mask, neg: neg, gray: grayscale, hue: hue,
+			add: padd, mult: pmul, tint: tint, alpha: a,
+		}
+		if batched {
+			batch.Begin(state)
+			rmTileSub(modelview, rp)
+			batch.End()
+			return
 		}
-		gfx.SetUniformI("mask", int(rp.mask))
-		gfx.SetUniformI("isTrapez", int(Btoi(AbsF(AbsF(rp.xts)-AbsF(rp.xbs)) > 0.001)))
-		gfx.SetUniformI("isFlat", 0)
-
-		gfx.SetUniformI("neg", int(Btoi(neg)))
-		gfx.SetUniformF("gray", grayscale)
-		gfx.SetUniformF("hue", hue)
-		gfx.SetUniformFv("add", padd[:])
-		gfx.SetUniformFv("mult", pmul[:])
-		gfx.SetUniformFv("tint", tint[:])
-		gfx.SetUniformF("alpha", a)
+
+		state.apply()
+		uv := rp.texCoords()
+		gfx.SetUniformI("isTrapez", int(Btoi(isTrapez)))
+		gfx.SetUniformF("uvRect", uv[0], uv[1], uv[2]-uv[0], uv[3]-uv[1])
 
 		rmTileSub(modelview, rp)
 
 		gfx.ReleasePipeline()
 	}, rp.trans, rp.paltex != nil, invblend, &neg, &padd, &pmul, rp.paltex == nil)
 
-	gfx.DisableScissor()
+	if !batched {
+		gfx.DisableScissor()
+	}
 }
 
 func renderWithBlending(render func(eq BlendEquation, src, dst BlendFunc, a float32), trans int32, correctAlpha bool, invblend int32, neg *bool, acolor *[3]float32, mcolor *[3]float32, isrgba bool) {
@@ -438,6 +458,8 @@ func FillRect(rect [4]int32, color uint32, trans int32) {
 	x1, y1 := float32(rect[0]), -float32(rect[1])
 	x2, y2 := float32(rect[0]+rect[2]), -float32(rect[1]+rect[3])
 
+	batch.Flush()
+
 	renderWithBlending(func(eq BlendEquation, src, dst BlendFunc, a float32) {
 		gfx.SetPipeline(eq, src, dst)
 		gfx.SetVertexData(
@@ -170,6 +170,14 @@ func newTexture(width, height, depth int32, filter bool) (t *Texture) {
 	return
 }
 
+// Free the texture without waiting for the garbage collector. Must be
+// called on the main thread.
+func (t *Texture) Delete() {
+	runtime.SetFinalizer(t, nil)
+	gl.DeleteTextures(1, &t.handle)
+	t.handle = 0
+}
+
 func newDataTexture(width, height int32) (t *Texture) {
 	var h uint32
 	gl.ActiveTexture(gl.TEXTURE0)
@@ -250,6 +258,7 @@ type Renderer struct {
 	// Post-processing shaders
 	postVertBuffer   uint32
 	postShaderSelect []*ShaderProgram
+	postTargets      [2]postTarget // Intermediate targets of multi-pass chains
 	// Shader and vertex data for primitive rendering
 	spriteShader *ShaderProgram
 	vertexBuffer uint32
@@ -290,7 +299,7 @@ func (r *Renderer) Init() {
 	// Store current timestamp
 	updateTimeStamp()
 
-	r.postShaderSelect = make([]*ShaderProgram, 1+len(sys.externalShaderList))
+	r.postShaderSelect = make([]*ShaderProgram, 1+len(sys.postProcessing.shaders))
 
 	// Data buffers for rendering
 	postVertData := f32.Bytes(binary.LittleEndian, -1, -1, 1, -1, -1, 1, 1, 1)
@@ -308,7 +317,7 @@ func (r *Renderer) Init() {
 	r.spriteShader = newShaderProgram(vertShader, fragShader, "Main Shader")
 	r.spriteShader.RegisterAttributes("position", "uv", "texcoord")
 	r.spriteShader.RegisterUniforms("modelview", "projection", "x1x2x4x3",
-		"alpha", "tint", "mask", "neg", "gray", "add", "mult", "isFlat", "isRgba", "isTrapez", "hue")
+		"alpha", "tint", "mask", "neg", "gray", "add", "mult", "isFlat", "isRgba", "isTrapez", "hue", "uvRect")
 	r.spriteShader.RegisterTextures("pal", "tex")
 
 	// 3D model shader
@@ -320,7 +329,7 @@ func (r *Renderer) Init() {
 	// Compile postprocessing shaders
 
 	// Calculate total amount of shaders loaded.
-	r.postShaderSelect = make([]*ShaderProgram, 1+len(sys.externalShaderList))
+	r.postShaderSelect = make([]*ShaderProgram, 1+len(sys.postProcessing.shaders))
 
 	// Ident shader (no postprocessing)
 	r.postShaderSelect[0] = newShaderProgram(identVertShader, identFragShader, "Identity Postprocess")
@@ -328,10 +337,13 @@ func (r *Renderer) Init() {
 	r.postShaderSelect[0].RegisterUniforms("Texture", "TextureSize")
 
 	// External Shaders
-	for i := 0; i < len(sys.externalShaderList); i++ {
-		r.postShaderSelect[1+i] = newShaderProgram(sys.externalShaders[0][i],
-			sys.externalShaders[1][i], fmt.Sprintf("Postprocess Shader #%v", i+1))
+	for i, ps := range sys.postProcessing.shaders {
+		r.postShaderSelect[1+i] = newShaderProgram(ps.vert, ps.frag, fmt.Sprintf("Postprocess Shader #%v", i+1))
+		r.postShaderSelect[1+i].RegisterAttributes("VertCoord")
 		r.postShaderSelect[1+i].RegisterUniforms("Texture", "TextureSize")
+		for _, p := range ps.Parameters {
+			r.postShaderSelect[1+i].RegisterUniforms(p.Name)
+		}
 	}
 
 	if sys.multisampleAntialiasing > 0 {
@@ -429,44 +441,94 @@ func (r *Renderer) EndFrame() {
 	}
 
 	x, y, resizedWidth, resizedHeight := sys.window.GetScaledViewportSize()
-	postShader := r.postShaderSelect[sys.postProcessingShader]
 
-	var scaleMode int32 // GL enum
-	if sys.windowScaleMode == true {
-		scaleMode = gl.LINEAR
-	} else {
-		scaleMode = gl.NEAREST
+	src := r.fbo_texture
+	if sys.multisampleAntialiasing > 0 {
+		src = r.fbo_f_texture.handle
 	}
 
-	gl.Viewport(x, y, int32(resizedWidth), int32(resizedHeight))
-	gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
-	gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
-
-	gl.UseProgram(postShader.program)
 	gl.Disable(gl.BLEND)
-
 	gl.ActiveTexture(gl.TEXTURE0)
-	if sys.multisampleAntialiasing > 0 {
-		gl.BindTexture(gl.TEXTURE_2D, r.fbo_f_texture.handle)
-	} else {
-		gl.BindTexture(gl.TEXTURE_2D, r.fbo_texture)
-	}
+	gl.BindBuffer(gl.ARRAY_BUFFER, r.postVertBuffer)
 
-	// set post-processing parameters
-	gl.Uniform1i(postShader.u["Texture"], 0)
-	gl.Uniform2f(postShader.u["TextureSize"], float32(resizedWidth), float32(resizedHeight))
-	gl.Uniform1f(postShader.u["CurrentTime"], float32(time.Now().Unix()))
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, scaleMode)
-	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, scaleMode)
+	// Every pass but the last one renders into an intermediate target,
+	// which becomes the input of the next pass
+	passes := sys.postProcessing.Passes()
+	for i, idx := range passes {
+		postShader := r.postShaderSelect[idx]
+		last := i == len(passes)-1
+		if last {
+			gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
+			gl.Viewport(x, y, int32(resizedWidth), int32(resizedHeight))
+			gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
+		} else {
+			target := r.postTarget(i%2, int32(resizedWidth), int32(resizedHeight))
+			gl.BindFramebuffer(gl.FRAMEBUFFER, target.fbo)
+			gl.Viewport(0, 0, target.width, target.height)
+		}
 
-	gl.BindBuffer(gl.ARRAY_BUFFER, r.postVertBuffer)
+		gl.UseProgram(postShader.program)
+		gl.BindTexture(gl.TEXTURE_2D, src)
 
-	loc := r.modelShader.a["VertCoord"]
-	gl.EnableVertexAttribArray(uint32(loc))
-	gl.VertexAttribPointerWithOffset(uint32(loc), 2, gl.FLOAT, false, 0, 0)
+		var scaleMode int32 // GL enum
+		if sys.postProcessing.Linear(idx) {
+			scaleMode = gl.LINEAR
+		} else {
+			scaleMode = gl.NEAREST
+		}
 
-	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
-	gl.DisableVertexAttribArray(uint32(loc))
+		// set post-processing parameters
+		gl.Uniform1i(postShader.u["Texture"], 0)
+		gl.Uniform2f(postShader.u["TextureSize"], float32(resizedWidth), float32(resizedHeight))
+		gl.Uniform1f(postShader.u["CurrentTime"], float32(time.Now().Unix()))
+		if ps := sys.postProcessing.Shader(idx); ps != nil {
+			for j, p := range ps.Parameters {
+				gl.Uniform1f(postShader.u[p.Name], ps.values[j])
+			}
+		}
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, scaleMode)
+		gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, scaleMode)
+
+		loc := postShader.a["VertCoord"]
+		gl.EnableVertexAttribArray(uint32(loc))
+		gl.VertexAttribPointerWithOffset(uint32(loc), 2, gl.FLOAT, false, 0, 0)
+
+		gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
+		gl.DisableVertexAttribArray(uint32(loc))
+
+		if !last {
+			src = r.postTargets[i%2].texture
+		}
+	}
+}
+
+// Intermediate render target of a post-processing chain
+type postTarget struct {
+	fbo, texture  uint32
+	width, height int32
+}
+
+// Return intermediate target i, (re)allocating it if the size changed
+func (r *Renderer) postTarget(i int, width, height int32) *postTarget {
+	t := &r.postTargets[i]
+	if t.fbo != 0 && t.width == width && t.height == height {
+		return t
+	}
+	if t.fbo == 0 {
+		gl.GenFramebuffers(1, &t.fbo)
+		gl.GenTextures(1, &t.texture)
+	}
+	t.width, t.height = width, height
+	gl.BindTexture(gl.TEXTURE_2D, t.texture)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, width, height, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, t.fbo)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, t.texture, 0)
+	if status := gl.CheckFramebufferStatus(gl.FRAMEBUFFER); status != gl.FRAMEBUFFER_COMPLETE {
+		sys.errLog.Printf("framebuffer create failed: 0x%x", status)
+	}
+	return t
 }
 
 func (r *Renderer) SetPipeline(eq BlendEquation, src, dst BlendFunc) {
@@ -624,12 +686,11 @@ func (r *Renderer) SetModelMorphTarget(offsets [8]uint32, weights [8]float32, po
 
 }
 
+// Read the finished frame from the window framebuffer. Call it after
+// EndFrame and before SwapBuffers.
 func (r *Renderer) ReadPixels(data []uint8, width, height int) {
-	r.EndFrame()
-	sys.window.SwapBuffers()
 	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, 0)
 	gl.ReadPixels(0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, unsafe.Pointer(&data[0]))
-	r.BeginFrame(false)
 }
 
 func (r *Renderer) Scissor(x, y, width, height int32) {
@@ -747,6 +808,15 @@ func (r *Renderer) SetStageIndexData(values ...uint32) {
 func (r *Renderer) RenderQuad() {
 	gl.DrawArrays(gl.TRIANGLE_STRIP, 0, 4)
 }
+func (r *Renderer) RenderTriangles(count int) {
+	gl.DrawArrays(gl.TRIANGLES, 0, int32(count))
+}
 func (r *Renderer) RenderElements(mode PrimitiveMode, count, offset int) {
 	gl.DrawElementsWithOffset(PrimitiveModeLUT[mode], int32(count), gl.UNSIGNED_INT, uintptr(offset))
 }
+
+// Clear the depth buffer so the next model is not occluded by earlier ones
+func (r *Renderer) ClearDepth() {
+	gl.DepthMask(true)
+	gl.Clear(gl.DEPTH_BUFFER_BIT)
+}
@@ -89,6 +89,15 @@ func newTexture(width, height, depth int32, filter bool) (t *Texture) {
 	return
 }
 
+// Free the texture without waiting for the garbage collector. Must be
+// called on the main thread.
+func (t *Texture) Delete() {
+	runtime.SetFinalizer(t, nil)
+	C.kinc_g4_texture_destroy(t.handle)
+	C.free(unsafe.Pointer(t.handle))
+	t.handle = nil
+}
+
 func (t *Texture) SetData(data []byte) {
 	pixels := C.kinc_g4_texture_lock(t.handle)
 	stride := C.kinc_g4_texture_stride(t.handle)
@@ -143,6 +152,9 @@ type Renderer struct {
 	layout       *C.kinc_g4_vertex_structure_t
 	indexBuffer  *C.kinc_g4_index_buffer_t
 	vertexBuffer *C.kinc_g4_vertex_buffer_t
+	// Sprite batches are drawn as triangle lists from larger buffers
+	batchIndexBuffer  *C.kinc_g4_index_buffer_t
+	batchVertexBuffer *C.kinc_g4_vertex_buffer_t
 	// All pipelines use the same shaders
 	vertexShader   *C.kinc_g4_shader_t
 	fragmentShader *C.kinc_g4_shader_t
@@ -174,6 +186,18 @@ func (r *Renderer) Init() {
 	r.vertexBuffer = (*C.kinc_g4_vertex_buffer_t)(C.malloc(C.sizeof_kinc_g4_vertex_buffer_t))
 	C.kinc_g4_vertex_buffer_init(r.vertexBuffer, 4, r.layout, C.KINC_G4_USAGE_DYNAMIC, 0)
 
+	r.batchIndexBuffer = (*C.kinc_g4_index_buffer_t)(C.malloc(C.sizeof_kinc_g4_index_buffer_t))
+	C.kinc_g4_index_buffer_init(r.batchIndexBuffer, maxBatchQuads*6, C.KINC_G4_INDEX_BUFFER_FORMAT_16BIT, C.KINC_G4_USAGE_STATIC)
+	data = C.kinc_g4_index_buffer_lock(r.batchIndexBuffer)
+	indices = unsafe.Slice((*uint16)(unsafe.Pointer(data)), maxBatchQuads*6)
+	for i := range indices {
+		indices[i] = uint16(i)
+	}
+	C.kinc_g4_index_buffer_unlock(r.batchIndexBuffer)
+
+	r.batchVertexBuffer = (*C.kinc_g4_vertex_buffer_t)(C.malloc(C.sizeof_kinc_g4_vertex_buffer_t))
+	C.kinc_g4_vertex_buffer_init(r.batchVertexBuffer, maxBatchQuads*6, r.layout, C.KINC_G4_USAGE_DYNAMIC, 0)
+
 	r.vertexShader = C.load_shader(C.CString("sprite.vert"), C.KINC_G4_SHADER_TYPE_VERTEX)
 	r.fragmentShader = C.load_shader(C.CString("sprite.frag"), C.KINC_G4_SHADER_TYPE_FRAGMENT)
 
@@ -222,7 +246,7 @@ func (r *Renderer) SetPipeline(eq BlendEquation, src, dst BlendFunc) {
 		p.u = make(map[string]C.kinc_g4_constant_location_t)
 		p.t = make(map[string]C.kinc_g4_texture_unit_t)
 		p.RegisterUniforms("modelview", "projection", "x1x2x4x3",
-			"alpha", "tint", "mask", "neg", "gray", "add", "mult", "isFlat", "isRgba", "isTrapez", "hue")
+			"alpha", "tint", "mask", "neg", "gray", "add", "mult", "isFlat", "isRgba", "isTrapez", "hue", "uvRect")
 		p.RegisterTextures("pal", "tex")
 
 		r.pipelineCache[params] = p
@@ -290,7 +314,15 @@ func (r *Renderer) SetTexture(name string, t *Texture) {
 	C.kinc_g4_set_texture_mipmap_filter(unit, C.KINC_G4_MIPMAP_FILTER_NONE)
 }
 
+// Quads go to the 4 vertex buffer, anything larger is a triangle list
 func (r *Renderer) SetVertexData(values ...float32) {
+	if len(values) > 16 {
+		count := len(values) / 4
+		data := C.kinc_g4_vertex_buffer_lock(r.batchVertexBuffer, 0, C.int(count))
+		copy(unsafe.Slice((*float32)(unsafe.Pointer(data)), count*4), values)
+		C.kinc_g4_vertex_buffer_unlock(r.batchVertexBuffer, C.int(count))
+		return
+	}
 	data := C.kinc_g4_vertex_buffer_lock_all(r.vertexBuffer)
 	for i := 0; i < len(values); i++ {
 		dst := unsafe.Add(unsafe.Pointer(data), uintptr(i*4))
@@ -304,3 +336,14 @@ func (r *Renderer) RenderQuad() {
 	C.kinc_g4_set_index_buffer(r.indexBuffer)
 	C.kinc_g4_draw_indexed_vertices()
 }
+
+// Clear the depth buffer so the next model is not occluded by earlier ones
+func (r *Renderer) ClearDepth() {
+	C.kinc_g4_clear(C.KINC_G4_CLEAR_DEPTH, 0, 1.0, 0)
+}
+
+func (r *Renderer) RenderTriangles(count int) {
+	C.kinc_g4_set_vertex_buffer(r.batchVertexBuffer)
+	C.kinc_g4_set_index_buffer(r.batchIndexBuffer)
+	C.kinc_g4_draw_indexed_vertices_from_to(0, C.int(count))
+}
@@ -0,0 +1,496 @@
+//go:build soft
+
+// Software rasterizer implementing the Renderer/Texture API on the CPU.
+// It reproduces the sprite shader (palette lookup, PalFX, tint, masking,
+// trapezoid correction), the blending pipeline and scissor windows, so
+// frames can be drawn and inspected on machines without a GPU. 3D model
+// rendering is not supported and is silently skipped.
+
+package main
+
+import (
+	"image"
+	"math"
+
+	mgl "github.com/go-gl/mathgl/mgl32"
+)
+
+const Renderer_API = 3
+
+// ------------------------------------------------------------------
+// Texture
+
+type Texture struct {
+	width  int32
+	height int32
+	depth  int32
+	filter bool
+	data   []byte
+}
+
+func newTexture(width, height, depth int32, filter bool) (t *Texture) {
+	return &Texture{width: width, height: height, depth: depth, filter: filter}
+}
+
+func newDataTexture(width, height int32) (t *Texture) {
+	return &Texture{width: width, height: height, depth: 32}
+}
+
+// Release the pixel data
+func (t *Texture) Delete() {
+	t.data = nil
+}
+
+// Copy texel data into the texture
+func (t *Texture) SetData(data []byte) {
+	size := int(t.width) * int(t.height) * int(Max(t.depth, 8)/8)
+	t.data = make([]byte, size)
+	if data != nil {
+		copy(t.data, data)
+	}
+}
+
+func (t *Texture) SetDataG(data []byte, mag, min, ws, wt int32) {
+	t.SetData(data)
+}
+
+func (t *Texture) SetPixelData(data []float32) {
+	t.data = make([]byte, len(data))
+	for i, v := range data {
+		t.data[i] = uint8(ClampF(v, 0, 1) * 255)
+	}
+}
+
+// Return whether texture has texel data
+func (t *Texture) IsValid() bool {
+	return t.data != nil
+}
+
+// Fetch a texel as normalized RGBA, clamping coordinates to the edges
+func (t *Texture) texel(x, y int32) (c [4]float32) {
+	x, y = Clamp(x, 0, t.width-1), Clamp(y, 0, t.height-1)
+	switch t.depth {
+	case 8:
+		v := float32(t.data[y*t.width+x]) / 255
+		c = [4]float32{v, v, v, 1}
+	case 24:
+		i := (y*t.width + x) * 3
+		c = [4]float32{float32(t.data[i]) / 255, float32(t.data[i+1]) / 255,
+			float32(t.data[i+2]) / 255, 1}
+	default:
+		i := (y*t.width + x) * 4
+		c = [4]float32{float32(t.data[i]) / 255, float32(t.data[i+1]) / 255,
+			float32(t.data[i+2]) / 255, float32(t.data[i+3]) / 255}
+	}
+	return
+}
+
+// Sample the texture at normalized coordinates, like texture2D with
+// CLAMP_TO_EDGE wrapping
+func (t *Texture) sample(u, v float32) [4]float32 {
+	fx, fy := u*float32(t.width), v*float32(t.height)
+	if !t.filter {
+		return t.texel(int32(math.Floor(float64(fx))), int32(math.Floor(float64(fy))))
+	}
+	fx, fy = fx-0.5, fy-0.5
+	x0, y0 := int32(math.Floor(float64(fx))), int32(math.Floor(float64(fy)))
+	ax, ay := fx-float32(x0), fy-float32(y0)
+	c00, c10 := t.texel(x0, y0), t.texel(x0+1, y0)
+	c01, c11 := t.texel(x0, y0+1), t.texel(x0+1, y0+1)
+	var c [4]float32
+	for i := range c {
+		top := c00[i] + (c10[i]-c00[i])*ax
+		bot := c01[i] + (c11[i]-c01[i])*ax
+		c[i] = top + (bot-top)*ay
+	}
+	return c
+}
+
+// ------------------------------------------------------------------
+// Renderer
+
+// Vertex positions are snapped to a 1/256 pixel grid so that edges shared
+// by two triangles are evaluated exactly
+const softSubpixel = 256
+
+type softVertex struct {
+	x, y int64   // Window coordinates in subpixel units
+	w    float32 // Clip w
+	u, v float32
+}
+
+type Renderer struct {
+	// Framebuffer with premultiplied RGBA8 pixels, top row first
+	width, height int32
+	fb            []uint8
+	// Blending state
+	eq       BlendEquation
+	src, dst BlendFunc
+	// Scissor rectangle in framebuffer coordinates
+	scissor    [4]int32
+	useScissor bool
+	// Sprite shader state
+	uniformI map[string]int
+	uniformF map[string][]float32
+	textures map[string]*Texture
+	vertices []float32
+}
+
+func (r *Renderer) Init() {
+	sys.errLog.Printf("Using software renderer")
+	r.width, r.height = sys.scrrect[2], sys.scrrect[3]
+	r.fb = make([]uint8, 4*r.width*r.height)
+	r.uniformI = make(map[string]int)
+	r.uniformF = make(map[string][]float32)
+	r.textures = make(map[string]*Texture)
+}
+
+func (r *Renderer) Close() {
+}
+
+func (r *Renderer) BeginFrame(clearColor bool) {
+	nextTickCount()
+	if clearColor {
+		for i := range r.fb {
+			r.fb[i] = 0
+		}
+	}
+}
+
+func (r *Renderer) EndFrame() {
+}
+
+// Image returns a copy of the current framebuffer contents
+func (r *Renderer) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(r.width), int(r.height)))
+	copy(img.Pix, r.fb)
+	return img
+}
+
+func (r *Renderer) SetPipeline(eq BlendEquation, src, dst BlendFunc) {
+	r.eq, r.src, r.dst = eq, src, dst
+}
+
+func (r *Renderer) ReleasePipeline() {
+}
+
+func (r *Renderer) SetModelPipeline(eq BlendEquation, src, dst BlendFunc, depthTest, depthMask, doubleSided, invertFrontFace, useUV, useVertColor, useJoint0, useJoint1 bool, numVertices, vertAttrOffset uint32) {
+}
+
+func (r *Renderer) ReleaseModelPipeline() {
+}
+
+func (r *Renderer) SetModelMorphTarget(offsets [8]uint32, weights [8]float32, positionTargetCount, uvTargetCount int) {
+}
+
+// Copy the framebuffer into data bottom row first, matching glReadPixels
+func (r *Renderer) ReadPixels(data []uint8, width, height int) {
+	w, h := Min(int32(width), r.width), Min(int32(height), r.height)
+	for y := int32(0); y < h; y++ {
+		src := r.fb[4*(r.height-1-y)*r.width:]
+		copy(data[4*int32(width)*y:], src[:4*w])
+	}
+}
+
+func (r *Renderer) Scissor(x, y, width, height int32) {
+	r.scissor = [4]int32{x, y, width, height}
+	r.useScissor = true
+}
+
+func (r *Renderer) DisableScissor() {
+	r.useScissor = false
+}
+
+func (r *Renderer) SetUniformI(name string, val int) {
+	r.uniformI[name] = val
+}
+
+func (r *Renderer) SetUniformF(name string, values ...float32) {
+	r.uniformF[name] = append([]float32{}, values...)
+}
+
+func (r *Renderer) SetUniformFv(name string, values []float32) {
+	r.uniformF[name] = append([]float32{}, values...)
+}
+
+func (r *Renderer) SetUniformMatrix(name string, value []float32) {
+	r.uniformF[name] = append([]float32{}, value...)
+}
+
+func (r *Renderer) SetTexture(name string, t *Texture) {
+	r.textures[name] = t
+}
+
+func (r *Renderer) SetModelUniformI(name string, val int) {
+}
+
+func (r *Renderer) SetModelUniformF(name string, values ...float32) {
+}
+
+func (r *Renderer) SetModelUniformFv(name string, values []float32) {
+}
+
+func (r *Renderer) SetModelUniformMatrix(name string, value []float32) {
+}
+
+func (r *Renderer) SetModelTexture(name string, t *Texture) {
+}
+
+func (r *Renderer) SetVertexData(values ...float32) {
+	r.vertices = append(r.vertices[:0], values...)
+}
+
+func (r *Renderer) SetStageVertexData(values []byte) {
+}
+
+func (r *Renderer) SetStageIndexData(values ...uint32) {
+}
+
+func (r *Renderer) RenderElements(mode PrimitiveMode, count, offset int) {
+}
+
+func (r *Renderer) ClearDepth() {
+}
+
+// Rasterize the current vertex data as a 4 vertex triangle strip
+func (r *Renderer) RenderQuad() {
+	v, ok := r.transformVertices(4)
+	if !ok {
+		return
+	}
+	r.rasterize(v[0], v[1], v[2])
+	r.rasterize(v[2], v[1], v[3])
+}
+
+// Rasterize the current vertex data as a list of triangles
+func (r *Renderer) RenderTriangles(count int) {
+	v, ok := r.transformVertices(count)
+	if !ok {
+		return
+	}
+	for i := 0; i+2 < len(v); i += 3 {
+		r.rasterize(v[i], v[i+1], v[i+2])
+	}
+}
+
+// Project the first count vertices to window coordinates
+func (r *Renderer) transformVertices(count int) ([]softVertex, bool) {
+	if len(r.vertices) < count*4 {
+		return nil, false
+	}
+	mvp := r.matrix("projection").Mul4(r.matrix("modelview"))
+	v := make([]softVertex, count)
+	for i := range v {
+		p := mvp.Mul4x1(mgl.Vec4{r.vertices[i*4], r.vertices[i*4+1], 0, 1})
+		if p[3] == 0 {
+			return nil, false
+		}
+		v[i] = softVertex{
+			x: softSnap((p[0]/p[3] + 1) / 2 * float32(r.width)),
+			y: softSnap((1 - (p[1]/p[3]+1)/2) * float32(r.height)),
+			w: p[3],
+			u: r.vertices[i*4+2],
+			v: r.vertices[i*4+3],
+		}
+	}
+	return v, true
+}
+
+func (r *Renderer) matrix(name string) mgl.Mat4 {
+	var m mgl.Mat4
+	if f := r.uniformF[name]; len(f) == 16 {
+		copy(m[:], f)
+	} else {
+		m = mgl.Ident4()
+	}
+	return m
+}
+
+func (r *Renderer) uniformVec(name string, def ...float32) []float32 {
+	if f := r.uniformF[name]; len(f) >= len(def) {
+		return f
+	}
+	return def
+}
+
+// Draw one triangle with perspective-correct texture coordinates
+func (r *Renderer) rasterize(a, b, c softVertex) {
+	area := edgeFunc(a.x, a.y, b.x, b.y, c.x, c.y)
+	if area == 0 {
+		return
+	}
+	if area < 0 {
+		b, c = c, b
+		area = -area
+	}
+	x0, y0, x1, y1 := int32(0), int32(0), r.width, r.height
+	if r.useScissor {
+		x0, y0 = Max(x0, r.scissor[0]), Max(y0, r.scissor[1])
+		x1, y1 = Min(x1, r.scissor[0]+r.scissor[2]), Min(y1, r.scissor[1]+r.scissor[3])
+	}
+	minX := Max(x0, int32(min(a.x, b.x, c.x)/softSubpixel))
+	minY := Max(y0, int32(min(a.y, b.y, c.y)/softSubpixel))
+	maxX := Min(x1-1, int32(max(a.x, b.x, c.x)/softSubpixel))
+	maxY := Min(y1-1, int32(max(a.y, b.y, c.y)/softSubpixel))
+	for py := minY; py <= maxY; py++ {
+		sy := int64(py)*softSubpixel + softSubpixel/2
+		for px := minX; px <= maxX; px++ {
+			sx := int64(px)*softSubpixel + softSubpixel/2
+			w0 := edgeFunc(b.x, b.y, c.x, c.y, sx, sy)
+			w1 := edgeFunc(c.x, c.y, a.x, a.y, sx, sy)
+			w2 := edgeFunc(a.x, a.y, b.x, b.y, sx, sy)
+			if !edgeCovers(w0, b, c) || !edgeCovers(w1, c, a) || !edgeCovers(w2, a, b) {
+				continue
+			}
+			// Interpolate uv / w and 1 / w for perspective correction
+			l0 := float32(w0) / float32(area) / a.w
+			l1 := float32(w1) / float32(area) / b.w
+			l2 := float32(w2) / float32(area) / c.w
+			iw := l0 + l1 + l2
+			u := (l0*a.u + l1*b.u + l2*c.u) / iw
+			v := (l0*a.v + l1*b.v + l2*c.v) / iw
+			r.blend(px, py, r.shade(float32(px)+0.5, float32(r.height-py)-0.5, u, v))
+		}
+	}
+}
+
+// Convert a window coordinate to subpixel units, clamped well outside the
+// screen so the edge functions cannot overflow
+func softSnap(f float32) int64 {
+	return int64(math.Round(float64(ClampF(f, -1<<20, 1<<20) * softSubpixel)))
+}
+
+func edgeFunc(ax, ay, bx, by, px, py int64) int64 {
+	return (bx-ax)*(py-ay) - (by-ay)*(px-ax)
+}
+
+// Top-left fill rule, so pixels on edges shared by the two triangles of a
+// quad are only drawn once
+func edgeCovers(w int64, a, b softVertex) bool {
+	if w != 0 {
+		return w > 0
+	}
+	return (a.y == b.y && b.x < a.x) || b.y < a.y
+}
+
+// CPU port of shaders/sprite.frag.glsl. fragX and fragY are window
+// coordinates with the origin at the bottom left, like gl_FragCoord.
+func (r *Renderer) shade(fragX, fragY, u, v float32) [4]float32 {
+	tint := r.uniformVec("tint", 0, 0, 0, 0)
+	if r.uniformI["isFlat"] != 0 {
+		return [4]float32{tint[0], tint[1], tint[2], tint[3]}
+	}
+	tex := r.textures["tex"]
+	if tex == nil || !tex.IsValid() {
+		return [4]float32{}
+	}
+	if r.uniformI["isTrapez"] != 0 {
+		x := r.uniformVec("x1x2x4x3", 0, 0, 0, 0)
+		rect := r.uniformVec("uvRect", 0, 0, 1, 1)
+		lv := v
+		if rect[3] != 0 {
+			lv = (v - rect[1]) / rect[3]
+		}
+		left := x[2] + (x[0]-x[2])*lv
+		right := x[3] + (x[1]-x[3])*lv
+		if right != left {
+			u = rect[0] + rect[2]*(fragX-left)/(right-left)
+		}
+	}
+	c := tex.sample(u, v)
+	alpha := r.uniformVec("alpha", 1)[0]
+	add := r.uniformVec("add", 0, 0, 0)
+	mult := r.uniformVec("mult", 1, 1, 1)
+	negBase := [3]float32{1, 1, 1}
+	finalAdd := [3]float32{add[0], add[1], add[2]}
+	finalMul := [4]float32{mult[0], mult[1], mult[2], alpha}
+	mask := r.uniformI["mask"]
+	if r.uniformI["isRgba"] != 0 {
+		if mask == -1 {
+			c[3] = 1
+		}
+		for i := 0; i < 3; i++ {
+			negBase[i] *= c[3]
+			finalAdd[i] *= c[3]
+			finalMul[i] *= alpha
+		}
+	} else {
+		pal := r.textures["pal"]
+		if pal == nil || !pal.IsValid() {
+			return [4]float32{}
+		}
+		c = pal.texel(int32(c[0]*0.9966*float32(pal.width)), 0)
+		if mask == -1 {
+			c[3] = 1
+		}
+	}
+	if hue := r.uniformVec("hue", 0)[0]; hue != 0 {
+		c = softHueShift(c, hue)
+	}
+	if r.uniformI["neg"] != 0 {
+		for i := 0; i < 3; i++ {
+			c[i] = negBase[i] - c[i]
+		}
+	}
+	gray := r.uniformVec("gray", 0)[0]
+	avg := (c[0] + c[1] + c[2]) / 3
+	for i := 0; i < 3; i++ {
+		c[i] = c[i] + (avg-c[i])*gray + finalAdd[i]
+	}
+	for i := range c {
+		c[i] *= finalMul[i]
+	}
+	// Final tint for shadows, keeping premultiplied alpha
+	for i := 0; i < 3; i++ {
+		c[i] = c[i] + (tint[i]*c[3]-c[i])*tint[3]
+	}
+	return c
+}
+
+func softHueShift(c [4]float32, dhue float32) [4]float32 {
+	s, co := float32(math.Sin(float64(dhue))), float32(math.Cos(float64(dhue)))
+	cols := [3][3]float32{
+		{0.167444, 0.329213, -0.496657},
+		{-0.327948, 0.035669, 0.292279},
+		{1.250268, -1.047561, -0.202707},
+	}
+	luma := (0.299*c[0] + 0.587*c[1] + 0.114*c[2]) * (1 - co)
+	var out [4]float32
+	for j := 0; j < 3; j++ {
+		dot := c[0]*s*cols[j][0] + c[1]*s*cols[j][1] + c[2]*s*cols[j][2]
+		out[j] = c[j]*co + dot + luma
+	}
+	out[3] = c[3]
+	return out
+}
+
+func softBlendFactor(f BlendFunc, srcAlpha float32) float32 {
+	switch f {
+	case BlendZero:
+		return 0
+	case BlendSrcAlpha:
+		return srcAlpha
+	case BlendOneMinusSrcAlpha:
+		return 1 - srcAlpha
+	}
+	return 1
+}
+
+// Blend a fragment into the framebuffer using the current pipeline
+func (r *Renderer) blend(x, y int32, c [4]float32) {
+	for i := range c {
+		c[i] = ClampF(c[i], 0, 1)
+	}
+	sf, df := softBlendFactor(r.src, c[3]), softBlendFactor(r.dst, c[3])
+	p := r.fb[4*(y*r.width+x):]
+	for i := 0; i < 4; i++ {
+		d := float32(p[i]) / 255
+		var res float32
+		if r.eq == BlendReverseSubtract {
+			res = d*df - c[i]*sf
+		} else {
+			res = c[i]*sf + d*df
+		}
+		p[i] = uint8(ClampF(res, 0, 1)*255 + 0.5)
+	}
+}
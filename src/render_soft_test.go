@@ -0,0 +1,275 @@
+//go:build soft
+
+package main
+
+import (
+	"flag"
+	"image"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	mgl "github.com/go-gl/mathgl/mgl32"
+)
+
+// Golden tests for the software renderer. Each case draws a small scene and
+// compares the framebuffer with a reference image in testdata/soft. Run
+// "go test -tags=soft,headless -run TestSoftRenderer -update" to rewrite the
+// images after an intended change to the output.
+
+var updateGolden = flag.Bool("update", false, "rewrite the reference images of the golden tests")
+
+const softTestWidth, softTestHeight = 32, 24
+
+// 8x8 paletted sprite: a frame of index 1, a diagonal of index 2, index 3
+// elsewhere and a transparent corner of index 0
+func softTestSprite() *Texture {
+	px := make([]byte, 64)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			switch {
+			case x < 2 && y < 2:
+				px[y*8+x] = 0
+			case x == 0 || y == 0 || x == 7 || y == 7:
+				px[y*8+x] = 1
+			case x == y:
+				px[y*8+x] = 2
+			default:
+				px[y*8+x] = 3
+			}
+		}
+	}
+	t := newTexture(8, 8, 8, false)
+	t.SetData(px)
+	return t
+}
+
+func softTestPalette(colors ...[4]byte) *Texture {
+	data := make([]byte, 256*4)
+	for i, c := range colors {
+		copy(data[i*4:], c[:])
+	}
+	t := newTexture(256, 1, 32, false)
+	t.SetData(data)
+	return t
+}
+
+var softTestPal1 = [][4]byte{{0, 0, 0, 0}, {255, 255, 255, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}}
+var softTestPal2 = [][4]byte{{0, 0, 0, 0}, {0, 0, 0, 255}, {255, 255, 0, 255}, {0, 160, 0, 255}}
+
+// Draws tex with its top left corner at x, y in screen pixels, scaled by sx
+// and sy, the same way drawQuads feeds the sprite shader
+func softTestDraw(r *Renderer, tex, pal *Texture, x, y, sx, sy float32,
+	eq BlendEquation, src, dst BlendFunc, alpha float32) {
+	proj := mgl.Ortho(0, softTestWidth, 0, softTestHeight, -65535, 65535)
+	mv := mgl.Translate3D(0, softTestHeight, 0)
+	r.SetPipeline(eq, src, dst)
+	r.SetUniformMatrix("projection", proj[:])
+	r.SetUniformMatrix("modelview", mv[:])
+	r.SetTexture("tex", tex)
+	r.SetTexture("pal", pal)
+	r.SetUniformI("isRgba", 0)
+	r.SetUniformI("isFlat", 0)
+	r.SetUniformI("mask", 0)
+	r.SetUniformF("alpha", alpha)
+	r.SetUniformFv("mult", []float32{1, 1, 1})
+	r.SetUniformFv("add", []float32{0, 0, 0})
+	r.SetUniformFv("tint", []float32{0, 0, 0, 0})
+	x1, y1 := x, -y-float32(tex.height)*sy
+	x2, y2 := x+float32(tex.width)*sx, -y
+	r.SetVertexData(
+		x2, y1, 1, 1,
+		x2, y2, 1, 0,
+		x1, y1, 0, 1,
+		x1, y2, 0, 0)
+	r.RenderQuad()
+	r.ReleasePipeline()
+}
+
+// Fills the whole screen with a flat color
+func softTestFill(r *Renderer, c [4]float32) {
+	proj := mgl.Ortho(0, softTestWidth, 0, softTestHeight, -65535, 65535)
+	mv := mgl.Ident4()
+	r.SetPipeline(BlendAdd, BlendOne, BlendZero)
+	r.SetUniformMatrix("projection", proj[:])
+	r.SetUniformMatrix("modelview", mv[:])
+	r.SetUniformI("isFlat", 1)
+	r.SetUniformFv("tint", c[:])
+	r.SetVertexData(
+		softTestWidth, 0, 1, 1,
+		softTestWidth, softTestHeight, 1, 0,
+		0, 0, 0, 1,
+		0, softTestHeight, 0, 0)
+	r.RenderQuad()
+	r.SetUniformI("isFlat", 0)
+	r.ReleasePipeline()
+}
+
+// Parameters to draw tex through RenderSprite with its top left corner at
+// x, y, the way Animation.Draw sets them up
+func softTestParams(tex, pal *Texture, x, y, scale float32) RenderParams {
+	return RenderParams{tex: tex, paltex: pal, size: [2]uint16{uint16(tex.width), uint16(tex.height)},
+		x: -x, y: -y, tile: notiling, xts: scale, xbs: scale, ys: scale, vs: 1, xas: 1, yas: 1,
+		trans: 255, window: &sys.scrrect}
+}
+
+// PalFX with the given effects and no others
+func softTestPalFX(set func(pf *PalFX)) *PalFX {
+	pf := newPalFX()
+	pf.clear()
+	pf.enable, pf.eColor, pf.eMul = true, 1, pf.mul
+	set(pf)
+	return pf
+}
+
+// Loads the test character as the first entry of the select screen, the
+// same way the loader does for a match
+func softTestChar(t *testing.T) *Char {
+	const def = "testdata/char/test.def"
+	sys.sel = *newSelect()
+	sys.sel.addChar(def)
+	c := newChar(0, 0)
+	if err := c.load(def); err != nil {
+		t.Fatal(err)
+	}
+	sys.runMainThreadTask()
+	c.gi().palno = 1
+	c.loadPalette()
+	return c
+}
+
+func TestSoftRenderer(t *testing.T) {
+	scrrect, oldGfx, sel, cgi := sys.scrrect, gfx, sys.sel, sys.cgi[0]
+	sys.scrrect = [4]int32{0, 0, softTestWidth, softTestHeight}
+	defer func() { sys.scrrect, gfx, sys.sel, sys.cgi[0] = scrrect, oldGfx, sel, cgi }()
+	char := softTestChar(t)
+	spr := softTestSprite()
+	pal1, pal2 := softTestPalette(softTestPal1...), softTestPalette(softTestPal2...)
+	gray := [4]float32{0.5, 0.5, 0.5, 1}
+	cases := []struct {
+		name string
+		draw func(r *Renderer)
+	}{
+		{"blit", func(r *Renderer) {
+			softTestDraw(r, spr, pal1, 4, 4, 1, 1, BlendAdd, BlendOne, BlendOneMinusSrcAlpha, 1)
+		}},
+		{"palette", func(r *Renderer) {
+			softTestDraw(r, spr, pal1, 4, 4, 1, 1, BlendAdd, BlendOne, BlendOneMinusSrcAlpha, 1)
+			softTestDraw(r, spr, pal2, 18, 4, 1, 1, BlendAdd, BlendOne, BlendOneMinusSrcAlpha, 1)
+		}},
+		{"blend_add", func(r *Renderer) {
+			softTestFill(r, gray)
+			softTestDraw(r, spr, pal1, 4, 4, 1, 1, BlendAdd, BlendSrcAlpha, BlendOne, 1)
+		}},
+		{"blend_sub", func(r *Renderer) {
+			softTestFill(r, gray)
+			softTestDraw(r, spr, pal1, 4, 4, 1, 1, BlendReverseSubtract, BlendSrcAlpha, BlendOne, 1)
+		}},
+		{"blend_alpha", func(r *Renderer) {
+			softTestFill(r, gray)
+			softTestDraw(r, spr, pal1, 4, 4, 1, 1, BlendAdd, BlendSrcAlpha, BlendOne, 0.5)
+			softTestDraw(r, spr, pal2, 14, 8, 1, 1, BlendAdd, BlendOne, BlendOneMinusSrcAlpha, 1)
+		}},
+		{"scale", func(r *Renderer) {
+			softTestDraw(r, spr, pal1, 1, 1, 2, 2, BlendAdd, BlendOne, BlendOneMinusSrcAlpha, 1)
+			softTestDraw(r, spr, pal2, 18, 2, 1.5, 0.5, BlendAdd, BlendOne, BlendOneMinusSrcAlpha, 1)
+		}},
+		{"palfx_add", func(r *Renderer) {
+			rp := softTestParams(spr, pal1, 4, 4, 2)
+			rp.pfx = softTestPalFX(func(pf *PalFX) { pf.eAdd = [3]int32{0, 96, -128} })
+			RenderSprite(rp)
+		}},
+		{"palfx_mul", func(r *Renderer) {
+			rp := softTestParams(spr, pal1, 4, 4, 2)
+			rp.pfx = softTestPalFX(func(pf *PalFX) { pf.eMul = [3]int32{256, 128, 64} })
+			RenderSprite(rp)
+		}},
+		{"palfx_color", func(r *Renderer) {
+			rp := softTestParams(spr, pal1, 4, 4, 2)
+			rp.pfx = softTestPalFX(func(pf *PalFX) { pf.eColor = 0.25 })
+			RenderSprite(rp)
+		}},
+		{"palfx_hue", func(r *Renderer) {
+			rp := softTestParams(spr, pal1, 4, 4, 2)
+			rp.pfx = softTestPalFX(func(pf *PalFX) { pf.eHue = 0.5 })
+			RenderSprite(rp)
+		}},
+		{"window", func(r *Renderer) {
+			rp := softTestParams(spr, pal1, 4, 4, 2)
+			rp.window = &[4]int32{8, 6, 14, 8}
+			RenderSprite(rp)
+		}},
+		{"tile", func(r *Renderer) {
+			rp := softTestParams(spr, pal1, 3, 2, 1)
+			rp.tile = Tiling{x: 1, y: 1, sx: 10, sy: 9}
+			RenderSprite(rp)
+		}},
+		{"char", func(r *Renderer) {
+			stand, punch := char.gi().anim.get(0), char.gi().anim.get(200)
+			stand.SetAnimElem(1)
+			punch.SetAnimElem(3)
+			// The second one faces left, which flips the horizontal scale
+			stand.Draw(&sys.scrrect, 8, 22, 1, 1, 0.5, 0.5, 0.5, 0, Rotation{}, 0, nil, false, 1, false, 1, 0, 0)
+			punch.Draw(&sys.scrrect, 26, 22, 1, 1, -0.5, -0.5, 0.5, 0, Rotation{}, 0, nil, false, -1, false, 1, 0, 0)
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Renderer{}
+			r.Init()
+			gfx = r
+			r.BeginFrame(true)
+			c.draw(r)
+			r.EndFrame()
+			softTestCompare(t, r.Image(), filepath.Join("testdata", "soft", c.name+".png"))
+		})
+	}
+}
+
+// The reference images hold the framebuffer bytes as they are, so pixels
+// that are not valid premultiplied colors, like the ones left by subtractive
+// blending, survive the round trip through PNG
+func softTestCompare(t *testing.T, got *image.RGBA, path string) {
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, &image.NRGBA{Pix: got.Pix, Stride: got.Stride, Rect: got.Rect}); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != got.Bounds() {
+		t.Fatalf("size is %v, want %v", got.Bounds(), img.Bounds())
+	}
+	// Opaque images are stored without an alpha channel
+	ref, ok := img.(*image.NRGBA)
+	if !ok {
+		ref = image.NewNRGBA(img.Bounds())
+		draw.Draw(ref, ref.Rect, img, image.Point{}, draw.Src)
+	}
+	// Allow a difference of one level per channel for rounding of the
+	// floating point math on other architectures
+	for i := range got.Pix {
+		if d := int(got.Pix[i]) - int(ref.Pix[i]); d < -1 || d > 1 {
+			x, y := i%got.Stride/4, i/got.Stride
+			t.Fatalf("pixel %v,%v is %v, want %v", x, y, got.Pix[i&^3:i&^3+4], ref.Pix[i&^3:i&^3+4])
+		}
+	}
+}
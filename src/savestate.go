@@ -0,0 +1,651 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// Training savestates. A snapshot is a deep copy of everything the fight
+// simulation reads and writes: characters and helpers with their command
+// buffers, projectiles, explods, the stage and its background controllers,
+// the camera, the lifebar, the timers and the RNG seed. Loaded assets (sprites,
+// sounds, fonts, compiled states) are immutable during a match and are shared
+// with the snapshot instead of copied.
+
+const savestateDir = "save/training"
+
+// Per player runtime values kept in CharGlobalInfo
+type cgiState struct {
+	wakewakaLength int32
+	pctype         ProjContact
+	pctime, pcid   int32
+	projidcount    int
+	remappedpal    [2]int32
+}
+
+// Simulation state, mirroring the System fields of the same name
+type simState struct {
+	randseed           int32
+	gameTime           int32
+	round              int32
+	intro              int32
+	time               int32
+	lastHitter         [2]int
+	winTeam            int
+	winType            [2]WinType
+	winTrigger         [2]WinType
+	wins               [2]int32
+	roundsExisted      [2]int32
+	draws              int32
+	chars              [MaxSimul*2 + MaxAttachedChar][]*Char
+	charList           CharList
+	cgi                [MaxSimul*2 + MaxAttachedChar]cgiState
+	workingChar        *Char
+	debugWC            *Char
+	specialFlag        GlobalSpecialFlag
+	envShake           EnvShake
+	pause              int32
+	pausetime          int32
+	pausebg            bool
+	pauseendcmdbuftime int32
+	pauseplayer        int
+	super              int32
+	supertime          int32
+	superpausebg       bool
+	superendcmdbuftime int32
+	superplayer        int
+	superdarken        bool
+	superanim          *Animation
+	superpmap          PalFX
+	superpos           [2]float32
+	superfacing        float32
+	superp2defmul      float32
+	envcol             [3]int32
+	envcol_time        int32
+	envcol_under       bool
+	stage              *Stage
+	stageList          map[int32]*Stage
+	nextCharId         int32
+	screenleft         float32
+	screenright        float32
+	xmin, xmax         float32
+	drawScale          float32
+	zoomlag            float32
+	zoomScale          float32
+	zoomPosXLag        float32
+	zoomPosYLag        float32
+	enableZoomtime     int32
+	zoomCameraBound    bool
+	zoomStageBound     bool
+	zoomPos            [2]float32
+	cam                Camera
+	finish             FinishType
+	waitdown           int32
+	slowtime           int32
+	shuttertime        int32
+	fadeintime         int32
+	fadeouttime        int32
+	wintime            int32
+	projs              [MaxSimul*2 + MaxAttachedChar][]Projectile
+	explods            [MaxSimul*2 + MaxAttachedChar][]Explod
+	explodsLayerN1     [MaxSimul*2 + MaxAttachedChar][]int
+	explodsLayer0      [MaxSimul*2 + MaxAttachedChar][]int
+	explodsLayer1      [MaxSimul*2 + MaxAttachedChar][]int
+	allPalFX, bgPalFX  PalFX
+	lifebar            Lifebar
+	aiInput            [MaxSimul*2 + MaxAttachedChar]AiInput
+	autoguard          [MaxSimul*2 + MaxAttachedChar]bool
+	firstAttack        [3]int
+}
+
+func (st *simState) capture(s *System) {
+	*st = simState{randseed: s.randseed, gameTime: s.gameTime, round: s.round,
+		intro: s.intro, time: s.time, lastHitter: s.lastHitter, winTeam: s.winTeam,
+		winType: s.winType, winTrigger: s.winTrigger, wins: s.wins,
+		roundsExisted: s.roundsExisted, draws: s.draws, chars: s.chars,
+		charList: s.charList, workingChar: s.workingChar, debugWC: s.debugWC,
+		specialFlag: s.specialFlag, envShake: s.envShake, pause: s.pause,
+		pausetime: s.pausetime, pausebg: s.pausebg,
+		pauseendcmdbuftime: s.pauseendcmdbuftime, pauseplayer: s.pauseplayer,
+		super: s.super, supertime: s.supertime, superpausebg: s.superpausebg,
+		superendcmdbuftime: s.superendcmdbuftime, superplayer: s.superplayer,
+		superdarken: s.superdarken, superanim: s.superanim, superpmap: s.superpmap,
+		superpos: s.superpos, superfacing: s.superfacing,
+		superp2defmul: s.superp2defmul, envcol: s.envcol, envcol_time: s.envcol_time,
+		envcol_under: s.envcol_under, stage: s.stage, stageList: s.stageList,
+		nextCharId: s.nextCharId, screenleft: s.screenleft,
+		screenright: s.screenright, xmin: s.xmin, xmax: s.xmax,
+		drawScale: s.drawScale, zoomlag: s.zoomlag, zoomScale: s.zoomScale,
+		zoomPosXLag: s.zoomPosXLag, zoomPosYLag: s.zoomPosYLag,
+		enableZoomtime: s.enableZoomtime, zoomCameraBound: s.zoomCameraBound,
+		zoomStageBound: s.zoomStageBound, zoomPos: s.zoomPos, cam: s.cam,
+		finish: s.finish, waitdown: s.waitdown, slowtime: s.slowtime,
+		shuttertime: s.shuttertime, fadeintime: s.fadeintime,
+		fadeouttime: s.fadeouttime, wintime: s.wintime, projs: s.projs,
+		explods: s.explods, explodsLayerN1: s.explodsLayerN1,
+		explodsLayer0: s.explodsLayer0, explodsLayer1: s.explodsLayer1,
+		allPalFX: s.allPalFX, bgPalFX: s.bgPalFX, lifebar: s.lifebar,
+		aiInput: s.aiInput, autoguard: s.autoguard, firstAttack: s.firstAttack}
+	for i := range s.cgi {
+		gi := &s.cgi[i]
+		st.cgi[i] = cgiState{wakewakaLength: gi.wakewakaLength, pctype: gi.pctype,
+			pctime: gi.pctime, pcid: gi.pcid, projidcount: gi.projidcount,
+			remappedpal: gi.remappedpal}
+	}
+}
+
+func (st *simState) apply(s *System) {
+	s.randseed, s.gameTime, s.round, s.intro, s.time = st.randseed, st.gameTime,
+		st.round, st.intro, st.time
+	s.lastHitter, s.winTeam, s.winType, s.winTrigger = st.lastHitter, st.winTeam,
+		st.winType, st.winTrigger
+	s.wins, s.roundsExisted, s.draws = st.wins, st.roundsExisted, st.draws
+	s.chars, s.charList = st.chars, st.charList
+	s.workingChar, s.debugWC = st.workingChar, st.debugWC
+	s.specialFlag, s.envShake = st.specialFlag, st.envShake
+	s.pause, s.pausetime, s.pausebg = st.pause, st.pausetime, st.pausebg
+	s.pauseendcmdbuftime, s.pauseplayer = st.pauseendcmdbuftime, st.pauseplayer
+	s.super, s.supertime, s.superpausebg = st.super, st.supertime, st.superpausebg
+	s.superendcmdbuftime, s.superplayer = st.superendcmdbuftime, st.superplayer
+	s.superdarken, s.superanim, s.superpmap = st.superdarken, st.superanim, st.superpmap
+	s.superpos, s.superfacing, s.superp2defmul = st.superpos, st.superfacing,
+		st.superp2defmul
+	s.envcol, s.envcol_time, s.envcol_under = st.envcol, st.envcol_time, st.envcol_under
+	s.stage, s.stageList, s.nextCharId = st.stage, st.stageList, st.nextCharId
+	s.screenleft, s.screenright, s.xmin, s.xmax = st.screenleft, st.screenright,
+		st.xmin, st.xmax
+	s.drawScale, s.zoomlag, s.zoomScale = st.drawScale, st.zoomlag, st.zoomScale
+	s.zoomPosXLag, s.zoomPosYLag, s.enableZoomtime = st.zoomPosXLag, st.zoomPosYLag,
+		st.enableZoomtime
+	s.zoomCameraBound, s.zoomStageBound, s.zoomPos = st.zoomCameraBound,
+		st.zoomStageBound, st.zoomPos
+	s.cam, s.finish, s.waitdown, s.slowtime = st.cam, st.finish, st.waitdown, st.slowtime
+	s.shuttertime, s.fadeintime, s.fadeouttime, s.wintime = st.shuttertime,
+		st.fadeintime, st.fadeouttime, st.wintime
+	s.projs, s.explods = st.projs, st.explods
+	s.explodsLayerN1, s.explodsLayer0, s.explodsLayer1 = st.explodsLayerN1,
+		st.explodsLayer0, st.explodsLayer1
+	s.allPalFX, s.bgPalFX, s.lifebar = st.allPalFX, st.bgPalFX, st.lifebar
+	s.aiInput, s.autoguard, s.firstAttack = st.aiInput, st.autoguard, st.firstAttack
+	for i := range s.cgi {
+		gi, c := &s.cgi[i], &st.cgi[i]
+		gi.wakewakaLength, gi.pctype, gi.pctime, gi.pcid = c.wakewakaLength,
+			c.pctype, c.pctime, c.pcid
+		gi.projidcount, gi.remappedpal = c.projidcount, c.remappedpal
+	}
+}
+
+// Types that hold loaded data and are shared between the match and its
+// snapshots rather than copied. So are all types from other packages.
+var snapSharedTypes = map[reflect.Type]bool{}
+
+// The engine's own package path, which is not "main" under go test
+var snapPkgPath = reflect.TypeOf(System{}).PkgPath()
+
+func init() {
+	for _, v := range []interface{}{Sff{}, Snd{}, Fnt{}, CharGlobalInfo{},
+		StateBytecode{}, StateBlock{}, stateDef{}, Sprite{}, PaletteList{},
+		Palette{}, Texture{}, TextureAtlas{}, Model{}, CharModel{}, SoundChannels{},
+		SoundEffect{}, Sound{}, FightFx{}, AnimFrame{}} {
+		snapSharedTypes[reflect.TypeOf(v)] = true
+	}
+}
+
+func snapShared(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if pkg := t.PkgPath(); pkg != "" && pkg != snapPkgPath {
+		return true
+	}
+	return snapSharedTypes[t]
+}
+
+// Whether values of t can be copied with a plain assignment
+var snapFlatCache = map[reflect.Type]bool{}
+
+func snapFlat(t reflect.Type) bool {
+	if f, ok := snapFlatCache[t]; ok {
+		return f
+	}
+	snapFlatCache[t] = true // Recursive types are checked through pointers
+	flat := true
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		flat = snapShared(t)
+	case reflect.Array:
+		flat = snapFlat(t.Elem())
+	case reflect.Struct:
+		if !snapShared(t) {
+			for i := 0; i < t.NumField() && flat; i++ {
+				flat = snapFlat(t.Field(i).Type)
+			}
+		}
+	}
+	snapFlatCache[t] = flat
+	return flat
+}
+
+// Fields are accessed through their address so unexported ones can be set.
+func snapAccess(v reflect.Value) reflect.Value {
+	if v.CanAddr() && !v.CanSet() {
+		return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+	}
+	return v
+}
+
+type snapKey struct {
+	t reflect.Type
+	p uintptr
+}
+
+// Deep copier that keeps pointers that alias the same object aliased in the
+// copy, e.g. a helper referenced from its parent and from the char list.
+type snapCopier struct {
+	ptrs map[snapKey]reflect.Value
+	maps map[uintptr]reflect.Value
+}
+
+func newSnapCopier() *snapCopier {
+	return &snapCopier{ptrs: make(map[snapKey]reflect.Value),
+		maps: make(map[uintptr]reflect.Value)}
+}
+
+func (sc *snapCopier) copy(dst, src reflect.Value) {
+	dst, src = snapAccess(dst), snapAccess(src)
+	t := src.Type()
+	if snapFlat(t) {
+		dst.Set(src)
+		return
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(t))
+			return
+		}
+		key := snapKey{t, src.Pointer()}
+		if p, ok := sc.ptrs[key]; ok {
+			dst.Set(p)
+			return
+		}
+		p := reflect.New(t.Elem())
+		sc.ptrs[key] = p
+		sc.copy(p.Elem(), src.Elem())
+		dst.Set(p)
+	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(t))
+			return
+		}
+		s := reflect.MakeSlice(t, src.Len(), src.Cap())
+		for i := 0; i < src.Len(); i++ {
+			sc.copy(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(t))
+			return
+		}
+		if m, ok := sc.maps[src.Pointer()]; ok {
+			dst.Set(m)
+			return
+		}
+		m := reflect.MakeMapWithSize(t, src.Len())
+		sc.maps[src.Pointer()] = m
+		iter := src.MapRange()
+		for iter.Next() {
+			v := reflect.New(t.Elem()).Elem()
+			sc.copy(v, iter.Value())
+			m.SetMapIndex(iter.Key(), v)
+		}
+		dst.Set(m)
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(t))
+			return
+		}
+		v := reflect.New(src.Elem().Type()).Elem()
+		sc.copy(v, src.Elem())
+		dst.Set(v)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			sc.copy(dst.Index(i), src.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < src.NumField(); i++ {
+			sc.copy(dst.Field(i), src.Field(i))
+		}
+	default:
+		dst.Set(src)
+	}
+}
+
+// Hash of a value graph. Shared data hashes by address, everything else by
+// content, so a restored state hashes the same as the state it came from.
+type snapHasher struct {
+	seen map[snapKey]uint64 // Visit order of pointers, to handle cycles
+	buf  [8]byte
+}
+
+func (sh *snapHasher) write(h interface{ Write([]byte) (int, error) }, u uint64) {
+	binary.LittleEndian.PutUint64(sh.buf[:], u)
+	h.Write(sh.buf[:])
+}
+
+func (sh *snapHasher) hash(v reflect.Value) uint64 {
+	h := fnv.New64a()
+	sh.walk(h, v)
+	return h.Sum64()
+}
+
+func (sh *snapHasher) walk(h interface{ Write([]byte) (int, error) }, v reflect.Value) {
+	v = snapAccess(v)
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Bool:
+		sh.write(h, uint64(Btoi(v.Bool())))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		sh.write(h, uint64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr:
+		sh.write(h, v.Uint())
+	case reflect.Float32:
+		sh.write(h, uint64(math.Float32bits(float32(v.Float()))))
+	case reflect.Float64:
+		sh.write(h, math.Float64bits(v.Float()))
+	case reflect.Complex64, reflect.Complex128:
+		sh.write(h, math.Float64bits(real(v.Complex())))
+		sh.write(h, math.Float64bits(imag(v.Complex())))
+	case reflect.String:
+		h.Write([]byte(v.String()))
+		sh.write(h, uint64(v.Len()))
+	case reflect.Ptr:
+		if v.IsNil() {
+			sh.write(h, 0)
+		} else if snapShared(t) {
+			sh.write(h, uint64(v.Pointer()))
+		} else if n, ok := sh.seen[snapKey{t, v.Pointer()}]; ok {
+			sh.write(h, n)
+		} else {
+			sh.seen[snapKey{t, v.Pointer()}] = uint64(len(sh.seen) + 1)
+			sh.write(h, 1)
+			sh.walk(h, v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && snapShared(t) {
+			if v.Len() > 0 {
+				sh.write(h, uint64(v.Pointer()))
+			}
+		}
+		sh.write(h, uint64(v.Len()))
+		if t.Kind() == reflect.Array || !snapShared(t) {
+			for i := 0; i < v.Len(); i++ {
+				sh.walk(h, v.Index(i))
+			}
+		}
+	case reflect.Map:
+		// Entries are visited in the order of their key hashes, as map
+		// iteration order is random
+		sh.write(h, uint64(v.Len()))
+		type entry struct {
+			k uint64
+			v reflect.Value
+		}
+		var entries []entry
+		iter := v.MapRange()
+		for iter.Next() {
+			kh := (&snapHasher{seen: make(map[snapKey]uint64)}).hash(iter.Key())
+			entries = append(entries, entry{kh, iter.Value()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].k < entries[j].k })
+		for _, e := range entries {
+			sh.write(h, e.k)
+			sh.walk(h, e.v)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			sh.write(h, 0)
+		} else {
+			h.Write([]byte(v.Elem().Type().String()))
+			sh.walk(h, v.Elem())
+		}
+	case reflect.Struct:
+		if snapShared(t) {
+			// Shared data held by value, such as sound channels, is output
+			// rather than simulation state
+			return
+		}
+		for i := 0; i < v.NumField(); i++ {
+			sh.walk(h, v.Field(i))
+		}
+	default:
+		// Funcs, channels and unsafe pointers hash by identity
+		if !v.IsNil() {
+			sh.write(h, uint64(v.Pointer()))
+		}
+	}
+}
+
+func (st *simState) checksum() uint64 {
+	return (&snapHasher{seen: make(map[snapKey]uint64)}).hash(reflect.ValueOf(st).Elem())
+}
+
+type SaveState struct {
+	state simState
+	sum   uint64
+	match []string // Stage and character defs the state belongs to
+}
+
+func savestateMatch() []string {
+	m := []string{sys.stage.def}
+	for i := range sys.chars {
+		if len(sys.chars[i]) > 0 {
+			m = append(m, sys.cgi[i].def)
+		} else {
+			m = append(m, "")
+		}
+	}
+	return m
+}
+
+type SaveStates struct {
+	slots map[string]*SaveState
+}
+
+var savestates = &SaveStates{slots: make(map[string]*SaveState)}
+
+// Savestates are only offered in training, outside of netplay and replays.
+func (ss *SaveStates) enabled() bool {
+	return sys.gameMode == "training" && sys.netInput == nil && sys.fileInput == nil
+}
+
+// Copies the running match into the named slot.
+func (ss *SaveStates) Save(name string) (uint64, error) {
+	if !ss.enabled() {
+		return 0, Error("Savestates are only available in training mode")
+	}
+	var live simState
+	live.capture(&sys)
+	s := &SaveState{match: savestateMatch()}
+	newSnapCopier().copy(reflect.ValueOf(&s.state).Elem(), reflect.ValueOf(&live).Elem())
+	s.sum = s.state.checksum()
+	ss.slots[name] = s
+	return s.sum, nil
+}
+
+// Puts the match back in the state stored in the named slot. The slot is
+// copied again, so it can be loaded any number of times. Returns the checksum
+// of the restored match, and an error if it differs from the saved one.
+func (ss *SaveStates) Load(name string) (uint64, error) {
+	if !ss.enabled() {
+		return 0, Error("Savestates are only available in training mode")
+	}
+	s, ok := ss.slots[name]
+	if !ok {
+		return 0, Error(fmt.Sprintf("Savestate not found: %v", name))
+	}
+	if strings.Join(s.match, "\n") != strings.Join(savestateMatch(), "\n") {
+		return 0, Error(fmt.Sprintf("Savestate %v belongs to another match", name))
+	}
+	sys.stopAllSound()
+	var st simState
+	newSnapCopier().copy(reflect.ValueOf(&st).Elem(), reflect.ValueOf(&s.state).Elem())
+	st.apply(&sys)
+	var live simState
+	live.capture(&sys)
+	sum := live.checksum()
+	if sum != s.sum {
+		return sum, Error(fmt.Sprintf("Savestate %v restored with checksum %016x, saved as %016x",
+			name, sum, s.sum))
+	}
+	return sum, nil
+}
+
+func (ss *SaveStates) Delete(name string) {
+	delete(ss.slots, name)
+}
+
+// Forgets all slots, e.g. when the match they belong to ends.
+func (ss *SaveStates) Clear() {
+	ss.slots = make(map[string]*SaveState)
+}
+
+// Checksum of the running match, or of the named slot.
+func (ss *SaveStates) Checksum(name string) (uint64, error) {
+	if name == "" {
+		var live simState
+		live.capture(&sys)
+		return live.checksum(), nil
+	}
+	s, ok := ss.slots[name]
+	if !ok {
+		return 0, Error(fmt.Sprintf("Savestate not found: %v", name))
+	}
+	return s.sum, nil
+}
+
+func (ss *SaveStates) Names() []string {
+	names := make([]string, 0, len(ss.slots))
+	for n := range ss.slots {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Position presets are exported from a savestate and keep the situation
+// rather than the whole simulation: each player's position, velocity,
+// facing, state and resources, plus the round timer. Importing one puts the
+// root characters back in that situation, which works across sessions as
+// long as the same characters are loaded.
+type positionPresetChar struct {
+	Def     string
+	Pos     [3]float32
+	Vel     [3]float32
+	Facing  float32
+	StateNo int32
+	Life    int32
+	RedLife int32
+	Power   int32
+	Dizzy   int32
+	Guard   int32
+}
+
+type positionPreset struct {
+	Stage string
+	Time  int32
+	Chars []positionPresetChar
+}
+
+func positionPresetFileName(file string) string {
+	if filepath.Ext(file) == "" {
+		file += ".json"
+	}
+	if !filepath.IsAbs(file) && !strings.ContainsAny(file, "/\\") {
+		file = filepath.Join(savestateDir, file)
+	}
+	return file
+}
+
+// Writes the position preset of the named slot to a file.
+func (ss *SaveStates) ExportPosition(name, file string) error {
+	s, ok := ss.slots[name]
+	if !ok {
+		return Error(fmt.Sprintf("Savestate not found: %v", name))
+	}
+	data := positionPreset{Time: s.state.time}
+	if s.state.stage != nil {
+		data.Stage = s.state.stage.def
+	}
+	for _, p := range s.state.chars {
+		if len(p) == 0 {
+			continue
+		}
+		c := p[0]
+		data.Chars = append(data.Chars, positionPresetChar{Def: c.gi().def,
+			Pos: c.pos, Vel: c.vel, Facing: c.facing, StateNo: c.ss.no,
+			Life: c.life, RedLife: c.redLife, Power: c.power, Dizzy: c.dizzyPoints,
+			Guard: c.guardPoints})
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	file = positionPresetFileName(file)
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+// Puts the root characters in the position preset stored in a file, then
+// saves the result into the named slot.
+func (ss *SaveStates) ImportPosition(file, name string) error {
+	if !ss.enabled() {
+		return Error("Savestates are only available in training mode")
+	}
+	b, err := os.ReadFile(positionPresetFileName(file))
+	if err != nil {
+		return err
+	}
+	var data positionPreset
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	i := 0
+	for _, p := range sys.chars {
+		if len(p) == 0 {
+			continue
+		}
+		if i >= len(data.Chars) {
+			break
+		}
+		d, c := data.Chars[i], p[0]
+		i++
+		if d.Def != c.gi().def {
+			sys.errLog.Printf("Position preset %v was exported with %v, loading it on %v",
+				file, d.Def, c.gi().def)
+		}
+		c.setPosX(d.Pos[0])
+		c.setPosY(d.Pos[1])
+		c.setPosZ(d.Pos[2])
+		c.vel, c.facing = d.Vel, d.Facing
+		c.life, c.redLife, c.power = d.Life, d.RedLife, d.Power
+		c.dizzyPoints, c.guardPoints = d.Dizzy, d.Guard
+		c.changeState(d.StateNo, -1, -1, "")
+	}
+	sys.time = data.Time
+	_, err = ss.Save(name)
+	return err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func savestateTestSnapshot(s *System) simState {
+	var live, snap simState
+	live.capture(s)
+	newSnapCopier().copy(reflect.ValueOf(&snap).Elem(), reflect.ValueOf(&live).Elem())
+	return snap
+}
+
+func TestSaveStateRestore(t *testing.T) {
+	s := &System{}
+	root, helper := &Char{}, &Char{}
+	root.pos, root.life, root.children = [3]float32{10, 0, 0}, 1000, []*Char{helper}
+	helper.pos, helper.life, helper.helperIndex = [3]float32{20, -5, 0}, 1, 1
+	s.chars[0] = []*Char{root, helper}
+	s.time, s.randseed, s.workingChar = 99, 42, helper
+
+	snap := savestateTestSnapshot(s)
+	sum := snap.checksum()
+	if snap.chars[0][0] == root || snap.chars[0][1] == helper {
+		t.Fatal("the snapshot shares characters with the match")
+	}
+
+	// Play on, then restore
+	root.pos[0], root.life = 50, 10
+	helper.pos[1] = 0
+	s.chars[0] = s.chars[0][:1]
+	s.time, s.randseed, s.workingChar = 5, 7, root
+	if live := savestateTestSnapshot(s); live.checksum() == sum {
+		t.Fatal("the checksum did not change with the match")
+	}
+	var st simState
+	newSnapCopier().copy(reflect.ValueOf(&st).Elem(), reflect.ValueOf(&snap).Elem())
+	st.apply(s)
+
+	if s.time != 99 || s.randseed != 42 {
+		t.Errorf("time %v and seed %v, want 99 and 42", s.time, s.randseed)
+	}
+	if len(s.chars[0]) != 2 {
+		t.Fatalf("%v characters, want 2", len(s.chars[0]))
+	}
+	r, h := s.chars[0][0], s.chars[0][1]
+	if r.pos != [3]float32{10, 0, 0} || r.life != 1000 {
+		t.Errorf("root at %v with %v life, want at [10 0 0] with 1000", r.pos, r.life)
+	}
+	if h.pos != [3]float32{20, -5, 0} || h.helperIndex != 1 {
+		t.Errorf("helper %v at %v, want 1 at [20 -5 0]", h.helperIndex, h.pos)
+	}
+	if len(r.children) != 1 || r.children[0] != h || s.workingChar != h {
+		t.Error("the helper is no longer shared by the char list, its parent and workingChar")
+	}
+	// The slot can be loaded again after the match changed the restored copy
+	r.life = 0
+	if snap.chars[0][0].life != 1000 {
+		t.Error("restoring shares characters with the snapshot")
+	}
+	r.life = 1000
+	if live := savestateTestSnapshot(s); live.checksum() != sum {
+		t.Errorf("checksum %016x after restoring, want %016x", live.checksum(), sum)
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Scenario tests. A scenario file sets up a quick match, feeds scripted
+// inputs to the players and checks trigger expressions at given frames, for
+// example:
+//
+//	p1 = kfm
+//	p2 = kfm
+//	stage = stages/stage0.def
+//	p1: 236a at frame 10
+//	p2: 4 from frame 0 to 60
+//	expect p1: stateno = 1000 at frame 14
+//	expect p2: life < lifemax from frame 30 to 40
+//
+// Inputs use numpad notation relative to the facing of the character (6 is
+// forward, 5 is neutral). Each direction is held for one frame, and buttons
+// written after a direction are pressed on the next frame while it is still
+// held, so 236a is 2, 3, 6 and 6+a on four consecutive frames. Buttons
+// written together or joined with + are pressed together. Frame 0 is the
+// first frame of the round after the intro. The results are printed to
+// stderr and the exit status is 1 if any expectation failed. The lifebar
+// can be set with "lifebar = <fight.def>".
+//
+// A headless build (soft and headless tags) runs the match without a
+// window, renderer, sound or Lua scripts, as fast as it can. Other builds
+// run it in a hidden window through the normal game loop, which needs a
+// display even on a server. testdata/scenario has a scenario the tests
+// run, the ones in external/scenarios are examples for the Kung Fu Man and
+// stage0 of a full game folder.
+
+const scenarioExt = ".scenario"
+
+var scenarioSetting = regexp.MustCompile(`^(p[0-9]+(\.[A-Za-z]+)?|stage|time|tmode[12]|lifebar)$`)
+
+var scenarioDirs = [...]InputBits{
+	1: IB_PD | IB_PL, 2: IB_PD, 3: IB_PD | IB_PR,
+	4: IB_PL, 5: 0, 6: IB_PR,
+	7: IB_PU | IB_PL, 8: IB_PU, 9: IB_PU | IB_PR}
+
+var scenarioButtons = map[byte]InputBits{'a': IB_A, 'b': IB_B, 'c': IB_C,
+	'x': IB_X, 'y': IB_Y, 'z': IB_Z, 's': IB_S, 'd': IB_D, 'w': IB_W, 'm': IB_M}
+
+type scenarioExpect struct {
+	line     int
+	playerNo int
+	text     string
+	expr     *debugExpr
+	from, to int
+	failure  string
+}
+
+type Scenario struct {
+	file     string
+	active   bool
+	inputs   [MaxSimul * 2][]InputBits // Back is IB_PL and forward IB_PR
+	drive    [MaxSimul * 2]bool
+	expects  []*scenarioExpect
+	frame    int // -1 until the round starts
+	last     int
+	finished bool
+}
+
+var scenario = &Scenario{frame: -1}
+
+// Parses "at frame <n>" or "from frame <n> to <m>" at the end of s.
+func parseScenarioFrames(s string) (rest string, from, to int, err error) {
+	if i := strings.LastIndex(s, " at frame "); i >= 0 {
+		if from, err = strconv.Atoi(strings.TrimSpace(s[i+10:])); err == nil && from >= 0 {
+			return strings.TrimSpace(s[:i]), from, from, nil
+		}
+	} else if i := strings.LastIndex(s, " from frame "); i >= 0 {
+		f := strings.Fields(s[i+12:])
+		if len(f) == 3 && f[1] == "to" {
+			from, err = strconv.Atoi(f[0])
+			if err == nil {
+				to, err = strconv.Atoi(f[2])
+			}
+			if err == nil && from >= 0 && to >= from {
+				return strings.TrimSpace(s[:i]), from, to, nil
+			}
+		}
+	}
+	return s, 0, 0, Error("Expected \"at frame <n>\" or \"from frame <n> to <m>\"")
+}
+
+// Parses "p<n>:" at the start of s and returns the player index.
+func parseScenarioPlayer(s string) (rest string, pn int, err error) {
+	i := strings.Index(s, ":")
+	if i < 2 || s[0] != 'p' {
+		return s, 0, Error("Expected \"p<n>:\"")
+	}
+	n, err := strconv.Atoi(s[1:i])
+	if err != nil || n < 1 || n > MaxSimul*2 {
+		return s, 0, Error("Invalid player: " + s[:i])
+	}
+	return strings.TrimSpace(s[i+1:]), n - 1, nil
+}
+
+// Converts an input in numpad notation to one InputBits per frame.
+func parseScenarioInput(s string) ([]InputBits, error) {
+	var steps []InputBits
+	var dir InputBits
+	join, afterDir := false, false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= '1' && ch <= '9' {
+			dir = scenarioDirs[ch-'0']
+			steps = append(steps, dir)
+			join, afterDir = false, true
+		} else if ch == '+' && len(steps) > 0 {
+			join = true
+		} else if b, ok := scenarioButtons[ch]; ok {
+			if len(steps) > 0 && (join || !afterDir) {
+				steps[len(steps)-1] |= b
+			} else {
+				steps = append(steps, dir|b)
+			}
+			join, afterDir = false, false
+		} else {
+			return nil, Error(fmt.Sprintf("Invalid input %q in %v", ch, s))
+		}
+	}
+	if len(steps) == 0 {
+		return nil, Error("Missing input")
+	}
+	return steps, nil
+}
+
+func (sc *Scenario) parseLine(line string, lineNo int) error {
+	if strings.HasPrefix(line, "expect ") {
+		rest, pn, err := parseScenarioPlayer(strings.TrimSpace(line[7:]))
+		if err != nil {
+			return err
+		}
+		expr, from, to, err := parseScenarioFrames(rest)
+		if err != nil {
+			return err
+		}
+		sc.expects = append(sc.expects, &scenarioExpect{line: lineNo, playerNo: pn,
+			text: line[7:], expr: newDebugExpr(expr), from: from, to: to})
+		if to > sc.last {
+			sc.last = to
+		}
+		return nil
+	}
+	if strings.HasPrefix(line, "p") && strings.Contains(line, ":") {
+		rest, pn, err := parseScenarioPlayer(line)
+		if err != nil {
+			return err
+		}
+		input, from, to, err := parseScenarioFrames(rest)
+		if err != nil {
+			return err
+		}
+		steps, err := parseScenarioInput(input)
+		if err != nil {
+			return err
+		}
+		if from != to {
+			if len(steps) > 1 {
+				return Error("Only a single input can be held over several frames")
+			}
+			for len(steps) <= to-from {
+				steps = append(steps, steps[0])
+			}
+		}
+		for len(sc.inputs[pn]) < from+len(steps) {
+			sc.inputs[pn] = append(sc.inputs[pn], 0)
+		}
+		for i, ib := range steps {
+			sc.inputs[pn][from+i] |= ib
+		}
+		sc.drive[pn] = true
+		if from+len(steps)-1 > sc.last {
+			sc.last = from + len(steps) - 1
+		}
+		return nil
+	}
+	if i := strings.Index(line, "="); i > 0 {
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if !scenarioSetting.MatchString(key) {
+			return Error("Unknown setting: " + key)
+		}
+		if key == "stage" {
+			key = "s"
+		}
+		sys.cmdFlags["-"+key] = value
+		if pn, err := strconv.Atoi(key[1:]); err == nil && key[0] == 'p' && pn >= 1 && pn <= MaxSimul*2 {
+			sc.drive[pn-1] = true
+		}
+		return nil
+	}
+	return Error("Invalid line: " + line)
+}
+
+// Reads a scenario file and sets up the quick match that runs it.
+func (sc *Scenario) Load(file string) error {
+	str, err := LoadText(file)
+	if err != nil {
+		return err
+	}
+	sc.file = file
+	for i, line := range SplitAndTrim(str, "\n") {
+		if j := strings.IndexAny(line, ";#"); j >= 0 {
+			line = strings.TrimSpace(line[:j])
+		}
+		if line != "" {
+			if err := sc.parseLine(line, i+1); err != nil {
+				return Error(fmt.Sprintf("%v:%v: %v", file, i+1, err))
+			}
+		}
+	}
+	if sys.cmdFlags["-p1"] == "" || sys.cmdFlags["-p2"] == "" {
+		return Error(file + ": p1 and p2 must be set")
+	}
+	for k, v := range map[string]string{"-rounds": "1", "-time": "-1",
+		"-nosound": "", "-windowed": ""} {
+		if _, ok := sys.cmdFlags[k]; !ok {
+			sys.cmdFlags[k] = v
+		}
+	}
+	sc.active = true
+	return nil
+}
+
+func (sc *Scenario) driving(pn int) bool {
+	return sc.active && pn < len(sc.drive) && sc.drive[pn]
+}
+
+// Converts an input where IB_PL is back and IB_PR forward to the screen
+// directions BitsToKeys expects, for a character with the given facing.
+func scenarioAbsInput(ib InputBits, facing float32) InputBits {
+	if facing < 0 {
+		return ib&^(IB_PL|IB_PR) | (ib&IB_PL)<<1 | (ib&IB_PR)>>1
+	}
+	return ib
+}
+
+// Feeds the command buffer of c, a character of player pn, with the scripted
+// input of the current frame.
+func (sc *Scenario) input(c *Char, pn int) bool {
+	cl := &c.cmd[0]
+	step := cl.Buffer.Bb != 0
+	var ib InputBits
+	if sc.frame >= 0 && sc.frame < len(sc.inputs[pn]) {
+		ib = sc.inputs[pn][sc.frame]
+	}
+	scenarioAbsInput(ib, c.facing).BitsToKeys(cl.Buffer, int32(c.facing))
+	return step
+}
+
+// Called once per game tick after the characters acted.
+func (sc *Scenario) update() {
+	if !sc.active || sc.finished {
+		return
+	}
+	if sc.frame < 0 {
+		if sys.intro == 0 && sys.roundState() == 2 {
+			sc.frame = 0
+		}
+		return
+	}
+	for _, e := range sc.expects {
+		if sc.frame >= e.from && sc.frame <= e.to && e.failure == "" {
+			sc.check(e)
+		}
+	}
+	if sc.frame++; sc.frame > sc.last {
+		sc.finished = true
+		sys.endMatch = true
+	}
+}
+
+func (sc *Scenario) check(e *scenarioExpect) {
+	if len(sys.chars[e.playerNo]) == 0 {
+		e.failure = fmt.Sprintf("P%v does not exist", e.playerNo+1)
+		return
+	}
+	c := sys.chars[e.playerNo][0]
+	bv, err := e.expr.run(c)
+	if err != nil {
+		e.failure = err.Error()
+	} else if !bv.ToB() {
+		e.failure = fmt.Sprintf("false at frame %v (state %v, anim %v, life %v, pos %v,%v)",
+			sc.frame, c.ss.no, c.animNo, c.life,
+			c.pos[0]/c.localscl, c.pos[1]/c.localscl)
+	}
+}
+
+// Prints the results to stderr and returns the exit status of the run.
+func (sc *Scenario) Result() int {
+	if !sc.finished {
+		for _, e := range sc.expects {
+			if e.failure == "" && e.to >= sc.frame {
+				e.failure = fmt.Sprintf("the match ended at frame %v", sc.frame)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Scenario %v\n", sc.file)
+	failed := 0
+	for _, e := range sc.expects {
+		if e.failure != "" {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAIL line %v: %v\n    %v\n", e.line, e.text, e.failure)
+		} else {
+			fmt.Fprintf(os.Stderr, "PASS line %v: %v\n", e.line, e.text)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%v passed, %v failed\n", len(sc.expects)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Lifebar of a scenario run: the one it sets, or the fight def of motif.
+func scenarioLifebar(motif string) string {
+	if def := sys.cmdFlags["-lifebar"]; def != "" {
+		return def
+	}
+	def := "fight.def"
+	if str, err := LoadText(motif); err == nil {
+		lines, i := SplitAndTrim(str, "\n"), 0
+		for i < len(lines) {
+			if is, name, _ := ReadIniSection(lines, &i); name == "files" {
+				if is["fight"] != "" {
+					def = is["fight"]
+				}
+				break
+			}
+		}
+	}
+	return SearchFile(def, []string{motif, "data/"})
+}
+
+// Sets up and loads the match of a headless run the way the quick match of
+// the Lua scripts does, for the players, stage, time and rounds set by the
+// scenario.
+func (sc *Scenario) loadMatch(cfg *configSettings) error {
+	flags := sys.cmdFlags
+	def := scenarioLifebar(cfg.Motif)
+	lb, err := loadLifebar(def)
+	if err != nil {
+		return Error(fmt.Sprintf("Can't load %v: %v", def, err))
+	}
+	sys.lifebar = *lb
+	sys.commonLua = nil
+	rounds, _ := strconv.Atoi(flags["-rounds"])
+	roundTime, _ := strconv.Atoi(flags["-time"])
+	sys.lifebar.ro.match_wins = [...]int32{int32(rounds), int32(rounds)}
+	sys.roundTime = Max(-1, int32(roundTime)*sys.lifebar.ti.framespercount)
+
+	stage := flags["-s"]
+	if stage == "" {
+		stage = cfg.StartStage
+	}
+	for _, v := range []string{stage, "stages/" + stage, "stages/" + stage + ".def"} {
+		if FileExist(v) != "" {
+			stage = v
+			break
+		}
+	}
+	sys.sel.ClearSelected()
+	if err := sys.sel.AddStage(stage); err != nil {
+		return Error(fmt.Sprintf("Can't add stage %v: %v", stage, err))
+	}
+	sys.match = 1
+	sys.sel.SelectStage(len(sys.sel.stagelist))
+
+	// Players in -p order, odd numbers on the left side
+	var players [2][]int
+	for pn := 1; pn <= MaxSimul*2; pn++ {
+		if flags[fmt.Sprintf("-p%v", pn)] != "" {
+			players[(pn-1)&1] = append(players[(pn-1)&1], pn)
+		}
+	}
+	for side, pns := range players {
+		tm, _ := strconv.Atoi(flags[fmt.Sprintf("-tmode%v", side+1)])
+		if tm == int(TM_Single) && len(pns) > 1 {
+			tm = int(TM_Simul)
+		}
+		sys.tmode[side] = TeamMode(tm)
+		sys.numSimul[side], sys.numTurns[side] = int32(len(pns)), int32(len(pns))
+		if sys.tmode[side] == TM_Turns {
+			sys.numSimul[side] = 1
+		} else if len(pns) == 1 {
+			sys.tmode[side] = TM_Single
+		}
+		for _, pn := range pns {
+			p := fmt.Sprintf("-p%v", pn)
+			pal, err := strconv.Atoi(flags[p+".color"])
+			if err != nil {
+				pal, _ = strconv.Atoi(flags[p+".pal"])
+			}
+			if pal < 1 || pal > MaxPalNo {
+				pal = 1
+			}
+			sys.sel.addChar(flags[p])
+			if !sys.sel.AddSelectedChar(side, len(sys.sel.charlist)-1, pal) {
+				return Error("Can't add character " + flags[p])
+			}
+			ai, _ := strconv.ParseFloat(flags[p+".ai"], 32)
+			sys.com[pn-1] = MaxF(0, float32(ai))
+		}
+	}
+
+	sys.loadStart()
+	for sys.loader.state != LS_Complete {
+		if sys.loader.state == LS_Error {
+			return sys.loader.err
+		}
+		sys.await(FPS)
+	}
+
+	// Same set up as the first round of a match started by the scripts
+	sys.charList.clear()
+	for side := 0; side < 2; side++ {
+		for i := side; i < MaxSimul*2; i += 2 {
+			if len(sys.chars[i]) > 0 {
+				sys.chars[i][0].id = sys.newCharId()
+			}
+		}
+	}
+	for i, c := range sys.chars {
+		if len(c) == 0 {
+			continue
+		}
+		sys.charList.add(c[0])
+		c[0].loadPalette()
+		for j, cj := range sys.chars {
+			if i != j && len(cj) > 0 {
+				if len(cj[0].cmd) == 0 {
+					cj[0].cmd = make([]CommandList, len(sys.chars))
+				}
+				cj[0].cmd[i].CopyList(c[0].cmd[i])
+			}
+		}
+	}
+	sys.endMatch, sys.matchData = false, sys.luaLState.NewTable()
+	sys.matchWins = [...]int32{sys.lifebar.ro.match_wins[1], sys.lifebar.ro.match_wins[0]}
+	sys.teamLeader = [...]int{0, 1}
+	sys.stage.reset()
+	return nil
+}
+
+// Runs the match of a loaded scenario in a headless build, without the Lua
+// scripts, and returns the exit status. The lifebar of the motif and the
+// start stage of cfg are used if the scenario does not set them.
+func (sc *Scenario) Run(cfg *configSettings) int {
+	if err := sc.loadMatch(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	sys.fight()
+	return sc.Result()
+}
+
+// Runs every scenario file of dir in its own process and returns the exit
+// status of the run.
+func runScenarioDir(dir string) int {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+scenarioExt))
+	if err != nil || len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "No %v files in %v\n", scenarioExt, dir)
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	failed := 0
+	for _, f := range files {
+		args := append([]string{}, os.Args[1:]...)
+		for i := range args {
+			if args[i] == "-scenario" && i+1 < len(args) {
+				args[i+1] = f
+			}
+		}
+		cmd := exec.Command(exe, args...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			failed++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%v of %v scenarios passed\n", len(files)-failed, len(files))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
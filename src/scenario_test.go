@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScenarioInput(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []InputBits
+	}{
+		{"236a", []InputBits{IB_PD, IB_PD | IB_PR, IB_PR, IB_PR | IB_A}},
+		{"5", []InputBits{0}},
+		{"a", []InputBits{IB_A}},
+		{"ab", []InputBits{IB_A | IB_B}},
+		{"a+b", []InputBits{IB_A | IB_B}},
+		{"6+a", []InputBits{IB_PR | IB_A}},
+		{"4x", []InputBits{IB_PL, IB_PL | IB_X}},
+		{"2xy", []InputBits{IB_PD, IB_PD | IB_X | IB_Y}},
+		{"41236c", []InputBits{IB_PL, IB_PD | IB_PL, IB_PD, IB_PD | IB_PR, IB_PR, IB_PR | IB_C}},
+		{"9s", []InputBits{IB_PU | IB_PR, IB_PU | IB_PR | IB_S}},
+	}
+	for _, c := range cases {
+		got, err := parseScenarioInput(c.in)
+		if err != nil {
+			t.Errorf("%v: %v", c.in, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v, want %v", c.in, got, c.want)
+		}
+	}
+	for _, in := range []string{"", "0", "6e", "+a", "2 3"} {
+		if _, err := parseScenarioInput(in); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestParseScenarioFrames(t *testing.T) {
+	cases := []struct {
+		in       string
+		rest     string
+		from, to int
+	}{
+		{"236a at frame 10", "236a", 10, 10},
+		{"4 from frame 0 to 60", "4", 0, 60},
+		{"stateno = 1000 at frame 0", "stateno = 1000", 0, 0},
+		{"life < lifemax from frame 30 to 30", "life < lifemax", 30, 30},
+	}
+	for _, c := range cases {
+		rest, from, to, err := parseScenarioFrames(c.in)
+		if err != nil {
+			t.Errorf("%v: %v", c.in, err)
+		} else if rest != c.rest || from != c.from || to != c.to {
+			t.Errorf("%v: got %q from %v to %v, want %q from %v to %v",
+				c.in, rest, from, to, c.rest, c.from, c.to)
+		}
+	}
+	for _, in := range []string{"236a", "a at frame -1", "a at frame x",
+		"a from frame 10 to 5", "a from frame 0", "a from frame 0 until 5"} {
+		if _, _, _, err := parseScenarioFrames(in); err == nil {
+			t.Errorf("%q: no error", in)
+		}
+	}
+}
+
+func TestScenarioParseLine(t *testing.T) {
+	flags := sys.cmdFlags
+	sys.cmdFlags = make(map[string]string)
+	defer func() { sys.cmdFlags = flags }()
+	sc := &Scenario{frame: -1}
+	lines := []string{
+		"p1 = kfm",
+		"p2.ai = 8",
+		"stage = stages/stage0.def",
+		"p1: 236a at frame 2",
+		"p2: 4 from frame 0 to 3",
+		"p1: b at frame 3",
+		"expect p2: life < lifemax from frame 10 to 20",
+	}
+	for i, line := range lines {
+		if err := sc.parseLine(line, i+1); err != nil {
+			t.Fatalf("%v: %v", line, err)
+		}
+	}
+	if sys.cmdFlags["-p1"] != "kfm" || sys.cmdFlags["-p2.ai"] != "8" ||
+		sys.cmdFlags["-s"] != "stages/stage0.def" {
+		t.Errorf("settings %v", sys.cmdFlags)
+	}
+	// Inputs starting on the same frame are merged
+	want := []InputBits{0, 0, IB_PD, IB_PD | IB_PR | IB_B, IB_PR, IB_PR | IB_A}
+	if !reflect.DeepEqual(sc.inputs[0], want) {
+		t.Errorf("p1 inputs %v, want %v", sc.inputs[0], want)
+	}
+	// A held input repeats on every frame of the range
+	want = []InputBits{IB_PL, IB_PL, IB_PL, IB_PL}
+	if !reflect.DeepEqual(sc.inputs[1], want) {
+		t.Errorf("p2 inputs %v, want %v", sc.inputs[1], want)
+	}
+	if !sc.drive[0] || !sc.drive[1] || sc.drive[2] {
+		t.Errorf("driven players %v", sc.drive)
+	}
+	if len(sc.expects) != 1 || sc.expects[0].playerNo != 1 || sc.expects[0].from != 10 ||
+		sc.expects[0].to != 20 || sc.expects[0].expr.text != "life < lifemax" {
+		t.Errorf("expectations %+v", sc.expects)
+	}
+	if sc.last != 20 {
+		t.Errorf("last frame %v, want 20", sc.last)
+	}
+	for _, line := range []string{
+		"p2: 236a from frame 0 to 5",
+		"p9: a at frame 0",
+		"p1 a at frame 0",
+		"expect p1: stateno = 0",
+		"speed = 2",
+		"hello",
+	} {
+		if err := sc.parseLine(line, 1); err == nil {
+			t.Errorf("%q: no error", line)
+		}
+	}
+}
+
+func TestScenarioFacing(t *testing.T) {
+	// Back and forward are swapped for a character facing left, the other
+	// directions and the buttons are kept
+	cases := []struct {
+		in, right, left InputBits
+	}{
+		{IB_PR, IB_PR, IB_PL},
+		{IB_PL | IB_A, IB_PL | IB_A, IB_PR | IB_A},
+		{IB_PD | IB_PR | IB_X, IB_PD | IB_PR | IB_X, IB_PD | IB_PL | IB_X},
+		{IB_PU | IB_PL, IB_PU | IB_PL, IB_PU | IB_PR},
+		{IB_PD | IB_C, IB_PD | IB_C, IB_PD | IB_C},
+	}
+	for _, c := range cases {
+		if got := scenarioAbsInput(c.in, 1); got != c.right {
+			t.Errorf("%v facing right: got %v, want %v", c.in, got, c.right)
+		}
+		if got := scenarioAbsInput(c.in, -1); got != c.left {
+			t.Errorf("%v facing left: got %v, want %v", c.in, got, c.left)
+		}
+	}
+}
+
+// Runs a scenario the way a headless build does, from the root of the
+// repository so that the common files in data are found.
+func scenarioTestRun(file, config string) int {
+	if err := os.Chdir(".."); err != nil {
+		return 2
+	}
+	sys.cmdFlags = map[string]string{"-config": config}
+	if err := scenario.Load(file); err != nil {
+		return 2
+	}
+	tmp := setupConfig(false)
+	sys.headless = true
+	sys.luaLState = sys.init(tmp.GameWidth, tmp.GameHeight)
+	defer sys.shutdown()
+	return scenario.Run(&tmp)
+}
+
+func TestScenarioRun(t *testing.T) {
+	// The match changes the global state, so it runs in a child process
+	if file := os.Getenv("IKEMEN_SCENARIO"); file != "" {
+		os.Exit(scenarioTestRun(file, os.Getenv("IKEMEN_SCENARIO_CONFIG")))
+	}
+	dir := t.TempDir()
+	failing := filepath.Join(dir, "failing.scenario")
+	src, err := os.ReadFile("testdata/scenario/punch.scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src = append(src, "expect p2: life = 1000 at frame 10\n"...)
+	if err := os.WriteFile(failing, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		file   string
+		status int
+		report string
+	}{
+		{"src/testdata/scenario/punch.scenario", 0, "6 passed, 0 failed"},
+		{failing, 1, "6 passed, 1 failed"},
+	}
+	for _, c := range cases {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestScenarioRun$")
+		cmd.Env = append(os.Environ(), "IKEMEN_SCENARIO="+c.file,
+			"IKEMEN_SCENARIO_CONFIG="+filepath.Join(dir, "config.json"))
+		out, _ := cmd.CombinedOutput()
+		if status := cmd.ProcessState.ExitCode(); status != c.status ||
+			!strings.Contains(string(out), c.report) {
+			t.Errorf("%v: exit status %v, want %v and %q\n%s", c.file, status, c.status, c.report, out)
+		}
+	}
+}
@@ -34,6 +34,13 @@ func numArg(l *lua.LState, argi int) float64 {
 func boolArg(l *lua.LState, argi int) bool {
 	return l.ToBool(argi)
 }
+func audioBusArg(l *lua.LState, argi int) *AudioBus {
+	i := audioBusIndex(strArg(l, argi))
+	if i < 0 {
+		l.RaiseError("\nInvalid audio bus: %v\n", l.Get(argi))
+	}
+	return sys.audioBuses[i]
+}
 func tableArg(l *lua.LState, argi int) *lua.LTable {
 	return l.ToTable(argi)
 }
@@ -56,6 +63,30 @@ func userDataError(l *lua.LState, argi int, udtype interface{}) {
 // Register external functions to be called from Lua scripts
 func systemScriptInit(l *lua.LState) {
 	triggerFunctions(l)
+	luaRegister(l, "addBGMLayer", func(l *lua.LState) int {
+		// file, volume, trigger, round, fade
+		def := MusicLayerDef{File: strArg(l, 1), Volume: 100}
+		if l.GetTop() >= 2 {
+			def.Volume = int32(numArg(l, 2))
+		}
+		if l.GetTop() >= 3 {
+			def.Trigger = strArg(l, 3)
+		}
+		if l.GetTop() >= 4 {
+			def.Round = int32(numArg(l, 4))
+		}
+		if l.GetTop() >= 5 {
+			def.Fade = int32(numArg(l, 5))
+		}
+		i, err := sys.bgm.AddLayer(def)
+		if err != nil {
+			sys.errLog.Printf("Failed to load bgm layer: %v", err)
+			l.Push(lua.LNil)
+			return 1
+		}
+		l.Push(lua.LNumber(i + 1))
+		return 1
+	})
 	luaRegister(l, "addChar", func(l *lua.LState) int {
 		for _, c := range strings.Split(strings.TrimSpace(strArg(l, 1)), "\n") {
 			c = strings.Trim(c, "\r")
@@ -462,6 +493,57 @@ func systemScriptInit(l *lua.LState) {
 		bg.reset()
 		return 0
 	})
+	// Optional player number and helper ID of the breakpoint functions,
+	// starting at argument n. Missing or nil arguments match any character.
+	breakTarget := func(l *lua.LState, n int) (pn int, id int32) {
+		pn, id = -1, -1
+		if l.GetTop() >= n && l.Get(n) != lua.LNil && numArg(l, n) > 0 {
+			pn = int(numArg(l, n)) - 1
+		}
+		if l.GetTop() >= n+1 && l.Get(n+1) != lua.LNil {
+			id = int32(numArg(l, n+1))
+		}
+		return
+	}
+	luaRegister(l, "breakDelete", func(l *lua.LState) int {
+		n := 0
+		if l.GetTop() >= 1 {
+			n = int(numArg(l, 1))
+		}
+		breakpoints.Delete(n)
+		return 0
+	})
+	luaRegister(l, "breakEnable", func(l *lua.LState) int {
+		breakpoints.Enable(int(numArg(l, 1)), l.GetTop() < 2 || boolArg(l, 2))
+		return 0
+	})
+	luaRegister(l, "breakExpr", func(l *lua.LState) int {
+		pn, id := breakTarget(l, 2)
+		l.Push(lua.LNumber(breakpoints.Add(&Breakpoint{typ: BP_Expr, playerNo: pn,
+			helperId: id, expr: newDebugExpr(strArg(l, 1))})))
+		return 1
+	})
+	luaRegister(l, "breakHit", func(l *lua.LState) int {
+		pn, id := breakTarget(l, 1)
+		l.Push(lua.LNumber(breakpoints.Add(&Breakpoint{typ: BP_Hit, playerNo: pn,
+			helperId: id})))
+		return 1
+	})
+	luaRegister(l, "breakList", func(l *lua.LState) int {
+		tbl := l.NewTable()
+		for i, bp := range breakpoints.list {
+			sys.appendToConsole(fmt.Sprintf("%v. %v", i+1, bp))
+			tbl.Append(lua.LString(bp.String()))
+		}
+		l.Push(tbl)
+		return 1
+	})
+	luaRegister(l, "breakState", func(l *lua.LState) int {
+		pn, id := breakTarget(l, 2)
+		l.Push(lua.LNumber(breakpoints.Add(&Breakpoint{typ: BP_State, playerNo: pn,
+			helperId: id, stateNo: int32(numArg(l, 1))})))
+		return 1
+	})
 	luaRegister(l, "changeColorPalette", func(*lua.LState) int {
 		preanim := toUserData(l, 1).(*Anim)
 		p := int16(numArg(l, 2))
@@ -552,7 +634,7 @@ func systemScriptInit(l *lua.LState) {
 			l.RaiseError("\nPlayer not found: %v\n", pn)
 		}
 		f, lw, lp, stopgh, stopcs := false, false, false, false, false
-		var g, n, ch, vo, priority, lc int32 = -1, 0, -1, 100, 0, 0
+		var g, n, ch, vo, priority, lc, bus int32 = -1, 0, -1, 100, 0, 0, -1
 		var loopstart, loopend, startposition int = 0, 0, 0
 		var p, fr float32 = 0, 1
 		x := &sys.chars[pn-1][0].pos[0]
@@ -605,6 +687,11 @@ func systemScriptInit(l *lua.LState) {
 		if l.GetTop() >= 16 { // StopOnChangeState
 			stopcs = boolArg(l, 17)
 		}
+		if l.GetTop() >= 18 {
+			if bus = int32(audioBusIndex(strArg(l, 18))); bus < 0 {
+				l.RaiseError("\nInvalid audio bus: %v\n", strArg(l, 18))
+			}
+		}
 		preffix := ""
 		if f {
 			preffix = "f"
@@ -613,14 +700,14 @@ func systemScriptInit(l *lua.LState) {
 		// If the loopcount is 0, then read the loop parameter
 		if lc == 0 {
 			if lp {
-				sys.chars[pn-1][0].playSound(preffix, lw, -1, g, n, ch, vo, p, fr, ls, x, false, priority, loopstart, loopend, startposition, stopgh, stopcs)
+				sys.chars[pn-1][0].playSound(preffix, lw, -1, g, n, ch, vo, p, fr, ls, x, false, priority, loopstart, loopend, startposition, stopgh, stopcs, bus)
 			} else {
-				sys.chars[pn-1][0].playSound(preffix, lw, 0, g, n, ch, vo, p, fr, ls, x, false, priority, loopstart, loopend, startposition, stopgh, stopcs)
+				sys.chars[pn-1][0].playSound(preffix, lw, 0, g, n, ch, vo, p, fr, ls, x, false, priority, loopstart, loopend, startposition, stopgh, stopcs, bus)
 			}
 
 			// Otherwise, read the loopcount parameter directly
 		} else {
-			sys.chars[pn-1][0].playSound(preffix, lw, lc, g, n, ch, vo, p, fr, ls, x, false, priority, loopstart, loopend, startposition, stopgh, stopcs)
+			sys.chars[pn-1][0].playSound(preffix, lw, lc, g, n, ch, vo, p, fr, ls, x, false, priority, loopstart, loopend, startposition, stopgh, stopcs, bus)
 		}
 		return 0
 	})
@@ -948,6 +1035,10 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(lua.LBool(true))
 		return 1
 	})
+	luaRegister(l, "fileExists", func(l *lua.LState) int {
+		l.Push(lua.LBool(FileExist(strArg(l, 1)) != ""))
+		return 1
+	})
 	luaRegister(l, "fillRect", func(l *lua.LState) int {
 		rect := [4]int32{int32((float32(numArg(l, 1))/sys.luaSpriteScale + float32(sys.gameWidth-320)/2 + sys.luaSpriteOffsetX) * sys.widthScale),
 			int32((float32(numArg(l, 2))/sys.luaSpriteScale + float32(sys.gameHeight-240)) * sys.heightScale),
@@ -992,6 +1083,35 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(lua.LNumber(fnt.TextWidth(strArg(l, 2), bank)))
 		return 1
 	})
+	luaRegister(l, "fontGetTextLayout", func(*lua.LState) int {
+		fnt, ok := toUserData(l, 1).(*Fnt)
+		if !ok {
+			userDataError(l, 1, fnt)
+		}
+		var bank int32
+		var wrap float32
+		if l.GetTop() >= 3 {
+			bank = int32(numArg(l, 3))
+		}
+		if l.GetTop() >= 4 {
+			wrap = float32(numArg(l, 4))
+		}
+		tl := fnt.Layout(strArg(l, 2), bank, wrap)
+		tbl := l.NewTable()
+		for _, g := range tl.Glyphs {
+			subt := l.NewTable()
+			subt.RawSetString("char", lua.LString(string(g.Rune)))
+			subt.RawSetString("x", lua.LNumber(g.X))
+			subt.RawSetString("y", lua.LNumber(g.Y))
+			subt.RawSetString("line", lua.LNumber(g.Line+1))
+			subt.RawSetString("fallback", lua.LBool(g.Font != fnt))
+			tbl.Append(subt)
+		}
+		l.Push(tbl)
+		l.Push(lua.LNumber(tl.Width()))
+		l.Push(lua.LNumber(len(tl.Lines)))
+		return 3
+	})
 	luaRegister(l, "fontNew", func(l *lua.LState) int {
 		var height int32 = -1
 		if l.GetTop() >= 2 {
@@ -1141,6 +1261,7 @@ func systemScriptInit(l *lua.LState) {
 					for i, b := range sys.reloadCharSlot {
 						if b {
 							if s := sys.cgi[i].sff; s != nil {
+								releaseSff(s)
 								removeSFFCache(s.filename)
 							}
 							sys.chars[i] = []*Char{}
@@ -1247,7 +1368,7 @@ func systemScriptInit(l *lua.LState) {
 				l.Push(lua.LNumber(winp))
 				l.Push(tbl)
 				if sys.playBgmFlg {
-					sys.bgm.Open("", 1, 100, 0, 0, 0, 1.0)
+					sys.bgm.Open("", 1, 100, 0, 0, 0, 1.0, 0)
 					sys.playBgmFlg = false
 				}
 				sys.clearAllSound()
@@ -1269,6 +1390,15 @@ func systemScriptInit(l *lua.LState) {
 			}
 		}
 	})
+	luaRegister(l, "getAudioBusParam", func(l *lua.LState) int {
+		b := audioBusArg(l, 1)
+		v, ok := b.Param(strArg(l, 2))
+		if !ok {
+			l.RaiseError("\nInvalid audio bus parameter: %v\n", strArg(l, 2))
+		}
+		l.Push(lua.LNumber(v))
+		return 1
+	})
 	luaRegister(l, "getCharAttachedInfo", func(*lua.LState) int {
 		def := strArg(l, 1)
 		idx := strings.Index(def, "/")
@@ -1499,6 +1629,34 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(lua.LNumber(sys.frameCounter))
 		return 1
 	})
+	luaRegister(l, "getFrameData", func(*lua.LState) int {
+		pn := int(numArg(l, 1))
+		if pn < 1 || pn > len(sys.chars) || len(sys.chars[pn-1]) == 0 {
+			l.RaiseError("\nInvalid player number: %v\n", pn)
+		}
+		fd := &sys.chars[pn-1][0].frameData
+		tbl := l.NewTable()
+		tbl.RawSetString("done", lua.LBool(fd.done))
+		tbl.RawSetString("stateno", lua.LNumber(fd.stateNo))
+		tbl.RawSetString("startup", lua.LNumber(fd.startup))
+		tbl.RawSetString("active", lua.LNumber(fd.active))
+		tbl.RawSetString("recovery", lua.LNumber(fd.recovery))
+		contact := "whiff"
+		switch fd.contact {
+		case int32(MC_Hit):
+			contact = "hit"
+		case int32(MC_Guarded):
+			contact = "guard"
+		case int32(MC_Reversed):
+			contact = "reversal"
+		}
+		tbl.RawSetString("contact", lua.LString(contact))
+		if fd.hasAdv {
+			tbl.RawSetString("advantage", lua.LNumber(fd.advantage))
+		}
+		l.Push(tbl)
+		return 1
+	})
 	luaRegister(l, "getJoystickName", func(*lua.LState) int {
 		l.Push(lua.LString(input.GetJoystickName(int(numArg(l, 1)))))
 		return 1
@@ -1594,6 +1752,18 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(lua.LString(s))
 		return 1
 	})
+	luaRegister(l, "getLanguages", func(l *lua.LState) int {
+		tbl := l.NewTable()
+		codes, names := lang.Languages()
+		for i, c := range codes {
+			t := l.NewTable()
+			t.RawSetString("code", lua.LString(c))
+			t.RawSetString("name", lua.LString(names[i]))
+			tbl.Append(t)
+		}
+		l.Push(tbl)
+		return 1
+	})
 	luaRegister(l, "getListenPort", func(*lua.LState) int {
 		l.Push(lua.LString(sys.listenPort))
 		return 1
@@ -1614,6 +1784,42 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(lua.LNumber(sys.lifebar.ro.match_wins[tn-1]))
 		return 1
 	})
+	luaRegister(l, "getPostProcessingParams", func(l *lua.LState) int {
+		// Parameters of every pass in the active chain
+		tbl := l.NewTable()
+		for _, idx := range sys.postProcessing.Passes() {
+			ps := sys.postProcessing.Shader(idx)
+			if ps == nil {
+				continue
+			}
+			for i, p := range ps.Parameters {
+				subt := l.NewTable()
+				subt.RawSetString("shader", lua.LString(ps.name))
+				subt.RawSetString("name", lua.LString(p.Name))
+				subt.RawSetString("label", lua.LString(p.Label))
+				subt.RawSetString("value", lua.LNumber(ps.values[i]))
+				subt.RawSetString("default", lua.LNumber(p.Default))
+				subt.RawSetString("min", lua.LNumber(p.Min))
+				subt.RawSetString("max", lua.LNumber(p.Max))
+				subt.RawSetString("step", lua.LNumber(p.Step))
+				tbl.Append(subt)
+			}
+		}
+		l.Push(tbl)
+		return 1
+	})
+	luaRegister(l, "getPostProcessingPreset", func(l *lua.LState) int {
+		l.Push(lua.LString(sys.postProcessing.preset))
+		return 1
+	})
+	luaRegister(l, "getPostProcessingPresets", func(l *lua.LState) int {
+		tbl := l.NewTable()
+		for _, name := range sys.postProcessing.Presets() {
+			tbl.Append(lua.LString(name))
+		}
+		l.Push(tbl)
+		return 1
+	})
 	luaRegister(l, "getRank", func(*lua.LState) int {
 		tn := int(numArg(l, 1))
 		if tn < 1 || tn > 2 {
@@ -1688,6 +1894,36 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(newUserData(l, w))
 		return 1
 	})
+	luaRegister(l, "inspectorPage", func(l *lua.LState) int {
+		inspector.SetPage(int(numArg(l, 1)))
+		return 0
+	})
+	luaRegister(l, "inspectorScroll", func(l *lua.LState) int {
+		inspector.Scroll(int(numArg(l, 1)))
+		return 0
+	})
+	luaRegister(l, "inspectorSelect", func(l *lua.LState) int {
+		inspector.Select(int(numArg(l, 1)))
+		return 0
+	})
+	luaRegister(l, "inspectorSet", func(l *lua.LState) int {
+		if err := inspector.Set(strArg(l, 1), numArg(l, 2)); err != nil {
+			sys.appendToConsole(err.Error())
+		}
+		return 0
+	})
+	luaRegister(l, "inspectorUnwatch", func(l *lua.LState) int {
+		n := 0
+		if l.GetTop() >= 1 {
+			n = int(numArg(l, 1))
+		}
+		inspector.Unwatch(n)
+		return 0
+	})
+	luaRegister(l, "inspectorWatch", func(l *lua.LState) int {
+		inspector.Watch(strArg(l, 1))
+		return 0
+	})
 	luaRegister(l, "loadDebugFont", func(l *lua.LState) int {
 		ts := NewTextSprite()
 		f, err := loadFnt(strArg(l, 1), -1)
@@ -1724,6 +1960,22 @@ func systemScriptInit(l *lua.LState) {
 		l.Push(lua.LBool(sys.loader.state == LS_Loading))
 		return 1
 	})
+	luaRegister(l, "langPlural", func(l *lua.LState) int {
+		var args []string
+		for i := 3; i <= l.GetTop(); i++ {
+			args = append(args, l.Get(i).String())
+		}
+		l.Push(lua.LString(lang.Plural(strArg(l, 1), numArg(l, 2), args...)))
+		return 1
+	})
+	luaRegister(l, "langString", func(l *lua.LState) int {
+		var args []string
+		for i := 2; i <= l.GetTop(); i++ {
+			args = append(args, l.Get(i).String())
+		}
+		l.Push(lua.LString(lang.Get(strArg(l, 1), args...)))
+		return 1
+	})
 	luaRegister(l, "loadLifebar", func(l *lua.LState) int {
 		lb, err := loadLifebar(strArg(l, 1))
 		if err != nil {
@@ -1814,7 +2066,7 @@ func systemScriptInit(l *lua.LState) {
 			if l.GetTop() >= 7 {
 				freqmul = ClampF(float32(numArg(l, 7)), 0.01, 5.0)
 			}
-			sys.bgm.Open(strArg(l, 1), loop, volume, loopstart, loopend, startposition, freqmul)
+			sys.bgm.Open(strArg(l, 1), loop, volume, loopstart, loopend, startposition, freqmul, sys.bgm.crossfade)
 		} else {
 			if l.GetTop() >= 2 {
 				// loop = int(numArg(l, 2))
@@ -1835,8 +2087,37 @@ func systemScriptInit(l *lua.LState) {
 			if l.GetTop() >= 7 {
 				freqmul = ClampF(float32(numArg(l, 7)), 0.01, 5.0)
 			}
-			sys.bgm.Open(strArg(l, 1), loop, volume, loopstart, loopend, startposition, freqmul)
+			sys.bgm.Open(strArg(l, 1), loop, volume, loopstart, loopend, startposition, freqmul, sys.bgm.crossfade)
+		}
+		return 0
+	})
+	luaRegister(l, "playBGMPlaylist", func(l *lua.LState) int {
+		// tracks (table or comma separated string), shuffle, volume, crossfade
+		var tracks []string
+		if tbl, ok := l.Get(1).(*lua.LTable); ok {
+			tbl.ForEach(func(_, value lua.LValue) {
+				if name := strings.TrimSpace(lua.LVAsString(value)); name != "" {
+					tracks = append(tracks, name)
+				}
+			})
+		} else {
+			for _, name := range SplitAndTrim(strArg(l, 1), ",") {
+				if name != "" {
+					tracks = append(tracks, name)
+				}
+			}
+		}
+		shuffle, volume, crossfade := false, 100, sys.bgm.crossfade
+		if l.GetTop() >= 2 {
+			shuffle = boolArg(l, 2)
+		}
+		if l.GetTop() >= 3 {
+			volume = int(numArg(l, 3))
+		}
+		if l.GetTop() >= 4 {
+			crossfade = int32(numArg(l, 4))
 		}
+		sys.bgm.OpenPlaylist(tracks, shuffle, volume, crossfade)
 		return 0
 	})
 	luaRegister(l, "playerBufReset", func(*lua.LState) int {
@@ -1912,10 +2193,64 @@ func systemScriptInit(l *lua.LState) {
 		fmt.Println(strArg(l, 1))
 		return 0
 	})
+	luaRegister(l, "profilerReport", func(l *lua.LState) int {
+		file := ""
+		if l.GetTop() >= 1 {
+			file = strArg(l, 1)
+		}
+		file, err := profiler.Report(file)
+		if err != nil {
+			sys.appendToConsole(fmt.Sprintf("Failed to write the profiler report: %v", err))
+			l.Push(lua.LBool(false))
+			return 1
+		}
+		sys.appendToConsole("Profiler report saved to " + file)
+		l.Push(lua.LString(file))
+		return 1
+	})
+	luaRegister(l, "profilerReset", func(*lua.LState) int {
+		profiler.Reset()
+		return 0
+	})
 	luaRegister(l, "puts", func(*lua.LState) int {
 		fmt.Println(strArg(l, 1))
 		return 0
 	})
+	luaRegister(l, "readFile", func(*lua.LState) int {
+		b, err := vfs.ReadFile(strArg(l, 1))
+		if err != nil {
+			l.Push(lua.LNil)
+			return 1
+		}
+		l.Push(lua.LString(b))
+		return 1
+	})
+	luaRegister(l, "recordStart", func(*lua.LState) int {
+		// basename, offline
+		var basename string
+		var offline bool
+		if l.GetTop() >= 1 {
+			basename = strArg(l, 1)
+		}
+		if l.GetTop() >= 2 {
+			offline = boolArg(l, 2)
+		}
+		if err := sys.recorder.Start(basename, offline); err != nil {
+			sys.errLog.Printf("Failed to start recording: %v", err)
+			l.Push(lua.LBool(false))
+			return 1
+		}
+		l.Push(lua.LBool(true))
+		return 1
+	})
+	luaRegister(l, "recordStop", func(*lua.LState) int {
+		sys.recorder.Stop()
+		return 0
+	})
+	luaRegister(l, "recording", func(*lua.LState) int {
+		l.Push(lua.LBool(sys.recorder.recording))
+		return 1
+	})
 	luaRegister(l, "refresh", func(*lua.LState) int {
 		sys.tickSound()
 		if !sys.update() {
@@ -1997,6 +2332,10 @@ func systemScriptInit(l *lua.LState) {
 		sys.roundResetFlg = true
 		return 0
 	})
+	luaRegister(l, "scenarioResult", func(*lua.LState) int {
+		l.Push(lua.LNumber(scenario.Result()))
+		return 1
+	})
 	luaRegister(l, "screenshot", func(*lua.LState) int {
 		captureScreen()
 		return 0
@@ -2067,11 +2406,24 @@ func systemScriptInit(l *lua.LState) {
 		sys.loadStart()
 		return 0
 	})
+	luaRegister(l, "setBGMCrossfade", func(l *lua.LState) int {
+		sys.bgm.crossfade = Max(0, int32(numArg(l, 1)))
+		return 0
+	})
 	luaRegister(l, "setBGMFreqMul", func(l *lua.LState) int {
 		freqmul := ClampF(float32(numArg(l, 1)), 0.01, 5.0)
 		sys.bgm.SetFreqMul(freqmul)
 		return 0
 	})
+	luaRegister(l, "setBGMLayerVolume", func(l *lua.LState) int {
+		// layer, volume, frames
+		var frames int32
+		if l.GetTop() >= 3 {
+			frames = int32(numArg(l, 3))
+		}
+		l.Push(lua.LBool(sys.bgm.SetLayerVolume(int(numArg(l, 1))-1, int32(numArg(l, 2)), frames)))
+		return 1
+	})
 	luaRegister(l, "setBGMLoopPoints", func(l *lua.LState) int {
 		var loopstart, loopend int = 0, 0
 		if l.GetTop() >= 1 {
@@ -2091,6 +2443,23 @@ func systemScriptInit(l *lua.LState) {
 		sys.bgm.Seek(position)
 		return 0
 	})
+	luaRegister(l, "sffCacheInfo", func(l *lua.LState) int {
+		files, used := sffCacheUsage()
+		tbl := l.NewTable()
+		tbl.RawSetString("used", lua.LNumber(used))
+		tbl.RawSetString("limit", lua.LNumber(sys.sffCacheSize))
+		subt := l.NewTable()
+		for _, f := range files {
+			ft := l.NewTable()
+			ft.RawSetString("file", lua.LString(f.Filename))
+			ft.RawSetString("size", lua.LNumber(f.Size))
+			ft.RawSetString("refs", lua.LNumber(f.RefCount))
+			subt.Append(ft)
+		}
+		tbl.RawSetString("files", subt)
+		l.Push(tbl)
+		return 1
+	})
 	luaRegister(l, "sffNew", func(l *lua.LState) int {
 		if l.GetTop() == 0 {
 			l.Push(newUserData(l, newSff()))
@@ -2141,6 +2510,24 @@ func systemScriptInit(l *lua.LState) {
 		sys.allowDebugMode = d
 		return 0
 	})
+	luaRegister(l, "setAudioBusParam", func(l *lua.LState) int {
+		// bus, param, value, frames
+		b := audioBusArg(l, 1)
+		if strings.ToLower(strArg(l, 2)) == "duckby" {
+			if !b.SetDuckBy(strArg(l, 3)) {
+				l.RaiseError("\nInvalid audio bus: %v\n", strArg(l, 3))
+			}
+			return 0
+		}
+		var frames int32
+		if l.GetTop() >= 4 {
+			frames = int32(numArg(l, 4))
+		}
+		if !b.SetParam(strArg(l, 2), float32(numArg(l, 3)), frames) {
+			l.RaiseError("\nInvalid audio bus parameter: %v\n", strArg(l, 2))
+		}
+		return 0
+	})
 	luaRegister(l, "setAudioDucking", func(l *lua.LState) int {
 		sys.audioDucking = boolArg(l, 1)
 		return 0
@@ -2283,6 +2670,10 @@ func systemScriptInit(l *lua.LState) {
 		})
 		return 0
 	})
+	luaRegister(l, "setLanguage", func(l *lua.LState) int {
+		lang.SetLanguage(strArg(l, 1))
+		return 0
+	})
 	luaRegister(l, "setLife", func(*lua.LState) int {
 		if sys.debugWC.alive() {
 			sys.debugWC.lifeSet(int32(numArg(l, 1)))
@@ -2317,6 +2708,13 @@ func systemScriptInit(l *lua.LState) {
 				v.active = v.enabled[sys.gameMode]
 			}
 		}
+		for _, v := range sys.lifebar.fd {
+			if _, ok := v.enabled[sys.gameMode]; ok {
+				v.active = v.enabled[sys.gameMode]
+			} else {
+				v.active = false
+			}
+		}
 		if _, ok := sys.lifebar.tr.enabled[sys.gameMode]; ok {
 			sys.lifebar.tr.active = sys.lifebar.tr.enabled[sys.gameMode]
 		}
@@ -2338,6 +2736,8 @@ func systemScriptInit(l *lua.LState) {
 					sys.lifebar.ma.active = lua.LVAsBool(value)
 				case "mode": // enabled by default
 					sys.lifebar.mode = lua.LVAsBool(value)
+				case "p1frameData":
+					sys.lifebar.fd[0].active = lua.LVAsBool(value)
 				case "p1aiLevel":
 				case "p1ai":
 					sys.lifebar.ai[0].active = lua.LVAsBool(value)
@@ -2345,6 +2745,8 @@ func systemScriptInit(l *lua.LState) {
 					sys.lifebar.sc[0].active = lua.LVAsBool(value)
 				case "p1winCount":
 					sys.lifebar.wc[0].active = lua.LVAsBool(value)
+				case "p2frameData":
+					sys.lifebar.fd[1].active = lua.LVAsBool(value)
 				case "p2aiLevel":
 				case "p2ai":
 					sys.lifebar.ai[1].active = lua.LVAsBool(value)
@@ -2478,6 +2880,7 @@ func systemScriptInit(l *lua.LState) {
 	})
 	luaRegister(l, "setMotifDir", func(*lua.LState) int {
 		sys.motifDir = strArg(l, 1)
+		lang.Reload()
 		return 0
 	})
 	luaRegister(l, "setPanningRange", func(l *lua.LState) int {
@@ -2502,6 +2905,15 @@ func systemScriptInit(l *lua.LState) {
 		}
 		return 0
 	})
+	luaRegister(l, "setPostProcessingParam", func(l *lua.LState) int {
+		// shader, param, value
+		l.Push(lua.LBool(sys.postProcessing.SetParam(strArg(l, 1), strArg(l, 2), float32(numArg(l, 3)))))
+		return 1
+	})
+	luaRegister(l, "setPostProcessingPreset", func(l *lua.LState) int {
+		l.Push(lua.LBool(sys.postProcessing.SetPreset(strArg(l, 1))))
+		return 1
+	})
 	luaRegister(l, "setPower", func(*lua.LState) int {
 		sys.debugWC.setPower(int32(numArg(l, 1)))
 		return 0
@@ -2761,6 +3173,14 @@ func systemScriptInit(l *lua.LState) {
 			float32(numArg(l, 4))/sys.luaSpriteScale, float32(numArg(l, 5))/sys.luaSpriteScale)
 		return 0
 	})
+	luaRegister(l, "textImgSetWrapWidth", func(*lua.LState) int {
+		ts, ok := toUserData(l, 1).(*TextSprite)
+		if !ok {
+			userDataError(l, 1, ts)
+		}
+		ts.wrap = float32(numArg(l, 2)) / sys.luaSpriteScale
+		return 0
+	})
 	luaRegister(l, "toggleClsnDraw", func(*lua.LState) int {
 		if !sys.allowDebugMode {
 			return 0
@@ -2803,6 +3223,13 @@ func systemScriptInit(l *lua.LState) {
 		}
 		return 0
 	})
+	luaRegister(l, "toggleInspector", func(*lua.LState) int {
+		if !sys.allowDebugMode {
+			return 0
+		}
+		inspector.enabled = !inspector.enabled
+		return 0
+	})
 	luaRegister(l, "toggleWireframeDraw", func(*lua.LState) int {
 		if !sys.allowDebugMode {
 			return 0
@@ -2901,6 +3328,13 @@ func systemScriptInit(l *lua.LState) {
 		}
 		return 0
 	})
+	luaRegister(l, "toggleProfiler", func(*lua.LState) int {
+		if !sys.allowDebugMode {
+			return 0
+		}
+		profiler.enabled = !profiler.enabled
+		return 0
+	})
 	luaRegister(l, "toggleStatusDraw", func(*lua.LState) int {
 		if l.GetTop() >= 1 {
 			sys.statusDraw = boolArg(l, 1)
@@ -2920,6 +3354,136 @@ func systemScriptInit(l *lua.LState) {
 		sys.window.SetSwapInterval(sys.vRetrace)
 		return 0
 	})
+	luaRegister(l, "traceDump", func(l *lua.LState) int {
+		file := ""
+		if l.GetTop() >= 1 {
+			file = strArg(l, 1)
+		}
+		file, err := stateTrace.Dump(file)
+		if err != nil {
+			sys.appendToConsole(fmt.Sprintf("Failed to write the state trace: %v", err))
+			l.Push(lua.LBool(false))
+			return 1
+		}
+		sys.appendToConsole("State trace saved to " + file)
+		l.Push(lua.LString(file))
+		return 1
+	})
+	luaRegister(l, "traceStart", func(*lua.LState) int {
+		stateTrace.Start()
+		return 0
+	})
+	luaRegister(l, "traceStop", func(*lua.LState) int {
+		stateTrace.Stop()
+		return 0
+	})
+	luaRegister(l, "trainingClearSlot", func(l *lua.LState) int {
+		dummyRec.Clear(int(numArg(l, 1)) - 1)
+		return 0
+	})
+	luaRegister(l, "trainingDeleteState", func(l *lua.LState) int {
+		savestates.Delete(strArg(l, 1))
+		return 0
+	})
+	luaRegister(l, "trainingExportPosition", func(l *lua.LState) int {
+		if err := savestates.ExportPosition(strArg(l, 1), strArg(l, 2)); err != nil {
+			l.RaiseError("\n%v\n", err.Error())
+		}
+		return 0
+	})
+	luaRegister(l, "trainingImportPosition", func(l *lua.LState) int {
+		name := strArg(l, 1)
+		if l.GetTop() >= 2 {
+			name = strArg(l, 2)
+		}
+		if err := savestates.ImportPosition(strArg(l, 1), name); err != nil {
+			l.RaiseError("\n%v\n", err.Error())
+		}
+		return 0
+	})
+	luaRegister(l, "trainingInfo", func(l *lua.LState) int {
+		tbl := l.NewTable()
+		tbl.RawSetString("recording", lua.LNumber(dummyRec.recording+1))
+		tbl.RawSetString("playback", lua.LString(dummyPlaybackNames[dummyRec.mode]))
+		tbl.RawSetString("slot", lua.LNumber(dummyRec.slot+1))
+		frames := l.NewTable()
+		for _, s := range dummyRec.slots {
+			frames.Append(lua.LNumber(len(s)))
+		}
+		tbl.RawSetString("frames", frames)
+		l.Push(tbl)
+		return 1
+	})
+	luaRegister(l, "trainingLoadState", func(l *lua.LState) int {
+		sum, err := savestates.Load(strArg(l, 1))
+		if err != nil {
+			sys.errLog.Printf("%v", err)
+			sys.appendToConsole(err.Error())
+			l.Push(lua.LFalse)
+			return 1
+		}
+		l.Push(lua.LString(fmt.Sprintf("%016x", sum)))
+		return 1
+	})
+	luaRegister(l, "trainingPlayback", func(l *lua.LState) int {
+		mode := DP_Off
+		name := strings.ToLower(strArg(l, 1))
+		for i, n := range dummyPlaybackNames {
+			if n == name {
+				mode = DummyPlayback(i)
+			}
+		}
+		slot := 0
+		if l.GetTop() >= 2 {
+			slot = int(numArg(l, 2))
+		}
+		dummyRec.SetPlayback(mode, slot-1)
+		return 0
+	})
+	luaRegister(l, "trainingRecord", func(l *lua.LState) int {
+		pn := 1
+		if l.GetTop() >= 2 {
+			pn = int(numArg(l, 2))
+		}
+		if err := dummyRec.Record(int(numArg(l, 1))-1, pn-1); err != nil {
+			l.RaiseError("\n%v\n", err.Error())
+		}
+		return 0
+	})
+	luaRegister(l, "trainingSaveState", func(l *lua.LState) int {
+		sum, err := savestates.Save(strArg(l, 1))
+		if err != nil {
+			sys.errLog.Printf("%v", err)
+			l.Push(lua.LFalse)
+			return 1
+		}
+		l.Push(lua.LString(fmt.Sprintf("%016x", sum)))
+		return 1
+	})
+	luaRegister(l, "trainingStateChecksum", func(l *lua.LState) int {
+		name := ""
+		if l.GetTop() >= 1 {
+			name = strArg(l, 1)
+		}
+		sum, err := savestates.Checksum(name)
+		if err != nil {
+			l.RaiseError("\n%v\n", err.Error())
+		}
+		l.Push(lua.LString(fmt.Sprintf("%016x", sum)))
+		return 1
+	})
+	luaRegister(l, "trainingStates", func(l *lua.LState) int {
+		tbl := l.NewTable()
+		for _, n := range savestates.Names() {
+			tbl.Append(lua.LString(n))
+		}
+		l.Push(tbl)
+		return 1
+	})
+	luaRegister(l, "trainingStop", func(l *lua.LState) int {
+		dummyRec.Stop()
+		return 0
+	})
 	luaRegister(l, "updateVolume", func(l *lua.LState) int {
 		if l.GetTop() >= 1 {
 			sys.bgm.bgmVolume = int(Min(int32(numArg(l, 1)), int32(sys.maxBgmVolume)))
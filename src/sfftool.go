@@ -0,0 +1,856 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SFF authoring tools. Sprites are read to memory without creating any
+// texture, exported to a folder of PNG files with a manifest, and packed
+// back into SFF v2.01 files.
+
+const sffManifestName = "sff.json"
+
+// Compression formats of SFF v2 sprite data
+var sffFormats = map[string]byte{
+	"raw": 0, "rle8": 2, "rle5": 3, "lz5": 4, "png8": 10, "png24": 11, "png32": 12,
+}
+
+func sffFormatName(format byte) string {
+	for k, v := range sffFormats {
+		if v == format {
+			return k
+		}
+	}
+	return ""
+}
+
+// ------------------------------------------------------------------
+// Reading
+
+type sffRawPalette struct {
+	group, number int16
+	colors        []uint32 // RGBA, as in PaletteList
+	link          int      // Index of the palette this one is an alias of, or -1
+}
+
+type sffRawSprite struct {
+	group, number int16
+	axis          [2]int16
+	w, h          int
+	depth         byte   // 8 for paletted sprites, 32 otherwise
+	pix           []byte // Palette indices, or non premultiplied RGBA
+	palette       int
+	format        string
+	link          int // Index of the sprite whose image is shared, or -1
+}
+
+type sffRaw struct {
+	header   SffHeader
+	palettes []*sffRawPalette
+	sprites  []*sffRawSprite
+}
+
+// Read every sprite and palette of an SFF v1 or v2 file
+func readSffRaw(filename string) (*sffRaw, error) {
+	f, err := vfs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sr := &sffRaw{}
+	var lofs, tofs uint32
+	if err := sr.header.Read(f, &lofs, &tofs); err != nil {
+		return nil, err
+	}
+	read := func(x interface{}) error {
+		return binary.Read(f, binary.LittleEndian, x)
+	}
+	readAt := func(ofs int64, size uint32) ([]byte, error) {
+		b := make([]byte, size)
+		_, err := f.ReadAt(b, ofs)
+		return b, err
+	}
+	for i := 0; i < int(sr.header.NumberOfPalettes); i++ {
+		f.Seek(int64(sr.header.FirstPaletteHeaderOffset)+int64(i*16), 0)
+		var gn [3]int16
+		var link uint16
+		var ofs, siz uint32
+		if err := read(gn[:]); err != nil {
+			return nil, err
+		}
+		if err := read(&link); err != nil {
+			return nil, err
+		}
+		if err := read(&ofs); err != nil {
+			return nil, err
+		}
+		if err := read(&siz); err != nil {
+			return nil, err
+		}
+		p := &sffRawPalette{group: gn[0], number: gn[1], link: -1}
+		if siz == 0 {
+			p.link = int(link)
+		} else {
+			data, err := readAt(int64(lofs+ofs), siz)
+			if err != nil {
+				return nil, err
+			}
+			p.colors = make([]uint32, 256)
+			for j := 0; j < len(data)/4 && j < 256; j++ {
+				rgba := data[j*4 : j*4+4]
+				if sr.header.Ver2 == 0 {
+					rgba[3] = byte(Btoi(j != 0) * 255)
+				}
+				p.colors[j] = uint32(rgba[3])<<24 | uint32(rgba[2])<<16 | uint32(rgba[1])<<8 | uint32(rgba[0])
+			}
+		}
+		sr.palettes = append(sr.palettes, p)
+	}
+	shofs := int64(sr.header.FirstSpriteHeaderOffset)
+	for i := 0; i < int(sr.header.NumberOfSprites); i++ {
+		f.Seek(shofs, 0)
+		tmp := newSprite()
+		var xofs, size uint32
+		var link uint16
+		rs := &sffRawSprite{link: -1, depth: 8}
+		if sr.header.Ver0 == 1 {
+			if err := tmp.readHeader(f, &xofs, &size, &link); err != nil {
+				return nil, err
+			}
+		} else if err := tmp.readHeaderV2(f, &xofs, &size, lofs, tofs, &link); err != nil {
+			return nil, err
+		}
+		rs.group, rs.number, rs.axis = tmp.Group, tmp.Number, tmp.Offset
+		switch {
+		case size == 0:
+			if int(link) >= i {
+				return nil, Error(fmt.Sprintf("sprite %v,%v links to a later sprite", rs.group, rs.number))
+			}
+			rs.link = int(link)
+			src := sr.sprites[rs.link]
+			rs.w, rs.h, rs.depth, rs.pix, rs.palette, rs.format = src.w, src.h, src.depth, src.pix, src.palette, src.format
+		case sr.header.Ver0 == 1:
+			if err := sr.readSpriteV1(f, tmp, rs, shofs, size, xofs); err != nil {
+				return nil, err
+			}
+		default:
+			rs.w, rs.h, rs.palette = int(tmp.Size[0]), int(tmp.Size[1]), tmp.palidx
+			data, err := readAt(int64(xofs), size)
+			if err != nil {
+				return nil, err
+			}
+			if err := rs.decodeV2(tmp, data); err != nil {
+				return nil, Error(fmt.Sprintf("sprite %v,%v: %v", rs.group, rs.number, err))
+			}
+		}
+		sr.sprites = append(sr.sprites, rs)
+		if sr.header.Ver0 == 1 {
+			shofs = int64(xofs)
+		} else {
+			shofs += 28
+		}
+	}
+	return sr, nil
+}
+
+// Read a PCX sprite. Sprites either carry a palette in their last 768 bytes
+// or use the palette of the previous sprite.
+func (sr *sffRaw) readSpriteV1(f VFile, tmp *Sprite, rs *sffRawSprite, shofs int64, size, next uint32) error {
+	offset := shofs + 32
+	if int64(next) > offset {
+		size = next - uint32(offset)
+	}
+	var ps byte
+	f.Seek(shofs+18, 0)
+	if err := binary.Read(f, binary.LittleEndian, &ps); err != nil {
+		return err
+	}
+	if err := tmp.readPcxHeader(f, offset); err != nil {
+		return err
+	}
+	rs.w, rs.h, rs.format = int(tmp.Size[0]), int(tmp.Size[1]), "pcx"
+	palSize := uint32(768)
+	if ps != 0 && len(sr.palettes) > 0 {
+		palSize = 0
+	}
+	if size < 128+palSize {
+		size = 128 + palSize
+	}
+	data := make([]byte, size-128)
+	if _, err := f.ReadAt(data, offset+128); err != nil && err != io.EOF {
+		return err
+	}
+	rs.pix = tmp.RlePcxDecode(data[:len(data)-int(palSize)])
+	if len(rs.pix) != rs.w*rs.h {
+		return Error(fmt.Sprintf("sprite %v,%v: invalid PCX data", rs.group, rs.number))
+	}
+	if palSize == 0 {
+		rs.palette = len(sr.palettes) - 1
+		return nil
+	}
+	pal := make([]uint32, 256)
+	rgb := data[len(data)-768:]
+	for i := range pal {
+		pal[i] = uint32(Btoi(i != 0)*255)<<24 | uint32(rgb[i*3+2])<<16 | uint32(rgb[i*3+1])<<8 | uint32(rgb[i*3])
+	}
+	sr.palettes = append(sr.palettes, &sffRawPalette{group: 1, number: int16(len(sr.palettes) + 1),
+		colors: pal, link: -1})
+	rs.palette = len(sr.palettes) - 1
+	return nil
+}
+
+func (rs *sffRawSprite) decodeV2(tmp *Sprite, data []byte) error {
+	format := byte(-tmp.rle)
+	rs.format = sffFormatName(format)
+	if format != 0 {
+		if len(data) < 4 {
+			return Error("truncated data")
+		}
+		data = data[4:]
+	}
+	switch format {
+	case 0:
+		switch tmp.coldepth {
+		case 8:
+			rs.pix = data
+		case 24, 32:
+			rs.depth = 32
+			rs.pix = make([]byte, rs.w*rs.h*4)
+			bpp := int(tmp.coldepth / 8)
+			for i := 0; i < rs.w*rs.h && (i+1)*bpp <= len(data); i++ {
+				copy(rs.pix[i*4:], data[i*bpp:(i+1)*bpp])
+				if bpp == 3 {
+					rs.pix[i*4+3] = 255
+				}
+			}
+		default:
+			return Error("unknown color depth")
+		}
+	case 2:
+		rs.pix = tmp.Rle8Decode(data)
+	case 3:
+		rs.pix = tmp.Rle5Decode(data)
+	case 4:
+		rs.pix = tmp.Lz5Decode(data)
+	case 10, 11, 12:
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if pi, ok := img.(*image.Paletted); ok && format == 10 {
+			rs.pix = pi.Pix
+		} else {
+			rs.depth = 32
+			rs.pix = imageToNRGBA(img).Pix
+		}
+	default:
+		return Error("unknown format")
+	}
+	if rs.depth == 8 && len(rs.pix) != rs.w*rs.h {
+		return Error("pixel data does not match the sprite size")
+	}
+	return nil
+}
+
+func imageToNRGBA(img image.Image) *image.NRGBA {
+	if n, ok := img.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) && n.Stride == n.Rect.Dx()*4 {
+		return n
+	}
+	n := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(n, n.Rect, img, img.Bounds().Min, draw.Src)
+	return n
+}
+
+// Colors of a palette, following aliases
+func (sr *sffRaw) paletteColors(i int) []uint32 {
+	for n := 0; i >= 0 && i < len(sr.palettes) && n < len(sr.palettes); n++ {
+		if p := sr.palettes[i]; p.link < 0 {
+			return p.colors
+		} else {
+			i = p.link
+		}
+	}
+	return make([]uint32, 256)
+}
+
+// ------------------------------------------------------------------
+// Export
+
+type sffManifest struct {
+	Version  string
+	Palettes []sffManifestPalette
+	Sprites  []sffManifestSprite
+}
+
+type sffManifestPalette struct {
+	Group, Number int16
+	// Reversed .act file, as used for character palettes
+	File string `json:",omitempty"`
+	// Index of the palette this one is an alias of
+	Link *int `json:",omitempty"`
+	// Alpha of each color, if not the default 0 for color 0 and 255 for the
+	// others
+	Alpha []int `json:",omitempty"`
+}
+
+type sffManifestSprite struct {
+	Group, Number int16
+	File          string `json:",omitempty"`
+	Axis          [2]int16
+	// Index in Palettes, for paletted sprites
+	Palette int
+	// Compression in the source file
+	Format string
+	// Index of the sprite whose image is shared
+	Link *int `json:",omitempty"`
+}
+
+func palToColors(pal []uint32) color.Palette {
+	cp := make(color.Palette, len(pal))
+	for i, c := range pal {
+		cp[i] = color.NRGBA{uint8(c), uint8(c >> 8), uint8(c >> 16), uint8(c >> 24)}
+	}
+	return cp
+}
+
+// Export an SFF to a folder of PNG files, palettes and a manifest
+func exportSff(filename, dir string) error {
+	sr, err := readSffRaw(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	m := sffManifest{Version: fmt.Sprintf("%v.%v%v", sr.header.Ver0, sr.header.Ver1, sr.header.Ver2)}
+	for i, p := range sr.palettes {
+		mp := sffManifestPalette{Group: p.group, Number: p.number}
+		if p.link >= 0 {
+			link := p.link
+			mp.Link = &link
+		} else {
+			mp.File = fmt.Sprintf("pal_%v_%v_%v.act", p.group, p.number, i)
+			act := make([]byte, 0, 768)
+			for j := 255; j >= 0; j-- {
+				c := p.colors[j]
+				act = append(act, byte(c), byte(c>>8), byte(c>>16))
+			}
+			for j, c := range p.colors {
+				if byte(c>>24) != byte(Btoi(j != 0)*255) {
+					mp.Alpha = make([]int, 256)
+					for k, c := range p.colors {
+						mp.Alpha[k] = int(c >> 24)
+					}
+					break
+				}
+			}
+			if err := os.WriteFile(filepath.Join(dir, mp.File), act, 0644); err != nil {
+				return err
+			}
+		}
+		m.Palettes = append(m.Palettes, mp)
+	}
+	for i, rs := range sr.sprites {
+		ms := sffManifestSprite{Group: rs.group, Number: rs.number, Axis: rs.axis,
+			Palette: rs.palette, Format: rs.format}
+		if rs.link >= 0 {
+			link := rs.link
+			ms.Link = &link
+			m.Sprites = append(m.Sprites, ms)
+			continue
+		}
+		ms.File = fmt.Sprintf("%v_%v_%v.png", rs.group, rs.number, i)
+		var img image.Image
+		rect := image.Rect(0, 0, rs.w, rs.h)
+		if rs.depth == 8 {
+			img = &image.Paletted{Pix: rs.pix, Stride: rs.w, Rect: rect,
+				Palette: palToColors(sr.paletteColors(rs.palette))}
+		} else {
+			img = &image.NRGBA{Pix: rs.pix, Stride: rs.w * 4, Rect: rect}
+		}
+		var buf bytes.Buffer
+		if rs.w > 0 && rs.h > 0 {
+			if err := png.Encode(&buf, img); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, ms.File), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+		m.Sprites = append(m.Sprites, ms)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, sffManifestName), data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported %v sprites and %v palettes from %v to %v\n",
+		len(sr.sprites), len(sr.palettes), filename, dir)
+	return nil
+}
+
+// ------------------------------------------------------------------
+// Building
+
+var sffPngNameRegexp = regexp.MustCompile(`^(-?[0-9]+)[_,](-?[0-9]+)(?:_[0-9]+)?\.png$`)
+
+// Read a sprite folder exported by exportSff. Folders without a manifest
+// may just contain "<group>_<number>.png" files, which then get their
+// palettes from the PNG files.
+func readSffFolder(dir string) (*sffRaw, error) {
+	var m sffManifest
+	if data, err := os.ReadFile(filepath.Join(dir, sffManifestName)); err == nil {
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, Error(fmt.Sprintf("%v: %v", sffManifestName, err))
+		}
+	} else {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if g := sffPngNameRegexp.FindStringSubmatch(strings.ToLower(e.Name())); g != nil {
+				gr, _ := strconv.Atoi(g[1])
+				no, _ := strconv.Atoi(g[2])
+				m.Sprites = append(m.Sprites, sffManifestSprite{Group: int16(gr), Number: int16(no),
+					File: e.Name(), Palette: -1})
+			}
+		}
+		sort.SliceStable(m.Sprites, func(i, j int) bool {
+			a, b := m.Sprites[i], m.Sprites[j]
+			return a.Group < b.Group || a.Group == b.Group && a.Number < b.Number
+		})
+	}
+	sr := &sffRaw{}
+	for i, mp := range m.Palettes {
+		p := &sffRawPalette{group: mp.Group, number: mp.Number, link: -1}
+		if mp.Link != nil {
+			if *mp.Link < 0 || *mp.Link >= i {
+				return nil, Error(fmt.Sprintf("palette %v,%v: invalid link", mp.Group, mp.Number))
+			}
+			p.link = *mp.Link
+		} else {
+			act, err := os.ReadFile(filepath.Join(dir, mp.File))
+			if err != nil {
+				return nil, err
+			}
+			if len(act) < 768 {
+				return nil, Error(fmt.Sprintf("%v: not a 768 byte .act file", mp.File))
+			}
+			p.colors = make([]uint32, 256)
+			for j := range p.colors {
+				rgb := act[(255-j)*3:]
+				a := uint32(Btoi(j != 0) * 255)
+				if len(mp.Alpha) == 256 {
+					a = uint32(mp.Alpha[j])
+				}
+				p.colors[j] = a<<24 | uint32(rgb[2])<<16 | uint32(rgb[1])<<8 | uint32(rgb[0])
+			}
+		}
+		sr.palettes = append(sr.palettes, p)
+	}
+	for i, ms := range m.Sprites {
+		rs := &sffRawSprite{group: ms.Group, number: ms.Number, axis: ms.Axis,
+			palette: ms.Palette, format: ms.Format, link: -1, depth: 8}
+		if ms.Link != nil {
+			if *ms.Link < 0 || *ms.Link >= i {
+				return nil, Error(fmt.Sprintf("sprite %v,%v: invalid link", ms.Group, ms.Number))
+			}
+			src := sr.sprites[*ms.Link]
+			rs.link, rs.w, rs.h, rs.depth, rs.pix, rs.palette = *ms.Link, src.w, src.h, src.depth, src.pix, src.palette
+			sr.sprites = append(sr.sprites, rs)
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, ms.File))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > 0 {
+			img, err := png.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, Error(fmt.Sprintf("%v: %v", ms.File, err))
+			}
+			rs.w, rs.h = img.Bounds().Dx(), img.Bounds().Dy()
+			if pi, ok := img.(*image.Paletted); ok {
+				rs.pix = make([]byte, 0, rs.w*rs.h)
+				for y := 0; y < rs.h; y++ {
+					rs.pix = append(rs.pix, pi.Pix[y*pi.Stride:y*pi.Stride+rs.w]...)
+				}
+				if rs.palette < 0 {
+					rs.palette = sr.addPalette(pi.Palette)
+				}
+			} else {
+				rs.depth = 32
+				rs.pix = imageToNRGBA(img).Pix
+			}
+		}
+		if rs.depth == 8 && (rs.palette < 0 || rs.palette >= len(sr.palettes)) {
+			return nil, Error(fmt.Sprintf("sprite %v,%v: invalid palette %v", ms.Group, ms.Number, rs.palette))
+		}
+		sr.sprites = append(sr.sprites, rs)
+	}
+	return sr, nil
+}
+
+// Add a palette taken from a PNG file, sharing an existing one if the colors
+// are the same
+func (sr *sffRaw) addPalette(cp color.Palette) int {
+	pal := make([]uint32, 256)
+	for i := 0; i < len(cp) && i < 256; i++ {
+		c := color.NRGBAModel.Convert(cp[i]).(color.NRGBA)
+		pal[i] = uint32(c.A)<<24 | uint32(c.B)<<16 | uint32(c.G)<<8 | uint32(c.R)
+	}
+	for i, p := range sr.palettes {
+		if p.link < 0 && equalPalettes(p.colors, pal) {
+			return i
+		}
+	}
+	sr.palettes = append(sr.palettes, &sffRawPalette{group: 1, number: int16(len(sr.palettes) + 1),
+		colors: pal, link: -1})
+	return len(sr.palettes) - 1
+}
+
+func equalPalettes(a, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode paletted pixels. LZ5 and RLE5 can only store colors below 32, other
+// sprites fall back to RLE8.
+func encodeSffPixels(rs *sffRawSprite, format byte, pal []uint32) (byte, []byte) {
+	if rs.depth != 8 {
+		if format != 0 {
+			format = 12
+		}
+	} else if format == 11 || format == 12 {
+		format = 10
+	}
+	if format == 3 || format == 4 {
+		for _, p := range rs.pix {
+			if p >= 32 {
+				format = 2
+				break
+			}
+		}
+	}
+	var data []byte
+	switch format {
+	case 0:
+		return 0, rs.pix
+	case 2:
+		data = rle8Encode(rs.pix)
+	case 3:
+		data = rle5Encode(rs.pix)
+	case 4:
+		data = lz5Encode(rs.pix)
+	case 10, 12:
+		var img image.Image
+		rect := image.Rect(0, 0, rs.w, rs.h)
+		if format == 10 {
+			img = &image.Paletted{Pix: rs.pix, Stride: rs.w, Rect: rect, Palette: palToColors(pal)}
+		} else {
+			img = &image.NRGBA{Pix: rs.pix, Stride: rs.w * 4, Rect: rect}
+		}
+		var buf bytes.Buffer
+		chk(png.Encode(&buf, img))
+		data = buf.Bytes()
+	}
+	return format, data
+}
+
+func rle8Encode(px []byte) []byte {
+	var out []byte
+	for i := 0; i < len(px); {
+		c, n := px[i], 1
+		for i+n < len(px) && px[i+n] == c && n < 63 {
+			n++
+		}
+		if n == 1 && c&0xc0 != 0x40 {
+			out = append(out, c)
+		} else {
+			out = append(out, 0x40|byte(n), c)
+		}
+		i += n
+	}
+	return out
+}
+
+func rle5Encode(px []byte) []byte {
+	var out []byte
+	run := func(i, max int) int {
+		n := 1
+		for i+n < len(px) && px[i+n] == px[i] && n < max {
+			n++
+		}
+		return n
+	}
+	for i := 0; i < len(px); {
+		// The first run of a packet has any color and up to 256 pixels,
+		// the following ones colors below 32 and up to 8 pixels
+		c, n := px[i], run(i, 256)
+		i += n
+		start := len(out)
+		out = append(out, byte(n-1), 0)
+		if c != 0 {
+			out[start+1] = 0x80
+			out = append(out, c)
+		}
+		dl := 0
+		for i < len(px) && px[i] < 32 && dl < 127 {
+			n := run(i, 8)
+			out = append(out, byte(n-1)<<5|px[i])
+			i += n
+			dl++
+		}
+		out[start+1] |= byte(dl)
+	}
+	return out
+}
+
+// LZ5 packets: runs of colors below 32, and copies of up to 64 pixels from
+// up to 256 pixels back, or up to 258 pixels from up to 1024 pixels back.
+// The top bits of three short copies hold the offset of the fourth one.
+func lz5Encode(px []byte) []byte {
+	out := []byte{0}
+	ctPos, packets := 0, 0
+	var recycled []int // Short copies whose top bits are free
+	head := make([]int32, 1<<16)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(px))
+	insert := func(i int) {
+		if i+1 < len(px) {
+			k := int(px[i])<<8 | int(px[i+1])
+			prev[i], head[k] = head[k], int32(i)
+		}
+	}
+	for i := 0; i < len(px); {
+		if packets == 8 {
+			ctPos, packets = len(out), 0
+			out = append(out, 0)
+		}
+		runLen := 1
+		for i+runLen < len(px) && px[i+runLen] == px[i] && runLen < 263 {
+			runLen++
+		}
+		// Longest match in the window
+		bestLen, bestOfs := 0, 0
+		if i+1 < len(px) {
+			steps := 0
+			for j := head[int(px[i])<<8|int(px[i+1])]; j >= 0 && i-int(j) <= 1024 && steps < 256; j = prev[j] {
+				n := 0
+				for i+n < len(px) && px[int(j)+n] == px[i+n] && n < 258 {
+					n++
+				}
+				if ofs := i - int(j); n > bestLen && (n >= 3 || ofs <= 256) {
+					bestLen, bestOfs = n, ofs
+				}
+				steps++
+			}
+		}
+		n := 0
+		if bestLen >= 2 && bestLen > runLen {
+			out[ctPos] |= 1 << packets
+			if bestOfs <= 256 && bestLen <= 64 {
+				n = bestLen
+				recycled = append(recycled, len(out))
+				out = append(out, byte(n-1))
+				ofs := byte(bestOfs - 1)
+				if len(recycled) < 4 {
+					out = append(out, ofs)
+				} else {
+					for k, pos := range recycled {
+						out[pos] |= ((ofs >> (6 - 2*k)) & 3) << 6
+					}
+					recycled = recycled[:0]
+				}
+			} else {
+				n = bestLen
+				ofs := bestOfs - 1
+				out = append(out, byte(ofs>>2)&0xc0, byte(ofs), byte(n-3))
+			}
+		} else if runLen >= 8 {
+			n = runLen
+			out = append(out, px[i], byte(n-8))
+		} else {
+			n = runLen
+			out = append(out, byte(n)<<5|px[i])
+		}
+		for k := 0; k < n; k++ {
+			insert(i + k)
+		}
+		i += n
+		packets++
+	}
+	return out
+}
+
+// Write an SFF v2.01 file. Sprites and palettes with the same data are
+// stored once.
+func writeSffV2(filename string, sr *sffRaw, format byte) error {
+	var ldata []byte
+	var palNodes, sprNodes []byte
+	le := binary.LittleEndian
+	for i, p := range sr.palettes {
+		link, ofs, siz := uint16(0), uint32(0), uint32(0)
+		target := p.link
+		if target < 0 {
+			for j := 0; j < i; j++ {
+				if q := sr.palettes[j]; q.link < 0 && equalPalettes(q.colors, p.colors) {
+					target = j
+					break
+				}
+			}
+		}
+		if target >= 0 {
+			link = uint16(target)
+		} else {
+			ofs, siz = uint32(len(ldata)), 1024
+			for _, c := range p.colors {
+				ldata = le.AppendUint32(ldata, c)
+			}
+		}
+		palNodes = le.AppendUint16(palNodes, uint16(p.group))
+		palNodes = le.AppendUint16(palNodes, uint16(p.number))
+		palNodes = le.AppendUint16(palNodes, 256)
+		palNodes = le.AppendUint16(palNodes, link)
+		palNodes = le.AppendUint32(palNodes, ofs)
+		palNodes = le.AppendUint32(palNodes, siz)
+	}
+	images := make(map[string]int)
+	for i, rs := range sr.sprites {
+		f := format
+		if f == 0xff {
+			if v, ok := sffFormats[rs.format]; ok {
+				f = v
+			} else {
+				f = 4
+			}
+		}
+		link, ofs, size := uint16(0), uint32(0), uint32(0)
+		var fmtOut byte
+		key := fmt.Sprintf("%v,%v,%v,%v:", rs.w, rs.h, rs.depth, rs.palette) + string(rs.pix)
+		if rs.link >= 0 {
+			link = uint16(rs.link)
+		} else if j, ok := images[key]; ok {
+			link = uint16(j)
+		} else {
+			images[key] = i
+			var data []byte
+			fmtOut, data = encodeSffPixels(rs, f, sr.paletteColors(rs.palette))
+			if fmtOut != 0 {
+				data = append(le.AppendUint32(nil, uint32(len(rs.pix))), data...)
+			}
+			ofs, size = uint32(len(ldata)), uint32(len(data))
+			ldata = append(ldata, data...)
+		}
+		sprNodes = le.AppendUint16(sprNodes, uint16(rs.group))
+		sprNodes = le.AppendUint16(sprNodes, uint16(rs.number))
+		sprNodes = le.AppendUint16(sprNodes, uint16(rs.w))
+		sprNodes = le.AppendUint16(sprNodes, uint16(rs.h))
+		sprNodes = le.AppendUint16(sprNodes, uint16(rs.axis[0]))
+		sprNodes = le.AppendUint16(sprNodes, uint16(rs.axis[1]))
+		sprNodes = le.AppendUint16(sprNodes, link)
+		sprNodes = append(sprNodes, fmtOut, rs.depth)
+		sprNodes = le.AppendUint32(sprNodes, ofs)
+		sprNodes = le.AppendUint32(sprNodes, size)
+		if rs.depth == 8 {
+			sprNodes = le.AppendUint16(sprNodes, uint16(rs.palette))
+		} else {
+			sprNodes = le.AppendUint16(sprNodes, 0)
+		}
+		sprNodes = le.AppendUint16(sprNodes, 0)
+	}
+	const headerSize = 512
+	palOfs := uint32(headerSize)
+	sprOfs := palOfs + uint32(len(palNodes))
+	lofs := sprOfs + uint32(len(sprNodes))
+	h := make([]byte, 0, headerSize)
+	h = append(h, "ElecbyteSpr\x00"...)
+	h = append(h, 0, 1, 0, 2) // 2.01
+	h = append(h, make([]byte, 20)...)
+	h = le.AppendUint32(h, sprOfs)
+	h = le.AppendUint32(h, uint32(len(sr.sprites)))
+	h = le.AppendUint32(h, palOfs)
+	h = le.AppendUint32(h, uint32(len(sr.palettes)))
+	h = le.AppendUint32(h, lofs)
+	h = le.AppendUint32(h, uint32(len(ldata)))
+	h = le.AppendUint32(h, lofs+uint32(len(ldata)))
+	h = le.AppendUint32(h, 0)
+	h = append(h, make([]byte, headerSize-len(h))...)
+	out := append(append(append(h, palNodes...), sprNodes...), ldata...)
+	return os.WriteFile(filename, out, 0644)
+}
+
+// Pack a sprite folder into an SFF v2.01 file, then read it back and check
+// that every sprite and palette decodes to the source data
+func buildSff(dir, filename, formatName string) error {
+	format := byte(0xff) // Keep the format of each sprite
+	if formatName != "" {
+		f, ok := sffFormats[strings.ToLower(formatName)]
+		if !ok {
+			return Error(fmt.Sprintf("unknown sprite format: %v", formatName))
+		}
+		format = f
+	}
+	sr, err := readSffFolder(dir)
+	if err != nil {
+		return err
+	}
+	if err := writeSffV2(filename, sr, format); err != nil {
+		return err
+	}
+	out, err := readSffRaw(filename)
+	if err != nil {
+		return Error(fmt.Sprintf("verification failed: %v", err))
+	}
+	if err := compareSffRaw(sr, out); err != nil {
+		return Error(fmt.Sprintf("verification failed: %v", err))
+	}
+	fmt.Printf("Built %v with %v sprites and %v palettes\n", filename, len(sr.sprites), len(sr.palettes))
+	return nil
+}
+
+func compareSffRaw(a, b *sffRaw) error {
+	if len(a.sprites) != len(b.sprites) || len(a.palettes) != len(b.palettes) {
+		return Error("different number of sprites or palettes")
+	}
+	for i := range a.palettes {
+		if !equalPalettes(a.paletteColors(i), b.paletteColors(i)) {
+			return Error(fmt.Sprintf("palette %v differs", i))
+		}
+	}
+	for i, x := range a.sprites {
+		y := b.sprites[i]
+		if x.group != y.group || x.number != y.number || x.axis != y.axis ||
+			x.w != y.w || x.h != y.h || x.depth != y.depth || !bytes.Equal(x.pix, y.pix) ||
+			x.depth == 8 && !equalPalettes(a.paletteColors(x.palette), b.paletteColors(y.palette)) {
+			return Error(fmt.Sprintf("sprite %v,%v differs", x.group, x.number))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Random pixels below max
+func sffTestRandom(r *rand.Rand, n, max int) []byte {
+	px := make([]byte, n)
+	for i := range px {
+		px[i] = byte(r.Intn(max))
+	}
+	return px
+}
+
+// Random pixels followed by a copy of n pixels from ofs pixels back
+func sffTestCopy(r *rand.Rand, ofs, n, max int) []byte {
+	px := sffTestRandom(r, ofs, max)
+	for i := 0; i < n; i++ {
+		px = append(px, px[len(px)-ofs])
+	}
+	return append(px, sffTestRandom(r, 16, max)...)
+}
+
+// Runs of a single color of every length from 1 to max
+func sffTestRuns(max, colors int) []byte {
+	var px []byte
+	for n := 1; n <= max; n++ {
+		px = append(px, bytes.Repeat([]byte{byte(n % colors)}, n)...)
+	}
+	return px
+}
+
+func sffTestInputs(colors int) map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	in := map[string][]byte{
+		"empty":       {},
+		"single":      {byte(colors - 1)},
+		"random":      sffTestRandom(r, 4096, colors),
+		"few colors":  sffTestRandom(r, 4096, 2),
+		"zeros":       make([]byte, 5000),
+		"runs":        sffTestRuns(300, colors),
+		"long run":    bytes.Repeat([]byte{byte(colors - 1)}, 20000),
+		"short tail":  append(bytes.Repeat([]byte{3}, 300), 1),
+		"copy before": append(sffTestRandom(r, 16, colors), sffTestRandom(r, 4096, colors)...),
+	}
+	// The limits of the LZ5 copies: short ones up to 64 pixels from up to
+	// 256 back, long ones up to 258 pixels from up to 1024 back
+	for _, ofs := range []int{1, 2, 255, 256, 257, 1023, 1024, 1025} {
+		for _, n := range []int{2, 3, 63, 64, 65, 257, 258, 259, 600} {
+			in[fmt.Sprintf("copy %v from %v", n, ofs)] = sffTestCopy(r, ofs, n, colors)
+		}
+	}
+	return in
+}
+
+func TestSffEncodeRoundTrip(t *testing.T) {
+	formats := []struct {
+		name   string
+		encode func([]byte) []byte
+		decode func(*Sprite, []byte) []byte
+		colors int
+	}{
+		{"rle8", rle8Encode, (*Sprite).Rle8Decode, 256},
+		{"rle5", rle5Encode, (*Sprite).Rle5Decode, 32},
+		{"lz5", lz5Encode, (*Sprite).Lz5Decode, 32},
+	}
+	for _, f := range formats {
+		for name, px := range sffTestInputs(f.colors) {
+			s := newSprite()
+			s.Size = [2]uint16{uint16(len(px)), 1}
+			got := f.decode(s, f.encode(px))
+			if !bytes.Equal(got, px) {
+				i := 0
+				for i < len(got) && i < len(px) && got[i] == px[i] {
+					i++
+				}
+				t.Errorf("%v %v: decoded %v pixels, want %v, first difference at %v",
+					f.name, name, len(got), len(px), i)
+			}
+		}
+	}
+}
+
+// Sprites and palettes that cover the formats and links of SFF v2: a
+// paletted sprite, RGBA sprites with and without transparency, a linked
+// sprite and a sprite with the same image as another one
+func sffTestRaw() *sffRaw {
+	r := rand.New(rand.NewSource(2))
+	pal := make([]uint32, 256)
+	for i := range pal {
+		pal[i] = 0xff000000 | r.Uint32()&0xffffff
+	}
+	pal[0] &= 0xffffff
+	alpha := append([]uint32(nil), pal...)
+	alpha[5] = alpha[5]&0xffffff | 0x80000000
+	sr := &sffRaw{palettes: []*sffRawPalette{
+		{group: 1, number: 1, colors: pal, link: -1},
+		{group: 1, number: 2, link: 0},
+		{group: 1, number: 3, colors: alpha, link: -1},
+	}}
+	paletted := &sffRawSprite{group: 0, number: 0, axis: [2]int16{3, -2}, w: 5, h: 3, depth: 8,
+		pix: sffTestRandom(r, 15, 256), palette: 0, format: "png8", link: -1}
+	rgba := &sffRawSprite{group: 0, number: 1, axis: [2]int16{-1, 7}, w: 4, h: 2, depth: 32,
+		pix: sffTestRandom(r, 32, 256), format: "png32", link: -1}
+	opaque := &sffRawSprite{group: 5, number: 0, w: 3, h: 3, depth: 32,
+		pix: sffTestRandom(r, 36, 256), format: "png32", link: -1}
+	for i := 3; i < len(opaque.pix); i += 4 {
+		opaque.pix[i] = 255
+	}
+	// Non premultiplied colors of fully transparent pixels are not kept
+	for i := 3; i < len(rgba.pix); i += 4 {
+		if rgba.pix[i] == 0 {
+			rgba.pix[i] = 1
+		}
+	}
+	small := &sffRawSprite{group: 9000, number: 0, w: 2, h: 2, depth: 8,
+		pix: []byte{0, 1, 2, 31}, palette: 2, format: "lz5", link: -1}
+	linked := *paletted
+	linked.number, linked.link = 10, 0
+	same := *small
+	same.number, same.format = 1, "rle8"
+	sr.sprites = []*sffRawSprite{paletted, rgba, opaque, small, &linked, &same}
+	return sr
+}
+
+// The PNG formats as stored in SFF v2 sprite data, after the size
+func TestSffDecodePng(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	pal := make(color.Palette, 16)
+	for i := range pal {
+		pal[i] = color.NRGBA{uint8(r.Intn(256)), uint8(r.Intn(256)), uint8(r.Intn(256)), 255}
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 3), pal)
+	copy(paletted.Pix, sffTestRandom(r, 12, 16))
+	rgb := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	rgba := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	copy(rgb.Pix, sffTestRandom(r, 24, 256))
+	copy(rgba.Pix, sffTestRandom(r, 24, 256))
+	for i := 3; i < len(rgb.Pix); i += 4 {
+		rgb.Pix[i] = 255
+		rgba.Pix[i] = max(rgba.Pix[i], 1)
+	}
+	cases := []struct {
+		name   string
+		format byte
+		img    image.Image
+		depth  byte
+		want   []byte
+	}{
+		{"png8", 10, paletted, 8, paletted.Pix},
+		{"png24", 11, rgb, 32, rgb.Pix},
+		{"png32", 12, rgba, 32, rgba.Pix},
+		// A format 10 sprite that is not paletted is read as RGBA
+		{"png8 rgba", 10, rgba, 32, rgba.Pix},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, c.img); err != nil {
+			t.Fatal(err)
+		}
+		tmp := newSprite()
+		tmp.rle = -int(c.format)
+		rs := &sffRawSprite{w: c.img.Bounds().Dx(), h: c.img.Bounds().Dy(), depth: 8}
+		data := append(binary.LittleEndian.AppendUint32(nil, uint32(len(c.want))), buf.Bytes()...)
+		if err := rs.decodeV2(tmp, data); err != nil {
+			t.Errorf("%v: %v", c.name, err)
+		} else if rs.depth != c.depth || !bytes.Equal(rs.pix, c.want) || rs.format != sffFormatName(c.format) {
+			t.Errorf("%v: got %v bit %v pixels %v, want %v bit %v", c.name, rs.depth, rs.format, rs.pix, c.depth, c.want)
+		}
+	}
+	tmp := newSprite()
+	tmp.rle = -10
+	if err := (&sffRawSprite{w: 1, h: 1}).decodeV2(tmp, []byte{1, 0, 0, 0, 'x'}); err == nil {
+		t.Error("invalid PNG data did not fail")
+	}
+}
+
+func TestSffWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	for _, format := range []string{"", "raw", "rle8", "rle5", "lz5", "png8", "png32"} {
+		sr := sffTestRaw()
+		f := byte(0xff)
+		if format != "" {
+			f = sffFormats[format]
+		}
+		fn := filepath.Join(dir, "test"+format+".sff")
+		if err := writeSffV2(fn, sr, f); err != nil {
+			t.Fatal(err)
+		}
+		out, err := readSffRaw(fn)
+		if err != nil {
+			t.Errorf("%v: %v", format, err)
+			continue
+		}
+		if err := compareSffRaw(sr, out); err != nil {
+			t.Errorf("%v: %v", format, err)
+		}
+		// Sprites keep their own format unless one is given. RGBA sprites
+		// are always stored as PNG, paletted ones never are as RGBA, and
+		// colors above 31 do not fit RLE5 or LZ5.
+		want := []string{"png8", "png32", "png32", "lz5", "png8", "lz5"}
+		switch format {
+		case "":
+		case "raw":
+			want = []string{"raw", "raw", "raw", "raw", "raw", "raw"}
+		case "rle5", "lz5":
+			want = []string{"rle8", "png32", "png32", format, "rle8", format}
+		case "png32":
+			want = []string{"png8", "png32", "png32", "png8", "png8", "png8"}
+		default:
+			want = []string{format, "png32", "png32", format, format, format}
+		}
+		for i, rs := range out.sprites {
+			if rs.format != want[i] {
+				t.Errorf("%v: sprite %v,%v is %v, want %v", format, rs.group, rs.number, rs.format, want[i])
+			}
+		}
+		if out.sprites[4].link != 0 || out.sprites[5].link != 3 || out.palettes[1].link != 0 {
+			t.Errorf("%v: links are not kept", format)
+		}
+	}
+}
+
+func TestSffExportBuild(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.sff")
+	if err := writeSffV2(src, sffTestRaw(), 0xff); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []string{src, "testdata/char/test.sff"} {
+		orig, err := readSffRaw(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp, built := filepath.Join(dir, "export"), filepath.Join(dir, "built.sff")
+		os.RemoveAll(exp)
+		if err := exportSff(fn, exp); err != nil {
+			t.Fatalf("%v: %v", fn, err)
+		}
+		if err := buildSff(exp, built, ""); err != nil {
+			t.Fatalf("%v: %v", fn, err)
+		}
+		out, err := readSffRaw(built)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := compareSffRaw(orig, out); err != nil {
+			t.Errorf("%v: %v", fn, err)
+		}
+		for i, rs := range out.sprites {
+			if rs.format != orig.sprites[i].format || rs.link != orig.sprites[i].link {
+				t.Errorf("%v: sprite %v,%v is %v linked to %v, want %v linked to %v", fn, rs.group, rs.number,
+					rs.format, rs.link, orig.sprites[i].format, orig.sprites[i].link)
+			}
+		}
+		// Without the manifest, the palettes come from the PNG files
+		if err := os.Remove(filepath.Join(exp, sffManifestName)); err != nil {
+			t.Fatal(err)
+		}
+		if err := buildSff(exp, built, "lz5"); err != nil {
+			t.Fatalf("%v without a manifest: %v", fn, err)
+		}
+		if out, err = readSffRaw(built); err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range orig.sprites {
+			found := false
+			for _, o := range out.sprites {
+				if o.group == rs.group && o.number == rs.number {
+					found = bytes.Equal(o.pix, rs.pix) && (rs.depth != 8 ||
+						equalPalettes(out.paletteColors(o.palette), orig.paletteColors(rs.palette)))
+				}
+			}
+			if !found && rs.link < 0 {
+				t.Errorf("%v without a manifest: sprite %v,%v differs", fn, rs.group, rs.number)
+			}
+		}
+	}
+}
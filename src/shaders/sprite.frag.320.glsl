@@ -4,6 +4,7 @@ uniform sampler2D tex;
 uniform sampler2D pal;
 
 uniform vec4 x1x2x4x3;
+uniform vec4 uvRect;
 uniform vec4 tint;
 uniform vec3 add, mult;
 uniform float alpha, gray, hue;
@@ -29,10 +30,11 @@ void main(void) {
     } else {
         vec2 uv = texcoord;
         if (isTrapez) {
-            // Compute left/right trapezoid bounds at height uv.y
-            vec2 bounds = mix(x1x2x4x3.zw, x1x2x4x3.xy, uv.y);
+            // Compute left/right trapezoid bounds at height uv.y, relative to
+            // the sprite rectangle inside its texture
+            vec2 bounds = mix(x1x2x4x3.zw, x1x2x4x3.xy, (uv.y - uvRect.y) / uvRect.w);
             // Correct uv.x from the fragment position on that segment
-            uv.x = (gl_FragCoord.x - bounds[0]) / (bounds[1] - bounds[0]);
+            uv.x = uvRect.x + uvRect.z * (gl_FragCoord.x - bounds[0]) / (bounds[1] - bounds[0]);
         }
 
         vec4 c = texture(tex, uv);
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"math"
-	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gopxl/beep/v2"
 	"github.com/gopxl/beep/v2/effects"
@@ -15,6 +18,7 @@ import (
 	"github.com/gopxl/beep/v2/speaker"
 	"github.com/gopxl/beep/v2/vorbis"
 	"github.com/gopxl/beep/v2/wav"
+	"github.com/mewkiz/flac"
 )
 
 const (
@@ -113,23 +117,40 @@ func (b *StreamLooper) Stream(samples [][2]float64) (n int, ok bool) {
 	if b.loopcount == 0 || b.s.Err() != nil {
 		return 0, false
 	}
+	wrapped := false
 	for len(samples) > 0 {
-		sn, sok := b.s.Stream(samples)
-		if !sok || (b.s.Position() >= b.loopend && b.loopend < b.s.Len()) {
-			if b.loopcount > 0 {
-				b.loopcount--
-			}
-			if b.loopcount == 0 {
-				break
-			}
-			err := b.s.Seek(b.loopstart)
-			if err != nil {
-				return n, true
-			}
-			continue
+		// Stop reading at the loop end so the loop is sample accurate
+		buf := samples
+		if b.loopend < b.s.Len() {
+			buf = buf[:max(0, min(b.loopend-b.s.Position(), len(buf)))]
+		}
+		sn, sok := 0, false
+		if len(buf) > 0 {
+			sn, sok = b.s.Stream(buf)
 		}
 		samples = samples[sn:]
 		n += sn
+		if sn > 0 {
+			wrapped = false
+			if sok && (b.loopend >= b.s.Len() || b.s.Position() < b.loopend) {
+				continue
+			}
+		}
+		// Nothing to play between the loop points
+		if wrapped {
+			break
+		}
+		if b.loopcount > 0 {
+			b.loopcount--
+		}
+		if b.loopcount == 0 {
+			break
+		}
+		err := b.s.Seek(b.loopstart)
+		if err != nil {
+			return n, true
+		}
+		wrapped = true
 	}
 	return n, true
 }
@@ -165,65 +186,47 @@ type Bgm struct {
 	freqmul    float32
 	sampleRate beep.SampleRate
 	startPos   int
+	track      *musicTrack
+	playlist   *MusicPlaylist
+	crossfade  int32 // Default crossfade in frames, set from Lua
 }
 
 func newBgm() *Bgm {
 	return &Bgm{}
 }
 
-func (bgm *Bgm) Open(filename string, loop, bgmVolume, bgmLoopStart, bgmLoopEnd, startPosition int, freqmul float32) {
+// Play a file, crossfading from the current track over the given number of
+// frames. Any playlist is stopped.
+func (bgm *Bgm) Open(filename string, loop, bgmVolume, bgmLoopStart, bgmLoopEnd, startPosition int, freqmul float32, crossfade int32) {
+	bgm.playlist = nil
+	bgm.open(filename, loop, bgmVolume, bgmLoopStart, bgmLoopEnd, startPosition, freqmul, crossfade)
+}
+
+func (bgm *Bgm) open(filename string, loop, bgmVolume, bgmLoopStart, bgmLoopEnd, startPosition int, freqmul float32, crossfade int32) {
 	bgm.filename = filename
 	bgm.loop = loop
 	bgm.bgmVolume = bgmVolume
 	bgm.freqmul = freqmul
-	// Starve the current music streamer
-	if bgm.ctrl != nil {
+	// Starve or fade out the current music streamer
+	if bgm.track != nil {
 		speaker.Lock()
-		bgm.ctrl.Streamer = nil
+		bgm.track.fadeOut(crossfade)
 		speaker.Unlock()
+		bgm.track = nil
 	}
 	// Special value "" is used to stop music
 	if filename == "" {
 		return
 	}
 
-	var f *os.File
-	var err error
-	f, err = os.Open(bgm.filename)
+	f, err := vfs.Open(bgm.filename)
 	if err != nil {
-		// sys.bgm = *newBgm() // removing this gets pause step playsnd to work correctly 100% of the time
-		bgm.filename = "tmp/stages/" + filename
-		f, err = os.Open(bgm.filename)
-		if err != nil {
-			sys.errLog.Printf("Failed to open bgm: %v", err)
-			return
-		}
+		sys.errLog.Printf("Failed to open bgm: %v", err)
+		return
 	}
 	// fmt.Printf("[DEBUG][sound.go] Bgm.Open: bgm.filename=%v\n", bgm.filename)
 	var format beep.Format
-	if HasExtension(bgm.filename, ".ogg") {
-		bgm.streamer, format, err = vorbis.Decode(f)
-		bgm.format = "ogg"
-	} else if HasExtension(bgm.filename, ".mp3") {
-		bgm.streamer, format, err = mp3.Decode(f)
-		bgm.format = "mp3"
-	} else if HasExtension(bgm.filename, ".wav") {
-		bgm.streamer, format, err = wav.Decode(f)
-		bgm.format = "wav"
-		// TODO: Reactivate FLAC support. Check that seeking/looping works correctly.
-		//} else if HasExtension(bgm.filename, ".flac") {
-		//	bgm.streamer, format, err = flac.Decode(f)
-		//	bgm.format = "flac"
-	} else if HasExtension(bgm.filename, ".mid") || HasExtension(bgm.filename, ".midi") {
-		if soundfont, sferr := loadSoundFont(audioSoundFont); sferr != nil {
-			err = sferr
-		} else {
-			bgm.streamer, format, err = midi.Decode(f, soundfont, beep.SampleRate(int(sys.audioSampleRate)))
-			bgm.format = "midi"
-		}
-	} else {
-		err = Error(fmt.Sprintf("unsupported file extension: %v", bgm.filename))
-	}
+	bgm.streamer, format, bgm.format, err = decodeMusic(f, bgm.filename)
 	if err != nil {
 		f.Close()
 		sys.errLog.Printf("Failed to load bgm: %v", err)
@@ -241,13 +244,42 @@ func (bgm *Bgm) Open(filename string, loop, bgmVolume, bgmLoopStart, bgmLoopEnd,
 	dstFreq := beep.SampleRate(float32(sys.audioSampleRate) / bgm.freqmul)
 	resampler := beep.Resample(audioResampleQuality, bgm.sampleRate, dstFreq, bgm.volctrl)
 	bgm.ctrl = &beep.Ctrl{Streamer: resampler}
+	bgm.track = newMusicTrack(bgm.ctrl, crossfade)
 	bgm.UpdateVolume()
 	bgm.streamer.Seek(startPosition)
-	speaker.Play(bgm.ctrl)
+	bgm.addStageLayers()
+	sys.audioBuses[BusBgm].Add(bgm.track)
+}
+
+// Decode a music file by its extension
+func decodeMusic(f VFile, filename string) (st beep.StreamSeeker, format beep.Format, name string, err error) {
+	if HasExtension(filename, ".ogg") {
+		st, format, err = vorbis.Decode(f)
+		name = "ogg"
+	} else if HasExtension(filename, ".mp3") {
+		st, format, err = mp3.Decode(f)
+		name = "mp3"
+	} else if HasExtension(filename, ".wav") {
+		st, format, err = wav.Decode(f)
+		name = "wav"
+	} else if HasExtension(filename, ".flac") {
+		st, format, err = decodeFlac(f)
+		name = "flac"
+	} else if HasExtension(filename, ".mid") || HasExtension(filename, ".midi") {
+		if soundfont, sferr := loadSoundFont(audioSoundFont); sferr != nil {
+			err = sferr
+		} else {
+			st, format, err = midi.Decode(f, soundfont, beep.SampleRate(int(sys.audioSampleRate)))
+			name = "midi"
+		}
+	} else {
+		err = Error(fmt.Sprintf("unsupported file extension: %v", filename))
+	}
+	return
 }
 
 func loadSoundFont(filename string) (*midi.SoundFont, error) {
-	f, err := os.Open(filename)
+	f, err := vfs.Open(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -282,6 +314,9 @@ func (bgm *Bgm) UpdateVolume() {
 	speaker.Lock()
 	bgm.volctrl.Volume = volume
 	bgm.volctrl.Silent = silent
+	for _, l := range bgm.layers() {
+		l.volctrl.Volume, l.volctrl.Silent = volume, silent
+	}
 	speaker.Unlock()
 }
 
@@ -293,6 +328,9 @@ func (bgm *Bgm) SetFreqMul(freqmul float32) {
 			if resampler, ok := bgm.ctrl.Streamer.(*beep.Resampler); ok {
 				speaker.Lock()
 				resampler.SetRatio(float64(srcRate) / float64(dstRate))
+				for _, l := range bgm.layers() {
+					l.resampler.SetRatio(float64(srcRate) / float64(dstRate))
+				}
 				bgm.freqmul = freqmul
 				speaker.Unlock()
 			}
@@ -320,6 +358,12 @@ func (bgm *Bgm) SetLoopPoints(bgmLoopStart int, bgmLoopEnd int) {
 				speaker.Unlock()
 			}
 		}
+		// Layers always share the loop points of the main track
+		speaker.Lock()
+		for _, l := range bgm.layers() {
+			l.looper.loopstart, l.looper.loopend = sl.loopstart, sl.loopend
+		}
+		speaker.Unlock()
 	}
 }
 
@@ -330,6 +374,9 @@ func (bgm *Bgm) Seek(positionSample int) {
 		positionSample = 0
 	}
 	bgm.streamer.Seek(positionSample)
+	for _, l := range bgm.layers() {
+		l.streamer.Seek(positionSample)
+	}
 	speaker.Unlock()
 }
 
@@ -337,44 +384,202 @@ func (bgm *Bgm) Seek(positionSample int) {
 // Sound
 
 type Sound struct {
-	wavData []byte
-	format  beep.Format
-	length  int
+	data   []byte // WAV, OGG Vorbis or FLAC file
+	format beep.Format
+	length int
 }
 
-func readSound(f *os.File, size uint32) (*Sound, error) {
+func readSound(f VFile, size uint32) (*Sound, error) {
 	if size < 128 {
-		return nil, fmt.Errorf("wav size is too small")
+		return nil, fmt.Errorf("sound size is too small")
 	}
-	wavData := make([]byte, size)
-	if _, err := f.Read(wavData); err != nil {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
 		return nil, err
 	}
-	// Decode the sound at least once, so that we know the format is OK
-	s, fmt, err := wav.Decode(bytes.NewReader(wavData))
+	return newSound(data)
+}
+
+// Check that the sound data can be decoded. Returns nil without an error
+// if the sound is corrupted and has to be disabled.
+func newSound(data []byte) (*Sound, error) {
+	s, format, err := decodeSound(data)
 	if err != nil {
 		return nil, err
 	}
-	// Check if the file can be fully played
-	var samples [512][2]float64
-	for {
-		sn, _ := s.Stream(samples[:])
-		if sn == 0 {
-			// If sound wasn't able to be fully played, we disable it to avoid engine freezing
-			if s.Position() < s.Len() {
-				return nil, nil
+	defer s.Close()
+	// Check if a WAV file can be fully played. Compressed sounds are not
+	// decoded ahead, since it would make loading much slower.
+	if isWav(data) {
+		var samples [512][2]float64
+		for {
+			sn, _ := s.Stream(samples[:])
+			if sn == 0 {
+				// If sound wasn't able to be fully played, we disable it to avoid engine freezing
+				if s.Position() < s.Len() {
+					return nil, nil
+				}
+				break
 			}
-			break
 		}
 	}
-	return &Sound{wavData, fmt, s.Len()}, nil
+	return &Sound{data, format, s.Len()}, nil
 }
 
 func (s *Sound) GetStreamer() beep.StreamSeeker {
-	streamer, _, _ := wav.Decode(bytes.NewReader(s.wavData))
+	streamer, _, _ := decodeSound(s.data)
 	return streamer
 }
 
+func isWav(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("RIFF"))
+}
+
+// Pick a decoder from the file signature
+func decodeSound(data []byte) (beep.StreamSeekCloser, beep.Format, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		if bytes.Contains(data[:min(len(data), 64)], []byte("OpusHead")) {
+			return nil, beep.Format{}, Error("Opus sounds are not supported, use OGG Vorbis")
+		}
+		return vorbis.Decode(memFile{bytes.NewReader(data)})
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return decodeFlac(bytes.NewReader(data))
+	default:
+		return wav.Decode(bytes.NewReader(data))
+	}
+}
+
+// In memory file. The vorbis decoder can only seek if its reader does.
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error {
+	return nil
+}
+
+// ------------------------------------------------------------------
+// FlacDecoder
+
+// FlacDecoder streams FLAC audio. Unlike the beep decoder, seeking is
+// sample accurate and drops the samples buffered from the previous
+// position, so loop points work.
+type FlacDecoder struct {
+	stream *flac.Stream
+	data   [][2]float64
+	buf    [][2]float64 // Samples of data not streamed yet
+	pos    int
+	eof    bool
+	err    error
+}
+
+func decodeFlac(r io.ReadSeeker) (beep.StreamSeekCloser, beep.Format, error) {
+	stream, err := flac.NewSeek(r)
+	if err != nil {
+		return nil, beep.Format{}, err
+	}
+	format := beep.Format{
+		SampleRate:  beep.SampleRate(stream.Info.SampleRate),
+		NumChannels: int(stream.Info.NChannels),
+		Precision:   int(stream.Info.BitsPerSample+7) / 8,
+	}
+	return &FlacDecoder{stream: stream}, format, nil
+}
+
+// Decode the next frame into buf
+func (d *FlacDecoder) refill() error {
+	frame, err := d.stream.ParseNext()
+	if err != nil {
+		return err
+	}
+	left := frame.Subframes[0].Samples
+	right := left
+	if len(frame.Subframes) > 1 {
+		right = frame.Subframes[1].Samples
+	}
+	if cap(d.data) < len(left) {
+		d.data = make([][2]float64, len(left))
+	}
+	d.data = d.data[:len(left)]
+	q := 1 / float64(int64(1)<<(d.stream.Info.BitsPerSample-1))
+	for i := range d.data {
+		d.data[i] = [2]float64{float64(left[i]) * q, float64(right[i]) * q}
+	}
+	d.buf = d.data
+	return nil
+}
+
+func (d *FlacDecoder) Stream(samples [][2]float64) (n int, ok bool) {
+	if d.err != nil || d.eof {
+		return 0, false
+	}
+	for n < len(samples) {
+		if len(d.buf) == 0 {
+			if err := d.refill(); err == io.EOF {
+				d.eof = true
+				break
+			} else if err != nil {
+				d.err = err
+				break
+			}
+		}
+		c := copy(samples[n:], d.buf)
+		d.buf = d.buf[c:]
+		n += c
+	}
+	d.pos += n
+	return n, n > 0
+}
+
+func (d *FlacDecoder) Err() error {
+	return d.err
+}
+
+func (d *FlacDecoder) Len() int {
+	return int(d.stream.Info.NSamples)
+}
+
+func (d *FlacDecoder) Position() int {
+	return d.pos
+}
+
+func (d *FlacDecoder) Seek(p int) error {
+	if p < 0 || p > d.Len() {
+		return fmt.Errorf("flac: seek position %v out of range [0, %v]", p, d.Len())
+	}
+	d.buf, d.eof, d.err = nil, false, nil
+	if p == d.Len() {
+		d.pos, d.eof = p, true
+		return nil
+	}
+	start, err := d.stream.Seek(uint64(p))
+	if err != nil {
+		// Seeking into a short last frame fails, start from an earlier one
+		back := int(d.stream.Info.BlockSizeMax)
+		if start, err = d.stream.Seek(uint64(max(0, p-back))); err != nil {
+			return err
+		}
+	}
+	// Seeking lands on the first sample of a frame
+	d.pos = int(start)
+	for d.pos < p {
+		if len(d.buf) == 0 {
+			if err := d.refill(); err != nil {
+				return err
+			}
+		}
+		skip := min(p-d.pos, len(d.buf))
+		d.buf = d.buf[skip:]
+		d.pos += skip
+	}
+	return nil
+}
+
+func (d *FlacDecoder) Close() error {
+	return d.stream.Close()
+}
+
 // ------------------------------------------------------------------
 // Snd
 
@@ -395,8 +600,11 @@ func LoadSnd(filename string) (*Snd, error) {
 // The "keepItem" function allows to filter out unwanted waves.
 // If max > 0, the function returns immediately when a matching entry is found. It also gives up after "max" non-matching entries.
 func LoadSndFiltered(filename string, keepItem func([2]int32) bool, max uint32) (*Snd, error) {
+	if DirExist(filename) {
+		return loadSndFolder(filename, keepItem, max)
+	}
 	s := newSnd()
-	f, err := os.Open(filename)
+	f, err := vfs.Open(filename)
 	if err != nil {
 		return nil, err
 	}
@@ -469,6 +677,57 @@ func LoadSndFiltered(filename string, keepItem func([2]int32) bool, max uint32)
 	}
 	return s, nil
 }
+
+// Load a folder of loose sound files named "<group>_<number>.<ext>", where
+// ext is wav, ogg or flac, as if it was a .snd file
+func loadSndFolder(dirname string, keepItem func([2]int32) bool, max uint32) (*Snd, error) {
+	s := newSnd()
+	entries, err := vfs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	var skipped uint32
+	for _, e := range entries {
+		name := e.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if e.IsDir() || (ext != ".wav" && ext != ".ogg" && ext != ".flac") {
+			continue
+		}
+		gn := strings.SplitN(strings.TrimSuffix(name, filepath.Ext(name)), "_", 2)
+		if len(gn) != 2 {
+			continue
+		}
+		g, gerr := strconv.ParseInt(gn[0], 10, 32)
+		n, nerr := strconv.ParseInt(gn[1], 10, 32)
+		num := [2]int32{int32(g), int32(n)}
+		if gerr != nil || nerr != nil || !keepItem(num) {
+			if skipped++; max > 0 && skipped >= max {
+				break
+			}
+			continue
+		}
+		if _, ok := s.table[num]; ok {
+			continue
+		}
+		data, err := vfs.ReadFile(dirname + "/" + name)
+		if err == nil {
+			s.table[num], err = newSound(data)
+		}
+		if err != nil {
+			sys.errLog.Printf("%v sound %v,%v can't be read: %v\n", dirname, num[0], num[1], err)
+			delete(s.table, num)
+			if max > 0 {
+				return nil, err
+			}
+		} else if s.table[num] == nil {
+			sys.appendToConsole(fmt.Sprintf("WARNING: %v sound %v,%v is corrupted and can't be played, so it was disabled", name, num[0], num[1]))
+		}
+		if max > 0 {
+			break
+		}
+	}
+	return s, nil
+}
 func (s *Snd) Get(gn [2]int32) *Sound {
 	return s.table[gn]
 }
@@ -548,7 +807,7 @@ type SoundChannel struct {
 	stopOnChangeState bool
 }
 
-func (s *SoundChannel) Play(sound *Sound, loop int32, freqmul float32, loopStart, loopEnd, startPosition int) {
+func (s *SoundChannel) Play(sound *Sound, loop int32, freqmul float32, loopStart, loopEnd, startPosition, bus int) {
 	if sound == nil {
 		return
 	}
@@ -567,7 +826,10 @@ func (s *SoundChannel) Play(sound *Sound, loop int32, freqmul float32, loopStart
 	resampler := beep.Resample(audioResampleQuality, srcRate, dstRate, s.sfx)
 	s.ctrl = &beep.Ctrl{Streamer: resampler}
 	s.streamer.Seek(startPosition)
-	sys.soundMixer.Add(s.ctrl)
+	if bus < 0 || bus >= NumAudioBuses {
+		bus = BusSfx
+	}
+	sys.audioBuses[bus].Add(s.ctrl)
 }
 func (s *SoundChannel) IsPlaying() bool {
 	return s.sound != nil
@@ -726,7 +988,7 @@ func (s *SoundChannels) Play(sound *Sound, volumescale int32, pan float32, loopS
 	if c == nil {
 		return false
 	}
-	c.Play(sound, 0, 1.0, loopStart, loopEnd, startPosition)
+	c.Play(sound, 0, 1.0, loopStart, loopEnd, startPosition, BusSystem)
 	c.SetVolume(float32(volumescale * 64 / 25))
 	c.SetPan(pan, 0, nil)
 	return true
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 100 samples per frame
+const audioTestRate = 6000
+
+// Switch to the test audio settings and fresh buses until the test ends
+func audioTestSetup(t *testing.T) {
+	rate, buses, channels := sys.audioSampleRate, sys.audioBuses, sys.wavChannels
+	stereo, panning, xmin, xmax := sys.stereoEffects, sys.panningRange, sys.xmin, sys.xmax
+	master, bgm, maxBgm := sys.masterVolume, sys.bgmVolume, sys.maxBgmVolume
+	t.Cleanup(func() {
+		sys.audioSampleRate, sys.audioBuses, sys.wavChannels = rate, buses, channels
+		sys.stereoEffects, sys.panningRange, sys.xmin, sys.xmax = stereo, panning, xmin, xmax
+		sys.masterVolume, sys.bgmVolume, sys.maxBgmVolume = master, bgm, maxBgm
+	})
+	sys.audioSampleRate, sys.wavChannels = audioTestRate, 256
+	sys.stereoEffects, sys.panningRange, sys.xmin, sys.xmax = true, 30, -160, 160
+	sys.masterVolume, sys.bgmVolume, sys.maxBgmVolume = 100, 100, 100
+	sys.audioBuses = newAudioBuses(nil)
+}
+
+// 16-bit stereo WAV in which sample i holds (i+1)*8 on both channels, so
+// the source position of each mixed sample can be read back
+func audioTestWav(n int) []byte {
+	var buf bytes.Buffer
+	writeWavHeader(&buf, uint32(n*4))
+	for i := 0; i < n; i++ {
+		v := uint16((i + 1) * 8)
+		binary.Write(&buf, binary.LittleEndian, [2]uint16{v, v})
+	}
+	return buf.Bytes()
+}
+
+func audioTestSound(t *testing.T, n int) *Sound {
+	s, err := newSound(audioTestWav(n))
+	if err != nil || s == nil {
+		t.Fatalf("test sound: %v", err)
+	}
+	return s
+}
+
+// Source positions of the left channel, -1 for silence
+func audioTestPositions(samples [][2]float64, gain float64) []int {
+	pos := make([]int, len(samples))
+	for i, s := range samples {
+		pos[i] = int(math.Round(s[0]/gain*32768/8)) - 1
+	}
+	return pos
+}
+
+// Positions from a up to b, excluding b
+func audioTestRange(a, b int) []int {
+	var r []int
+	for i := a; i < b; i++ {
+		r = append(r, i)
+	}
+	return r
+}
+
+func audioTestSilence(n int) []int {
+	r := make([]int, n)
+	for i := range r {
+		r[i] = -1
+	}
+	return r
+}
+
+// Mix frames of a bus through a null output
+func audioTestPull(bus, frames int) [][2]float64 {
+	out := &nullOutput{}
+	out.Play(sys.audioBuses[bus])
+	var samples [][2]float64
+	for i := 0; i < frames; i++ {
+		samples = append(samples, out.pull()...)
+	}
+	return samples
+}
+
+func TestFrameSampleCount(t *testing.T) {
+	rate := sys.audioSampleRate
+	defer func() { sys.audioSampleRate = rate }()
+	sys.audioSampleRate = 44000
+	acc, want := 0, []int{733, 733, 734, 733, 733, 734}
+	for i, w := range want {
+		if n := frameSampleCount(&acc); n != w {
+			t.Errorf("frame %v: %v samples, want %v", i, n, w)
+		}
+	}
+}
+
+func TestStreamLooper(t *testing.T) {
+	cases := []struct {
+		name                      string
+		count, loopstart, loopend int
+		want                      []int
+	}{
+		{"once", 1, 0, 0, audioTestRange(0, 10)},
+		{"twice", 2, 2, 6, append(audioTestRange(0, 6), audioTestRange(2, 6)...)},
+		{"to the end", 3, 7, 0, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 7, 8, 9, 7, 8, 9}},
+		{"start out of range", 2, 10, 0, append(audioTestRange(0, 10), audioTestRange(0, 10)...)},
+		{"end before start", 2, 5, 3, append(audioTestRange(0, 10), audioTestRange(5, 10)...)},
+		{"forever", -1, 8, 0, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 8, 9, 8, 9, 8, 9, 8, 9, 8, 9}},
+	}
+	// Reads that stop short of the loop end or cross it must give the same
+	// result
+	for _, chunk := range []int{1, 3, 64} {
+		for _, c := range cases {
+			st, _, err := decodeSound(audioTestWav(10))
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := newStreamLooper(st, c.count, c.loopstart, c.loopend)
+			var got [][2]float64
+			buf := make([][2]float64, chunk)
+			for len(got) < 20 {
+				n, ok := l.Stream(buf)
+				if !ok || n == 0 {
+					break
+				}
+				got = append(got, buf[:n]...)
+			}
+			got = got[:min(len(got), 20)]
+			if pos := audioTestPositions(got, 1); !reflect.DeepEqual(pos, c.want) {
+				t.Errorf("%v in chunks of %v: got %v, want %v", c.name, chunk, pos, c.want)
+			}
+		}
+	}
+}
+
+func TestBgmLoopPoints(t *testing.T) {
+	audioTestSetup(t)
+	name := filepath.Join(t.TempDir(), "bgm.wav")
+	if err := os.WriteFile(name, audioTestWav(1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name               string
+		loop               int
+		loopstart, loopend int
+		set                [2]int // Loop points set after opening, if not 0
+		frames             int
+		want               []int
+	}{
+		{"no loop", 0, 0, 0, [2]int{}, 11,
+			append(audioTestRange(0, 1000), audioTestSilence(100)...)},
+		{"loop", 1, 100, 300, [2]int{}, 5,
+			append(audioTestRange(0, 300), audioTestRange(100, 300)...)},
+		{"set both", 1, 100, 300, [2]int{200, 400}, 6,
+			append(audioTestRange(0, 400), audioTestRange(200, 400)...)},
+		{"set end", 1, 100, 300, [2]int{100, 200}, 4,
+			append(append(audioTestRange(0, 200), audioTestRange(100, 200)...), audioTestRange(100, 200)...)},
+		{"set start", 1, 100, 300, [2]int{0, 300}, 6,
+			append(audioTestRange(0, 300), audioTestRange(0, 300)...)},
+	}
+	for _, c := range cases {
+		sys.audioBuses = newAudioBuses(nil)
+		bgm := newBgm()
+		bgm.Open(name, c.loop, 100, c.loopstart, c.loopend, 0, 1, 0)
+		if bgm.volctrl == nil {
+			t.Fatalf("%v: the music was not opened", c.name)
+		}
+		if c.set != [2]int{} {
+			bgm.SetLoopPoints(c.set[0], c.set[1])
+		}
+		got := audioTestPositions(audioTestPull(BusBgm, c.frames), math.Pow(2, bgm.volctrl.Volume))
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSoundChannelsPriority(t *testing.T) {
+	audioTestSetup(t)
+	sys.wavChannels = 2
+	snd := audioTestSound(t, 1000)
+	sc := newSoundChannels(0)
+	play := func(ch int32, lowpriority bool, priority int32) *SoundChannel {
+		c := sc.New(ch, lowpriority, priority)
+		if c != nil {
+			c.Play(snd, 0, 1, 0, 0, 0, BusSfx)
+			c.SetChannel(ch)
+			c.SetPriority(priority)
+		}
+		return c
+	}
+	a := play(0, false, 5)
+	if a == nil {
+		t.Fatal("no free channel")
+	}
+	audioTestPull(BusSfx, 1)
+	if play(0, false, 4) != nil {
+		t.Error("a lower priority sound stole the channel")
+	}
+	if play(0, true, 5) != nil {
+		t.Error("a low priority sound stole the channel from the same priority")
+	}
+	if sc.Get(0) != a {
+		t.Fatal("the playing sound was stopped")
+	}
+	// The stolen sound stops, only the new one is heard from its start
+	if play(0, false, 5) != a {
+		t.Fatal("the same priority did not steal the channel")
+	}
+	if got := audioTestPositions(audioTestPull(BusSfx, 1), 1); !reflect.DeepEqual(got, audioTestRange(0, 100)) {
+		t.Errorf("after stealing got %v, want 0 to 99", got)
+	}
+	if play(0, true, 6) != a {
+		t.Error("a higher low priority sound did not steal the channel")
+	}
+	// Sounds without a channel take the free slots, and are dropped once
+	// every slot plays
+	if b := play(-1, false, 0); b == nil || b == a {
+		t.Error("no free channel for a second sound")
+	}
+	if play(-1, false, 10) != nil {
+		t.Error("a sound played with every channel in use")
+	}
+	sc.StopAll()
+	if sc.Get(0) != nil || play(-1, false, 0) == nil {
+		t.Error("channels are still in use after stopping all sounds")
+	}
+}
+
+func TestSoundChannelPan(t *testing.T) {
+	audioTestSetup(t)
+	snd := audioTestSound(t, 1000)
+	left := float32(-160)
+	cases := []struct {
+		name   string
+		stereo bool
+		p, ls  float32
+		x      *float32
+		l, r   float64
+	}{
+		{"center", true, 0, 1, nil, 1, 1},
+		{"abspan right", true, 160, 1, nil, 0.7, 1.3},
+		{"abspan left", true, -160, 1, nil, 1.3, 0.7},
+		{"pan at the left edge", true, 0, 1, &left, 1.3, 0.7},
+		{"pan right of the left edge", true, 320, 1, &left, 0.7, 1.3},
+		{"scaled pan", true, 80, 2, nil, 0.7, 1.3},
+		{"mono", false, 160, 1, nil, 1, 1},
+	}
+	for _, c := range cases {
+		sys.audioBuses = newAudioBuses(nil)
+		sys.stereoEffects = c.stereo
+		var ch SoundChannel
+		ch.Play(snd, 0, 1, 0, 0, 0, BusSfx)
+		ch.SetPan(c.p, c.ls, c.x)
+		s := audioTestPull(BusSfx, 1)[10]
+		src := 11 * 8 / 32768.0
+		if math.Abs(s[0]-src*c.l) > 1e-9 || math.Abs(s[1]-src*c.r) > 1e-9 {
+			t.Errorf("%v: gains %.3f and %.3f, want %v and %v", c.name, s[0]/src, s[1]/src, c.l, c.r)
+		}
+	}
+}
+
+func TestSoundChannelFreqMul(t *testing.T) {
+	audioTestSetup(t)
+	snd := audioTestSound(t, 1000)
+	for _, f := range []float64{1, 2, 0.5, 1.5} {
+		sys.audioBuses = newAudioBuses(nil)
+		var ch SoundChannel
+		ch.Play(snd, 0, float32(f), 0, 0, 0, BusSfx)
+		for i, s := range audioTestPull(BusSfx, 2) {
+			if want := (float64(i)*f + 1) * 8 / 32768; math.Abs(s[0]-want) > 1e-9 {
+				t.Errorf("freqmul %v: sample %v is %v, want %v", f, i, s[0], want)
+				break
+			}
+		}
+	}
+	// Changing it while playing keeps the position
+	sys.audioBuses = newAudioBuses(nil)
+	var ch SoundChannel
+	ch.Play(snd, 0, 1, 0, 0, 0, BusSfx)
+	audioTestPull(BusSfx, 1)
+	ch.SetFreqMul(2)
+	got := audioTestPositions(audioTestPull(BusSfx, 1), 1)
+	for i, p := range got {
+		if p != 100+i*2 {
+			t.Errorf("after SetFreqMul(2) sample %v is at %v, want %v", i, p, 100+i*2)
+			break
+		}
+	}
+}
+
+func TestWavFileOutput(t *testing.T) {
+	audioTestSetup(t)
+	name := filepath.Join(t.TempDir(), "out.wav")
+	out, err := newWavFileOutput(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ch SoundChannel
+	ch.Play(audioTestSound(t, 250), 0, 1, 0, 0, 0, BusSfx)
+	out.Play(sys.audioBuses[BusSfx])
+	for i := 0; i < 3; i++ {
+		out.Frame()
+	}
+	out.Close()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 44+300*4 {
+		t.Fatalf("%v bytes, want %v", len(data), 44+300*4)
+	}
+	st, format, err := decodeSound(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.SampleRate != audioTestRate || st.Len() != 300 {
+		t.Errorf("%v samples at %v Hz, want 300 at %v Hz", st.Len(), format.SampleRate, audioTestRate)
+	}
+	samples := make([][2]float64, 300)
+	st.Stream(samples)
+	// The sound ends after 250 samples, the rest of the frame is silent
+	want := append(audioTestRange(0, 250), audioTestSilence(50)...)
+	if got := audioTestPositions(samples, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBgmCrossfadePaused(t *testing.T) {
+	audioTestSetup(t)
+	name := filepath.Join(t.TempDir(), "bgm.wav")
+	if err := os.WriteFile(name, audioTestWav(1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bgm := newBgm()
+	bgm.Open(name, 1, 100, 0, 0, 0, 1, 0)
+	audioTestPull(BusBgm, 1)
+	bgm.SetPaused(true)
+	old := bgm.track
+	bgm.Open(name, 1, 100, 0, 0, 0, 1, 60)
+	audioTestPull(BusBgm, 1)
+	if !old.ended {
+		t.Error("the paused track is still fading out")
+	}
+}
@@ -9,9 +9,8 @@ import (
 	"image"
 	"image/draw"
 	_ "image/jpeg"
-	"log"
 	"math"
-	"os"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -741,6 +740,12 @@ type Stage struct {
 	bgmratiolife      int32
 	bgmtriggerlife    int32
 	bgmtriggeralt     int32
+	bgmcrossfade      int32
+	bgmplaylist       []string
+	bgmshuffle        bool
+	bgmlayers         []MusicLayerDef
+	ambience          string
+	ambiencevolume    int32
 	mainstage         bool
 	stageCamera       stageCamera
 	stageTime         int32
@@ -759,7 +764,8 @@ func newStage(def string) *Stage {
 		zoffsetlink: -1, autoturn: true, resetbg: true, localscl: 1,
 		scale:        [...]float32{float32(math.NaN()), float32(math.NaN())},
 		bgmratiolife: 30, stageCamera: *newStageCamera(),
-		constants: make(map[string]float32), p1p3dist: 25, bgmvolume: 100}
+		constants: make(map[string]float32), p1p3dist: 25, bgmvolume: 100,
+		ambiencevolume: 100}
 	s.sdw.intensity = 128
 	s.sdw.color = 0x808080
 	s.sdw.yscale = 0.4
@@ -768,24 +774,14 @@ func newStage(def string) *Stage {
 	return s
 }
 func loadStage(def string, main bool) (*Stage, error) {
-	var str, zipDef, zipFileName string
+	var str string
 	var err error
 	// fmt.Printf("[DEBUG][stage.go] loadStage: def=%v main=%v\n", def, main)
-	if strings.Index(def, ".zip") == -1 {
-		zipFileName = ""
-		zipDef = ""
-	} else {
-		lines := SplitAndTrim(def, "|")
-		zipFileName = lines[0]
-		zipDef = lines[1]
-	}
+	// Files of zipped stages are looked up inside the archive
+	def = vfsPath(def)
+	zipped := strings.Contains(strings.ToLower(def), ".zip/")
 	s := newStage(def)
-	if zipFileName == "" {
-		str, err = LoadText(def)
-	} else {
-		str, err = LoadTextFromZip(zipFileName, zipDef)
-	}
-	if err != nil {
+	if str, err = LoadText(def); err != nil {
 		return nil, err
 	}
 	s.sff = &Sff{}
@@ -1031,16 +1027,12 @@ func loadStage(def string, main bool) (*Stage, error) {
 	if sectionExists {
 		sectionExists = false
 		s.bgmusic = sec[0]["bgmusic"]
-		if zipFileName != "" {
-			path := FileExist("tmp/stages/" + s.bgmusic)
-			if path == "" {
-				err = ExtractFileFromZip(zipFileName, s.bgmusic, "tmp/stages")
-				path = "tmp/stages/" + s.bgmusic
-			}
-			if err != nil {
-				log.Fatal(err)
-			}
-			s.bgmusic = path
+		if zipped && s.bgmusic != "" {
+			s.bgmusic = SearchFile(s.bgmusic, []string{def, ""})
+		}
+		// Playlist and layer files may also be relative to the stage
+		searchMusic := func(name string) string {
+			return SearchFile(name, []string{def, "", "sound/"})
 		}
 		// fmt.Printf("[DEBUG][stage.go] loadStage: s.bgmusic=%v\n", s.bgmusic)
 		sec[0].ReadI32("bgmvolume", &s.bgmvolume)
@@ -1051,6 +1043,30 @@ func loadStage(def string, main bool) (*Stage, error) {
 		sec[0].ReadI32("bgmratio.life", &s.bgmratiolife)
 		sec[0].ReadI32("bgmtrigger.life", &s.bgmtriggerlife)
 		sec[0].ReadI32("bgmtrigger.alt", &s.bgmtriggeralt)
+		sec[0].ReadI32("bgmcrossfade", &s.bgmcrossfade)
+		for _, name := range SplitAndTrim(sec[0]["bgmplaylist"], ",") {
+			if name != "" {
+				s.bgmplaylist = append(s.bgmplaylist, searchMusic(name))
+			}
+		}
+		sec[0].ReadBool("bgmplaylist.shuffle", &s.bgmshuffle)
+		for i := 1; ; i++ {
+			key := fmt.Sprintf("bgmlayer%v", i)
+			name := sec[0][key]
+			if name == "" {
+				break
+			}
+			l := MusicLayerDef{File: searchMusic(name), Volume: 100}
+			sec[0].ReadI32(key+".volume", &l.Volume)
+			l.Trigger = strings.ToLower(sec[0][key+".trigger"])
+			sec[0].ReadI32(key+".round", &l.Round)
+			sec[0].ReadI32(key+".fade", &l.Fade)
+			s.bgmlayers = append(s.bgmlayers, l)
+		}
+		if name := sec[0]["ambience"]; name != "" {
+			s.ambience = searchMusic(name)
+		}
+		sec[0].ReadI32("ambience.volume", &s.ambiencevolume)
 	}
 	if sec = defmap[fmt.Sprintf("%v.bgdef", sys.language)]; len(sec) > 0 {
 		sectionExists = true
@@ -1065,20 +1081,7 @@ func loadStage(def string, main bool) (*Stage, error) {
 			var sff *Sff
 			var err error
 			// fmt.Printf("[DEBUG][stage.go] loadStage.loadSff: filename=%v\n", filename)
-			if zipFileName == "" {
-				sff, err = loadSff(filename, false)
-			} else {
-				path := FileExist("tmp/stages/" + filename)
-				if path == "" {
-					err = ExtractFileFromZip(zipFileName, filename, "tmp/stages")
-					path = "tmp/stages/" + filename
-				}
-				if err != nil {
-					log.Fatal(err)
-				} else {
-					sff, err = loadSff(path, false)
-				}
-			}
+			sff, err = loadSff(filename, false)
 			if err != nil {
 				return err
 			}
@@ -1096,20 +1099,7 @@ func loadStage(def string, main bool) (*Stage, error) {
 			var model *Model
 			var err error
 			// fmt.Printf("[DEBUG][stage.go] loadStage.loadglTFStage: filename=%v\n", filename)
-			if zipFileName == "" {
-				model, err = loadglTFStage(filename)
-			} else {
-				path := FileExist("tmp/stages/" + filename)
-				if path == "" {
-					err = ExtractFileFromZip(zipFileName, filename, "tmp/stages")
-					path = "tmp/stages/" + filename
-				}
-				if err != nil {
-					log.Fatal(err)
-				} else {
-					model, err = loadglTFStage(path)
-				}
-			}
+			model, err = loadglTFStage(filename)
 			if err != nil {
 				return err
 			}
@@ -1765,6 +1755,9 @@ type Model struct {
 	skins               []*Skin
 	vertexBuffer        []byte
 	elementBuffer       []uint32
+	// Position of the buffers above in the shared model buffers
+	vertexBase  uint32
+	elementBase uint32
 }
 type Scene struct {
 	nodes []uint32
@@ -1788,6 +1781,7 @@ const (
 )
 
 type GLTFAnimation struct {
+	name     string
 	duration float32
 	time     float32
 	channels []*GLTFAnimationChannel
@@ -1901,7 +1895,13 @@ var gltfPrimitiveModeMap = map[gltf.PrimitiveMode]PrimitiveMode{
 
 func loadglTFStage(filepath string) (*Model, error) {
 	mdl := &Model{offset: [3]float32{0, 0, 0}, rotation: [3]float32{0, 0, 0}, scale: [3]float32{1, 1, 1}}
-	doc, err := gltf.Open(filepath)
+	f, err := vfs.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	doc := new(gltf.Document)
+	err = gltf.NewDecoderFS(f, vfs.Sub(path.Dir(vfsPath(filepath)))).Decode(doc)
+	f.Close()
 	if err != nil {
 		return nil, err
 	}
@@ -1925,7 +1925,7 @@ func loadglTFStage(filepath string) (*Model, error) {
 				}
 			} else {
 				if err := LoadFile(&img.URI, []string{filepath, "", sys.motifDir, "data/"}, func(filename string) error {
-					data, err := os.ReadFile(filename)
+					data, err := vfs.ReadFile(filename)
 					if err != nil {
 						return err
 					}
@@ -2368,7 +2368,7 @@ func loadglTFStage(filepath string) (*Model, error) {
 	}
 	mdl.animationTimeStamps = map[uint32][]float32{}
 	for _, a := range doc.Animations {
-		anim := &GLTFAnimation{}
+		anim := &GLTFAnimation{name: a.Name}
 		mdl.animations = append(mdl.animations, anim)
 		anim.duration = 0
 		for _, c := range a.Channels {
@@ -2567,7 +2567,7 @@ func drawNode(mdl *Model, n *Node, proj, view mgl.Mat4, drawBlended bool) {
 		}
 		color := mdl.materials[*p.materialIndex].baseColorFactor
 		modelview := view.Mul4(n.worldTransform)
-		gfx.SetModelPipeline(blendEq, src, dst, n.zTest, n.zWrite, mdl.materials[*p.materialIndex].doubleSided, modelview.Det() < 0, p.useUV, p.useVertexColor, p.useJoint0, p.useJoint1, p.numVertices, p.vertexBufferOffset)
+		gfx.SetModelPipeline(blendEq, src, dst, n.zTest, n.zWrite, mdl.materials[*p.materialIndex].doubleSided, modelview.Det() < 0, p.useUV, p.useVertexColor, p.useJoint0, p.useJoint1, p.numVertices, mdl.vertexBase+p.vertexBufferOffset)
 
 		gfx.SetModelUniformMatrix("projection", proj[:])
 		gfx.SetModelUniformMatrix("modelview", modelview[:])
@@ -2589,7 +2589,7 @@ func drawNode(mdl *Model, n *Node, proj, view mgl.Mat4, drawBlended bool) {
 			for _, t := range morphTargetWeights {
 				morphTarget := p.morphTargets[t.index]
 				if morphTarget.positionOffset != nil {
-					targetOffsets[targetCount] = *morphTarget.positionOffset
+					targetOffsets[targetCount] = mdl.vertexBase + *morphTarget.positionOffset
 					targetWeights[targetCount] = t.weight
 					targetCount += 1
 				}
@@ -2598,7 +2598,7 @@ func drawNode(mdl *Model, n *Node, proj, view mgl.Mat4, drawBlended bool) {
 			for _, t := range morphTargetWeights {
 				morphTarget := p.morphTargets[t.index]
 				if morphTarget.uvOffset != nil {
-					targetOffsets[targetCount] = *morphTarget.uvOffset
+					targetOffsets[targetCount] = mdl.vertexBase + *morphTarget.uvOffset
 					targetWeights[targetCount] = t.weight
 					targetCount += 1
 				}
@@ -2607,7 +2607,7 @@ func drawNode(mdl *Model, n *Node, proj, view mgl.Mat4, drawBlended bool) {
 			for _, t := range morphTargetWeights {
 				morphTarget := p.morphTargets[t.index]
 				if morphTarget.colorOffset != nil {
-					targetOffsets[targetCount] = *morphTarget.colorOffset
+					targetOffsets[targetCount] = mdl.vertexBase + *morphTarget.colorOffset
 					targetWeights[targetCount] = t.weight
 					targetCount += 1
 				}
@@ -2633,7 +2633,7 @@ func drawNode(mdl *Model, n *Node, proj, view mgl.Mat4, drawBlended bool) {
 			gfx.SetModelUniformI("numJoints", len(mdl.skins[*n.skin].joints))
 		}
 
-		gfx.RenderElements(mode, int(p.numIndices), int(p.elementBufferOffset))
+		gfx.RenderElements(mode, int(p.numIndices), int(mdl.elementBase+p.elementBufferOffset))
 
 		gfx.ReleaseModelPipeline()
 
@@ -2643,6 +2643,7 @@ func (s *Stage) drawModel(pos [2]float32, yofs float32, scl float32, sceneNumber
 	if s.model == nil || len(s.model.scenes) <= sceneNumber {
 		return
 	}
+	batch.Flush()
 
 	drawFOV := s.stageCamera.fov * math.Pi / 180
 
@@ -2693,141 +2694,146 @@ func (model *Model) step() {
 		if anim.time >= anim.duration && anim.duration > 0 {
 			anim.time = anim.duration
 		}
-		for _, channel := range anim.channels {
-			node := model.nodes[channel.nodeIndex]
-			sampler := anim.samplers[channel.samplerIndex]
-			prevIndex := 0
-			for i, t := range model.animationTimeStamps[sampler.inputIndex] {
-				if anim.time < t {
-					prevIndex = i - 1
-					break
-				}
-			}
-			if prevIndex != -1 && sampler.interpolation != InterpolationStep && prevIndex+1 < len(model.animationTimeStamps[sampler.inputIndex]) {
-				if sampler.interpolation == InterpolationLinear {
-					rate := (anim.time - model.animationTimeStamps[sampler.inputIndex][prevIndex]) / (model.animationTimeStamps[sampler.inputIndex][prevIndex+1] - model.animationTimeStamps[sampler.inputIndex][prevIndex])
-					switch channel.path {
-					case TRSTranslation:
-						for i := 0; i < 3; i++ {
-							newVal := sampler.output[prevIndex*3+i]*(1-rate) + sampler.output[(prevIndex+1)*3+i]*rate
-							if node.transition[i] != newVal {
-								node.transition[i] = newVal
-								node.transformChanged = true
-							}
-						}
-					case TRSScale:
-						for i := 0; i < 3; i++ {
-							newVal := sampler.output[prevIndex*3+i]*(1-rate) + sampler.output[(prevIndex+1)*3+i]*rate
-							if node.scale[i] != newVal {
-								node.scale[i] = newVal
-								node.transformChanged = true
-							}
-						}
-					case TRSRotation:
-						q1 := mgl.Quat{sampler.output[prevIndex*4+3], mgl.Vec3{sampler.output[prevIndex*4], sampler.output[prevIndex*4+1], sampler.output[prevIndex*4+2]}}
-						q2 := mgl.Quat{sampler.output[(prevIndex+1)*4+3], mgl.Vec3{sampler.output[(prevIndex+1)*4], sampler.output[(prevIndex+1)*4+1], sampler.output[(prevIndex+1)*4+2]}}
-						dotProduct := q1.Dot(q2)
-						if dotProduct < 0 {
-							q1 = q1.Inverse()
-						}
-						q := mgl.QuatSlerp(q1, q2, rate)
-						if node.rotation[0] != q.X() || node.rotation[1] != q.Y() || node.rotation[2] != q.Z() || node.rotation[3] != q.W {
-							node.rotation[0] = q.X()
-							node.rotation[1] = q.Y()
-							node.rotation[2] = q.Z()
-							node.rotation[3] = q.W
+		model.animate(anim)
+	}
+}
+
+// Apply the channels of an animation at its current time to the nodes
+func (model *Model) animate(anim *GLTFAnimation) {
+	for _, channel := range anim.channels {
+		node := model.nodes[channel.nodeIndex]
+		sampler := anim.samplers[channel.samplerIndex]
+		prevIndex := 0
+		for i, t := range model.animationTimeStamps[sampler.inputIndex] {
+			if anim.time < t {
+				prevIndex = i - 1
+				break
+			}
+		}
+		if prevIndex != -1 && sampler.interpolation != InterpolationStep && prevIndex+1 < len(model.animationTimeStamps[sampler.inputIndex]) {
+			if sampler.interpolation == InterpolationLinear {
+				rate := (anim.time - model.animationTimeStamps[sampler.inputIndex][prevIndex]) / (model.animationTimeStamps[sampler.inputIndex][prevIndex+1] - model.animationTimeStamps[sampler.inputIndex][prevIndex])
+				switch channel.path {
+				case TRSTranslation:
+					for i := 0; i < 3; i++ {
+						newVal := sampler.output[prevIndex*3+i]*(1-rate) + sampler.output[(prevIndex+1)*3+i]*rate
+						if node.transition[i] != newVal {
+							node.transition[i] = newVal
 							node.transformChanged = true
 						}
-					case MorphTargetWeight:
-						for i := 0; i < len(node.morphTargetWeights); i++ {
-							newVal := sampler.output[prevIndex*len(node.morphTargetWeights)+i]*(1-rate) + sampler.output[(prevIndex+1)*len(node.morphTargetWeights)+i]*rate
-							node.morphTargetWeights[i] = newVal
-						}
 					}
-				} else {
-					delta := (model.animationTimeStamps[sampler.inputIndex][prevIndex+1] - model.animationTimeStamps[sampler.inputIndex][prevIndex])
-					rate := (anim.time - model.animationTimeStamps[sampler.inputIndex][prevIndex]) / delta
-					rateSquare := rate * rate
-					rateCube := rateSquare * rate
-
-					switch channel.path {
-					case TRSTranslation:
-						for i := 0; i < 3; i++ {
-							newVal := (2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*9+3*i+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*9+3*i+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*9+3*i+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*9+3*i]
-							if node.transition[i] != newVal {
-								node.transition[i] = newVal
-								node.transformChanged = true
-							}
-						}
-					case TRSScale:
-						for i := 0; i < 3; i++ {
-							newVal := (2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*9+3*i+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*9+3*i+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*9+3*i+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*9+3*i]
-							if node.scale[i] != newVal {
-								node.scale[i] = newVal
-								node.transformChanged = true
-							}
-						}
-					case TRSRotation:
-						q1 := mgl.Quat{sampler.output[prevIndex*4+3], mgl.Vec3{sampler.output[prevIndex*4], sampler.output[prevIndex*4+1], sampler.output[prevIndex*4+2]}}
-						q2 := mgl.Quat{sampler.output[(prevIndex+1)*4+3], mgl.Vec3{sampler.output[(prevIndex+1)*4], sampler.output[(prevIndex+1)*4+1], sampler.output[(prevIndex+1)*4+2]}}
-						dotProduct := q1.Dot(q2)
-						if dotProduct < 0 {
-							q1 = q1.Inverse()
-						}
-						q := mgl.Quat{(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+9+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+9+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+9+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12+9],
-							mgl.Vec3{
-								(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12],
-								(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+3+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+3+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+3+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12+3],
-								(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+6+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+6+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+6+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12+6],
-							}}.Normalize()
-						if node.rotation[0] != q.X() || node.rotation[1] != q.Y() || node.rotation[2] != q.Z() || node.rotation[3] != q.W {
-							node.rotation[0] = q.X()
-							node.rotation[1] = q.Y()
-							node.rotation[2] = q.Z()
-							node.rotation[3] = q.W
+				case TRSScale:
+					for i := 0; i < 3; i++ {
+						newVal := sampler.output[prevIndex*3+i]*(1-rate) + sampler.output[(prevIndex+1)*3+i]*rate
+						if node.scale[i] != newVal {
+							node.scale[i] = newVal
 							node.transformChanged = true
 						}
-					case MorphTargetWeight:
-						for i := 0; i < len(node.morphTargetWeights); i++ {
-							newVal := (2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*3*len(node.morphTargetWeights)+3*i+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*3*len(node.morphTargetWeights)+3*i+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*3*len(node.morphTargetWeights)+3*i+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*3*len(node.morphTargetWeights)+3*i]
-							node.morphTargetWeights[i] = newVal
-						}
+					}
+				case TRSRotation:
+					q1 := mgl.Quat{sampler.output[prevIndex*4+3], mgl.Vec3{sampler.output[prevIndex*4], sampler.output[prevIndex*4+1], sampler.output[prevIndex*4+2]}}
+					q2 := mgl.Quat{sampler.output[(prevIndex+1)*4+3], mgl.Vec3{sampler.output[(prevIndex+1)*4], sampler.output[(prevIndex+1)*4+1], sampler.output[(prevIndex+1)*4+2]}}
+					dotProduct := q1.Dot(q2)
+					if dotProduct < 0 {
+						q1 = q1.Inverse()
+					}
+					q := mgl.QuatSlerp(q1, q2, rate)
+					if node.rotation[0] != q.X() || node.rotation[1] != q.Y() || node.rotation[2] != q.Z() || node.rotation[3] != q.W {
+						node.rotation[0] = q.X()
+						node.rotation[1] = q.Y()
+						node.rotation[2] = q.Z()
+						node.rotation[3] = q.W
+						node.transformChanged = true
+					}
+				case MorphTargetWeight:
+					for i := 0; i < len(node.morphTargetWeights); i++ {
+						newVal := sampler.output[prevIndex*len(node.morphTargetWeights)+i]*(1-rate) + sampler.output[(prevIndex+1)*len(node.morphTargetWeights)+i]*rate
+						node.morphTargetWeights[i] = newVal
 					}
 				}
-
 			} else {
-				if prevIndex == -1 {
-					prevIndex = 0
-				}
+				delta := (model.animationTimeStamps[sampler.inputIndex][prevIndex+1] - model.animationTimeStamps[sampler.inputIndex][prevIndex])
+				rate := (anim.time - model.animationTimeStamps[sampler.inputIndex][prevIndex]) / delta
+				rateSquare := rate * rate
+				rateCube := rateSquare * rate
+
 				switch channel.path {
 				case TRSTranslation:
 					for i := 0; i < 3; i++ {
-						if node.transition[i] != sampler.output[prevIndex*3+i] {
-							node.transition[i] = sampler.output[prevIndex*3+i]
+						newVal := (2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*9+3*i+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*9+3*i+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*9+3*i+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*9+3*i]
+						if node.transition[i] != newVal {
+							node.transition[i] = newVal
 							node.transformChanged = true
 						}
 					}
 				case TRSScale:
 					for i := 0; i < 3; i++ {
-						if node.scale[i] != sampler.output[prevIndex*3+i] {
-							node.scale[i] = sampler.output[prevIndex*3+i]
+						newVal := (2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*9+3*i+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*9+3*i+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*9+3*i+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*9+3*i]
+						if node.scale[i] != newVal {
+							node.scale[i] = newVal
 							node.transformChanged = true
 						}
 					}
 				case TRSRotation:
-					for i := 0; i < 4; i++ {
-						if node.rotation[i] != sampler.output[prevIndex*4+i] {
-							node.rotation[i] = sampler.output[prevIndex*4+i]
-							node.transformChanged = true
-						}
+					q1 := mgl.Quat{sampler.output[prevIndex*4+3], mgl.Vec3{sampler.output[prevIndex*4], sampler.output[prevIndex*4+1], sampler.output[prevIndex*4+2]}}
+					q2 := mgl.Quat{sampler.output[(prevIndex+1)*4+3], mgl.Vec3{sampler.output[(prevIndex+1)*4], sampler.output[(prevIndex+1)*4+1], sampler.output[(prevIndex+1)*4+2]}}
+					dotProduct := q1.Dot(q2)
+					if dotProduct < 0 {
+						q1 = q1.Inverse()
+					}
+					q := mgl.Quat{(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+9+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+9+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+9+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12+9],
+						mgl.Vec3{
+							(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12],
+							(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+3+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+3+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+3+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12+3],
+							(2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*12+6+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*12+6+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*12+6+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*12+6],
+						}}.Normalize()
+					if node.rotation[0] != q.X() || node.rotation[1] != q.Y() || node.rotation[2] != q.Z() || node.rotation[3] != q.W {
+						node.rotation[0] = q.X()
+						node.rotation[1] = q.Y()
+						node.rotation[2] = q.Z()
+						node.rotation[3] = q.W
+						node.transformChanged = true
 					}
 				case MorphTargetWeight:
 					for i := 0; i < len(node.morphTargetWeights); i++ {
-						newVal := sampler.output[prevIndex*len(node.morphTargetWeights)+i]
+						newVal := (2*rateCube-3*rateSquare+1)*sampler.output[prevIndex*3*len(node.morphTargetWeights)+3*i+1] + delta*(rateCube-2*rateSquare+rate)*sampler.output[prevIndex*3*len(node.morphTargetWeights)+3*i+2] + (-2*rateCube+3*rateSquare)*sampler.output[(prevIndex+1)*3*len(node.morphTargetWeights)+3*i+1] + delta*(rateCube-rateSquare)*sampler.output[(prevIndex+1)*3*len(node.morphTargetWeights)+3*i]
 						node.morphTargetWeights[i] = newVal
 					}
 				}
 			}
+
+		} else {
+			if prevIndex == -1 {
+				prevIndex = 0
+			}
+			switch channel.path {
+			case TRSTranslation:
+				for i := 0; i < 3; i++ {
+					if node.transition[i] != sampler.output[prevIndex*3+i] {
+						node.transition[i] = sampler.output[prevIndex*3+i]
+						node.transformChanged = true
+					}
+				}
+			case TRSScale:
+				for i := 0; i < 3; i++ {
+					if node.scale[i] != sampler.output[prevIndex*3+i] {
+						node.scale[i] = sampler.output[prevIndex*3+i]
+						node.transformChanged = true
+					}
+				}
+			case TRSRotation:
+				for i := 0; i < 4; i++ {
+					if node.rotation[i] != sampler.output[prevIndex*4+i] {
+						node.rotation[i] = sampler.output[prevIndex*4+i]
+						node.transformChanged = true
+					}
+				}
+			case MorphTargetWeight:
+				for i := 0; i < len(node.morphTargetWeights); i++ {
+					newVal := sampler.output[prevIndex*len(node.morphTargetWeights)+i]
+					node.morphTargetWeights[i] = newVal
+				}
+			}
 		}
 	}
 }
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State transition trace. When enabled, every state change, HitDef contact
+// and helper or explod spawn/destroy of the current match is kept in a ring
+// buffer that can be written to a JSONL file, one event per line after a
+// header line describing the match. The trace is dumped automatically when
+// the game crashes, and -traceview filters a dump from the command line.
+// State changes name the controller that caused them, in CNS or ZSS, if the
+// character was loaded while tracing was on.
+
+const (
+	traceBufferSize = 1 << 16
+	traceDir        = "save/traces"
+)
+
+type traceEventKind byte
+
+const (
+	TE_State traceEventKind = iota
+	TE_Hit
+	TE_Guard
+	TE_Helper
+	TE_HelperEnd
+	TE_Explod
+	TE_ExplodEnd
+)
+
+var traceEventNames = [...]string{"state", "hit", "guard", "helper",
+	"helperend", "explod", "explodend"}
+
+// Location of a state controller in a CNS file.
+type traceSource struct {
+	ctrl string
+	file string
+	line int
+}
+
+type traceEvent struct {
+	kind     traceEventKind
+	frame    int32
+	id       int32
+	playerNo int
+	helperId int32
+	from, to int32 // State change, or the current state in from for other events
+	other    int32 // Target, parent or explod ID
+	src      *traceSource
+}
+
+func (e *traceEvent) appendJSON(b []byte) []byte {
+	b = append(b, `{"f":`...)
+	b = strconv.AppendInt(b, int64(e.frame), 10)
+	b = append(b, `,"e":"`...)
+	b = append(b, traceEventNames[e.kind]...)
+	b = append(b, `","id":`...)
+	b = strconv.AppendInt(b, int64(e.id), 10)
+	b = append(b, `,"p":`...)
+	b = strconv.AppendInt(b, int64(e.playerNo+1), 10)
+	if e.helperId != 0 {
+		b = append(b, `,"hid":`...)
+		b = strconv.AppendInt(b, int64(e.helperId), 10)
+	}
+	if e.kind == TE_State {
+		b = append(b, `,"from":`...)
+		b = strconv.AppendInt(b, int64(e.from), 10)
+		b = append(b, `,"to":`...)
+		b = strconv.AppendInt(b, int64(e.to), 10)
+		if e.src != nil {
+			b = append(b, `,"ctrl":`...)
+			b = strconv.AppendQuote(b, e.src.ctrl)
+			b = append(b, `,"src":`...)
+			b = strconv.AppendQuote(b, e.src.file+":"+strconv.Itoa(e.src.line))
+		}
+	} else {
+		b = append(b, `,"st":`...)
+		b = strconv.AppendInt(b, int64(e.from), 10)
+		switch e.kind {
+		case TE_Hit, TE_Guard:
+			b = append(b, `,"tgt":`...)
+			b = strconv.AppendInt(b, int64(e.other), 10)
+		case TE_Helper:
+			b = append(b, `,"parent":`...)
+			b = strconv.AppendInt(b, int64(e.other), 10)
+		case TE_Explod, TE_ExplodEnd:
+			b = append(b, `,"xid":`...)
+			b = strconv.AppendInt(b, int64(e.other), 10)
+		}
+	}
+	return append(b, "}\n"...)
+}
+
+type traceChar struct {
+	Player int    `json:"p"`
+	Id     int32  `json:"id"`
+	Name   string `json:"name"`
+	Def    string `json:"def"`
+}
+
+// First line of a trace file.
+type traceHeader struct {
+	Event string      `json:"e"`
+	Date  string      `json:"date"`
+	Stage string      `json:"stage"`
+	Chars []traceChar `json:"chars"`
+}
+
+// An explod slot being watched for its removal.
+type traceExplod struct {
+	playerNo, index int
+	id, playerId    int32
+	anim            *Animation
+}
+
+type StateTrace struct {
+	enabled bool
+	events  []traceEvent
+	pos     int
+	count   int
+	header  traceHeader
+	cause   *byte // First byte of the running ChangeState, SelfState or TargetState
+	explods []traceExplod
+	dumped  bool
+}
+
+var stateTrace = &StateTrace{}
+
+// Controller locations of each player, keyed by the first byte of the
+// compiled controller. They are dropped when the player's character is
+// compiled again, which the loader may do while a match is running.
+var traceSources [MaxSimul*2 + MaxAttachedChar]map[*byte]*traceSource
+var traceSourcesMu sync.Mutex
+
+// Forgets the controller locations of the previous character of player pn.
+func clearTraceSources(pn int) {
+	traceSourcesMu.Lock()
+	defer traceSourcesMu.Unlock()
+	traceSources[pn] = nil
+}
+
+// Remembers where a state changing controller of player pn comes from. Nothing
+// is kept while tracing is off.
+func registerTraceSource(pn int, sc StateController, file string, line int) {
+	if !stateTrace.enabled {
+		return
+	}
+	var name string
+	var b []byte
+	switch s := sc.(type) {
+	case changeState:
+		name, b = "ChangeState", s
+	case selfState:
+		name, b = "SelfState", s
+	case targetState:
+		name, b = "TargetState", s
+	}
+	if len(b) > 0 {
+		traceSourcesMu.Lock()
+		defer traceSourcesMu.Unlock()
+		if traceSources[pn] == nil {
+			traceSources[pn] = make(map[*byte]*traceSource)
+		}
+		traceSources[pn][&b[0]] = &traceSource{ctrl: name, file: file, line: line}
+	}
+}
+
+func (st *StateTrace) Start() {
+	st.enabled = true
+}
+
+func (st *StateTrace) Stop() {
+	st.enabled = false
+	st.cause = nil
+}
+
+// Clears the buffer at the start of a match.
+func (st *StateTrace) newMatch() {
+	st.pos, st.count, st.explods = 0, 0, st.explods[:0]
+	st.header = traceHeader{Event: "match", Date: time.Now().Format(time.RFC3339)}
+	if sys.stage != nil {
+		st.header.Stage = sys.stage.def
+	}
+	for i, p := range sys.chars {
+		if len(p) > 0 {
+			st.header.Chars = append(st.header.Chars, traceChar{Player: i + 1,
+				Id: p[0].id, Name: p[0].name, Def: p[0].gi().def})
+		}
+	}
+}
+
+func (st *StateTrace) add(e traceEvent) {
+	if st.events == nil {
+		st.events = make([]traceEvent, traceBufferSize)
+	}
+	e.frame = sys.gameTime
+	st.events[st.pos] = e
+	st.pos = (st.pos + 1) % len(st.events)
+	if st.count < len(st.events) {
+		st.count++
+	}
+}
+
+// Called when c enters a state.
+func (st *StateTrace) stateChanged(c *Char) {
+	if !st.enabled {
+		return
+	}
+	e := traceEvent{kind: TE_State, id: c.id, playerNo: c.playerNo,
+		helperId: c.helperId, from: c.ss.prevno, to: c.ss.no}
+	if st.cause != nil {
+		// The controller may come from the states of another player
+		traceSourcesMu.Lock()
+		for _, m := range traceSources {
+			if src, ok := m[st.cause]; ok {
+				e.src = src
+				break
+			}
+		}
+		traceSourcesMu.Unlock()
+	}
+	st.add(e)
+}
+
+// Called when a HitDef of c hits or is guarded.
+func (st *StateTrace) hitContact(c *Char, guarded bool) {
+	if !st.enabled {
+		return
+	}
+	e := traceEvent{kind: TE_Hit, id: c.id, playerNo: c.playerNo,
+		helperId: c.helperId, from: c.ss.no, other: -1}
+	if guarded {
+		e.kind = TE_Guard
+	}
+	if len(c.hitdefTargets) > 0 {
+		e.other = c.hitdefTargets[len(c.hitdefTargets)-1]
+	}
+	st.add(e)
+}
+
+func (st *StateTrace) helperSpawned(h, parent *Char, stateNo int32) {
+	if st.enabled {
+		st.add(traceEvent{kind: TE_Helper, id: h.id, playerNo: h.playerNo,
+			helperId: h.helperId, from: stateNo, other: parent.id})
+	}
+}
+
+func (st *StateTrace) helperDestroyed(h *Char) {
+	if st.enabled {
+		st.add(traceEvent{kind: TE_HelperEnd, id: h.id, playerNo: h.playerNo,
+			helperId: h.helperId, from: h.ss.no})
+	}
+}
+
+func (st *StateTrace) explodSpawned(c *Char, i int) {
+	if !st.enabled {
+		return
+	}
+	e := &sys.explods[c.playerNo][i]
+	st.add(traceEvent{kind: TE_Explod, id: c.id, playerNo: c.playerNo,
+		helperId: c.helperId, from: c.ss.no, other: e.id})
+	st.explods = append(st.explods, traceExplod{playerNo: c.playerNo, index: i,
+		id: e.id, playerId: e.playerId, anim: e.anim})
+}
+
+// Called once per game tick to notice removed explods, which are freed from
+// many places.
+func (st *StateTrace) update() {
+	if !st.enabled {
+		st.explods = st.explods[:0]
+		return
+	}
+	live := st.explods[:0]
+	for _, te := range st.explods {
+		if te.index < len(sys.explods[te.playerNo]) {
+			e := &sys.explods[te.playerNo][te.index]
+			if e.id != IErr && e.playerId == te.playerId && e.anim == te.anim {
+				live = append(live, te)
+				continue
+			}
+		}
+		e := traceEvent{kind: TE_ExplodEnd, id: te.playerId, playerNo: te.playerNo,
+			from: -1, other: te.id}
+		if c := sys.playerID(te.playerId); c != nil {
+			e.helperId, e.from = c.helperId, c.ss.no
+		}
+		st.add(e)
+	}
+	st.explods = live
+}
+
+// Writes the buffered events to file, or to a new file in save/traces if
+// file is empty, and returns the file name.
+func (st *StateTrace) Dump(file string) (string, error) {
+	if !st.enabled && st.count == 0 {
+		return file, Error("State tracing is disabled")
+	}
+	if file == "" {
+		file = filepath.Join(traceDir,
+			"trace-"+time.Now().Format("20060102-150405")+".jsonl")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return file, err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	h, err := json.Marshal(st.header)
+	if err != nil {
+		return file, err
+	}
+	w.Write(append(h, '\n'))
+	var b []byte
+	for i := st.pos - st.count; i < st.pos; i++ {
+		b = st.events[(i+len(st.events))%len(st.events)].appendJSON(b[:0])
+		w.Write(b)
+	}
+	return file, w.Flush()
+}
+
+// Dumps the trace once when the game crashes.
+func (st *StateTrace) crashDump() {
+	if !st.enabled || st.dumped || st.count == 0 {
+		return
+	}
+	st.dumped = true
+	if file, err := st.Dump(""); err != nil {
+		fmt.Printf("Failed to write the state trace: %v\n", err)
+	} else {
+		fmt.Printf("State trace saved to %v\n", file)
+	}
+}
+
+// Prints the events of a trace file that involve the given entity id and/or
+// state number. Empty filters match everything.
+func viewTrace(file, id, state string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	parse := func(s string) (*int32, error) {
+		if s == "" {
+			return nil, nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, Error("Invalid trace filter: " + s)
+		}
+		v := int32(n)
+		return &v, nil
+	}
+	fid, err := parse(id)
+	if err != nil {
+		return err
+	}
+	fst, err := parse(state)
+	if err != nil {
+		return err
+	}
+	is := func(p *int32, v int32) bool {
+		return p != nil && *p == v
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e struct {
+			E            string
+			Id           *int32
+			From, To, St *int32
+			Tgt, Parent  *int32
+		}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return err
+		}
+		if e.E != "match" {
+			if fid != nil && !is(e.Id, *fid) && !is(e.Tgt, *fid) && !is(e.Parent, *fid) {
+				continue
+			}
+			if fst != nil && !is(e.From, *fst) && !is(e.To, *fst) && !is(e.St, *fst) {
+				continue
+			}
+		}
+		fmt.Println(sc.Text())
+	}
+	return sc.Err()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTraceSourcesZss(t *testing.T) {
+	defer func(enabled bool) {
+		stateTrace.enabled = enabled
+		clearTraceSources(0)
+	}(stateTrace.enabled)
+	src := "[StateDef 200;]\n\n# comment\nif time = 1 {\n\tChangeState{\n\t\tvalue: 0}\n}\nselfState{value: 5}\n"
+	compile := func() []traceSource {
+		clearTraceSources(0)
+		c := newCompiler()
+		if err := c.stateCompileZ(make(map[int32]StateBytecode), "test.zss", src,
+			map[string]float32{}); err != nil {
+			t.Fatal(err)
+		}
+		var got []traceSource
+		for _, s := range traceSources[0] {
+			got = append(got, *s)
+		}
+		if len(got) == 2 && got[0].line > got[1].line {
+			got[0], got[1] = got[1], got[0]
+		}
+		return got
+	}
+
+	stateTrace.enabled = false
+	if got := compile(); len(got) != 0 {
+		t.Errorf("kept %v while tracing was off", got)
+	}
+	stateTrace.enabled = true
+	want := []traceSource{{"ChangeState", "test.zss", 5}, {"SelfState", "test.zss", 8}}
+	if got := compile(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -16,7 +16,6 @@ import (
 	"time"
 
 	"github.com/gopxl/beep/v2"
-	"github.com/gopxl/beep/v2/speaker"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -45,6 +44,8 @@ var sys = System{
 	team1VS2Life:      1,
 	turnsRecoveryRate: 1.0 / 300,
 	soundMixer:        &beep.Mixer{},
+	outputMixer:       &beep.Mixer{},
+	recorder:          newRecorder(),
 	bgm:               *newBgm(),
 	soundChannels:     newSoundChannels(16),
 	allPalFX:          *newPalFX(),
@@ -111,10 +112,12 @@ type System struct {
 	windowScaleMode         bool
 	window                  *Window
 	gameEnd, frameSkip      bool
+	headless                bool // No window, renderer or sound, for scenario runs
 	redrawWait              struct{ nextTime, lastDraw time.Time }
 	brightness              int32
 	roundTime               int32
 	language                string
+	languageFallback        string
 	lifeMul                 float32
 	team1VS2Life            float32
 	turnsRecoveryRate       float32
@@ -122,7 +125,10 @@ type System struct {
 	debugDraw               bool
 	debugRef                [2]int // player number, helper index
 	soundMixer              *beep.Mixer
+	outputMixer             *beep.Mixer // Sound effects and BGM, as sent to the audio output
+	recorder                *Recorder
 	bgm                     Bgm
+	ambience                StageAmbience
 	soundChannels           *SoundChannels
 	allPalFX, bgPalFX       PalFX
 	lifebar                 Lifebar
@@ -191,6 +197,7 @@ type System struct {
 	envcol_under            bool
 	stage                   *Stage
 	stageList               map[int32]*Stage
+	uploadedModels          []*Model
 	stageLoop               bool
 	stageLoopNo             int
 	wireframeDraw           bool
@@ -283,9 +290,13 @@ type System struct {
 	wavVolume               int
 	bgmVolume               int
 	audioDucking            bool
+	audioBusSettings        map[string]AudioBusSettings
+	audioBuses              [NumAudioBuses]*AudioBus
 	windowTitle             string
 	screenshotFolder        string
 	audioSampleRate         int32
+	audioOutputName         string
+	audioOutput             AudioOutput
 	//FLAC_FrameWait          int
 
 	// Common Files
@@ -325,11 +336,15 @@ type System struct {
 	postProcessingShader    int32
 	multisampleAntialiasing int32
 	fontShaderVer           uint
+	// Fonts searched for glyphs missing from every loaded font
+	fontFallback []string
 
 	// External Shader Vars
-	externalShaderList  []string
-	externalShaderNames []string
-	externalShaders     [][]string
+	externalShaderList    []string
+	postProcessingPresets map[string][]string
+	postProcessingPreset  string
+	postProcessingParams  map[string]float32
+	postProcessing        *PostProcessing
 
 	// Icon
 	windowMainIcon         []image.Image
@@ -339,6 +354,12 @@ type System struct {
 	borderless bool
 	vRetrace   int
 	pngFilter  bool // Controls the GL_TEXTURE_MAG_FILTER on 32bit sprites
+	// Pack small sprites into shared textures and merge consecutive
+	// sprite draws with the same state into one draw call
+	spriteAtlas    bool
+	spriteBatching bool
+	// Size limit of the SFF cache in bytes, 0 for no limit
+	sffCacheSize int64
 
 	gameMode          string
 	frameCounter      int32
@@ -395,54 +416,37 @@ type System struct {
 func (s *System) init(w, h int32) *lua.LState {
 	s.setWindowSize(w, h)
 	var err error
-	// Create a system window.
-	s.window, err = s.newWindow(int(s.scrrect[2]), int(s.scrrect[3]))
-	chk(err)
-
-	// Check if the shader selected is currently available.
-	if s.postProcessingShader < int32(len(s.externalShaderList)) {
-		s.postProcessingShader = 0
-	}
+	if s.headless {
+		// Nothing is shown or heard, but the sounds still advance
+		s.audioOutput, s.frameSkip = &nullOutput{}, true
+	} else {
+		// Create a system window.
+		s.window, err = s.newWindow(int(s.scrrect[2]), int(s.scrrect[3]))
+		chk(err)
 
-	// Loading of external shader data.
-	// We need to do this before the render initialization at "gfx.Init()"
-	if len(s.externalShaderList) > 0 {
-		// First we initialize arrays.
-		s.externalShaders = make([][]string, 2)
-		s.externalShaderNames = make([]string, len(s.externalShaderList))
-		s.externalShaders[0] = make([]string, len(s.externalShaderList))
-		s.externalShaders[1] = make([]string, len(s.externalShaderList))
-
-		// Then we load.
-		for i, shaderLocation := range s.externalShaderList {
-			// Create names.
-			shaderLocation = strings.Replace(shaderLocation, "\\", "/", -1)
-			splitDir := strings.Split(shaderLocation, "/")
-			s.externalShaderNames[i] = splitDir[len(splitDir)-1]
-
-			// Load vert shaders.
-			content, err := os.ReadFile(shaderLocation + ".vert")
-			if err != nil {
-				chk(err)
-			}
-			s.externalShaders[0][i] = string(content) + "\x00"
+		// Loading of external shader data.
+		// We need to do this before the render initialization at "gfx.Init()"
+		s.postProcessing = newPostProcessing(s.externalShaderList, s.postProcessingPresets, s.postProcessingParams)
+		if !s.postProcessing.SetPreset(s.postProcessingPreset) {
+			s.errLog.Printf("Post-processing preset not found: %v", s.postProcessingPreset)
+		}
 
-			// Load frag shaders.
-			content, err = os.ReadFile(shaderLocation + ".frag")
-			if err != nil {
-				chk(err)
-			}
-			s.externalShaders[1][i] = string(content) + "\x00"
+		// Now we proceed to init the render.
+		gfx.Init()
+		gfx.BeginFrame(false)
+		// And the audio.
+		if s.audioOutput, err = newAudioOutput(s.audioOutputName); err != nil {
+			s.errLog.Printf("Failed to open audio output, sound is disabled: %v", err)
+			s.audioOutput = &nullOutput{}
 		}
 	}
-	// PS: The "\x00" is what is know as Null Terminator.
-
-	// Now we proceed to init the render.
-	gfx.Init()
-	gfx.BeginFrame(false)
-	// And the audio.
-	speaker.Init(beep.SampleRate(sys.audioSampleRate), audioOutLen)
-	speaker.Play(NewNormalizer(s.soundMixer))
+	s.audioBuses = newAudioBuses(s.audioBusSettings)
+	for _, b := range s.audioBuses[:BusBgm] {
+		s.soundMixer.Add(b)
+	}
+	// Sound buses are mixed first, since the BGM bus may be ducked by them
+	s.outputMixer.Add(NewNormalizer(s.soundMixer), s.audioBuses[BusBgm])
+	s.audioOutput.Play(&RecorderTap{s.outputMixer, s.recorder})
 	l := lua.NewState()
 	l.Options.IncludeGoStackTrace = true
 	l.OpenLibs()
@@ -458,7 +462,7 @@ func (s *System) init(w, h int32) *lua.LState {
 	systemScriptInit(l)
 	s.shortcutScripts = make(map[ShortcutKey]*ShortcutScript)
 	// So now that we have a window we add a icon.
-	if len(s.windowMainIconLocation) > 0 {
+	if len(s.windowMainIconLocation) > 0 && !s.headless {
 		// First we initialize arrays.
 		var f = make([]io.ReadCloser, len(s.windowMainIconLocation))
 		s.windowMainIcon = make([]image.Image, len(s.windowMainIconLocation))
@@ -494,9 +498,13 @@ func (s *System) shutdown() {
 	if !sys.gameEnd {
 		sys.gameEnd = true
 	}
-	gfx.Close()
-	s.window.Close()
-	speaker.Close()
+	s.recorder.Stop()
+	if !s.headless {
+		gfx.Close()
+		s.window.Close()
+	}
+	s.audioOutput.Close()
+	vfs.Close()
 }
 func (s *System) setWindowSize(w, h int32) {
 	s.scrrect[2], s.scrrect[3] = w, h
@@ -513,6 +521,9 @@ func (s *System) eventUpdate() bool {
 	for _, v := range s.shortcutScripts {
 		v.Activate = false
 	}
+	if s.headless {
+		return !s.gameEnd
+	}
 	s.window.pollEvents()
 	s.gameEnd = s.window.shouldClose()
 	return !s.gameEnd
@@ -529,15 +540,35 @@ func (s *System) runMainThreadTask() {
 }
 
 func (s *System) await(fps int) bool {
+	s.audioOutput.Frame()
+	// Headless runs draw nothing and are not paced
+	if s.headless {
+		s.frameSkip = true
+		s.runMainThreadTask()
+		return s.eventUpdate()
+	}
 	if !s.frameSkip {
 		// Render the finished frame
-		gfx.EndFrame()
-		s.window.SwapBuffers()
+		batch.Flush()
+		if s.recorder.recording {
+			s.recorder.CaptureFrame()
+		} else {
+			gfx.EndFrame()
+			s.window.SwapBuffers()
+		}
 		// Begin the next frame after events have been processed. Do not clear
 		// the screen if network input is present.
 		defer gfx.BeginFrame(sys.netInput == nil)
+	} else if s.recorder.recording {
+		s.recorder.RepeatFrame()
 	}
 	s.runMainThreadTask()
+	// Offline recordings render as fast as possible
+	if s.recorder.offline {
+		s.frameSkip = false
+		s.eventUpdate()
+		return !s.gameEnd
+	}
 	now := time.Now()
 	diff := s.redrawWait.nextTime.Sub(now)
 	wait := time.Second / time.Duration(fps)
@@ -583,6 +614,9 @@ func (s *System) update() bool {
 }
 func (s *System) tickSound() {
 	s.soundChannels.Tick()
+	for _, b := range s.audioBuses {
+		b.Tick()
+	}
 	if !s.noSoundFlg {
 		for _, ch := range s.chars {
 			for _, c := range ch {
@@ -591,6 +625,8 @@ func (s *System) tickSound() {
 		}
 	}
 
+	s.bgm.Tick()
+
 	// Always pause if noMusic flag set or pause master volume is 0.
 	s.bgm.SetPaused(s.nomusic || (s.paused && s.pauseMasterVolume == 0))
 
@@ -957,9 +993,52 @@ func (s *System) playerClear(pn int, destroy bool) {
 	s.explodsLayer0[pn] = s.explodsLayer0[pn][:0]
 	s.explodsLayer1[pn] = s.explodsLayer1[pn][:0]
 }
+
+// Upload the stage and character models into the shared model buffers.
+// Nothing is uploaded if the models are the ones of the last upload, so
+// the buffers are only rebuilt after a stage or character was loaded.
+func (s *System) uploadModels() {
+	var models []*Model
+	if s.stage.model != nil {
+		models = append(models, s.stage.model)
+	}
+	for i := range s.chars {
+		if len(s.chars[i]) > 0 && s.cgi[i].model != nil {
+			models = append(models, s.cgi[i].model.Model)
+		}
+	}
+	if len(models) == len(s.uploadedModels) {
+		same := true
+		for i, m := range models {
+			if m != s.uploadedModels[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	s.uploadedModels = models
+	var vertexBuffer []byte
+	var elementBuffer []uint32
+	for _, m := range models {
+		m.vertexBase, m.elementBase = uint32(len(vertexBuffer)), uint32(4*len(elementBuffer))
+		vertexBuffer = append(vertexBuffer, m.vertexBuffer...)
+		elementBuffer = append(elementBuffer, m.elementBuffer...)
+	}
+	if len(vertexBuffer) == 0 || len(elementBuffer) == 0 {
+		return
+	}
+	sys.mainThreadTask <- func() {
+		gfx.SetStageVertexData(vertexBuffer)
+		gfx.SetStageIndexData(elementBuffer...)
+	}
+}
 func (s *System) nextRound() {
 	s.resetGblEffect()
 	s.lifebar.reset()
+	s.resetFrameData()
 	s.firstAttack = [3]int{-1, -1, 0}
 	s.finish = FT_NotYet
 	s.winTeam = -1
@@ -1011,13 +1090,8 @@ func (s *System) nextRound() {
 		if s.round > 1 && !s.roundResetFlg {
 			swap = true
 		}
-		if s.stage.model != nil {
-			sys.mainThreadTask <- func() {
-				gfx.SetStageVertexData(s.stage.model.vertexBuffer)
-				gfx.SetStageIndexData(s.stage.model.elementBuffer...)
-			}
-		}
 	}
+	s.uploadModels()
 	s.cam.stageCamera = s.stage.stageCamera
 	s.cam.Init()
 	s.screenleft = float32(s.stage.screenleft) * s.stage.localscl
@@ -1107,6 +1181,7 @@ func (s *System) resetFrameTime() {
 	s.nextAddTime, s.oldNextAddTime = 1, 1
 }
 func (s *System) commandUpdate() {
+	dummyRec.prepare()
 	for i, p := range s.chars {
 		if len(p) > 0 {
 			r := p[0]
@@ -1130,7 +1205,7 @@ func (s *System) commandUpdate() {
 			for _, c := range p {
 				if (c.helperIndex == 0 ||
 					c.helperIndex > 0 && &c.cmd[0] != &r.cmd[0]) &&
-					c.cmd[0].Input(c.key, int32(c.facing), sys.com[i], c.inputFlag) {
+					dummyRec.input(c, i) {
 					hp := c.hitPause() && c.gi().constants["input.pauseonhitpause"] != 0
 					buftime := Btoi(hp && c.gi().mugenver[0] != 1)
 					if s.super > 0 {
@@ -1151,7 +1226,9 @@ func (s *System) commandUpdate() {
 				cc := int32(-1)
 				// AI Scaling
 				// TODO: Balance AI Scaling
-				if sys.roundState() == 2 && RandF32(0, sys.com[i]/2+32) > 32 {
+				if dummyRec.playingBack(i) || dummyRec.controlling(i) {
+					cc = -1
+				} else if sys.roundState() == 2 && RandF32(0, sys.com[i]/2+32) > 32 {
 					cc = Rand(0, int32(len(r.cmd[r.ss.sb.playerNo].Commands))-1)
 				} else {
 					cc = -1
@@ -1164,6 +1241,7 @@ func (s *System) commandUpdate() {
 			}
 		}
 	}
+	dummyRec.advance()
 }
 func (s *System) charUpdate() {
 	s.charList.update()
@@ -1204,6 +1282,7 @@ func (s *System) posReset() {
 	}
 }
 func (s *System) action() {
+	hotReload.update()
 	s.spritesLayerN1 = s.spritesLayerN1[:0]
 	s.spritesLayerU = s.spritesLayerU[:0]
 	s.spritesLayer0 = s.spritesLayer0[:0]
@@ -1549,7 +1628,14 @@ func (s *System) action() {
 		if s.superanim != nil {
 			s.superanim.Action()
 		}
+		breakpoints.resume()
+		profiler.frameBegin()
 		s.charList.action()
+		profiler.frameEnd()
+		s.updateFrameData()
+		breakpoints.update()
+		stateTrace.update()
+		scenario.update()
 		s.nomusic = s.gsf(GSF_nomusic) && !sys.postMatchFlg
 	} else {
 		s.charUpdate()
@@ -1623,7 +1709,7 @@ func (s *System) action() {
 	if s.superanim != nil {
 		s.spritesLayer1.add(&SprData{s.superanim, &s.superpmap, s.superpos,
 			[...]float32{s.superfacing, 1}, [2]int32{-1}, 5, Rotation{}, [2]float32{},
-			false, true, s.cgi[s.superplayer].mugenver[0] != 1, 1, 1, 0, 0, [4]float32{0, 0, 0, 0}}, 0, 0, 0, 0)
+			false, true, s.cgi[s.superplayer].mugenver[0] != 1, 1, 1, 0, 0, [4]float32{0, 0, 0, 0}, nil, nil}, 0, 0, 0, 0)
 		if s.superanim.loopend {
 			s.superanim = nil
 		}
@@ -1974,6 +2060,9 @@ func (s *System) fight() (reload bool) {
 	defer func() {
 		s.oldNextAddTime = 1
 		s.nomusic = false
+		s.ambience.Stop()
+		dummyRec.reset()
+		savestates.Clear()
 		s.allPalFX.clear()
 		s.allPalFX.enable = false
 		for i, p := range s.chars {
@@ -2050,6 +2139,7 @@ func (s *System) fight() (reload bool) {
 		defer s.netInput.Stop()
 	}
 	s.wincnt.init()
+	stateTrace.newMatch()
 
 	// Initialize super meter values, and max power for teams sharing meter
 	var level [len(s.chars)]int32
@@ -2197,9 +2287,15 @@ func (s *System) fight() (reload bool) {
 	// default bgm playback, used only in Quick VS or if externalized Lua implementaion is disabled
 	if s.round == 1 && (s.gameMode == "" || len(sys.commonLua) == 0) {
 		// fmt.Printf("[DEBUG][system.go] System.fight: %v\n", s.stage.bgmusic)
-		s.bgm.Open(s.stage.bgmusic, 1, int(s.stage.bgmvolume), int(s.stage.bgmloopstart), int(s.stage.bgmloopend), int(s.stage.bgmstartposition), s.stage.bgmfreqmul)
+		if len(s.stage.bgmplaylist) > 0 {
+			s.bgm.OpenPlaylist(s.stage.bgmplaylist, s.stage.bgmshuffle, int(s.stage.bgmvolume), s.stage.bgmcrossfade)
+		} else {
+			s.bgm.Open(s.stage.bgmusic, 1, int(s.stage.bgmvolume), int(s.stage.bgmloopstart), int(s.stage.bgmloopend), int(s.stage.bgmstartposition), s.stage.bgmfreqmul, s.stage.bgmcrossfade)
+		}
 	}
 
+	s.ambience.Play(s.stage.ambience, s.stage.ambiencevolume)
+
 	oldWins, oldDraws := s.wins, s.draws
 	oldTeamLeader := s.teamLeader
 	// Anonymous function to reset values, called at the start of each round
@@ -2411,6 +2507,12 @@ func (s *System) fight() (reload bool) {
 		if !s.frameSkip && s.debugDraw {
 			s.drawDebugText()
 		}
+		if !s.frameSkip && inspector.enabled {
+			inspector.draw()
+		}
+		if !s.frameSkip && profiler.enabled {
+			profiler.draw()
+		}
 		// Break if finished
 		if fin && (!s.postMatchFlg || len(sys.commonLua) == 0) {
 			break
@@ -2651,7 +2753,7 @@ func (s *Select) GetStage(n int) *SelectStage {
 	return &s.stagelist[n-1]
 }
 func (s *Select) addChar(def string) {
-	var zipFileName, tstr, str string
+	var tstr, str string
 	var err error
 	tnow := time.Now()
 	defer func() {
@@ -2671,7 +2773,6 @@ func (s *Select) addChar(def string) {
 		return
 	}
 	if strings.Index(def, ".zip") == -1 {
-		zipFileName = ""
 		idx := strings.Index(def, "/")
 		if len(def) >= 4 && strings.ToLower(def[len(def)-4:]) == ".def" {
 			if idx < 0 {
@@ -2702,11 +2803,9 @@ func (s *Select) addChar(def string) {
 		sc.def = def
 		// fmt.Printf("[standar] sc.def=[%v]\n", sc.def)
 	} else { // load from zip
-		zipFileName = "chars/" + filepath.Base(def)
-		sc.def = strings.TrimSuffix(filepath.Base(zipFileName), ".zip") + ".def"
-		// fmt.Printf("[zip] [%v] sc.def=[%v]\n", def, sc.def)
-		str, err = LoadTextFromZip(zipFileName, sc.def)
-		sc.def = zipFileName + "," + sc.def
+		zipFileName := "chars/" + filepath.Base(def)
+		sc.def = zipFileName + "/" + strings.TrimSuffix(filepath.Base(zipFileName), ".zip") + ".def"
+		str, err = LoadText(sc.def)
 		if err != nil {
 			sc.name = "dummyslot"
 			fmt.Printf("[Error] %v\n", err)
@@ -2844,13 +2943,7 @@ func (s *Select) addChar(def string) {
 	// read size values
 	LoadFile(&cns, []string{def, "", "data/"}, func(filename string) error {
 		// fmt.Printf("Load CNS=[%v]\n", filename)
-		var str string
-		var err error
-		if zipFileName == "" {
-			str, err = LoadText(filename)
-		} else {
-			str, err = LoadTextFromZip(zipFileName, filename)
-		}
+		str, err := LoadText(filename)
 		if err != nil {
 			fmt.Printf("[ERROR] Load CNS=[%v]\n%v", filename, err)
 			return err
@@ -2874,14 +2967,8 @@ func (s *Select) addChar(def string) {
 	})
 	// preload animations
 	LoadFile(&anim, []string{def, "", "data/"}, func(filename string) error {
-		var str string
-		var err error
 		// fmt.Printf("Load Anim=[%v]\n", filename)
-		if zipFileName == "" {
-			str, err = LoadText(filename)
-		} else {
-			str, err = LoadTextFromZip(zipFileName, filename)
-		}
+		str, err := LoadText(filename)
 		if err != nil {
 			fmt.Printf("[ERROR] Load Anim=[%v]\n%v", filename, err)
 			return err
@@ -2909,20 +2996,7 @@ func (s *Select) addChar(def string) {
 		LoadFile(&fp, []string{def, "", "data/"}, func(file string) error {
 			var selPal []int32
 			var err error = nil
-			if zipFileName == "" {
-				sc.sff, selPal, err = preloadSff(file, true, listSpr, s.charSpritePreload, sc.palfiles, sc.pal)
-			} else {
-				path := FileExist("tmp/chars/" + file)
-				if path == "" {
-					err = ExtractFileFromZip(zipFileName, file, "tmp/chars")
-					path = "tmp/chars/" + file
-				}
-				if err != nil {
-					log.Fatal(err)
-				} else {
-					sc.sff, selPal, err = preloadSff(file, true, listSpr, s.charSpritePreload, sc.palfiles, sc.pal)
-				}
-			}
+			sc.sff, selPal, err = preloadSff(file, true, listSpr, s.charSpritePreload, sc.palfiles, sc.pal)
 			if err != nil {
 				panic(fmt.Errorf("failed to load %v: %v\nerror preloading %v", file, err, def))
 			}
@@ -2967,11 +3041,7 @@ func (s *Select) addChar(def string) {
 	if len(movelist) > 0 {
 		LoadFile(&movelist, []string{def, "", "data/"}, func(file string) error {
 			var err error
-			if zipFileName == "" {
-				sc.movelist, err = LoadText(file)
-			} else {
-				sc.movelist, err = LoadTextFromZip(zipFileName, file)
-			}
+			sc.movelist, err = LoadText(file)
 			if err != nil {
 				fmt.Printf("[ERROR] Load Movelist=[%v]\n%v", file, err)
 				return err
@@ -2999,28 +3069,16 @@ func (s *Select) addChar(def string) {
 }
 func (s *Select) AddStage(def string) error {
 	// fmt.Printf("[DEBUG][system.go] AddStage: %v\n", def)
-	var zipDef, zipFileName, tstr string
+	var tstr string
 	tnow := time.Now()
 	defer func() {
 		sys.loadTime(tnow, tstr, false, false)
 	}()
-	if strings.Index(def, ".zip") == -1 {
-		zipFileName = ""
-		zipDef = ""
-	} else {
-		lines := SplitAndTrim(def, "|")
-		zipFileName = lines[0]
-		zipDef = lines[1]
-	}
+	// Zipped stages are given as "archive.zip|stage.def"
+	def = vfsPath(def)
 	var lines []string
 	if err := LoadFile(&def, []string{"", "data/"}, func(file string) error {
-		var str string
-		var err error
-		if zipFileName == "" {
-			str, err = LoadText(file)
-		} else {
-			str, err = LoadTextFromZip(zipFileName, zipDef)
-		}
+		str, err := LoadText(file)
 		if err != nil {
 			return err
 		}
@@ -3135,20 +3193,7 @@ func (s *Select) AddStage(def string) error {
 		// preload portion of sff file
 		LoadFile(&spr, []string{def, "", "data/"}, func(file string) error {
 			var err error
-			if zipFileName == "" {
-				ss.sff, _, err = preloadSff(file, false, listSpr, nil, nil, nil)
-			} else {
-				path := FileExist("tmp/stages/" + file)
-				if path == "" {
-					err = ExtractFileFromZip(zipFileName, file, "tmp/stages")
-					path = "tmp/stages/" + file
-				}
-				if err != nil {
-					log.Fatal(err)
-				} else {
-					ss.sff, _, err = preloadSff(file, false, listSpr, nil, nil, nil)
-				}
-			}
+			ss.sff, _, err = preloadSff(file, false, listSpr, nil, nil, nil)
 			if err != nil {
 				panic(fmt.Errorf("failed to load %v: %v\nerror preloading %v", file, err, def))
 			}
@@ -3286,6 +3331,7 @@ func (l *Loader) loadChar(pn int) int {
 	} else {
 		p = newChar(pn, 0)
 		if sys.cgi[pn].sff != nil {
+			releaseSff(sys.cgi[pn].sff)
 			sys.cgi[pn].sff.sprites = nil
 		}
 		sys.cgi[pn].sff = nil
@@ -3360,6 +3406,7 @@ func (l *Loader) loadAttachedChar(pn int) int {
 		p.clearCachedData()
 	} else {
 		p = newChar(pn, 0)
+		releaseSff(sys.cgi[pn].sff)
 		sys.cgi[pn].sff = nil
 		sys.cgi[pn].palettedata = nil
 		if len(sys.chars[pn]) > 0 {
@@ -3437,6 +3484,9 @@ func (l *Loader) loadStage() bool {
 			fmt.Println(tstr)
 			return true
 		}
+		for _, st := range sys.stageList {
+			releaseSff(st.sff)
+		}
 		sys.stageList = make(map[int32]*Stage)
 		sys.stageLoop = false
 		sys.stageList[0], l.err = loadStage(def, true)
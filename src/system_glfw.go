@@ -12,6 +12,9 @@ import (
 	glfw "github.com/go-gl/glfw/v3.3/glfw"
 )
 
+// This build always opens a window.
+const headless = false
+
 type Window struct {
 	*glfw.Window
 	title      string
@@ -44,6 +47,10 @@ func (s *System) newWindow(w, h int) (*Window, error) {
 	fullscreen := s.fullscreen && !forceWindowed
 
 	glfw.WindowHint(glfw.Resizable, glfw.True)
+	// Scenario tests run without showing the window
+	if _, ok := sys.cmdFlags["-scenario"]; ok {
+		glfw.WindowHint(glfw.Visible, glfw.False)
+	}
 
 	// only macOS needs this
 	if runtime.GOOS == "darwin" {
@@ -0,0 +1,66 @@
+//go:build headless
+
+package main
+
+import (
+	"image"
+	"time"
+)
+
+// Headless system for automated tests and scenario runs: no window is
+// created and nothing is shown. Rendering goes to the software renderer's
+// framebuffer, so build it with the soft tag.
+
+// Scenario runs of this build skip the window, renderer and Lua scripts.
+const headless = true
+
+type Window struct {
+	title      string
+	fullscreen bool
+	w, h       int
+	closed     bool
+}
+
+func updateTimeStamp() {
+	sys.prevTimestamp = float64(time.Now().UnixNano()) / 1e9
+}
+
+func (s *System) newWindow(w, h int) (*Window, error) {
+	return &Window{title: s.windowTitle, w: w, h: h}, nil
+}
+
+func (w *Window) SwapBuffers() {}
+
+func (w *Window) SetIcon(icon []image.Image) {}
+
+func (w *Window) SetSwapInterval(interval int) {}
+
+func (w *Window) GetSize() (int, int) {
+	return w.w, w.h
+}
+
+func (w *Window) GetScaledViewportSize() (int32, int32, int32, int32) {
+	return 0, 0, int32(w.w), int32(w.h)
+}
+
+func (w *Window) GetClipboardString() string {
+	return ""
+}
+
+func (w *Window) toggleFullscreen() {}
+
+func (w *Window) pollEvents() {}
+
+func (w *Window) shouldClose() bool {
+	return w.closed
+}
+
+func (w *Window) SetShouldClose(value bool) {
+	w.closed = value
+}
+
+func (w *Window) Close() {}
+
+func nextTickCount() {
+	sys.absTickCountGLFW++
+}
@@ -22,6 +22,9 @@ extern uint8_t close_callback(void *);
 */
 import "C"
 
+// This build always opens a window.
+const headless = false
+
 type Window struct {
 	width      int
 	height     int
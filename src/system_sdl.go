@@ -17,6 +17,9 @@ import (
 	sdl "github.com/veandco/go-sdl2/sdl"
 )
 
+// This build always opens a window.
+const headless = false
+
 type Window struct {
 	*sdl.Window
 	title       string
@@ -48,13 +51,19 @@ func (s *System) newWindow(w, h int) (*Window, error) {
 	_, forceWindowed := sys.cmdFlags["-windowed"]
 	fullscreen := s.fullscreen && !forceWindowed
 
+	// Scenario tests run without showing the window
+	var shown uint32 = sdl.WINDOW_SHOWN
+	if _, ok := sys.cmdFlags["-scenario"]; ok {
+		shown = sdl.WINDOW_HIDDEN
+	}
+
 	// Create main window.
 	if fullscreen && !s.borderless {
 		window, err = sdl.CreateWindow(s.windowTitle, sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
 			int32(w), int32(h), sdl.WINDOW_OPENGL|sdl.WINDOW_FULLSCREEN)
 	} else {
 		window, err = sdl.CreateWindow(s.windowTitle, sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
-			int32(w), int32(h), sdl.WINDOW_OPENGL|sdl.WINDOW_RESIZABLE|sdl.WINDOW_SHOWN)
+			int32(w), int32(h), sdl.WINDOW_OPENGL|sdl.WINDOW_RESIZABLE|shown)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("\nfailed to sdl.CreateWindow: %w\n", err)
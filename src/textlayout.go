@@ -0,0 +1,605 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Text layout shared by bitmap and TrueType fonts: fallback font chains,
+// kerning, Arabic shaping, bidirectional reordering, combining marks and
+// line wrapping. Layout only uses font metrics, so glyph positions can be
+// measured without a renderer.
+
+// LayoutGlyph is a glyph placed by the layout, in visual order
+type LayoutGlyph struct {
+	Rune rune
+	// Font of the chain drawing the glyph
+	Font *Fnt
+	// Sprite bank of bitmap fonts with BankType "sprite"
+	bt int32
+	// Pen position relative to the text origin, in unscaled font pixels
+	X, Y    float32
+	Advance float32
+	Line    int
+}
+
+// TextLayout holds the laid out glyphs and the width of each line
+type TextLayout struct {
+	Glyphs     []LayoutGlyph
+	Lines      []float32
+	LineHeight float32
+}
+
+// Width of the widest line
+func (tl *TextLayout) Width() (w float32) {
+	for _, lw := range tl.Lines {
+		w = MaxF(w, lw)
+	}
+	return
+}
+
+type layoutKey struct {
+	txt  string
+	bank int32
+	wrap float32
+}
+
+// Fonts loaded as fallbacks, shared by every chain using them
+var fallbackFnts = map[string]*Fnt{}
+
+// Load the fallback fonts listed in a font definition and in the config
+func (f *Fnt) loadFallbacks(deffile string, files []string, height int32) {
+	if height <= 0 {
+		height = int32(f.Size[1])
+	}
+	for _, name := range append(append([]string{}, files...), sys.fontFallback...) {
+		fb, err := loadFallbackFnt(deffile, name, height)
+		if err != nil {
+			sys.errLog.Printf("Failed to load fallback font %v: %v\n", name, err)
+			continue
+		}
+		if fb != f {
+			f.fallback = append(f.fallback, fb)
+		}
+	}
+}
+
+func loadFallbackFnt(deffile, name string, height int32) (fb *Fnt, err error) {
+	key := fmt.Sprintf("%v,%v", strings.ToLower(name), height)
+	if fb, ok := fallbackFnts[key]; ok {
+		return fb, nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = Error(fmt.Sprint(r))
+		}
+	}()
+	if HasExtension(name, ".ttf") || HasExtension(name, ".otf") {
+		fb = newFnt()
+		fb.Type = "truetype"
+		LoadFntTtf(fb, deffile, name, height)
+	} else {
+		file := SearchFile(name, []string{deffile, "font/", sys.motifDir, "", "data/"})
+		if fb, err = loadFntFile(file, height); err != nil {
+			return nil, err
+		}
+	}
+	fallbackFnts[key] = fb
+	return fb, nil
+}
+
+// Metrics of a TrueType font, used for glyph coverage and kerning
+func parseTtfMetrics(filename string) *truetype.Font {
+	data, err := vfs.ReadFile(filename)
+	if err != nil {
+		return nil
+	}
+	ttf, err := truetype.Parse(data)
+	if err != nil {
+		return nil
+	}
+	return ttf
+}
+
+func (f *Fnt) hasGlyph(r rune, bt int32) bool {
+	if f.Type == "truetype" {
+		return f.metrics == nil || f.metrics.Index(r) != 0
+	}
+	return r == ' ' || f.images[bt][r] != nil
+}
+
+func (f *Fnt) glyphAdvance(r rune, bt int32) float32 {
+	if f.Type == "truetype" {
+		if f.metrics != nil {
+			return float32(f.metrics.HMetric(fixed.I(int(f.Size[1])), f.metrics.Index(r)).AdvanceWidth.Round())
+		}
+		if f.ttf != nil {
+			return f.ttf.Width(1, "%s", string(r))
+		}
+		return 0
+	}
+	return float32(f.CharWidth(r, bt))
+}
+
+func (f *Fnt) kern(a, b rune) float32 {
+	if f.Type != "truetype" || f.metrics == nil {
+		return 0
+	}
+	k := f.metrics.Kern(fixed.I(int(f.Size[1])), f.metrics.Index(a), f.metrics.Index(b))
+	return float32(k) / 64
+}
+
+// Font of the chain that has a glyph for r. Bitmap fonts without the glyph
+// anywhere in the chain show a space, as Mugen does.
+func (f *Fnt) glyphFont(r rune, bt int32) (*Fnt, int32, rune) {
+	if f.hasGlyph(r, bt) {
+		return f, bt, r
+	}
+	for _, fb := range f.fallback {
+		if fb.hasGlyph(r, 0) {
+			return fb, 0, r
+		}
+	}
+	if f.Type == "truetype" {
+		return f, bt, r
+	}
+	return f, bt, ' '
+}
+
+func (f *Fnt) chainHasGlyph(r rune, bt int32) bool {
+	if f.hasGlyph(r, bt) {
+		return true
+	}
+	for _, fb := range f.fallback {
+		if fb.hasGlyph(r, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// Layout lays out txt, wrapping lines longer than wrap font pixels if wrap
+// is positive. "\n" starts a new line.
+func (f *Fnt) Layout(txt string, bank int32, wrap float32) *TextLayout {
+	key := layoutKey{txt, bank, wrap}
+	if tl, ok := f.layouts[key]; ok {
+		return tl
+	}
+	var bt int32
+	if f.BankType == "sprite" {
+		bt = bank
+	}
+	tl := &TextLayout{LineHeight: float32(int32(f.Size[1]) + f.Spacing[1])}
+	for _, para := range strings.Split(txt, "\n") {
+		f.layoutParagraph(tl, []rune(para), bt, wrap)
+	}
+	if f.layouts == nil || len(f.layouts) >= 256 {
+		f.layouts = make(map[layoutKey]*TextLayout)
+	}
+	f.layouts[key] = tl
+	return tl
+}
+
+type layoutRune struct {
+	r     rune
+	font  *Fnt
+	bt    int32
+	adv   float32
+	mark  bool
+	class bidiClass
+	level int
+}
+
+func (f *Fnt) layoutParagraph(tl *TextLayout, rs []rune, bt int32, wrap float32) {
+	rs = shapeArabic(rs, func(r rune) bool { return f.chainHasGlyph(r, bt) })
+	lr := make([]layoutRune, len(rs))
+	for i, r := range rs {
+		g := &lr[i]
+		g.font, g.bt, g.r = f.glyphFont(r, bt)
+		g.mark = isCombining(r)
+		g.class = bidiClassOf(r)
+		g.adv = g.font.glyphAdvance(g.r, g.bt)
+	}
+	base := resolveBidiLevels(lr)
+	for _, line := range f.breakLines(lr, wrap) {
+		f.placeLine(tl, line, base)
+	}
+}
+
+func isCombining(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me)
+}
+
+// Bitmap fonts add the font spacing after every character
+func (f *Fnt) spacing() float32 {
+	if f.Type == "truetype" {
+		return 0
+	}
+	return float32(f.Spacing[0])
+}
+
+// Split a paragraph into lines no wider than wrap
+func (f *Fnt) breakLines(lr []layoutRune, wrap float32) [][]layoutRune {
+	if wrap <= 0 {
+		return [][]layoutRune{lr}
+	}
+	var lines [][]layoutRune
+	start, lastBreak := 0, -1
+	w := float32(0)
+	for i := 0; i < len(lr); i++ {
+		if lr[i].mark {
+			continue
+		}
+		if i > start && canBreakBefore(lr, i) {
+			lastBreak = i
+		}
+		adv := lr[i].adv
+		if w+adv > wrap && i > start && lr[i].r != ' ' {
+			end := i
+			if lastBreak > start {
+				end = lastBreak
+			}
+			lines = append(lines, trimLineEnd(lr[start:end]))
+			start, lastBreak = end, -1
+			for start < len(lr) && lr[start].r == ' ' {
+				start++
+			}
+			w = 0
+			i = start - 1
+			continue
+		}
+		w += adv + f.spacing()
+	}
+	if start < len(lr) || len(lines) == 0 {
+		lines = append(lines, lr[start:])
+	}
+	return lines
+}
+
+func trimLineEnd(line []layoutRune) []layoutRune {
+	for len(line) > 0 && line[len(line)-1].r == ' ' {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// Characters that don't start a line and don't end one, for CJK text
+const (
+	noLineStart = "、。，．・：；？！ー）」』】〕〉》｝゛゜ぁぃぅぇぉっゃゅょァィゥェォッャュョ,.!?:;)]}"
+	noLineEnd   = "（「『【〔〈《｛([{"
+)
+
+func isWideBreakable(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		r >= 0x3000 && r <= 0x303f || r >= 0xff00 && r <= 0xffef
+}
+
+func canBreakBefore(lr []layoutRune, i int) bool {
+	prev, cur := lr[i-1].r, lr[i].r
+	if strings.ContainsRune(noLineStart, cur) || strings.ContainsRune(noLineEnd, prev) {
+		return false
+	}
+	return prev == ' ' || isWideBreakable(prev) || isWideBreakable(cur)
+}
+
+// Reorder a line visually and compute the glyph positions
+func (f *Fnt) placeLine(tl *TextLayout, line []layoutRune, base int) {
+	lineNo := len(tl.Lines)
+	// Trailing spaces take the paragraph level (rule L1)
+	vis := make([]layoutRune, len(line))
+	copy(vis, line)
+	for i := len(vis) - 1; i >= 0 && vis[i].r == ' '; i-- {
+		vis[i].level = base
+	}
+	reorderBidi(vis)
+	pen, width := float32(0), float32(0)
+	sp := f.spacing()
+	baseIdx, nbase := -1, 0
+	for i := range vis {
+		if !vis[i].mark {
+			nbase++
+		}
+	}
+	for i := range vis {
+		g := &vis[i]
+		lg := LayoutGlyph{Rune: g.r, Font: g.font, bt: g.bt, Y: float32(lineNo) * tl.LineHeight,
+			Line: lineNo}
+		if g.mark && baseIdx >= 0 {
+			b := tl.Glyphs[baseIdx]
+			if g.font.Type == "truetype" {
+				lg.X = b.X + b.Advance
+			} else {
+				lg.X = b.X + (b.Advance-g.adv)/2
+			}
+			tl.Glyphs = append(tl.Glyphs, lg)
+			continue
+		}
+		if baseIdx >= 0 {
+			if p := tl.Glyphs[baseIdx]; p.Font == g.font {
+				pen += g.font.kern(p.Rune, g.r)
+			}
+		}
+		lg.X, lg.Advance = pen, g.adv
+		tl.Glyphs = append(tl.Glyphs, lg)
+		baseIdx = len(tl.Glyphs) - 1
+		pen += g.adv + sp
+		nbase--
+		// Negative spacing matching the character width is left out of
+		// the width, as in Mugen
+		if g.adv+sp > 0 {
+			width += g.adv
+			if nbase > 0 {
+				width += sp
+			}
+		}
+	}
+	tl.Lines = append(tl.Lines, width)
+}
+
+// ------------------------------------------------------------------
+// Bidirectional text (a subset of UAX #9 without explicit embeddings)
+
+type bidiClass byte
+
+const (
+	bidiN bidiClass = iota // Neutral
+	bidiL
+	bidiR
+	bidiNum
+	bidiNSM
+)
+
+func bidiClassOf(r rune) bidiClass {
+	switch {
+	case isCombining(r):
+		return bidiNSM
+	case r >= '0' && r <= '9', r >= 0x0660 && r <= 0x0669, r >= 0x06f0 && r <= 0x06f9:
+		return bidiNum
+	case r >= 0x0590 && r <= 0x08ff, r >= 0xfb1d && r <= 0xfdff, r >= 0xfe70 && r <= 0xfefe,
+		r >= 0x10800 && r <= 0x10fff, r >= 0x1e800 && r <= 0x1efff:
+		return bidiR
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mc, r):
+		return bidiL
+	}
+	return bidiN
+}
+
+// Resolve the embedding level of each character and return the paragraph
+// level
+func resolveBidiLevels(lr []layoutRune) int {
+	base := 0
+	for _, g := range lr {
+		if g.class == bidiL {
+			break
+		} else if g.class == bidiR {
+			base = 1
+			break
+		}
+	}
+	dir := func(c bidiClass) int {
+		if c == bidiL {
+			return 0
+		}
+		return 1
+	}
+	cls := make([]bidiClass, len(lr))
+	prev, strong := bidiL, bidiL
+	if base == 1 {
+		prev, strong = bidiR, bidiR
+	}
+	for i, g := range lr {
+		// Marks take the class of their base, numbers after left to right
+		// text are left to right
+		switch cls[i] = g.class; cls[i] {
+		case bidiNSM:
+			cls[i] = prev
+		case bidiNum:
+			if strong == bidiL {
+				cls[i] = bidiL
+			}
+		case bidiL, bidiR:
+			strong = cls[i]
+		}
+		prev = cls[i]
+	}
+	for i := 0; i < len(cls); {
+		if cls[i] != bidiN {
+			i++
+			continue
+		}
+		// Neutrals between characters of the same direction take it,
+		// others the paragraph direction. Numbers count as right to left.
+		j := i
+		for j < len(cls) && cls[j] == bidiN {
+			j++
+		}
+		before, after := base, base
+		if i > 0 {
+			before = dir(cls[i-1])
+		}
+		if j < len(cls) {
+			after = dir(cls[j])
+		}
+		c := bidiL
+		if before == after && before == 1 || before != after && base == 1 {
+			c = bidiR
+		}
+		for k := i; k < j; k++ {
+			cls[k] = c
+		}
+		i = j
+	}
+	for i := range lr {
+		switch cls[i] {
+		case bidiR:
+			lr[i].level = 1
+		case bidiNum:
+			lr[i].level = 2
+		default:
+			lr[i].level = base * 2
+		}
+	}
+	return base
+}
+
+var bidiMirror = map[rune]rune{'(': ')', ')': '(', '[': ']', ']': '[', '{': '}', '}': '{',
+	'<': '>', '>': '<', '«': '»', '»': '«'}
+
+// Reverse every run at each level from the highest to the lowest odd one,
+// then put marks back after their base and mirror brackets in right to left
+// runs
+func reorderBidi(vis []layoutRune) {
+	maxLevel, minOdd := 0, math.MaxInt32
+	for _, g := range vis {
+		if g.level > maxLevel {
+			maxLevel = g.level
+		}
+		if g.level%2 == 1 && g.level < minOdd {
+			minOdd = g.level
+		}
+	}
+	for lv := maxLevel; lv >= minOdd && lv > 0; lv-- {
+		for i := 0; i < len(vis); {
+			if vis[i].level < lv {
+				i++
+				continue
+			}
+			j := i
+			for j < len(vis) && vis[j].level >= lv {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				vis[a], vis[b] = vis[b], vis[a]
+			}
+			i = j
+		}
+	}
+	for i := 0; i < len(vis); i++ {
+		if vis[i].level%2 == 0 {
+			continue
+		}
+		if m, ok := bidiMirror[vis[i].r]; ok && vis[i].font.chainHasGlyph(m, vis[i].bt) {
+			vis[i].r = m
+		}
+		if !vis[i].mark {
+			continue
+		}
+		j := i
+		for j < len(vis) && vis[j].mark && vis[j].level == vis[i].level {
+			j++
+		}
+		if j < len(vis) && vis[j].level == vis[i].level {
+			b := vis[j]
+			copy(vis[i+1:j+1], vis[i:j])
+			vis[i] = b
+			for a, c := i+1, j; a < c; a, c = a+1, c-1 {
+				vis[a], vis[c] = vis[c], vis[a]
+			}
+		}
+		i = j
+	}
+}
+
+// ------------------------------------------------------------------
+// Arabic shaping with the presentation forms of the font
+
+// Isolated, final, initial and medial forms of the Arabic letters. Letters
+// with two forms only join the previous letter.
+var arabicForms = func() map[rune][4]rune {
+	m := make(map[rune][4]rune)
+	form := rune(0xfe80)
+	add := func(first rune, counts []int) {
+		for i, n := range counts {
+			var forms [4]rune
+			for k := 0; k < n; k++ {
+				forms[k] = form + rune(k)
+			}
+			m[first+rune(i)] = forms
+			form += rune(n)
+		}
+	}
+	add(0x0621, []int{1, 2, 2, 2, 2, 4, 2, 4, 2, 4, 4, 4, 4, 4, 2, 2, 2, 2, 4, 4, 4, 4, 4, 4, 4, 4})
+	add(0x0641, []int{4, 4, 4, 4, 4, 4, 4, 2, 2, 4})
+	return m
+}()
+
+const arabicTatweel = 0x0640
+
+// Isolated forms of the lam-alef ligatures, followed by their final forms
+var lamAlef = map[rune]rune{0x0622: 0xfef5, 0x0623: 0xfef7, 0x0625: 0xfef9, 0x0627: 0xfefb}
+
+func arabicJoinsNext(r rune) bool {
+	f, ok := arabicForms[r]
+	return ok && f[2] != 0 || r == arabicTatweel
+}
+
+func arabicJoinsPrev(r rune) bool {
+	f, ok := arabicForms[r]
+	return ok && f[1] != 0 || r == arabicTatweel
+}
+
+func shapeArabic(rs []rune, has func(rune) bool) []rune {
+	arabic := false
+	for _, r := range rs {
+		if r >= 0x0600 && r <= 0x06ff {
+			arabic = true
+			break
+		}
+	}
+	if !arabic {
+		return rs
+	}
+	neighbor := func(i, step int) rune {
+		for i += step; i >= 0 && i < len(rs); i += step {
+			if !isCombining(rs[i]) {
+				return rs[i]
+			}
+		}
+		return 0
+	}
+	out := make([]rune, 0, len(rs))
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		joinPrev := arabicJoinsNext(neighbor(i, -1)) && arabicJoinsPrev(r)
+		// Lam followed by alef is written as a ligature
+		if l, ok := lamAlef[neighbor(i, 1)]; r == 0x0644 && ok {
+			if joinPrev {
+				l++
+			}
+			if has(l) {
+				out = append(out, l)
+				for i++; isCombining(rs[i]); i++ {
+					out = append(out, rs[i])
+				}
+				continue
+			}
+		}
+		joinNext := forms[2] != 0 && arabicJoinsPrev(neighbor(i, 1))
+		var s rune
+		switch {
+		case joinPrev && joinNext:
+			s = forms[3]
+		case joinPrev:
+			s = forms[1]
+		case joinNext:
+			s = forms[2]
+		default:
+			s = forms[0]
+		}
+		if s != 0 && has(s) {
+			r = s
+		}
+		out = append(out, r)
+	}
+	return out
+}
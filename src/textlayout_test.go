@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+// Bitmap font with the given character widths, laid out without loading
+// any sprite
+func layoutTestFnt(spacing int32, widths map[rune]uint16) *Fnt {
+	f := newFnt()
+	f.Type = "bitmap"
+	f.Size = [2]uint16{8, 10}
+	f.Spacing = [2]int32{spacing, 2}
+	f.images[0] = make(map[rune]*FntCharImage)
+	for r, w := range widths {
+		f.images[0][r] = &FntCharImage{w: w}
+	}
+	return f
+}
+
+func TestTextLayout(t *testing.T) {
+	fb := layoutTestFnt(0, map[rune]uint16{'x': 9})
+	f := layoutTestFnt(1, map[rune]uint16{'a': 5, 'b': 6, 'c': 7,
+		'א': 4, 'ב': 3, '\u0301': 2})
+	f.fallback = []*Fnt{fb}
+	type glyph struct {
+		r    rune
+		font *Fnt
+		x, y float32
+		line int
+	}
+	cases := []struct {
+		name  string
+		txt   string
+		wrap  float32
+		want  []glyph
+		lines []float32
+	}{
+		{"spacing", "ab c", 0, []glyph{
+			{'a', f, 0, 0, 0}, {'b', f, 6, 0, 0}, {' ', f, 13, 0, 0}, {'c', f, 22, 0, 0}},
+			[]float32{29}},
+		{"newline", "a\nb", 0, []glyph{
+			{'a', f, 0, 0, 0}, {'b', f, 0, 12, 1}},
+			[]float32{5, 6}},
+		{"wrap", "ab ab", 14, []glyph{
+			{'a', f, 0, 0, 0}, {'b', f, 6, 0, 0}, {'a', f, 0, 12, 1}, {'b', f, 6, 12, 1}},
+			[]float32{12, 12}},
+		{"fallback", "ax", 0, []glyph{
+			{'a', f, 0, 0, 0}, {'x', fb, 6, 0, 0}},
+			[]float32{15}},
+		{"missing", "a?", 0, []glyph{
+			{'a', f, 0, 0, 0}, {' ', f, 6, 0, 0}},
+			[]float32{14}},
+		{"mark", "a\u0301b", 0, []glyph{
+			{'a', f, 0, 0, 0}, {'\u0301', f, 1.5, 0, 0}, {'b', f, 6, 0, 0}},
+			[]float32{12}},
+		{"rtl", "אב", 0, []glyph{
+			{'ב', f, 0, 0, 0}, {'א', f, 4, 0, 0}},
+			[]float32{8}},
+		{"mixed", "a אב b", 0, []glyph{
+			{'a', f, 0, 0, 0}, {' ', f, 6, 0, 0}, {'ב', f, 15, 0, 0},
+			{'א', f, 19, 0, 0}, {' ', f, 24, 0, 0}, {'b', f, 33, 0, 0}},
+			[]float32{39}},
+	}
+	for _, c := range cases {
+		tl := f.Layout(c.txt, 0, c.wrap)
+		if len(tl.Glyphs) != len(c.want) {
+			t.Errorf("%v: %v glyphs, want %v", c.name, len(tl.Glyphs), len(c.want))
+			continue
+		}
+		for i, g := range tl.Glyphs {
+			w := c.want[i]
+			if g.Rune != w.r || g.Font != w.font || g.X != w.x || g.Y != w.y || g.Line != w.line {
+				t.Errorf("%v: glyph %v is %q at %v,%v on line %v, want %q at %v,%v on line %v",
+					c.name, i, g.Rune, g.X, g.Y, g.Line, w.r, w.x, w.y, w.line)
+			}
+		}
+		if len(tl.Lines) != len(c.lines) {
+			t.Errorf("%v: %v lines, want %v", c.name, len(tl.Lines), len(c.lines))
+			continue
+		}
+		for i, lw := range tl.Lines {
+			if lw != c.lines[i] {
+				t.Errorf("%v: line %v is %v wide, want %v", c.name, i, lw, c.lines[i])
+			}
+		}
+	}
+}
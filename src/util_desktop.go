@@ -54,6 +54,7 @@ func LoadFntTtf(f *Fnt, fontfile string, filename string, height int32) {
 		panic(err)
 	}
 	f.ttf = ttf
+	f.metrics = parseTtfMetrics(fileDir)
 
 	// Create Ttf dummy palettes
 	f.palettes = make([][256]uint32, 1)
@@ -0,0 +1,561 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The virtual filesystem every content file is read through. Paths are the
+// usual relative or absolute paths, except that a .zip file can be entered
+// as if it was a directory, e.g. "chars/kfm.zip/kfm.def". On top of that,
+// directories and archives can be mounted over the tree; files in later
+// mounts hide the same files in earlier mounts and in the real tree, so a
+// mod folder can replace single files of an archived character.
+var vfs = newVirtualFS()
+
+// VFile is an open file of the virtual filesystem
+type VFile interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Convert a path to the form used by the virtual filesystem. The old
+// "archive.zip,file.def" and "archive.zip|file.def" forms are accepted too.
+func vfsPath(name string) string {
+	name = filepath.ToSlash(name)
+	for _, sep := range []string{".zip,", ".zip|"} {
+		if i := strings.Index(strings.ToLower(name), sep); i >= 0 {
+			name = name[:i+4] + "/" + strings.TrimSpace(name[i+5:])
+		}
+	}
+	if name == "" {
+		return name
+	}
+	return path.Clean(name)
+}
+
+// Whether a path component is an archive
+func isZipName(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".zip")
+}
+
+// ------------------------------------------------------------------
+// vfsArchive
+
+type vfsArchive struct {
+	file  *os.File
+	files map[string]*zip.File // By lower case name
+	dirs  map[string]bool
+}
+
+func openVfsArchive(filename string) (*vfsArchive, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	zr, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &vfsArchive{file: f, files: make(map[string]*zip.File),
+		dirs: map[string]bool{".": true}}
+	for _, zf := range zr.File {
+		name := strings.ToLower(path.Clean(strings.TrimPrefix(zf.Name, "/")))
+		if !strings.HasSuffix(zf.Name, "/") {
+			a.files[name] = zf
+		}
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			a.dirs[dir] = true
+		}
+		if strings.HasSuffix(zf.Name, "/") {
+			a.dirs[name] = true
+		}
+	}
+	return a, nil
+}
+
+func (a *vfsArchive) find(rel string) *vfsEntry {
+	rel = strings.ToLower(rel)
+	if zf, ok := a.files[rel]; ok {
+		return &vfsEntry{zf: zf, archive: a}
+	}
+	if a.dirs[rel] {
+		return &vfsEntry{dir: rel, archive: a}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------
+// vfsEntry
+
+// A file or directory found in the virtual filesystem
+type vfsEntry struct {
+	native  string // Path on disk
+	isDir   bool
+	zf      *zip.File
+	archive *vfsArchive
+	dir     string // Directory inside the archive
+	mounted bool
+}
+
+func (e *vfsEntry) IsDir() bool {
+	return e.isDir || (e.archive != nil && e.zf == nil)
+}
+
+// ReadAt of *os.File is safe for concurrent use, so entries that are stored
+// without compression are read in place. Compressed entries are inflated to
+// memory.
+func (e *vfsEntry) open() (VFile, error) {
+	if e.zf == nil {
+		if e.archive != nil || e.isDir {
+			return nil, Error(e.native + " is a directory")
+		}
+		return os.Open(e.native)
+	}
+	if e.zf.Method == zip.Store {
+		if ofs, err := e.zf.DataOffset(); err == nil {
+			return vfsReader{io.NewSectionReader(e.archive.file, ofs, int64(e.zf.UncompressedSize64))}, nil
+		}
+	}
+	r, err := e.zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return vfsReader{bytes.NewReader(data)}, nil
+}
+
+type vfsReader struct {
+	r interface {
+		io.Reader
+		io.ReaderAt
+		io.Seeker
+	}
+}
+
+func (r vfsReader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}
+
+func (r vfsReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.r.ReadAt(p, off)
+}
+
+func (r vfsReader) Seek(offset int64, whence int) (int64, error) {
+	return r.r.Seek(offset, whence)
+}
+
+func (r vfsReader) Close() error {
+	return nil
+}
+
+// Entries of a directory, by name
+func (e *vfsEntry) list() (map[string]bool, error) {
+	entries := make(map[string]bool)
+	if e.archive == nil {
+		des, err := os.ReadDir(e.native)
+		if err != nil {
+			return nil, err
+		}
+		for _, de := range des {
+			entries[de.Name()] = de.IsDir()
+		}
+		return entries, nil
+	}
+	prefix := ""
+	if e.dir != "." && e.dir != "" {
+		prefix = e.dir + "/"
+	}
+	add := func(name string, dir bool) {
+		if len(name) <= len(prefix) || !strings.EqualFold(name[:len(prefix)], prefix) {
+			return
+		}
+		if rest := name[len(prefix):]; !strings.Contains(rest, "/") {
+			entries[rest] = dir
+		}
+	}
+	for _, zf := range e.archive.files {
+		add(strings.TrimPrefix(path.Clean(zf.Name), "/"), false)
+	}
+	for d := range e.archive.dirs {
+		if d != "." {
+			add(d, true)
+		}
+	}
+	return entries, nil
+}
+
+type vfsDirEntry struct {
+	name string
+	dir  bool
+}
+
+func (d vfsDirEntry) Name() string {
+	return d.name
+}
+
+func (d vfsDirEntry) IsDir() bool {
+	return d.dir
+}
+
+func (d vfsDirEntry) Type() fs.FileMode {
+	if d.dir {
+		return fs.ModeDir
+	}
+	return 0
+}
+
+func (d vfsDirEntry) Info() (fs.FileInfo, error) {
+	return nil, fs.ErrInvalid
+}
+
+// ------------------------------------------------------------------
+// VirtualFS
+
+// A directory or archive mounted from the config. At is the virtual
+// directory it is mounted on, the root if empty.
+type MountSettings struct {
+	Path string
+	At   string
+}
+
+type vfsMount struct {
+	source  string // Directory or archive on disk
+	at      string // Virtual directory it is mounted on, "." for the root
+	archive *vfsArchive
+}
+
+// Path of a virtual file relative to the mount point
+func (m *vfsMount) rel(name string) (string, bool) {
+	if m.at == "." {
+		return name, !path.IsAbs(name) && !strings.HasPrefix(name, "../")
+	}
+	if strings.EqualFold(name, m.at) {
+		return ".", true
+	}
+	if len(name) > len(m.at) && name[len(m.at)] == '/' && strings.EqualFold(name[:len(m.at)], m.at) {
+		return name[len(m.at)+1:], true
+	}
+	return "", false
+}
+
+type VirtualFS struct {
+	mu       sync.RWMutex
+	mounts   []*vfsMount
+	archives map[string]*vfsArchive // Archives met in the real tree
+}
+
+func newVirtualFS() *VirtualFS {
+	return &VirtualFS{archives: make(map[string]*vfsArchive)}
+}
+
+// Mount a directory or a .zip archive on a virtual directory ("" for the
+// root). Later mounts take precedence.
+func (v *VirtualFS) Mount(source, at string) error {
+	m := &vfsMount{source: source, at: vfsPath(at)}
+	if m.at == "" {
+		m.at = "."
+	}
+	if info, err := os.Stat(source); err != nil {
+		return err
+	} else if !info.IsDir() {
+		if m.archive, err = openVfsArchive(source); err != nil {
+			return err
+		}
+	}
+	v.mu.Lock()
+	v.mounts = append(v.mounts, m)
+	v.mu.Unlock()
+	return nil
+}
+
+// Close every archive and remove all mounts
+func (v *VirtualFS) Close() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, m := range v.mounts {
+		if m.archive != nil {
+			m.archive.file.Close()
+		}
+	}
+	for _, a := range v.archives {
+		a.file.Close()
+	}
+	v.mounts = nil
+	v.archives = make(map[string]*vfsArchive)
+}
+
+func (v *VirtualFS) find(name string) *vfsEntry {
+	name = vfsPath(name)
+	if name == "" {
+		return nil
+	}
+	v.mu.RLock()
+	mounts := v.mounts
+	v.mu.RUnlock()
+	for i := len(mounts) - 1; i >= 0; i-- {
+		m := mounts[i]
+		rel, ok := m.rel(name)
+		if !ok {
+			continue
+		}
+		var e *vfsEntry
+		if m.archive != nil {
+			e = m.archive.find(rel)
+		} else {
+			e = v.findNative(path.Join(filepath.ToSlash(m.source), rel))
+		}
+		if e != nil {
+			e.mounted = true
+			return e
+		}
+	}
+	return v.findNative(name)
+}
+
+// Look a path up in the real tree, entering archives on the way
+func (v *VirtualFS) findNative(name string) *vfsEntry {
+	if info, err := os.Stat(name); err == nil {
+		return &vfsEntry{native: name, isDir: info.IsDir()}
+	}
+	// Enter the first archive in the path
+	parts := strings.Split(name, "/")
+	for i := range parts[:len(parts)-1] {
+		if !isZipName(parts[i]) {
+			continue
+		}
+		zipname := strings.Join(parts[:i+1], "/")
+		if zipname == "" {
+			continue
+		}
+		if a := v.archive(zipname); a != nil {
+			return a.find(strings.Join(parts[i+1:], "/"))
+		}
+	}
+	// Case insensitive match
+	if fp := globNoCase(name); fp != "" {
+		if info, err := os.Stat(fp); err == nil {
+			return &vfsEntry{native: fp, isDir: info.IsDir()}
+		}
+	}
+	return nil
+}
+
+// Open archives are kept for the session
+func (v *VirtualFS) archive(zipname string) *vfsArchive {
+	key := strings.ToLower(zipname)
+	v.mu.RLock()
+	a, ok := v.archives[key]
+	v.mu.RUnlock()
+	if ok {
+		return a
+	}
+	fp := zipname
+	if info, err := os.Stat(fp); err != nil || info.IsDir() {
+		if fp = globNoCase(zipname); fp == "" {
+			return nil
+		}
+	}
+	a, err := openVfsArchive(fp)
+	if err != nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if old, ok := v.archives[key]; ok {
+		a.file.Close()
+		return old
+	}
+	v.archives[key] = a
+	return a
+}
+
+// Open a file for reading
+func (v *VirtualFS) Open(name string) (VFile, error) {
+	e := v.find(name)
+	if e == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return e.open()
+}
+
+// Read a whole file
+func (v *VirtualFS) ReadFile(name string) ([]byte, error) {
+	f, err := v.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Returns the path to open the file with, or "" if there is no such file.
+// Files in the real tree are matched case insensitively. Mounted files keep
+// their virtual path, so files next to them are still looked up in the
+// virtual tree.
+func (v *VirtualFS) FileExist(name string) string {
+	e := v.find(name)
+	if e == nil || e.IsDir() {
+		return ""
+	}
+	if e.zf == nil && !e.mounted {
+		return e.native
+	}
+	return vfsPath(name)
+}
+
+// Whether the path is a directory, or an archive that can be entered
+func (v *VirtualFS) DirExist(name string) bool {
+	e := v.find(name)
+	if e == nil {
+		return false
+	}
+	if !e.IsDir() && isZipName(e.native) {
+		return v.archive(e.native) != nil
+	}
+	return e.IsDir()
+}
+
+// List a directory, merging all the mounts that cover it
+func (v *VirtualFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	name = vfsPath(name)
+	entries := make(map[string]bool)
+	found := false
+	merge := func(e *vfsEntry) {
+		if e == nil {
+			return
+		}
+		if !e.IsDir() && isZipName(e.native) {
+			if a := v.archive(e.native); a != nil {
+				e = &vfsEntry{dir: ".", archive: a}
+			}
+		}
+		if !e.IsDir() {
+			return
+		}
+		if list, err := e.list(); err == nil {
+			found = true
+			for k, d := range list {
+				if _, ok := entries[k]; !ok {
+					entries[k] = d
+				}
+			}
+		}
+	}
+	v.mu.RLock()
+	mounts := v.mounts
+	v.mu.RUnlock()
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if rel, ok := mounts[i].rel(name); ok {
+			if mounts[i].archive != nil {
+				merge(mounts[i].archive.find(rel))
+			} else {
+				merge(v.findNative(path.Join(filepath.ToSlash(mounts[i].source), rel)))
+			}
+		}
+	}
+	merge(v.findNative(name))
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	list := make([]fs.DirEntry, 0, len(entries))
+	for k, d := range entries {
+		list = append(list, vfsDirEntry{k, d})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// Match a path on disk ignoring case
+func globNoCase(name string) string {
+	var pattern string
+	for _, r := range name {
+		if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' {
+			pattern += "[" + strings.ToLower(string(r)) + strings.ToUpper(string(r)) + "]"
+		} else if r == '*' || r == '?' || r == '[' {
+			pattern += "\\" + string(r)
+		} else {
+			pattern += string(r)
+		}
+	}
+	if m, _ := filepath.Glob(pattern); len(m) > 0 {
+		return filepath.ToSlash(m[0])
+	}
+	return ""
+}
+
+// ------------------------------------------------------------------
+// vfsDirFS
+
+// An fs.FS view of a virtual directory, for libraries that resolve
+// relative files themselves
+type vfsDirFS struct {
+	v   *VirtualFS
+	dir string
+}
+
+func (v *VirtualFS) Sub(dir string) fs.FS {
+	return vfsDirFS{v, vfsPath(dir)}
+}
+
+func (d vfsDirFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := d.v.Open(path.Join(d.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return vfsFSFile{f, path.Base(name)}, nil
+}
+
+type vfsFSFile struct {
+	VFile
+	name string
+}
+
+func (f vfsFSFile) Stat() (fs.FileInfo, error) {
+	pos, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	f.Seek(pos, io.SeekStart)
+	return vfsFileInfo{f.name, size}, nil
+}
+
+type vfsFileInfo struct {
+	name string
+	size int64
+}
+
+func (i vfsFileInfo) Name() string       { return i.name }
+func (i vfsFileInfo) Size() int64        { return i.size }
+func (i vfsFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i vfsFileInfo) ModTime() time.Time { return time.Time{} }
+func (i vfsFileInfo) IsDir() bool        { return false }
+func (i vfsFileInfo) Sys() any           { return nil }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Write files by slash separated path under dir, or into a .zip archive
+// if dir ends with .zip
+func vfsTestWrite(t *testing.T, dir string, files map[string]string) {
+	if isZipName(dir) {
+		f, err := os.Create(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		zw := zip.NewWriter(f)
+		for name, data := range files {
+			// Stored and compressed entries are read differently
+			method := zip.Deflate
+			if len(data)%2 == 0 {
+				method = zip.Store
+			}
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, data)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	for name, data := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVirtualFS(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	vfsTestWrite(t, ".", map[string]string{
+		"chars/kfm/kfm.def":     "base",
+		"chars/kfm/kfm.cns":     "base cns",
+		"mod/chars/kfm/kfm.def": "mod",
+		"mod/chars/new.def":     "new",
+	})
+	vfsTestWrite(t, "chars/pack.zip", map[string]string{
+		"Pack/Pack.def":  "zipped",
+		"pack/pack.sff":  "zipped sff",
+		"pack/sub/a.txt": "a",
+	})
+	vfsTestWrite(t, "patch.zip", map[string]string{
+		"kfm.def": "patch",
+	})
+
+	v := newVirtualFS()
+	defer v.Close()
+	type lookup struct {
+		name, want string // Nothing to read if want is empty
+	}
+	steps := []struct {
+		mount, at string // Mounted before the lookups, if not empty
+		lookups   []lookup
+	}{
+		{"", "", []lookup{
+			{"chars/kfm/kfm.def", "base"},
+			{"chars/KFM/KFM.DEF", "base"},
+			{"./chars/kfm/../kfm/kfm.def", "base"},
+			{"chars/pack.zip/pack/pack.def", "zipped"},
+			{"chars/Pack.ZIP/PACK/pack.DEF", "zipped"},
+			{"chars/pack.zip,pack/pack.sff", "zipped sff"},
+			{"chars/pack.zip|pack/sub/a.txt", "a"},
+			{"chars/pack.zip/pack/missing.def", ""},
+			{"chars/pack.zip/pack", ""},
+			{"chars/new.def", ""},
+			{"", ""},
+		}},
+		// Mounted folders hide the tree, files they lack are still found
+		{"mod", "", []lookup{
+			{"chars/kfm/kfm.def", "mod"},
+			{"chars/kfm/kfm.cns", "base cns"},
+			{"chars/new.def", "new"},
+			{"mod/chars/new.def", "new"},
+		}},
+		// Later mounts hide earlier ones, only below their mount point
+		{"patch.zip", "chars/kfm", []lookup{
+			{"chars/kfm/kfm.def", "patch"},
+			{"chars/KFM/kfm.def", "patch"},
+			{"chars/kfm/kfm.cns", "base cns"},
+			{"chars/new.def", "new"},
+			{"kfm.def", ""},
+		}},
+	}
+	for _, s := range steps {
+		if s.mount != "" {
+			if err := v.Mount(s.mount, s.at); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, l := range s.lookups {
+			data, err := v.ReadFile(l.name)
+			if l.want == "" {
+				if err == nil {
+					t.Errorf("after mounting %q: %q read %q, want an error", s.mount, l.name, data)
+				}
+			} else if err != nil || string(data) != l.want {
+				t.Errorf("after mounting %q: %q read %q (%v), want %q", s.mount, l.name, data, err, l.want)
+			}
+		}
+	}
+	if err := v.Mount("missing", ""); err == nil {
+		t.Error("mounting a missing folder did not fail")
+	}
+
+	// Files in the real tree keep their disk path, the others are opened
+	// through their virtual path
+	exist := []lookup{
+		{"chars/kfm/kfm.cns", "chars/kfm/kfm.cns"},
+		{"CHARS/kfm/kfm.cns", "chars/kfm/kfm.cns"},
+		{"chars/kfm/kfm.def", "chars/kfm/kfm.def"},
+		{"chars/pack.zip,pack/pack.def", "chars/pack.zip/pack/pack.def"},
+		{"chars/kfm", ""},
+		{"chars/kfm/kfm.air", ""},
+	}
+	for _, l := range exist {
+		if got := v.FileExist(l.name); got != l.want {
+			t.Errorf("FileExist(%q) = %q, want %q", l.name, got, l.want)
+		}
+	}
+	for name, want := range map[string]bool{"chars": true, "chars/pack.zip": true,
+		"chars/pack.zip/pack/sub": true, "chars/pack.zip/pack/pack.def": false, "nothing": false} {
+		if got := v.DirExist(name); got != want {
+			t.Errorf("DirExist(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	// Listings merge the mounts and the tree, and keep the case of the
+	// archived names
+	lists := map[string][]string{
+		"chars":               {"kfm/", "new.def", "pack.zip"},
+		"chars/kfm":           {"kfm.cns", "kfm.def"},
+		"chars/pack.zip":      {"pack/"},
+		"chars/pack.zip/pack": {"Pack.def", "pack.sff", "sub/"},
+	}
+	for name, want := range lists {
+		des, err := v.ReadDir(name)
+		if err != nil {
+			t.Errorf("ReadDir(%q): %v", name, err)
+			continue
+		}
+		var got []string
+		for _, de := range des {
+			if de.IsDir() {
+				got = append(got, de.Name()+"/")
+			} else {
+				got = append(got, de.Name())
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadDir(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := v.ReadDir("nothing"); err == nil {
+		t.Error("listing a missing folder did not fail")
+	}
+}